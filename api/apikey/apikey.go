@@ -0,0 +1,244 @@
+package apikey
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/xaviercrochet/turbo-octo-adventure/pkg/auth"
+)
+
+// every key starts with the prefix, so the api can tell keys from access tokens
+const Prefix = auth.APIKeyPrefix
+
+var (
+	ErrInvalidKey = errors.New("invalid api key")
+	ErrNotFound   = errors.New("api key not found")
+	ErrNoScopes   = errors.New("api key must have at least one scope")
+)
+
+/*
+An api key lets a program (CI job, bot...) call the api without logging in
+
+Only the hash of the key is stored, the key itself is returned once, when it is issued
+*/
+type Key struct {
+	// random, part of the key so it can be looked up
+	ID   string `json:"id"`
+	Name string `json:"name"`
+	// permissions the key is restricted to
+	Scopes []string `json:"scopes"`
+	// roles of the admin who issued the key, the key is never granted more than them
+	Roles map[string][]string `json:"roles,omitempty"`
+	// organisation of the admin who issued the key
+	OrgID     string    `json:"org_id,omitempty"`
+	CreatedBy string    `json:"created_by"`
+	CreatedAt time.Time `json:"created_at"`
+	// the key never expires if zero
+	ExpiresAt time.Time  `json:"expires_at,omitempty"`
+	RevokedAt *time.Time `json:"revoked_at,omitempty"`
+	// sha256 of the key, hex encoded
+	Hash string `json:"hash,omitempty"`
+}
+
+// Returns true if the key can be used at now
+func (k *Key) Valid(now time.Time) bool {
+	return k.RevokedAt == nil && (k.ExpiresAt.IsZero() || now.Before(k.ExpiresAt))
+}
+
+/*
+Store issues, verifies and revokes api keys
+
+Keys are kept in memory and, if a file is given, saved to it so they survive restarts. Revoked keys are kept so they
+can still be listed
+*/
+type Store struct {
+	mu   sync.RWMutex
+	keys map[string]*Key
+	path string
+	now  func() time.Time
+}
+
+// Create an in memory store
+func NewStore() *Store {
+	return &Store{
+		keys: map[string]*Key{},
+		now:  time.Now,
+	}
+}
+
+// Create a store persisted in path, existing keys are loaded
+func OpenStore(path string) (*Store, error) {
+	s := NewStore()
+	s.path = path
+
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return s, nil
+	} else if err != nil {
+		return nil, fmt.Errorf("failed to read api keys: %w", err)
+	}
+
+	var keys []*Key
+	if err := json.Unmarshal(data, &keys); err != nil {
+		return nil, fmt.Errorf("failed to deserialize api keys: %w", err)
+	}
+	for _, key := range keys {
+		s.keys[key.ID] = key
+	}
+
+	return s, nil
+}
+
+/*
+Issue a new key, the ID, CreatedAt and Hash of the template are set by the store
+
+Returns the key and its secret value, which can't be retrieved later
+*/
+func (s *Store) Issue(template Key) (*Key, string, error) {
+	if len(template.Scopes) == 0 {
+		return nil, "", ErrNoScopes
+	}
+
+	id := make([]byte, 8)
+	secret := make([]byte, 32)
+	if _, err := rand.Read(id); err != nil {
+		return nil, "", fmt.Errorf("failed to generate api key: %w", err)
+	}
+	if _, err := rand.Read(secret); err != nil {
+		return nil, "", fmt.Errorf("failed to generate api key: %w", err)
+	}
+
+	key := template
+	key.ID = hex.EncodeToString(id)
+	key.CreatedAt = s.now().UTC()
+	key.RevokedAt = nil
+	value := Prefix + key.ID + "_" + base64.RawURLEncoding.EncodeToString(secret)
+	key.Hash = hash(value)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.keys[key.ID] = &key
+	if err := s.save(); err != nil {
+		// keep the memory and the file consistent
+		delete(s.keys, key.ID)
+		return nil, "", err
+	}
+
+	return redacted(&key), value, nil
+}
+
+// Returns every key of the organisation, revoked and expired ones included, newest first. Hashes are not returned
+func (s *Store) List(orgID string) []*Key {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	keys := []*Key{}
+	for _, key := range s.keys {
+		if key.OrgID == orgID {
+			keys = append(keys, redacted(key))
+		}
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		return keys[i].CreatedAt.After(keys[j].CreatedAt)
+	})
+	return keys
+}
+
+// Revoke the key of the organisation, it is rejected from now on. Keys of other organisations are not found
+func (s *Store) Revoke(orgID, id string) (*Key, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	key, ok := s.keys[id]
+	if !ok || key.OrgID != orgID {
+		return nil, ErrNotFound
+	}
+	if key.RevokedAt != nil {
+		return redacted(key), nil
+	}
+
+	now := s.now().UTC()
+	key.RevokedAt = &now
+	if err := s.save(); err != nil {
+		key.RevokedAt = nil
+		return nil, err
+	}
+
+	return redacted(key), nil
+}
+
+// Returns the key matching value, ErrInvalidKey if it is unknown, revoked or expired
+func (s *Store) Verify(value string) (*Key, error) {
+	rest, ok := strings.CutPrefix(value, Prefix)
+	if !ok {
+		return nil, ErrInvalidKey
+	}
+	id, _, ok := strings.Cut(rest, "_")
+	if !ok {
+		return nil, ErrInvalidKey
+	}
+
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	key, found := s.keys[id]
+	if !found || subtle.ConstantTimeCompare([]byte(key.Hash), []byte(hash(value))) != 1 {
+		return nil, ErrInvalidKey
+	}
+	if !key.Valid(s.now()) {
+		return nil, fmt.Errorf("%w: revoked or expired", ErrInvalidKey)
+	}
+
+	return redacted(key), nil
+}
+
+// keys carry enough entropy for a plain hash, a slow hash would only slow down every request
+func hash(value string) string {
+	sum := sha256.Sum256([]byte(value))
+	return hex.EncodeToString(sum[:])
+}
+
+// copy of the key without its hash
+func redacted(key *Key) *Key {
+	result := *key
+	result.Hash = ""
+	return &result
+}
+
+// Write every key to the file, if any. Must be called with the lock held
+func (s *Store) save() error {
+	if s.path == "" {
+		return nil
+	}
+
+	keys := make([]*Key, 0, len(s.keys))
+	for _, key := range s.keys {
+		keys = append(keys, key)
+	}
+	data, err := json.Marshal(keys)
+	if err != nil {
+		return fmt.Errorf("failed to serialize api keys: %w", err)
+	}
+
+	// write to a temporary file first, so a crash can't leave a truncated file behind
+	tmp := s.path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0600); err != nil {
+		return fmt.Errorf("failed to write api keys: %w", err)
+	}
+	if err := os.Rename(tmp, s.path); err != nil {
+		return fmt.Errorf("failed to write api keys: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,139 @@
+package apikey
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestIssueAndVerify(t *testing.T) {
+	store := NewStore()
+
+	key, value, err := store.Issue(Key{Name: "ci", Scopes: []string{"feed:read"}, CreatedBy: "alice"})
+	if err != nil {
+		t.Fatalf("Issue() error = %v", err)
+	}
+	if !strings.HasPrefix(value, Prefix+key.ID+"_") {
+		t.Errorf("Issue() value = %v, expected the prefix and the id", value)
+	}
+	if key.Hash != "" {
+		t.Errorf("Issue() returned the hash of the key")
+	}
+
+	verified, err := store.Verify(value)
+	if err != nil {
+		t.Fatalf("Verify() error = %v", err)
+	}
+	if verified.ID != key.ID || verified.Name != "ci" {
+		t.Errorf("Verify() = %+v, expected %+v", verified, key)
+	}
+
+	invalid := []string{
+		"",
+		"access-token",
+		Prefix + key.ID,
+		Prefix + key.ID + "_wrong",
+		Prefix + "unknown_" + strings.SplitN(value, "_", 3)[2],
+	}
+	for _, value := range invalid {
+		if _, err := store.Verify(value); !errors.Is(err, ErrInvalidKey) {
+			t.Errorf("Verify(%q) error = %v, expected %v", value, err, ErrInvalidKey)
+		}
+	}
+
+	if _, _, err := store.Issue(Key{Name: "no scopes"}); !errors.Is(err, ErrNoScopes) {
+		t.Errorf("Issue() error = %v, expected %v", err, ErrNoScopes)
+	}
+}
+
+func TestRevokeAndExpiry(t *testing.T) {
+	store := NewStore()
+	now := time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC)
+	store.now = func() time.Time { return now }
+
+	revoked, revokedValue, err := store.Issue(Key{Name: "revoked", Scopes: []string{"feed:read"}})
+	if err != nil {
+		t.Fatalf("Issue() error = %v", err)
+	}
+	_, expiringValue, err := store.Issue(Key{Name: "expiring", Scopes: []string{"feed:read"}, ExpiresAt: now.Add(time.Hour)})
+	if err != nil {
+		t.Fatalf("Issue() error = %v", err)
+	}
+
+	if _, err := store.Revoke("", revoked.ID); err != nil {
+		t.Fatalf("Revoke() error = %v", err)
+	}
+	if _, err := store.Verify(revokedValue); !errors.Is(err, ErrInvalidKey) {
+		t.Errorf("Verify() of a revoked key error = %v, expected %v", err, ErrInvalidKey)
+	}
+	if _, err := store.Revoke("", "unknown"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Revoke() error = %v, expected %v", err, ErrNotFound)
+	}
+
+	if _, err := store.Verify(expiringValue); err != nil {
+		t.Errorf("Verify() before expiry error = %v", err)
+	}
+	now = now.Add(2 * time.Hour)
+	if _, err := store.Verify(expiringValue); !errors.Is(err, ErrInvalidKey) {
+		t.Errorf("Verify() after expiry error = %v, expected %v", err, ErrInvalidKey)
+	}
+
+	// revoked and expired keys are still listed
+	if keys := store.List(""); len(keys) != 2 {
+		t.Errorf("List() returned %d keys, expected 2", len(keys))
+	}
+}
+
+func TestOpenStore(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "keys.json")
+
+	store, err := OpenStore(path)
+	if err != nil {
+		t.Fatalf("OpenStore() error = %v", err)
+	}
+	key, value, err := store.Issue(Key{Name: "ci", Scopes: []string{"feed:read"}})
+	if err != nil {
+		t.Fatalf("Issue() error = %v", err)
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("ReadFile() error = %v", err)
+	}
+	if strings.Contains(string(data), value) || !strings.Contains(string(data), hash(value)) {
+		t.Errorf("the file must hold the hash of the key, not the key itself")
+	}
+
+	reopened, err := OpenStore(path)
+	if err != nil {
+		t.Fatalf("OpenStore() error = %v", err)
+	}
+	if verified, err := reopened.Verify(value); err != nil || verified.ID != key.ID {
+		t.Errorf("Verify() after reopening = %+v, %v, expected %+v", verified, err, key)
+	}
+}
+
+func TestOrganisationsAreSeparated(t *testing.T) {
+	store := NewStore()
+
+	key, value, err := store.Issue(Key{Name: "ci", Scopes: []string{"feed:read"}, OrgID: "org-1"})
+	if err != nil {
+		t.Fatalf("Issue() error = %v", err)
+	}
+
+	if keys := store.List("org-2"); len(keys) != 0 {
+		t.Errorf("List(org-2) = %+v, expected no key", keys)
+	}
+	if _, err := store.Revoke("org-2", key.ID); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Revoke() of the key of another organisation error = %v, expected %v", err, ErrNotFound)
+	}
+	if verified, err := store.Verify(value); err != nil || verified.OrgID != "org-1" {
+		t.Errorf("Verify() = %+v, %v, expected the key of org-1", verified, err)
+	}
+	if keys := store.List("org-1"); len(keys) != 1 {
+		t.Errorf("List(org-1) returned %d keys, expected 1", len(keys))
+	}
+}
@@ -0,0 +1,185 @@
+package app
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/xaviercrochet/turbo-octo-adventure/api/apikey"
+	"github.com/xaviercrochet/turbo-octo-adventure/api/tenant"
+	"github.com/xaviercrochet/turbo-octo-adventure/pkg/auth"
+	"github.com/xaviercrochet/turbo-octo-adventure/pkg/policy"
+	"github.com/xaviercrochet/turbo-octo-adventure/pkg/util"
+	"github.com/zitadel/zitadel-go/v3/pkg/http/middleware"
+)
+
+type APIKeyRequest struct {
+	Name   string              `json:"name"`
+	Scopes []policy.Permission `json:"scopes"`
+	// lifetime of the key as a go duration, e.g. 720h. The key never expires if empty
+	ExpiresIn string `json:"expires_in,omitempty"`
+}
+
+// An issued key, Value is what to send as bearer token. It is only returned once
+type IssuedAPIKey struct {
+	*apikey.Key
+	Value string `json:"key"`
+}
+
+type APIKeysResponse struct {
+	Keys []*apikey.Key `json:"keys"`
+}
+
+/*
+/api/keys
+
+  - GET: list the api keys of the organisation, revoked and expired ones included, see APIKeysResponse
+  - POST: issue a key, see APIKeyRequest. The response holds the key, see IssuedAPIKey
+  - DELETE ?id=<id>: revoke a key
+
+A key belongs to the organisation of the caller and can only be scoped to permissions the caller is granted itself.
+Keys can't manage keys
+
+Response:
+  - 400 if the body, a scope or the lifetime is invalid
+  - 403 if the caller is not granted one of the scopes
+  - 404 if the key doesn't exist or the http verb is not supported
+*/
+func apiKeysHandler(authMw *middleware.Interceptor[*auth.Context], pol *policy.Policy, store *apikey.Store, orgID tenant.ID) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+		logger := util.DefaultLogger.FromContext(ctx)
+		authCtx := authMw.Context(ctx)
+
+		// a leaked key must not be able to mint new ones
+		if authCtx.CallerType() == auth.CallerAPIKey {
+			http.Error(w, "api keys can't manage api keys", http.StatusForbidden)
+			return
+		}
+
+		switch r.Method {
+		case http.MethodGet:
+			err := jsonResponse(w, &APIKeysResponse{Keys: store.List(string(orgID))}, http.StatusOK)
+			if err != nil {
+				logger.Error("error writing response", "error", err)
+			}
+
+		case http.MethodPost:
+			var request APIKeyRequest
+			if status, err := decodeJSON(r, &request); err != nil {
+				logger.Warn("could not deserialize request body", "error", err)
+				http.Error(w, "could not deserialize request body", status)
+				return
+			}
+
+			template, status, err := newAPIKey(pol, authCtx, orgID, &request, time.Now())
+			if err != nil {
+				http.Error(w, err.Error(), status)
+				return
+			}
+
+			key, value, err := store.Issue(*template)
+			if err != nil {
+				logger.Error("could not issue api key", "error", err)
+				http.Error(w, "could not issue api key", http.StatusInternalServerError)
+				return
+			}
+
+			logger.Info("api key issued", append(authCtx.LogAttrs(), "key_id", key.ID, "key_name", key.Name, "scopes", key.Scopes, "expires_at", key.ExpiresAt)...)
+
+			err = jsonResponse(w, &IssuedAPIKey{Key: key, Value: value}, http.StatusOK)
+			if err != nil {
+				logger.Error("error writing response", "error", err)
+			}
+
+		case http.MethodDelete:
+			id := r.URL.Query().Get("id")
+			key, err := store.Revoke(string(orgID), id)
+			if errors.Is(err, apikey.ErrNotFound) {
+				http.Error(w, err.Error(), http.StatusNotFound)
+				return
+			} else if err != nil {
+				logger.Error("could not revoke api key", "error", err)
+				http.Error(w, "could not revoke api key", http.StatusInternalServerError)
+				return
+			}
+
+			logger.Info("api key revoked", append(authCtx.LogAttrs(), "key_id", key.ID, "key_name", key.Name)...)
+
+			err = jsonResponse(w, key, http.StatusOK)
+			if err != nil {
+				logger.Error("error writing response", "error", err)
+			}
+
+		default:
+			http.Error(w, "not found", http.StatusNotFound)
+		}
+	}
+}
+
+// Validate the request and build the key to issue, returns the http status to respond with on error
+func newAPIKey(pol *policy.Policy, authCtx *auth.Context, orgID tenant.ID, request *APIKeyRequest, now time.Time) (*apikey.Key, int, error) {
+	name := strings.TrimSpace(request.Name)
+	if name == "" {
+		return nil, http.StatusBadRequest, errors.New("name can't be empty")
+	}
+	if len(request.Scopes) == 0 {
+		return nil, http.StatusBadRequest, apikey.ErrNoScopes
+	}
+
+	scopes := make([]string, 0, len(request.Scopes))
+	for _, scope := range request.Scopes {
+		if !policy.IsKnown(scope) {
+			return nil, http.StatusBadRequest, fmt.Errorf("unknown scope %q", scope)
+		}
+		if scope == policy.APIKeyManage {
+			return nil, http.StatusBadRequest, fmt.Errorf("api keys can't be granted %q", scope)
+		}
+		if !pol.IsGranted(authCtx, scope) {
+			return nil, http.StatusForbidden, fmt.Errorf("scope %q is not granted to you", scope)
+		}
+		scopes = append(scopes, string(scope))
+	}
+
+	key := &apikey.Key{
+		Name:      name,
+		Scopes:    scopes,
+		Roles:     authCtx.Roles,
+		OrgID:     string(orgID),
+		CreatedBy: authCtx.Username,
+	}
+	if request.ExpiresIn != "" {
+		lifetime, err := time.ParseDuration(request.ExpiresIn)
+		if err != nil || lifetime <= 0 {
+			return nil, http.StatusBadRequest, errors.New("expires_in must be a positive duration, e.g. 720h")
+		}
+		key.ExpiresAt = now.Add(lifetime).UTC()
+	}
+	return key, http.StatusOK, nil
+}
+
+// Resolves the api keys of the store for the authorization middleware
+type apiKeyVerifier struct {
+	store *apikey.Store
+}
+
+func (v *apiKeyVerifier) VerifyAPIKey(_ context.Context, value string) (*auth.Context, error) {
+	key, err := v.store.Verify(value)
+	if err != nil {
+		return nil, err
+	}
+
+	return &auth.Context{
+		Subject:  "apikey:" + key.ID,
+		Username: key.Name,
+		OrgID:    key.OrgID,
+		Roles:    key.Roles,
+		Expiry:   key.ExpiresAt,
+		Active:   true,
+		Caller:   auth.CallerAPIKey,
+		Scopes:   key.Scopes,
+	}, nil
+}
@@ -0,0 +1,117 @@
+package app
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/xaviercrochet/turbo-octo-adventure/api/apikey"
+	"github.com/xaviercrochet/turbo-octo-adventure/pkg/auth"
+	"github.com/xaviercrochet/turbo-octo-adventure/pkg/policy"
+	"github.com/zitadel/zitadel-go/v3/pkg/authorization"
+	"github.com/zitadel/zitadel-go/v3/pkg/http/middleware"
+)
+
+func TestAPIKeysHandler(t *testing.T) {
+	store := apikey.NewStore()
+	pol := policy.Default()
+	handler := apiKeysHandler(middleware.New[*auth.Context](nil), pol, store, "org-1")
+	admin := &auth.Context{Subject: "user-1", Username: "alice", OrgID: "org-1", Roles: map[string][]string{"admin": nil}, Active: true}
+
+	call := func(authCtx *auth.Context, method, target, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, target, strings.NewReader(body))
+		req = req.WithContext(authorization.WithAuthContext(req.Context(), authCtx))
+		w := httptest.NewRecorder()
+		handler(w, req)
+		return w
+	}
+
+	w := call(admin, http.MethodPost, "/api/keys", `{"name": "ci", "scopes": ["feed:read"], "expires_in": "720h"}`)
+	if w.Code != http.StatusOK {
+		t.Fatalf("POST status = %v, expected %v: %s", w.Code, http.StatusOK, w.Body)
+	}
+	var issued struct {
+		ID    string `json:"id"`
+		Value string `json:"key"`
+		Hash  string `json:"hash"`
+	}
+	if err := json.NewDecoder(w.Body).Decode(&issued); err != nil {
+		t.Fatalf("failed to decode response: %v", err)
+	}
+	if !strings.HasPrefix(issued.Value, apikey.Prefix) || issued.Hash != "" {
+		t.Errorf("POST returned %+v, expected the key without its hash", issued)
+	}
+
+	w = call(admin, http.MethodGet, "/api/keys", "")
+	var listed APIKeysResponse
+	if err := json.NewDecoder(w.Body).Decode(&listed); err != nil {
+		t.Fatalf("failed to decode response: %v", err)
+	}
+	if len(listed.Keys) != 1 || listed.Keys[0].ID != issued.ID || listed.Keys[0].CreatedBy != "alice" {
+		t.Errorf("GET returned %+v, expected the issued key", listed.Keys)
+	}
+
+	// the key works until it is revoked
+	verifier := &apiKeyVerifier{store: store}
+	keyCtx, err := verifier.VerifyAPIKey(context.Background(), issued.Value)
+	if err != nil {
+		t.Fatalf("VerifyAPIKey() error = %v", err)
+	}
+	if keyCtx.CallerType() != auth.CallerAPIKey || keyCtx.OrgID != "org-1" {
+		t.Errorf("VerifyAPIKey() = %+v, expected an api key of org-1", keyCtx)
+	}
+	// the issuer is an admin, the key is still limited to its scope
+	if permissions := pol.Permissions(keyCtx); len(permissions) != 1 || permissions[0] != policy.FeedRead {
+		t.Errorf("Permissions() = %v, expected only %v", permissions, policy.FeedRead)
+	}
+
+	// keys can't manage keys
+	if w := call(keyCtx, http.MethodGet, "/api/keys", ""); w.Code != http.StatusForbidden {
+		t.Errorf("GET with an api key status = %v, expected %v", w.Code, http.StatusForbidden)
+	}
+
+	if w := call(admin, http.MethodDelete, "/api/keys?id="+issued.ID, ""); w.Code != http.StatusOK {
+		t.Errorf("DELETE status = %v, expected %v", w.Code, http.StatusOK)
+	}
+	if _, err := verifier.VerifyAPIKey(context.Background(), issued.Value); err == nil {
+		t.Errorf("VerifyAPIKey() of a revoked key error = nil")
+	}
+	if w := call(admin, http.MethodDelete, "/api/keys?id=unknown", ""); w.Code != http.StatusNotFound {
+		t.Errorf("DELETE of an unknown key status = %v, expected %v", w.Code, http.StatusNotFound)
+	}
+	if w := call(admin, http.MethodPut, "/api/keys", ""); w.Code != http.StatusNotFound {
+		t.Errorf("PUT status = %v, expected %v", w.Code, http.StatusNotFound)
+	}
+}
+
+func TestNewAPIKeyValidation(t *testing.T) {
+	pol := policy.Default()
+	user := &auth.Context{Subject: "user-2", Username: "bob", Active: true}
+
+	tests := []struct {
+		name    string
+		request APIKeyRequest
+		status  int
+	}{
+		{name: "valid", request: APIKeyRequest{Name: "bot", Scopes: []policy.Permission{policy.FeedRead}}, status: http.StatusOK},
+		{name: "no name", request: APIKeyRequest{Scopes: []policy.Permission{policy.FeedRead}}, status: http.StatusBadRequest},
+		{name: "no scopes", request: APIKeyRequest{Name: "bot"}, status: http.StatusBadRequest},
+		{name: "unknown scope", request: APIKeyRequest{Name: "bot", Scopes: []policy.Permission{"feed:delete"}}, status: http.StatusBadRequest},
+		{name: "keys can't manage keys", request: APIKeyRequest{Name: "bot", Scopes: []policy.Permission{policy.APIKeyManage}}, status: http.StatusBadRequest},
+		{name: "scope not granted to the caller", request: APIKeyRequest{Name: "bot", Scopes: []policy.Permission{policy.FeedSelect}}, status: http.StatusForbidden},
+		{name: "invalid lifetime", request: APIKeyRequest{Name: "bot", Scopes: []policy.Permission{policy.FeedRead}, ExpiresIn: "-1h"}, status: http.StatusBadRequest},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, status, err := newAPIKey(pol, user, "org-1", &tt.request, time.Now())
+			if status != tt.status {
+				t.Errorf("newAPIKey() status = %v (%v), expected %v", status, err, tt.status)
+			}
+		})
+	}
+}
@@ -2,42 +2,27 @@ package app
 
 import (
 	"context"
+	"crypto/rand"
 	"encoding/json"
+	"errors"
 	"fmt"
-	"io"
 	"net/http"
-	"sync"
+	"time"
 
+	"github.com/xaviercrochet/turbo-octo-adventure/api/apikey"
+	"github.com/xaviercrochet/turbo-octo-adventure/api/archive"
+	"github.com/xaviercrochet/turbo-octo-adventure/api/audit"
 	"github.com/xaviercrochet/turbo-octo-adventure/api/musicbrainz"
+	"github.com/xaviercrochet/turbo-octo-adventure/api/subscription"
+	"github.com/xaviercrochet/turbo-octo-adventure/api/tenant"
+	"github.com/xaviercrochet/turbo-octo-adventure/api/webhook"
+	"github.com/xaviercrochet/turbo-octo-adventure/pkg/auth"
 	mw "github.com/xaviercrochet/turbo-octo-adventure/pkg/middleware"
-	"github.com/xaviercrochet/turbo-octo-adventure/pkg/net"
+	"github.com/xaviercrochet/turbo-octo-adventure/pkg/policy"
 	"github.com/xaviercrochet/turbo-octo-adventure/pkg/util"
-	"github.com/zitadel/zitadel-go/v3/pkg/authorization"
-	"github.com/zitadel/zitadel-go/v3/pkg/authorization/oauth"
 	"github.com/zitadel/zitadel-go/v3/pkg/http/middleware"
-	"github.com/zitadel/zitadel-go/v3/pkg/zitadel"
 )
 
-var (
-	// store, in memory, the username from which the feed will be retrieved from the musicbrainz api
-	selectedUsername = "xcrochet"
-	rwmu             sync.RWMutex
-)
-
-// updates the username used for MusicBrainz requests
-func setSelectedUsername(username string) {
-	rwmu.Lock()
-	selectedUsername = username
-	rwmu.Unlock()
-}
-
-// returns the currently selected username
-func getSelectedUsername() string {
-	rwmu.RLock()
-	defer rwmu.RUnlock()
-	return selectedUsername
-}
-
 type SelectedFeed struct {
 	Name string `json:"name"`
 }
@@ -47,14 +32,150 @@ type ServerOptions struct {
 	domain      string
 	keyFilePath string
 	port        string
+	// how the access tokens are verified, ZITADEL introspection by default
+	authConfig *auth.Config
+	// which roles grant which permissions
+	policy *policy.Policy
+	// where the audit trails are persisted, one file per organisation, in memory only if empty
+	auditFile string
+	// signs the subscription tokens, random if empty so tokens don't survive restarts
+	subscriptionKey []byte
+	// where the subscriptions are persisted, in memory only if empty
+	subscriptionFile string
+	// how often the selected feed is checked for new listens to send to the webhooks
+	webhookPollInterval time.Duration
+	// where listens are archived, in memory only if empty
+	archiveFile string
+	// how often listens are pulled into the archive
+	archiveSyncInterval time.Duration
+	// archived in addition to the selected feed
+	trackedUsers []string
+	// listens of the same track submitted within this duration are archived once
+	duplicateTolerance time.Duration
+	// where the preferences of the users are saved, one file per organisation, in memory only if empty
+	preferencesFile string
+	// where the api keys are saved, in memory only if empty
+	apiKeysFile string
+	// where the listens are retrieved from
+	musicbrainz *musicbrainz.Client
+	// larger request bodies are rejected with a 413
+	maxRequestSize int64
+	// how the requests are logged, see mw.DefaultAccessLog
+	accessLog *mw.AccessLog
+}
+
+// Option allows customization of the ServerOptions
+type Option func(*ServerOptions)
+
+// WithAuthConfig selects another authorization backend than ZITADEL
+func WithAuthConfig(config *auth.Config) Option {
+	return func(o *ServerOptions) {
+		o.authConfig = config
+	}
+}
+
+// WithPolicy replaces the default role to permission mapping
+func WithPolicy(p *policy.Policy) Option {
+	return func(o *ServerOptions) {
+		o.policy = p
+	}
+}
+
+// WithAuditFile persists the audit trails as json lines, in a file per organisation derived from path, see tenant.File
+func WithAuditFile(path string) Option {
+	return func(o *ServerOptions) {
+		o.auditFile = path
+	}
+}
+
+// WithSubscriptions signs the subscription tokens with key and persists the subscriptions in path (in memory if empty)
+func WithSubscriptions(key []byte, path string) Option {
+	return func(o *ServerOptions) {
+		o.subscriptionKey = key
+		o.subscriptionFile = path
+	}
+}
+
+// WithWebhookPollInterval changes how often new listens are looked for, webhook.DefaultPollInterval by default
+func WithWebhookPollInterval(interval time.Duration) Option {
+	return func(o *ServerOptions) {
+		o.webhookPollInterval = interval
+	}
+}
+
+// WithArchive persists the archived listens as json lines, in a file per organisation derived from path (in memory if
+// empty), synced every interval.
+// The listens of the trackedUsers are archived in addition to the ones of the selected feed
+func WithArchive(path string, interval time.Duration, trackedUsers ...string) Option {
+	return func(o *ServerOptions) {
+		o.archiveFile = path
+		o.archiveSyncInterval = interval
+		o.trackedUsers = trackedUsers
+	}
+}
+
+// WithDuplicateTolerance changes the duration within which two listens of the same track are considered the same
+// listen submitted twice, musicbrainz.DefaultDuplicateTolerance by default
+func WithDuplicateTolerance(tolerance time.Duration) Option {
+	return func(o *ServerOptions) {
+		o.duplicateTolerance = tolerance
+	}
+}
+
+// WithPreferencesFile saves the preferences of the users, in a file per organisation derived from path
+func WithPreferencesFile(path string) Option {
+	return func(o *ServerOptions) {
+		o.preferencesFile = path
+	}
+}
+
+// WithAPIKeysFile saves the api keys, hashed, in path
+func WithAPIKeysFile(path string) Option {
+	return func(o *ServerOptions) {
+		o.apiKeysFile = path
+	}
+}
+
+// WithMusicBrainzClient retrieves the listens with client instead of musicbrainz.DefaultClient
+func WithMusicBrainzClient(client *musicbrainz.Client) Option {
+	return func(o *ServerOptions) {
+		o.musicbrainz = client
+	}
+}
+
+// WithMaxRequestSize replaces mw.DefaultMaxBodySize, the maximum size of a request body in bytes
+func WithMaxRequestSize(size int64) Option {
+	return func(o *ServerOptions) {
+		o.maxRequestSize = size
+	}
 }
 
-func NewServerOptions(domain, keyFilePath, port string) *ServerOptions {
-	return &ServerOptions{
+// WithAccessLog replaces the default access log, i.e. to trust a reverse proxy or log in the Combined Log Format
+func WithAccessLog(accessLog *mw.AccessLog) Option {
+	return func(o *ServerOptions) {
+		o.accessLog = accessLog
+	}
+}
+
+func NewServerOptions(domain, keyFilePath, port string, options ...Option) *ServerOptions {
+	o := &ServerOptions{
 		domain:      domain,
 		keyFilePath: keyFilePath,
 		port:        port,
+		authConfig:  auth.NewZitadelConfig(domain, keyFilePath),
+		policy:      policy.Default(),
+
+		webhookPollInterval: webhook.DefaultPollInterval,
+		archiveSyncInterval: archive.DefaultSyncInterval,
+		duplicateTolerance:  musicbrainz.DefaultDuplicateTolerance,
+		musicbrainz:         musicbrainz.DefaultClient,
+		maxRequestSize:      mw.DefaultMaxBodySize,
+		accessLog:           mw.DefaultAccessLog(),
+	}
+	for _, option := range options {
+		option(o)
 	}
+	return o
 }
 
 /*
@@ -62,19 +183,55 @@ func NewServerOptions(domain, keyFilePath, port string) *ServerOptions {
 */
 
 func SetupRoutes(serverCtx context.Context, router *http.ServeMux, options *ServerOptions) error {
-	//setup authorziation context
-	authZ, err := authorization.New(serverCtx, zitadel.New(options.domain), oauth.DefaultAuthorization(options.keyFilePath))
+	// api keys let programs call the api without logging in
+	apiKeys := apikey.NewStore()
+	if options.apiKeysFile != "" {
+		var err error
+		if apiKeys, err = apikey.OpenStore(options.apiKeysFile); err != nil {
+			return fmt.Errorf("could not open api keys: %v", err)
+		}
+	}
+
+	//setup authorziation context, api keys go through the same middleware as the access tokens
+	authZ, err := auth.NewAuthorizer(serverCtx, options.authConfig, auth.WithAPIKeys(&apiKeyVerifier{store: apiKeys}))
 	if err != nil {
-		return fmt.Errorf("zitadel sdk could not initialize: %v", err)
+		return err
 	}
 
 	// initialize the authorization middleware
 	authMw := middleware.New(authZ)
+	pol := options.policy
+	// routes reading a body never read more than maxRequestSize bytes
+	limitBody := mw.MaxBodySize(options.maxRequestSize)
+	logRequests := options.accessLog.Middleware
+	// the caller is recorded in the access log as soon as it is authorized
+	requireAuthorization := func(next http.Handler) http.Handler {
+		return authMw.RequireAuthorization()(recordUser(authMw, next))
+	}
+
+	// the selected feed, audit trail, preferences, webhooks and archive of every organisation, see newTenantState
+	tenants := tenant.NewRegistry(newTenantState(serverCtx, options))
+
+	// subscriptions let feed readers read the feed without logging in
+	subscriptionKey := options.subscriptionKey
+	if len(subscriptionKey) == 0 {
+		util.DefaultLogger.Warn("no subscription key configured, subscription urls will be invalidated on restart")
+		subscriptionKey = make([]byte, 32)
+		if _, err := rand.Read(subscriptionKey); err != nil {
+			return fmt.Errorf("could not generate subscription key: %v", err)
+		}
+	}
+	subscriptions := subscription.NewStore(subscriptionKey)
+	if options.subscriptionFile != "" {
+		if subscriptions, err = subscription.OpenStore(subscriptionKey, options.subscriptionFile); err != nil {
+			return fmt.Errorf("could not open subscriptions: %v", err)
+		}
+	}
 
 	// This endpoint is accessible by anyone and will always return "200 OK" to indicate the API is running
 	router.Handle("/api/healthz",
 		mw.RequestContextMiddleware(
-			mw.LogMiddleware(http.HandlerFunc(
+			logRequests(http.HandlerFunc(
 				func(w http.ResponseWriter, r *http.Request) {
 					logger := util.DefaultLogger.FromContext(r.Context())
 					err = jsonResponse(w, "OK", http.StatusOK)
@@ -84,122 +241,310 @@ func SetupRoutes(serverCtx context.Context, router *http.ServeMux, options *Serv
 				}))))
 
 	/*
-
-	   Update selectedFeed
-
-	   Request body: see SelectedFeed
-
+	   Update the selected feed of the organisation of the caller, see selectFeedHandler
 	   - user need to be authenticated
-	   - user is authorized with admin role
+	   - user is granted the feed:select permission
+	*/
+	router.Handle("/api/select_feed", mw.RequestContextMiddleware(
+		logRequests(limitBody(
+			requireAuthorization(pol.Require(policy.FeedSelect)(
+				perTenant(authMw, tenants, func(t *tenantState) http.Handler {
+					return selectFeedHandler(authMw, t.feed, t.auditLog)
+				})))))))
 
-	   Response:
-	   - 401 if user is not authenticated
-	   - 403 if user is not authorized
-	   - 404 if http verb is not POST or username doesn't exist
+	/*
+	   Retrieve the archived music feed selected by the organisation of the caller, see feedHandler
+	   - user need to be authenticated
+	   - user is granted the feed:read permission
 	*/
+	router.Handle("/api/feed",
+		mw.RequestContextMiddleware(
+			logRequests(mw.Gzip(requireAuthorization(pol.Require(policy.FeedRead)(
+				perTenant(authMw, tenants, func(t *tenantState) http.Handler {
+					return feedHandler(authMw, pol, t.worker, t.archive, t.feed.get)
+				})))))))
 
-	router.Handle("/api/select_feed", mw.RequestContextMiddleware(
-		mw.LogMiddleware(
-			authMw.RequireAuthorization()(http.HandlerFunc(
-				func(w http.ResponseWriter, r *http.Request) {
-					ctx := r.Context()
-					logger := util.DefaultLogger.FromContext(ctx)
+	/*
+	   Export the selected feed, see exportHandler
+	   - user need to be authenticated
+	   - user is granted the feed:read permission
+	*/
+	router.Handle("/api/feed/export",
+		mw.RequestContextMiddleware(
+			logRequests(mw.Gzip(requireAuthorization(pol.Require(policy.FeedRead)(
+				perTenant(authMw, tenants, func(t *tenantState) http.Handler {
+					return exportHandler(t.getFeed, t.feed.get)
+				})))))))
 
-					// this endpoint only supports POST requests:w
-					if r.Method != http.MethodPost {
-						http.Error(w, "not found", http.StatusNotFound)
-						return
-					}
+	/*
+	   Manage the subscription of the caller, see subscriptionHandler
+	   - user need to be authenticated
+	   - user is granted the feed:read permission, which the subscription delegates to feed readers
+	*/
+	router.Handle("/api/subscription",
+		mw.RequestContextMiddleware(
+			logRequests(requireAuthorization(pol.Require(policy.FeedRead)(
+				perTenant(authMw, tenants, func(t *tenantState) http.Handler {
+					return subscriptionHandler(authMw, subscriptions, t.id)
+				}))))))
 
-					authCtx := authMw.Context(ctx)
-					if !authCtx.IsGrantedRole("admin") {
-						logger.Warn("user doesn't have access to the resource", "id", authCtx.UserID(), "username", authCtx.Username)
-						http.Error(w, "forbidden", http.StatusForbidden)
-						return
-					}
+	/*
+	   Syndication documents of the selected feed, see syndicationHandler
+	   - authenticated by a subscription token instead of an access token
+	*/
+	router.Handle("/api/feed.atom",
+		mw.RequestContextMiddleware(
+			logRequests(syndicationHandler(subscriptions, tenants, true))))
+	router.Handle("/api/feed.rss",
+		mw.RequestContextMiddleware(
+			logRequests(syndicationHandler(subscriptions, tenants, false))))
 
-					// deserialize the request payload
-					body, err := io.ReadAll(r.Body)
-					if err != nil {
-						logger.Warn("could not read request body", "id", authCtx.UserID(), "username", authCtx.Username, "error", err)
-						http.Error(w, "Error reading request body", http.StatusBadRequest)
-						return
-					}
-					defer r.Body.Close()
+	/*
+	   Manage the webhooks notified of new listens, see webhooksHandler and deliveriesHandler
+	   - user need to be authenticated
+	   - user is granted the webhook:manage permission
+	*/
+	router.Handle("/api/webhooks",
+		mw.RequestContextMiddleware(
+			logRequests(limitBody(requireAuthorization(pol.Require(policy.WebhookManage)(
+				perTenant(authMw, tenants, func(t *tenantState) http.Handler {
+					return webhooksHandler(authMw, t.webhooks)
+				})))))))
+	router.Handle("/api/webhooks/deliveries",
+		mw.RequestContextMiddleware(
+			logRequests(requireAuthorization(pol.Require(policy.WebhookManage)(
+				perTenant(authMw, tenants, func(t *tenantState) http.Handler {
+					return deliveriesHandler(t.dispatcher.Deliveries)
+				}))))))
+	router.Handle("/api/webhooks/dead_letters",
+		mw.RequestContextMiddleware(
+			logRequests(requireAuthorization(pol.Require(policy.WebhookManage)(
+				perTenant(authMw, tenants, func(t *tenantState) http.Handler {
+					return deliveriesHandler(t.dispatcher.DeadLetters)
+				}))))))
 
-					var selectedFeed SelectedFeed
-					err = json.Unmarshal(body, &selectedFeed)
-					if err != nil {
-						logger.Warn("could not deserialize request body", "id", authCtx.UserID(), "username", authCtx.Username, "error", err)
-						http.Error(w, "failed to deserialize request body", http.StatusBadRequest)
-						return
-					}
+	/*
+	   Full-text search over the archived listens of the selected feed, see searchHandler
+	   - user need to be authenticated
+	   - user is granted the feed:read permission
+	*/
+	router.Handle("/api/search",
+		mw.RequestContextMiddleware(
+			logRequests(requireAuthorization(pol.Require(policy.FeedRead)(
+				perTenant(authMw, tenants, func(t *tenantState) http.Handler {
+					return searchHandler(authMw, t.prefs, t.index, t.worker, t.feed.get)
+				}))))))
 
-					// update the username from wich '/feed' will retrieve the musicbrainz feed from
+	/*
+	   Display preferences of the caller, see preferencesHandler
+	   - user need to be authenticated
+	   - user is granted the feed:read permission
+	*/
+	router.Handle("/api/preferences",
+		mw.RequestContextMiddleware(
+			logRequests(limitBody(requireAuthorization(pol.Require(policy.FeedRead)(
+				perTenant(authMw, tenants, func(t *tenantState) http.Handler {
+					return preferencesHandler(authMw, t.prefs)
+				})))))))
 
-					setSelectedUsername(selectedFeed.Name)
+	/*
+	   Sync state of the archived users, see archiveStatusHandler
+	   - user need to be authenticated
+	   - user is granted the feed:read permission
+	*/
+	router.Handle("/api/archive/status",
+		mw.RequestContextMiddleware(
+			logRequests(requireAuthorization(pol.Require(policy.FeedRead)(
+				perTenant(authMw, tenants, func(t *tenantState) http.Handler {
+					return archiveStatusHandler(t.worker, t.users(options.trackedUsers))
+				}))))))
 
-					// OK
-					err = jsonResponse(w, "OK", http.StatusOK)
-					if err != nil {
-						logger.Error("error writing response", "error", err)
-					}
-				})))))
+	/*
+	   Issue, list and revoke api keys, see apiKeysHandler
+	   - user need to be authenticated
+	   - user is granted the apikey:manage permission
+	*/
+	router.Handle("/api/keys",
+		mw.RequestContextMiddleware(
+			logRequests(limitBody(requireAuthorization(pol.Require(policy.APIKeyManage)(
+				perTenant(authMw, tenants, func(t *tenantState) http.Handler {
+					return apiKeysHandler(authMw, pol, apiKeys, t.id)
+				})))))))
 
 	/*
-	   Retrieve music feed from feed API, based on selectedUsername
+	   Query the audit trail of the feed selection, see auditHandler
 	   - user need to be authenticated
-	   - user is authorized with any role
+	   - user is granted the audit:read permission
+	*/
+	router.Handle("/api/audit",
+		mw.RequestContextMiddleware(
+			logRequests(requireAuthorization(pol.Require(policy.AuditRead)(
+				perTenant(authMw, tenants, func(t *tenantState) http.Handler {
+					return auditHandler(t.auditLog)
+				}))))))
 
-	   Response:
-	   - 401 if not authenticated
-	   - 403 if not authorized
-	   - 404 if http verb is not GET
+	/*
+	   Roll back a change of the selected feed, see rollbackHandler
+	   - user need to be authenticated
+	   - user is granted the feed:select permission
 	*/
+	router.Handle("/api/audit/rollback",
+		mw.RequestContextMiddleware(
+			logRequests(limitBody(requireAuthorization(pol.Require(policy.FeedSelect)(
+				perTenant(authMw, tenants, func(t *tenantState) http.Handler {
+					return rollbackHandler(authMw, t.feed, t.auditLog)
+				})))))))
 
-	router.Handle("/api/feed",
+	/*
+	   Read and change the log level of the api, see logLevelHandler
+	   - user need to be authenticated
+	   - user is granted the log:manage permission
+	*/
+	router.Handle("/api/admin/log_level",
 		mw.RequestContextMiddleware(
-			mw.LogMiddleware(authMw.RequireAuthorization()(http.HandlerFunc(
-				func(w http.ResponseWriter, r *http.Request) {
-					ctx := r.Context()
-					logger := util.DefaultLogger.FromContext(ctx)
+			logRequests(limitBody(requireAuthorization(pol.Require(policy.LogManage)(
+				logLevelHandler(authMw, util.DefaultLogger)))))))
 
-					// this endpoint only supports GET requests
-					if r.Method != http.MethodGet {
-						http.Error(w, "not found", http.StatusNotFound)
-						return
-					}
+	return nil
+}
 
-					authCtx := authMw.Context(ctx)
+// Records the caller in the access log, see mw.SetAccessLogUser
+func recordUser(authMw *middleware.Interceptor[*auth.Context], next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if authCtx := authMw.Context(r.Context()); authCtx != nil {
+			mw.SetAccessLogUser(r.Context(), authCtx.UserID())
+		}
+		next.ServeHTTP(w, r)
+	})
+}
 
-					logger.Info("retrieving user feed", "id", authCtx.UserID(), "username", authCtx.Username, "feed_username", getSelectedUsername())
+/*
+/api/select_feed
 
-					// retrieve music feed from musicbrainz API
-					feed, err := musicbrainz.GetFeed(getSelectedUsername())
+Update the selected feed, the change is audited.
+Request body: see SelectedFeed
 
-					// handle client error if any
-					if err == net.ErrNotFound {
-						http.Error(w, "feed not found", http.StatusNotFound)
-					} else if err != nil {
-						logger.Warn("musicbrainz api call failed", "error", err)
-						http.Error(w, "musicbrainz api call failed", http.StatusInternalServerError)
-					}
+Response:
+  - 400 if the body is invalid
+  - 404 if http verb is not POST
+*/
+func selectFeedHandler(authMw *middleware.Interceptor[*auth.Context], feed *selectedFeed, auditLog *audit.Log) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+		logger := util.DefaultLogger.FromContext(ctx)
+
+		// this endpoint only supports POST requests
+		if r.Method != http.MethodPost {
+			http.Error(w, "not found", http.StatusNotFound)
+			return
+		}
+
+		authCtx := authMw.Context(ctx)
+
+		// deserialize the request payload
+		var selected SelectedFeed
+		if status, err := decodeJSON(r, &selected); err != nil {
+			logger.Warn("could not deserialize request body", "id", authCtx.UserID(), "username", authCtx.Username, "error", err)
+			http.Error(w, "failed to deserialize request body", status)
+			return
+		}
+
+		// update the username from wich '/feed' will retrieve the musicbrainz feed from, the change is audited
+		entry, err := changeSelectedUsername(ctx, feed, auditLog, authCtx, audit.Entry{
+			Action:   audit.ActionSelectFeed,
+			NewValue: selected.Name,
+		})
+		if err != nil {
+			logger.Error("could not record feed selection", "error", err)
+			http.Error(w, "could not record feed selection", http.StatusInternalServerError)
+			return
+		}
+
+		logger.Info("selected feed changed", "id", authCtx.UserID(), "username", authCtx.Username, "org_id", authCtx.OrgID, "old_feed_username", entry.OldValue, "feed_username", entry.NewValue)
+
+		// OK
+		err = jsonResponse(w, "OK", http.StatusOK)
+		if err != nil {
+			logger.Error("error writing response", "error", err)
+		}
+	}
+}
 
-					/*
-					   Return the feed and weather the user has sufficiant authorization to update selectedUsername
-					*/
-					resp := &FeedResponse{
-						Feed:        feed,
-						WriteAccess: authCtx.IsGrantedRole("admin"),
-					}
+/*
+GET /api/feed
 
-					err = jsonResponse(w, resp, http.StatusOK)
-					if err != nil {
-						logger.Error("error writing response", "error", err)
-					}
-				})))))
+Retrieve the archived music feed of the selected username, see parseFeedQuery for the time range.
+The permissions of the caller are returned along the feed, see FeedResponse.
+The response carries an ETag, clients revalidate it with If-None-Match
 
-	return nil
+Response:
+  - 304 if the feed and the permissions didn't change since the ETag of If-None-Match
+  - 400 if a query parameter is invalid
+  - 404 if http verb is not GET
+*/
+func feedHandler(authMw *middleware.Interceptor[*auth.Context], pol *policy.Policy, worker *archive.Worker, archiveStore *archive.Store, getUsername func() string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+		logger := util.DefaultLogger.FromContext(ctx)
+
+		// this endpoint only supports GET requests
+		if r.Method != http.MethodGet {
+			http.Error(w, "not found", http.StatusNotFound)
+			return
+		}
+
+		authCtx := authMw.Context(ctx)
+
+		query, err := parseFeedQuery(r)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		username := getUsername()
+		logger.Info("retrieving user feed", "id", authCtx.UserID(), "username", authCtx.Username, "org_id", authCtx.OrgID, "feed_username", username, "since", query.Since, "until", query.Until)
+
+		// retrieve music feed from the archive
+		feed, err := archivedFeed(ctx, worker, archiveStore, username, query)
+		if err != nil {
+			logger.Warn("musicbrainz api call failed", "error", err)
+			http.Error(w, "musicbrainz api call failed", http.StatusInternalServerError)
+			return
+		}
+
+		/*
+		   Return the feed and the permissions of the user, so the client knows what it can offer (i.e. updating the selected feed)
+		*/
+		resp := &FeedResponse{
+			Feed:        feed,
+			Permissions: pol.Permissions(authCtx),
+		}
+
+		// clients already holding this exact response get a 304
+		err = etagJSONResponse(w, r, resp)
+		if err != nil {
+			logger.Error("error writing response", "error", err)
+		}
+	}
+}
+
+/*
+Decode the json body of the request into v, as it is read
+
+Returns the status to answer with if the body can't be decoded: 413 if it is larger than allowed by mw.MaxBodySize,
+400 otherwise
+*/
+func decodeJSON(r *http.Request, v any) (int, error) {
+	defer r.Body.Close()
+
+	if err := json.NewDecoder(r.Body).Decode(v); err != nil {
+		var tooLarge *http.MaxBytesError
+		if errors.As(err, &tooLarge) {
+			return http.StatusRequestEntityTooLarge, err
+		}
+		return http.StatusBadRequest, err
+	}
+	return http.StatusOK, nil
 }
 
 func jsonResponse(w http.ResponseWriter, resp any, status int) error {
@@ -214,6 +559,7 @@ func jsonResponse(w http.ResponseWriter, resp any, status int) error {
 }
 
 type FeedResponse struct {
-	WriteAccess bool              `json:"write_access"`
-	Feed        *musicbrainz.Feed `json:"feed"`
+	// effective permissions of the caller
+	Permissions []policy.Permission `json:"permissions"`
+	Feed        *musicbrainz.Feed   `json:"feed"`
 }
@@ -1,13 +1,24 @@
 package app
 
 import (
+	"bytes"
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
 	"sync"
 	"testing"
+
+	"github.com/xaviercrochet/turbo-octo-adventure/api/audit"
+	"github.com/xaviercrochet/turbo-octo-adventure/pkg/auth"
+	mw "github.com/xaviercrochet/turbo-octo-adventure/pkg/middleware"
+	"github.com/xaviercrochet/turbo-octo-adventure/pkg/util"
+	"github.com/zitadel/zitadel-go/v3/pkg/authorization"
+	"github.com/zitadel/zitadel-go/v3/pkg/http/middleware"
 )
 
-func TestSetAndgetSelectedUsername(t *testing.T) {
-	initial := getSelectedUsername()
-	defer setSelectedUsername(initial)
+func TestSetAndGetSelectedFeed(t *testing.T) {
+	feed := newSelectedFeed(defaultSelectedUsername)
 
 	tests := []struct {
 		name     string
@@ -28,10 +39,10 @@ func TestSetAndgetSelectedUsername(t *testing.T) {
 
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			setSelectedUsername(tt.username)
-			result := getSelectedUsername()
+			feed.set(tt.username)
+			result := feed.get()
 			if result != tt.expected {
-				t.Errorf("getSelectedUsername() = %v, expected %v", result, tt.expected)
+				t.Errorf("get() = %v, expected %v", result, tt.expected)
 			}
 		})
 	}
@@ -45,8 +56,7 @@ func TestSetAndgetSelectedUsername(t *testing.T) {
 */
 
 func TestRaceCondition(t *testing.T) {
-	initial := getSelectedUsername()
-	defer setSelectedUsername(initial)
+	feed := newSelectedFeed(defaultSelectedUsername)
 
 	var wg sync.WaitGroup
 	for i := 0; i < 100; i++ {
@@ -54,14 +64,68 @@ func TestRaceCondition(t *testing.T) {
 
 		go func() {
 			defer wg.Done()
-			setSelectedUsername("user1")
+			feed.set("user1")
 		}()
 
 		go func() {
 			defer wg.Done()
-			_ = getSelectedUsername()
+			_ = feed.get()
 		}()
 	}
 
 	wg.Wait()
 }
+
+func TestSelectFeedHandlerLimitsTheBody(t *testing.T) {
+	feed := newSelectedFeed("before")
+	authCtx := &auth.Context{Subject: "user-1", Username: "alice", Active: true}
+	handler := mw.MaxBodySize(32)(selectFeedHandler(middleware.New[*auth.Context](nil), feed, audit.NewLog()))
+
+	tests := []struct {
+		name     string
+		body     string
+		status   int
+		selected string
+	}{
+		{name: "small body", body: `{"name": "after"}`, status: http.StatusOK, selected: "after"},
+		{name: "invalid body", body: `{"name": `, status: http.StatusBadRequest, selected: "after"},
+		{name: "large body", body: `{"name": "` + strings.Repeat("a", 64) + `"}`, status: http.StatusRequestEntityTooLarge, selected: "after"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodPost, "/api/select_feed", strings.NewReader(tt.body))
+			// the size is only known once the body is read
+			req.ContentLength = -1
+			req = req.WithContext(authorization.WithAuthContext(context.Background(), authCtx))
+			rec := httptest.NewRecorder()
+			handler.ServeHTTP(rec, req)
+
+			if rec.Code != tt.status {
+				t.Errorf("status = %d, expected %d: %s", rec.Code, tt.status, rec.Body)
+			}
+			if feed.get() != tt.selected {
+				t.Errorf("get() = %v, expected %v", feed.get(), tt.selected)
+			}
+		})
+	}
+}
+
+func TestRecordUser(t *testing.T) {
+	authMw := middleware.New[*auth.Context](nil)
+	authCtx := &auth.Context{Subject: "user-1", OrgID: "org-1", Active: true}
+
+	var output bytes.Buffer
+	accessLog := &mw.AccessLog{Format: mw.AccessLogCommon, Output: &output}
+	handler := accessLog.Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// set by the authorization middleware
+		r = r.WithContext(authorization.WithAuthContext(r.Context(), authCtx))
+		recordUser(authMw, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})).ServeHTTP(w, r)
+	}))
+	handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/api/feed", nil))
+
+	// hashed by the default logger
+	if expected := " - " + util.DefaultLogger.Redact("user_id", authCtx.UserID()) + " ["; !strings.Contains(output.String(), expected) {
+		t.Errorf("unexpected line %q, expected the user of the request", output.String())
+	}
+}
@@ -0,0 +1,107 @@
+package app
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"slices"
+	"strconv"
+	"time"
+
+	"github.com/xaviercrochet/turbo-octo-adventure/api/archive"
+	"github.com/xaviercrochet/turbo-octo-adventure/api/musicbrainz"
+	"github.com/xaviercrochet/turbo-octo-adventure/pkg/util"
+)
+
+// time range served when none is requested, the same as the musicbrainz api
+const defaultFeedWindow = musicbrainz.MaxWindowMinutes * time.Minute
+
+type ArchiveStatusResponse struct {
+	Users []*archive.SyncState `json:"users"`
+}
+
+/*
+Returns the archived feed of the user matching the query
+
+A user that was never synced, i.e. a feed that was just selected, is synced first. If that sync fails, whatever is
+already archived is served
+*/
+func archivedFeed(ctx context.Context, worker *archive.Worker, store *archive.Store, username string, query archive.Query) (*musicbrainz.Feed, error) {
+	if err := worker.EnsureSynced(ctx, username); err != nil && store.Count(username) == 0 {
+		return nil, err
+	}
+
+	return musicbrainz.NewFeed(username, store.Query(username, query)), nil
+}
+
+// feedGetter serving the default window of the archive
+func archivedFeedGetter(ctx context.Context, worker *archive.Worker, store *archive.Store) feedGetter {
+	return func(username string) (*musicbrainz.Feed, error) {
+		until := time.Now()
+		return archivedFeed(ctx, worker, store, username, archive.Query{Since: until.Add(-defaultFeedWindow), Until: until})
+	}
+}
+
+/*
+Query parameters of /api/feed (all optional):
+  - since, until: RFC3339 timestamps, until defaults to now and since to 5000 minutes before until
+  - limit: maximum number of songs, newest first
+*/
+func parseFeedQuery(r *http.Request) (archive.Query, error) {
+	values := r.URL.Query()
+	query := archive.Query{Until: time.Now()}
+
+	var err error
+	if until := values.Get("until"); until != "" {
+		if query.Until, err = time.Parse(time.RFC3339, until); err != nil {
+			return query, errors.New("until must be a RFC3339 timestamp")
+		}
+	}
+	query.Since = query.Until.Add(-defaultFeedWindow)
+	if since := values.Get("since"); since != "" {
+		if query.Since, err = time.Parse(time.RFC3339, since); err != nil {
+			return query, errors.New("since must be a RFC3339 timestamp")
+		}
+	}
+	if query.Since.After(query.Until) {
+		return query, errors.New("since must be before until")
+	}
+	if limit := values.Get("limit"); limit != "" {
+		if query.Limit, err = strconv.Atoi(limit); err != nil || query.Limit < 1 {
+			return query, errors.New("limit must be a positive integer")
+		}
+	}
+
+	return query, nil
+}
+
+/*
+GET /api/archive/status
+
+Sync state of the users archived for the organisation of the caller: its selected feed and the tracked users. See
+ArchiveStatusResponse.
+
+Response:
+  - 404 if http verb is not GET
+*/
+func archiveStatusHandler(worker *archive.Worker, getUsers func() []string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		logger := util.DefaultLogger.FromContext(r.Context())
+
+		if r.Method != http.MethodGet {
+			http.Error(w, "not found", http.StatusNotFound)
+			return
+		}
+
+		// feeds the organisation selected before are still in the archive, only the ones followed now are reported
+		users := getUsers()
+		states := slices.DeleteFunc(worker.States(), func(state *archive.SyncState) bool {
+			return !slices.Contains(users, state.Username)
+		})
+
+		err := jsonResponse(w, &ArchiveStatusResponse{Users: states}, http.StatusOK)
+		if err != nil {
+			logger.Error("error writing response", "error", err)
+		}
+	}
+}
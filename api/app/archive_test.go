@@ -0,0 +1,53 @@
+package app
+
+import (
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestParseFeedQuery(t *testing.T) {
+	tests := []struct {
+		name  string
+		query string
+		since string
+		until string
+		limit int
+		err   bool
+	}{
+		{
+			name:  "range",
+			query: "?since=2024-01-01T00:00:00Z&until=2024-02-01T00:00:00Z&limit=10",
+			since: "2024-01-01T00:00:00Z",
+			until: "2024-02-01T00:00:00Z",
+			limit: 10,
+		},
+		{
+			name:  "since defaults to the musicbrainz window",
+			query: "?until=2024-02-01T00:00:00Z",
+			since: "2024-01-28T12:40:00Z",
+			until: "2024-02-01T00:00:00Z",
+		},
+		{name: "invalid since", query: "?since=yesterday", err: true},
+		{name: "since after until", query: "?since=2024-02-01T00:00:00Z&until=2024-01-01T00:00:00Z", err: true},
+		{name: "invalid limit", query: "?limit=0", err: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			query, err := parseFeedQuery(httptest.NewRequest("GET", "/api/feed"+tt.query, nil))
+			if tt.err {
+				if err == nil {
+					t.Errorf("parseFeedQuery() = %+v, expected an error", query)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("parseFeedQuery() error = %v", err)
+			}
+			if query.Since.Format(time.RFC3339) != tt.since || query.Until.Format(time.RFC3339) != tt.until || query.Limit != tt.limit {
+				t.Errorf("parseFeedQuery() = %+v, expected %v - %v, limit %v", query, tt.since, tt.until, tt.limit)
+			}
+		})
+	}
+}
@@ -0,0 +1,176 @@
+package app
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/xaviercrochet/turbo-octo-adventure/api/audit"
+	"github.com/xaviercrochet/turbo-octo-adventure/pkg/auth"
+	"github.com/xaviercrochet/turbo-octo-adventure/pkg/util"
+	"github.com/zitadel/zitadel-go/v3/pkg/http/middleware"
+)
+
+/*
+Change the selected feed and record the change in the audit trail
+
+The selection is only changed if the audit entry could be written
+*/
+func changeSelectedUsername(ctx context.Context, feed *selectedFeed, auditLog *audit.Log, authCtx *auth.Context, entry audit.Entry) (*audit.Entry, error) {
+	feed.changeMu.Lock()
+	defer feed.changeMu.Unlock()
+
+	entry.ActorID = authCtx.UserID()
+	entry.ActorType = string(authCtx.CallerType())
+	entry.Username = authCtx.Username
+	entry.OldValue = feed.get()
+	entry.TraceID, _ = ctx.Value(util.TraceIDContextKey).(string)
+	entry.SenderTraceID, _ = ctx.Value(util.SenderTraceIDContextKey).(string)
+
+	recorded, err := auditLog.Append(entry)
+	if err != nil {
+		return nil, err
+	}
+
+	feed.set(entry.NewValue)
+
+	return recorded, nil
+}
+
+type AuditResponse struct {
+	Entries []*audit.Entry `json:"entries"`
+}
+
+type RollbackRequest struct {
+	// id of the audit entry to roll back
+	ID int64 `json:"id"`
+}
+
+// default number of entries returned by /api/audit
+const defaultAuditLimit = 50
+
+/*
+GET /api/audit
+
+Query parameters (all optional):
+  - actor: actor id or username
+  - action: select_feed or rollback
+  - since, until: RFC3339 timestamps
+  - limit: maximum number of entries, newest first (default 50)
+
+Response:
+  - 400 if a parameter is invalid
+  - 404 if http verb is not GET
+*/
+func auditHandler(auditLog *audit.Log) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		logger := util.DefaultLogger.FromContext(r.Context())
+
+		if r.Method != http.MethodGet {
+			http.Error(w, "not found", http.StatusNotFound)
+			return
+		}
+
+		filter, err := parseAuditFilter(r)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		err = jsonResponse(w, &AuditResponse{Entries: auditLog.Query(filter)}, http.StatusOK)
+		if err != nil {
+			logger.Error("error writing response", "error", err)
+		}
+	}
+}
+
+func parseAuditFilter(r *http.Request) (audit.Filter, error) {
+	query := r.URL.Query()
+	filter := audit.Filter{
+		Actor:  query.Get("actor"),
+		Action: query.Get("action"),
+		Limit:  defaultAuditLimit,
+	}
+
+	var err error
+	if since := query.Get("since"); since != "" {
+		if filter.Since, err = time.Parse(time.RFC3339, since); err != nil {
+			return filter, errors.New("since must be a RFC3339 timestamp")
+		}
+	}
+	if until := query.Get("until"); until != "" {
+		if filter.Until, err = time.Parse(time.RFC3339, until); err != nil {
+			return filter, errors.New("until must be a RFC3339 timestamp")
+		}
+	}
+	if limit := query.Get("limit"); limit != "" {
+		if filter.Limit, err = strconv.Atoi(limit); err != nil || filter.Limit < 1 {
+			return filter, errors.New("limit must be a positive integer")
+		}
+	}
+
+	return filter, nil
+}
+
+/*
+POST /api/audit/rollback
+
+Restore the feed that was selected before the change recorded in the given audit entry.
+The rollback is itself recorded in the audit trail
+
+Request body: see RollbackRequest
+
+Response:
+  - 400 if the body is invalid
+  - 404 if http verb is not POST or the entry doesn't exist
+*/
+func rollbackHandler(authMw *middleware.Interceptor[*auth.Context], feed *selectedFeed, auditLog *audit.Log) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+		logger := util.DefaultLogger.FromContext(ctx)
+
+		if r.Method != http.MethodPost {
+			http.Error(w, "not found", http.StatusNotFound)
+			return
+		}
+
+		authCtx := authMw.Context(ctx)
+
+		var rollback RollbackRequest
+		if status, err := decodeJSON(r, &rollback); err != nil {
+			logger.Warn("could not deserialize request body", "id", authCtx.UserID(), "username", authCtx.Username, "error", err)
+			http.Error(w, "failed to deserialize request body", status)
+			return
+		}
+
+		target, err := auditLog.Get(rollback.ID)
+		if errors.Is(err, audit.ErrEntryNotFound) {
+			http.Error(w, "audit entry not found", http.StatusNotFound)
+			return
+		} else if err != nil {
+			logger.Error("could not read audit entry", "error", err)
+			http.Error(w, "could not read audit entry", http.StatusInternalServerError)
+			return
+		}
+
+		entry, err := changeSelectedUsername(ctx, feed, auditLog, authCtx, audit.Entry{
+			Action:     audit.ActionRollback,
+			NewValue:   target.OldValue,
+			RollbackOf: target.ID,
+		})
+		if err != nil {
+			logger.Error("could not record rollback", "error", err)
+			http.Error(w, "could not record rollback", http.StatusInternalServerError)
+			return
+		}
+
+		logger.Info("selected feed rolled back", "id", authCtx.UserID(), "username", authCtx.Username, "rollback_of", target.ID, "feed_username", entry.NewValue)
+
+		err = jsonResponse(w, entry, http.StatusOK)
+		if err != nil {
+			logger.Error("error writing response", "error", err)
+		}
+	}
+}
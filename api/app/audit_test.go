@@ -0,0 +1,123 @@
+package app
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/xaviercrochet/turbo-octo-adventure/api/audit"
+	"github.com/xaviercrochet/turbo-octo-adventure/pkg/auth"
+	"github.com/xaviercrochet/turbo-octo-adventure/pkg/util"
+	"github.com/zitadel/zitadel-go/v3/pkg/authorization"
+	"github.com/zitadel/zitadel-go/v3/pkg/http/middleware"
+)
+
+func TestChangeSelectedUsernameIsAudited(t *testing.T) {
+	feed := newSelectedFeed("before")
+
+	auditLog := audit.NewLog()
+	authCtx := &auth.Context{Subject: "user-1", Username: "alice", Active: true}
+	ctx := context.WithValue(context.Background(), util.TraceIDContextKey, "trace-1")
+
+	entry, err := changeSelectedUsername(ctx, feed, auditLog, authCtx, audit.Entry{Action: audit.ActionSelectFeed, NewValue: "after"})
+	if err != nil {
+		t.Fatalf("changeSelectedUsername() error = %v", err)
+	}
+
+	expected := audit.Entry{
+		ID:        1,
+		Action:    audit.ActionSelectFeed,
+		ActorID:   "user-1",
+		ActorType: "user",
+		Username:  "alice",
+		TraceID:   "trace-1",
+		OldValue:  "before",
+		NewValue:  "after",
+	}
+	entry.Timestamp = expected.Timestamp
+	if *entry != expected {
+		t.Errorf("changeSelectedUsername() = %+v, expected %+v", entry, expected)
+	}
+	if feed.get() != "after" {
+		t.Errorf("get() = %v, expected after", feed.get())
+	}
+}
+
+func TestRollbackHandler(t *testing.T) {
+	feed := newSelectedFeed("a")
+
+	auditLog := audit.NewLog()
+	authCtx := &auth.Context{Subject: "user-1", Username: "alice", Active: true}
+	ctx := authorization.WithAuthContext(context.Background(), authCtx)
+
+	// a -> b -> c
+	for _, value := range []string{"b", "c"} {
+		if _, err := changeSelectedUsername(ctx, feed, auditLog, authCtx, audit.Entry{Action: audit.ActionSelectFeed, NewValue: value}); err != nil {
+			t.Fatalf("changeSelectedUsername() error = %v", err)
+		}
+	}
+
+	handler := rollbackHandler(middleware.New[*auth.Context](nil), feed, auditLog)
+
+	tests := []struct {
+		name     string
+		method   string
+		body     string
+		status   int
+		selected string
+	}{
+		{
+			name:     "roll back the first change",
+			method:   http.MethodPost,
+			body:     `{"id": 1}`,
+			status:   http.StatusOK,
+			selected: "a",
+		},
+		{
+			name:     "unknown entry",
+			method:   http.MethodPost,
+			body:     `{"id": 42}`,
+			status:   http.StatusNotFound,
+			selected: "a",
+		},
+		{
+			name:     "invalid body",
+			method:   http.MethodPost,
+			body:     `{"id": "one"}`,
+			status:   http.StatusBadRequest,
+			selected: "a",
+		},
+		{
+			name:     "GET is not supported",
+			method:   http.MethodGet,
+			status:   http.StatusNotFound,
+			selected: "a",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(tt.method, "/api/audit/rollback", strings.NewReader(tt.body)).WithContext(ctx)
+			rec := httptest.NewRecorder()
+
+			handler.ServeHTTP(rec, req)
+
+			if rec.Code != tt.status {
+				t.Errorf("status = %v, expected %v", rec.Code, tt.status)
+			}
+			if feed.get() != tt.selected {
+				t.Errorf("get() = %v, expected %v", feed.get(), tt.selected)
+			}
+		})
+	}
+
+	// the rollback itself is audited
+	latest := auditLog.Query(audit.Filter{Limit: 1})[0]
+	if latest.Action != audit.ActionRollback || latest.RollbackOf != 1 || latest.OldValue != "c" || latest.NewValue != "a" {
+		data, _ := json.Marshal(latest)
+		t.Errorf("latest audit entry = %s, expected a rollback of entry 1 from c to a", data)
+	}
+}
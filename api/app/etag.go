@@ -0,0 +1,63 @@
+package app
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"net/http"
+	"strings"
+)
+
+// Returns the strong ETag of a response body
+func etag(data []byte) string {
+	sum := sha256.Sum256(data)
+	return `"` + hex.EncodeToString(sum[:16]) + `"`
+}
+
+/*
+Returns true if the If-None-Match header of the request matches the ETag
+
+The comparison is weak (RFC 9110, section 13.1.2): W/"x" matches "x", so the ETags weakened by a compression middleware
+still match
+*/
+func etagMatches(r *http.Request, tag string) bool {
+	header := r.Header.Get("If-None-Match")
+	if strings.TrimSpace(header) == "*" {
+		return true
+	}
+
+	for _, candidate := range strings.Split(header, ",") {
+		if strings.TrimPrefix(strings.TrimSpace(candidate), "W/") == strings.TrimPrefix(tag, "W/") {
+			return true
+		}
+	}
+	return false
+}
+
+/*
+Same as jsonResponse, with an ETag computed over the response, the response isn't sent again to a client holding it
+
+Response:
+  - 304 without body if the If-None-Match header of the request matches the ETag of the response
+*/
+func etagJSONResponse(w http.ResponseWriter, r *http.Request, resp any) error {
+	data, err := json.Marshal(resp)
+	if err != nil {
+		return err
+	}
+
+	tag := etag(data)
+	w.Header().Set("ETag", tag)
+	// the response depends on the caller, shared caches must not keep it and clients must revalidate it
+	w.Header().Set("Cache-Control", "private, no-cache")
+
+	if etagMatches(r, tag) {
+		w.WriteHeader(http.StatusNotModified)
+		return nil
+	}
+
+	w.Header().Set("content-type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	_, err = w.Write(data)
+	return err
+}
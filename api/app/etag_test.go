@@ -0,0 +1,55 @@
+package app
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestEtagJSONResponse(t *testing.T) {
+	resp := &SelectedFeed{Name: "xcrochet"}
+
+	rec := httptest.NewRecorder()
+	if err := etagJSONResponse(rec, httptest.NewRequest(http.MethodGet, "/api/feed", nil), resp); err != nil {
+		t.Fatal(err)
+	}
+	tag := rec.Header().Get("ETag")
+	if rec.Code != http.StatusOK || tag == "" || rec.Body.String() != `{"name":"xcrochet"}` {
+		t.Fatalf("unexpected first response: %d %q %s", rec.Code, tag, rec.Body)
+	}
+	if rec.Header().Get("Cache-Control") != "private, no-cache" {
+		t.Errorf("Cache-Control = %q", rec.Header().Get("Cache-Control"))
+	}
+
+	tests := []struct {
+		name        string
+		ifNoneMatch string
+		resp        any
+		status      int
+	}{
+		{name: "same etag", ifNoneMatch: tag, resp: resp, status: http.StatusNotModified},
+		{name: "weakened by compression", ifNoneMatch: "W/" + tag, resp: resp, status: http.StatusNotModified},
+		{name: "one of several", ifNoneMatch: `"other", ` + tag, resp: resp, status: http.StatusNotModified},
+		{name: "any", ifNoneMatch: "*", resp: resp, status: http.StatusNotModified},
+		{name: "other etag", ifNoneMatch: `"other"`, resp: resp, status: http.StatusOK},
+		{name: "changed response", ifNoneMatch: tag, resp: &SelectedFeed{Name: "rjmunro"}, status: http.StatusOK},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, "/api/feed", nil)
+			req.Header.Set("If-None-Match", tt.ifNoneMatch)
+			rec := httptest.NewRecorder()
+			if err := etagJSONResponse(rec, req, tt.resp); err != nil {
+				t.Fatal(err)
+			}
+
+			if rec.Code != tt.status {
+				t.Errorf("status = %d, expected %d", rec.Code, tt.status)
+			}
+			if tt.status == http.StatusNotModified && (rec.Body.Len() != 0 || rec.Header().Get("ETag") != tag) {
+				t.Errorf("304 should have no body and the etag, got %q %s", rec.Header().Get("ETag"), rec.Body)
+			}
+		})
+	}
+}
@@ -0,0 +1,56 @@
+package app
+
+import (
+	"net/http"
+
+	"github.com/xaviercrochet/turbo-octo-adventure/api/export"
+	"github.com/xaviercrochet/turbo-octo-adventure/api/musicbrainz"
+	"github.com/xaviercrochet/turbo-octo-adventure/pkg/util"
+)
+
+// retrieves the feed of a user, allows tests to replace the musicbrainz api
+type feedGetter func(username string) (*musicbrainz.Feed, error)
+
+/*
+GET /api/feed/export?format=csv|jsonl|xspf|jspf
+
+Stream the selected feed as a file download.
+
+Response:
+  - 400 if the format is missing or unknown
+  - 404 if http verb is not GET
+  - 500 if the musicbrainz api call failed
+*/
+func exportHandler(getFeed feedGetter, getUsername func() string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		logger := util.DefaultLogger.FromContext(r.Context())
+
+		if r.Method != http.MethodGet {
+			http.Error(w, "not found", http.StatusNotFound)
+			return
+		}
+
+		format, err := export.ParseFormat(r.URL.Query().Get("format"))
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		username := getUsername()
+		feed, err := getFeed(username)
+		if err != nil {
+			logger.Warn("musicbrainz api call failed", "error", err)
+			http.Error(w, "musicbrainz api call failed", http.StatusInternalServerError)
+			return
+		}
+
+		w.Header().Set("Content-Type", format.ContentType())
+		w.Header().Set("Content-Disposition", format.ContentDisposition(username))
+		w.WriteHeader(http.StatusOK)
+
+		// the status is already sent, a failure can only be logged
+		if err := export.Write(w, format, feed); err != nil {
+			logger.Error("error writing export", "format", format, "error", err)
+		}
+	}
+}
@@ -0,0 +1,107 @@
+package app
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/xaviercrochet/turbo-octo-adventure/api/musicbrainz"
+)
+
+func TestExportHandler(t *testing.T) {
+	listenedAt := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+	getFeed := func(username string) (*musicbrainz.Feed, error) {
+		return &musicbrainz.Feed{
+			Username: username,
+			Songs: []*musicbrainz.Song{
+				{ID: "1", Title: "Song 1", Published: listenedAt, Updated: listenedAt, ListenedAt: listenedAt},
+			},
+		}, nil
+	}
+	failing := func(username string) (*musicbrainz.Feed, error) {
+		return nil, errors.New("musicbrainz is down")
+	}
+
+	tests := []struct {
+		name        string
+		method      string
+		query       string
+		getFeed     feedGetter
+		status      int
+		contentType string
+		body        string
+	}{
+		{
+			name:        "csv",
+			method:      http.MethodGet,
+			query:       "?format=csv",
+			getFeed:     getFeed,
+			status:      http.StatusOK,
+			contentType: "text/csv; charset=utf-8",
+			body:        "title,listened_at\nSong 1,2024-01-01T12:00:00Z\n",
+		},
+		{
+			name:        "jsonl",
+			method:      http.MethodGet,
+			query:       "?format=jsonl",
+			getFeed:     getFeed,
+			status:      http.StatusOK,
+			contentType: "application/jsonl; charset=utf-8",
+			body:        "{\"id\":\"1\",\"title\":\"Song 1\",\"published\":\"2024-01-01T12:00:00Z\",\"updated\":\"2024-01-01T12:00:00Z\",\"listened_at\":\"2024-01-01T12:00:00Z\"}\n",
+		},
+		{
+			name:    "unknown format",
+			method:  http.MethodGet,
+			query:   "?format=m3u",
+			getFeed: getFeed,
+			status:  http.StatusBadRequest,
+		},
+		{
+			name:    "missing format",
+			method:  http.MethodGet,
+			getFeed: getFeed,
+			status:  http.StatusBadRequest,
+		},
+		{
+			name:    "musicbrainz failure",
+			method:  http.MethodGet,
+			query:   "?format=csv",
+			getFeed: failing,
+			status:  http.StatusInternalServerError,
+		},
+		{
+			name:    "POST is not supported",
+			method:  http.MethodPost,
+			query:   "?format=csv",
+			getFeed: getFeed,
+			status:  http.StatusNotFound,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(tt.method, "/api/feed/export"+tt.query, nil)
+			rec := httptest.NewRecorder()
+
+			exportHandler(tt.getFeed, func() string { return "xcrochet" }).ServeHTTP(rec, req)
+
+			if rec.Code != tt.status {
+				t.Fatalf("status = %v, expected %v", rec.Code, tt.status)
+			}
+			if tt.status != http.StatusOK {
+				return
+			}
+			if got := rec.Header().Get("Content-Type"); got != tt.contentType {
+				t.Errorf("Content-Type = %v, expected %v", got, tt.contentType)
+			}
+			if got := rec.Header().Get("Content-Disposition"); got == "" {
+				t.Error("Content-Disposition is not set")
+			}
+			if rec.Body.String() != tt.body {
+				t.Errorf("body = %q, expected %q", rec.Body.String(), tt.body)
+			}
+		})
+	}
+}
@@ -0,0 +1,68 @@
+package app
+
+import (
+	"net/http"
+
+	"github.com/xaviercrochet/turbo-octo-adventure/pkg/auth"
+	"github.com/xaviercrochet/turbo-octo-adventure/pkg/util"
+	"github.com/zitadel/zitadel-go/v3/pkg/http/middleware"
+)
+
+type LogLevelRequest struct {
+	// debug, info, warn or error
+	Level string `json:"level"`
+}
+
+type LogLevelResponse struct {
+	Level string `json:"level"`
+}
+
+/*
+/api/admin/log_level
+
+Level of the logs of the api, changed without restarting it. The level is the one of the whole process, every
+organisation is affected.
+
+  - GET returns the current level
+  - PUT changes it, see LogLevelRequest
+
+Response:
+  - 400 if the level is unknown
+  - 404 if http verb is neither GET nor PUT
+*/
+func logLevelHandler(authMw *middleware.Interceptor[*auth.Context], logger *util.Logger) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+		requestLogger := util.DefaultLogger.FromContext(ctx)
+		authCtx := authMw.Context(ctx)
+
+		switch r.Method {
+		case http.MethodGet:
+		case http.MethodPut:
+			var request LogLevelRequest
+			if status, err := decodeJSON(r, &request); err != nil {
+				requestLogger.Warn("could not deserialize request body", "error", err)
+				http.Error(w, "could not deserialize request body", status)
+				return
+			}
+			level, err := util.ParseLogLevel(request.Level)
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+
+			previous := logger.Level()
+			logger.SetLevel(level)
+			// logged above the new level, so the change is always recorded
+			requestLogger.Warn("log level changed", append(authCtx.LogAttrs(), "old_level", previous, "new_level", level)...)
+		default:
+			http.Error(w, "not found", http.StatusNotFound)
+			return
+		}
+
+		err := jsonResponse(w, &LogLevelResponse{Level: logger.Level().String()}, http.StatusOK)
+		if err != nil {
+			requestLogger.Error("error writing response", "error", err)
+		}
+	}
+}
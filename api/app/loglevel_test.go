@@ -0,0 +1,57 @@
+package app
+
+import (
+	"context"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/xaviercrochet/turbo-octo-adventure/pkg/auth"
+	"github.com/xaviercrochet/turbo-octo-adventure/pkg/util"
+	"github.com/zitadel/zitadel-go/v3/pkg/authorization"
+	"github.com/zitadel/zitadel-go/v3/pkg/http/middleware"
+)
+
+func TestLogLevelHandler(t *testing.T) {
+	logger, _, err := util.NewLoggerWithConfig(&util.LogConfig{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	handler := logLevelHandler(middleware.New[*auth.Context](nil), logger)
+	ctx := authorization.WithAuthContext(context.Background(), &auth.Context{Subject: "user-1", Active: true})
+
+	tests := []struct {
+		name   string
+		method string
+		body   string
+		status int
+		level  slog.Level
+	}{
+		{name: "current level", method: http.MethodGet, status: http.StatusOK, level: slog.LevelInfo},
+		{name: "debug", method: http.MethodPut, body: `{"level": "debug"}`, status: http.StatusOK, level: slog.LevelDebug},
+		{name: "case insensitive", method: http.MethodPut, body: `{"level": "WARN"}`, status: http.StatusOK, level: slog.LevelWarn},
+		{name: "unknown level", method: http.MethodPut, body: `{"level": "loud"}`, status: http.StatusBadRequest, level: slog.LevelWarn},
+		{name: "invalid body", method: http.MethodPut, body: `{`, status: http.StatusBadRequest, level: slog.LevelWarn},
+		{name: "unsupported verb", method: http.MethodDelete, status: http.StatusNotFound, level: slog.LevelWarn},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(tt.method, "/api/admin/log_level", strings.NewReader(tt.body)).WithContext(ctx)
+			rec := httptest.NewRecorder()
+			handler.ServeHTTP(rec, req)
+
+			if rec.Code != tt.status {
+				t.Errorf("status = %d, expected %d: %s", rec.Code, tt.status, rec.Body)
+			}
+			if tt.status == http.StatusOK && !strings.Contains(rec.Body.String(), `"level":"`+tt.level.String()+`"`) {
+				t.Errorf("body = %s, expected level %v", rec.Body, tt.level)
+			}
+			if logger.Level() != tt.level {
+				t.Errorf("Level() = %v, expected %v", logger.Level(), tt.level)
+			}
+		})
+	}
+}
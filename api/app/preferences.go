@@ -0,0 +1,58 @@
+package app
+
+import (
+	"net/http"
+
+	"github.com/xaviercrochet/turbo-octo-adventure/pkg/auth"
+	"github.com/xaviercrochet/turbo-octo-adventure/pkg/preferences"
+	"github.com/xaviercrochet/turbo-octo-adventure/pkg/util"
+	"github.com/zitadel/zitadel-go/v3/pkg/http/middleware"
+)
+
+/*
+/api/preferences
+
+Display preferences of the caller, see preferences.Preferences
+  - GET: returns the preferences, the defaults if they were never changed
+  - PUT: replaces the preferences
+
+Response: the preferences
+  - 400 if the body is not valid json or a preference is invalid
+  - 404 if http verb is not supported
+*/
+func preferencesHandler(authMw *middleware.Interceptor[*auth.Context], store *preferences.Store) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+		logger := util.DefaultLogger.FromContext(ctx)
+		authCtx := authMw.Context(ctx)
+
+		switch r.Method {
+		case http.MethodGet:
+		case http.MethodPut:
+			var prefs preferences.Preferences
+			if status, err := decodeJSON(r, &prefs); err != nil {
+				logger.Warn("could not deserialize request body", "error", err)
+				http.Error(w, "could not deserialize request body", status)
+				return
+			}
+			if err := prefs.Validate(); err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+			if err := store.Set(authCtx.UserID(), &prefs); err != nil {
+				logger.Error("preferences update failed", "id", authCtx.UserID(), "error", err)
+				http.Error(w, "preferences update failed", http.StatusInternalServerError)
+				return
+			}
+			logger.Info("preferences updated", "id", authCtx.UserID(), "username", authCtx.Username, "timezone", prefs.Timezone)
+		default:
+			http.Error(w, "not found", http.StatusNotFound)
+			return
+		}
+
+		err := jsonResponse(w, store.Get(authCtx.UserID()), http.StatusOK)
+		if err != nil {
+			logger.Error("error writing response", "error", err)
+		}
+	}
+}
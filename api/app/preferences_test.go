@@ -0,0 +1,59 @@
+package app
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/xaviercrochet/turbo-octo-adventure/pkg/auth"
+	"github.com/xaviercrochet/turbo-octo-adventure/pkg/preferences"
+	"github.com/zitadel/zitadel-go/v3/pkg/authorization"
+	"github.com/zitadel/zitadel-go/v3/pkg/http/middleware"
+)
+
+func TestPreferencesHandler(t *testing.T) {
+	store := preferences.NewStore()
+	handler := preferencesHandler(middleware.New[*auth.Context](nil), store)
+
+	serve := func(userID, method, body string) *httptest.ResponseRecorder {
+		ctx := authorization.WithAuthContext(context.Background(), &auth.Context{Subject: userID, Username: userID, Active: true})
+		req := httptest.NewRequest(method, "/api/preferences", strings.NewReader(body)).WithContext(ctx)
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		return rec
+	}
+
+	rec := serve("user-1", http.MethodPut, `{"timezone": "Europe/Brussels", "clock": "12h", "date_format": "eu", "language": "fr"}`)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("PUT: status = %v, expected %v", rec.Code, http.StatusOK)
+	}
+
+	if rec := serve("user-1", http.MethodPut, `{"timezone": "Europe/Nowhere", "clock": "12h", "date_format": "eu"}`); rec.Code != http.StatusBadRequest {
+		t.Errorf("PUT invalid timezone: status = %v, expected %v", rec.Code, http.StatusBadRequest)
+	}
+	if rec := serve("user-1", http.MethodDelete, ""); rec.Code != http.StatusNotFound {
+		t.Errorf("DELETE: status = %v, expected %v", rec.Code, http.StatusNotFound)
+	}
+
+	// preferences are per user
+	tests := []struct {
+		userID   string
+		expected preferences.Preferences
+	}{
+		{userID: "user-1", expected: preferences.Preferences{Timezone: "Europe/Brussels", Clock: "12h", DateFormat: "eu", Language: "fr"}},
+		{userID: "user-2", expected: *preferences.Default()},
+	}
+	for _, tt := range tests {
+		rec := serve(tt.userID, http.MethodGet, "")
+		var got preferences.Preferences
+		if err := json.NewDecoder(rec.Body).Decode(&got); err != nil {
+			t.Fatalf("GET: invalid body: %v", err)
+		}
+		if got != tt.expected {
+			t.Errorf("GET %v = %+v, expected %+v", tt.userID, got, tt.expected)
+		}
+	}
+}
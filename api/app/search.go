@@ -0,0 +1,109 @@
+package app
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/xaviercrochet/turbo-octo-adventure/api/archive"
+	"github.com/xaviercrochet/turbo-octo-adventure/api/search"
+	"github.com/xaviercrochet/turbo-octo-adventure/pkg/auth"
+	"github.com/xaviercrochet/turbo-octo-adventure/pkg/preferences"
+	"github.com/xaviercrochet/turbo-octo-adventure/pkg/util"
+	"github.com/zitadel/zitadel-go/v3/pkg/http/middleware"
+)
+
+// number of search results returned when no limit is requested
+const defaultSearchLimit = 50
+
+type SearchResponse struct {
+	Username string           `json:"username"`
+	Results  []*search.Result `json:"results"`
+}
+
+/*
+Query parameters of /api/search:
+  - q: the query, see search.Query for the syntax. Days are in the timezone of the caller
+  - since, until (optional): RFC3339 timestamps narrowing the after: and before: operators of the query
+  - limit (optional): maximum number of results, 50 by default
+*/
+func parseSearchQuery(r *http.Request, location *time.Location) (*search.Query, int, error) {
+	values := r.URL.Query()
+
+	query, err := search.ParseQuery(values.Get("q"), location)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	if since := values.Get("since"); since != "" {
+		at, err := time.Parse(time.RFC3339, since)
+		if err != nil {
+			return nil, 0, errors.New("since must be a RFC3339 timestamp")
+		}
+		if at.After(query.Since) {
+			query.Since = at
+		}
+	}
+	if until := values.Get("until"); until != "" {
+		at, err := time.Parse(time.RFC3339, until)
+		if err != nil {
+			return nil, 0, errors.New("until must be a RFC3339 timestamp")
+		}
+		if query.Until.IsZero() || at.Before(query.Until) {
+			query.Until = at
+		}
+	}
+	if !query.Since.IsZero() && !query.Until.IsZero() && query.Since.After(query.Until) {
+		return nil, 0, errors.New("since must be before until")
+	}
+
+	limit := defaultSearchLimit
+	if value := values.Get("limit"); value != "" {
+		if limit, err = strconv.Atoi(value); err != nil || limit < 1 {
+			return nil, 0, errors.New("limit must be a positive integer")
+		}
+	}
+
+	return query, limit, nil
+}
+
+/*
+GET /api/search?q=
+
+Search the archived listens of the selected feed, most relevant first. See parseSearchQuery and SearchResponse.
+
+Response:
+  - 400 if a query parameter is invalid
+  - 404 if http verb is not GET
+*/
+func searchHandler(authMw *middleware.Interceptor[*auth.Context], prefs *preferences.Store, index *search.Index, worker *archive.Worker, getUsername func() string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+		logger := util.DefaultLogger.FromContext(ctx)
+
+		if r.Method != http.MethodGet {
+			http.Error(w, "not found", http.StatusNotFound)
+			return
+		}
+
+		location := prefs.Get(authMw.Context(ctx).UserID()).Location()
+		query, limit, err := parseSearchQuery(r, location)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		username := getUsername()
+		// a feed that was just selected is not archived yet, search whatever is archived if the sync fails
+		if err := worker.EnsureSynced(ctx, username); err != nil {
+			logger.Warn("could not sync the archive before searching", "username", username, "error", err)
+		}
+
+		resp := &SearchResponse{Username: username, Results: index.Search(username, query, limit)}
+		err = jsonResponse(w, resp, http.StatusOK)
+		if err != nil {
+			logger.Error("error writing response", "error", err)
+		}
+	}
+}
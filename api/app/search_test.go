@@ -0,0 +1,55 @@
+package app
+
+import (
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestParseSearchQuery(t *testing.T) {
+	tests := []struct {
+		name  string
+		query string
+		since string
+		until string
+		limit int
+		err   bool
+	}{
+		{
+			name:  "defaults",
+			query: "?q=daft+punk",
+			since: "0001-01-01T00:00:00Z",
+			until: "0001-01-01T00:00:00Z",
+			limit: defaultSearchLimit,
+		},
+		{
+			name:  "the narrowest range wins",
+			query: "?q=punk+after:2024-01-01+before:2024-01-31&since=2024-01-15T00:00:00Z&until=2024-02-15T00:00:00Z&limit=5",
+			since: "2024-01-15T00:00:00Z",
+			until: "2024-01-31T23:59:59Z",
+			limit: 5,
+		},
+		{name: "missing query", query: "?since=2024-01-15T00:00:00Z", err: true},
+		{name: "invalid until", query: "?q=punk&until=tomorrow", err: true},
+		{name: "since after until", query: "?q=punk&since=2024-02-01T00:00:00Z&until=2024-01-01T00:00:00Z", err: true},
+		{name: "invalid limit", query: "?q=punk&limit=-1", err: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			query, limit, err := parseSearchQuery(httptest.NewRequest("GET", "/api/search"+tt.query, nil), time.UTC)
+			if tt.err {
+				if err == nil {
+					t.Errorf("parseSearchQuery() = %+v, expected an error", query)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("parseSearchQuery() error = %v", err)
+			}
+			if query.Since.Format(time.RFC3339) != tt.since || query.Until.Format(time.RFC3339) != tt.until || limit != tt.limit {
+				t.Errorf("parseSearchQuery() = %+v, %v, expected %v - %v, limit %v", query, limit, tt.since, tt.until, tt.limit)
+			}
+		})
+	}
+}
@@ -0,0 +1,170 @@
+package app
+
+import (
+	"errors"
+	"net/http"
+	"net/url"
+	"time"
+
+	"github.com/xaviercrochet/turbo-octo-adventure/api/subscription"
+	"github.com/xaviercrochet/turbo-octo-adventure/api/syndication"
+	"github.com/xaviercrochet/turbo-octo-adventure/api/tenant"
+	"github.com/xaviercrochet/turbo-octo-adventure/pkg/auth"
+	mw "github.com/xaviercrochet/turbo-octo-adventure/pkg/middleware"
+	"github.com/xaviercrochet/turbo-octo-adventure/pkg/util"
+	"github.com/zitadel/zitadel-go/v3/pkg/http/middleware"
+)
+
+type SubscriptionResponse struct {
+	// secret, grants read access to the feed
+	Token     string    `json:"token"`
+	CreatedAt time.Time `json:"created_at"`
+	// paths of the syndication documents, including the token
+	AtomPath string `json:"atom_path"`
+	RSSPath  string `json:"rss_path"`
+}
+
+func newSubscriptionResponse(sub *subscription.Subscription, token string) *SubscriptionResponse {
+	query := url.Values{}
+	query.Set("token", token)
+
+	return &SubscriptionResponse{
+		Token:     token,
+		CreatedAt: sub.CreatedAt,
+		AtomPath:  "/api/feed.atom?" + query.Encode(),
+		RSSPath:   "/api/feed.rss?" + query.Encode(),
+	}
+}
+
+/*
+/api/subscription
+
+Manage the subscription of the caller within its organisation, see subscription.Store
+  - GET: returns the current subscription
+  - POST: creates a new subscription, revoking the previous one
+  - DELETE: revokes the subscription
+
+Response: see SubscriptionResponse, "OK" for DELETE
+  - 404 if the caller has no subscription (GET, DELETE) or the http verb is not supported
+*/
+func subscriptionHandler(authMw *middleware.Interceptor[*auth.Context], store *subscription.Store, orgID tenant.ID) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+		logger := util.DefaultLogger.FromContext(ctx)
+		authCtx := authMw.Context(ctx)
+
+		var (
+			sub   *subscription.Subscription
+			token string
+			err   error
+		)
+
+		switch r.Method {
+		case http.MethodGet:
+			sub, token, err = store.Get(string(orgID), authCtx.UserID())
+		case http.MethodPost:
+			sub, token, err = store.Create(string(orgID), authCtx.UserID(), authCtx.Username)
+			if err == nil {
+				logger.Info("subscription created", "id", authCtx.UserID(), "username", authCtx.Username)
+			}
+		case http.MethodDelete:
+			err = store.Revoke(string(orgID), authCtx.UserID())
+			if err == nil {
+				logger.Info("subscription revoked", "id", authCtx.UserID(), "username", authCtx.Username)
+				if err := jsonResponse(w, "OK", http.StatusOK); err != nil {
+					logger.Error("error writing response", "error", err)
+				}
+				return
+			}
+		default:
+			http.Error(w, "not found", http.StatusNotFound)
+			return
+		}
+
+		if errors.Is(err, subscription.ErrNotFound) {
+			http.Error(w, "subscription not found", http.StatusNotFound)
+			return
+		} else if err != nil {
+			logger.Error("subscription update failed", "id", authCtx.UserID(), "error", err)
+			http.Error(w, "subscription update failed", http.StatusInternalServerError)
+			return
+		}
+
+		err = jsonResponse(w, newSubscriptionResponse(sub, token), http.StatusOK)
+		if err != nil {
+			logger.Error("error writing response", "error", err)
+		}
+	}
+}
+
+/*
+GET /api/feed.atom?token=<token>, GET /api/feed.rss?token=<token>
+
+Render the selected feed as a syndication document. Feed readers can't log in, so the caller is authenticated by the
+subscription token instead of an access token. The feed is the one selected by the organisation of the subscription
+
+Response:
+  - 401 if the token is missing, invalid or revoked
+  - 404 if http verb is not GET
+  - 500 if the musicbrainz api call failed
+*/
+func syndicationHandler(store *subscription.Store, tenants *tenant.Registry[*tenantState], atom bool) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		logger := util.DefaultLogger.FromContext(r.Context())
+
+		if r.Method != http.MethodGet {
+			http.Error(w, "not found", http.StatusNotFound)
+			return
+		}
+
+		sub, err := store.Verify(r.URL.Query().Get("token"))
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusUnauthorized)
+			return
+		}
+		mw.SetAccessLogUser(r.Context(), sub.UserID)
+
+		// the organisation is part of the signed token, it can be trusted
+		id, err := tenant.Parse(sub.OrgID)
+		if err != nil {
+			http.Error(w, subscription.ErrInvalidToken.Error(), http.StatusUnauthorized)
+			return
+		}
+		t, err := tenants.Get(id)
+		if err != nil {
+			logger.Error("could not load the organisation", "org_id", id, "error", err)
+			http.Error(w, "could not load the organisation", http.StatusInternalServerError)
+			return
+		}
+
+		username := t.feed.get()
+		logger.Info("retrieving user feed", "id", sub.UserID, "username", sub.Username, "org_id", id, "feed_username", username, "subscription", true)
+
+		feed, err := t.getFeed(username)
+		if err != nil {
+			logger.Warn("musicbrainz api call failed", "error", err)
+			http.Error(w, "musicbrainz api call failed", http.StatusInternalServerError)
+			return
+		}
+
+		if atom {
+			w.Header().Set("Content-Type", syndication.AtomContentType)
+			err = syndication.WriteAtom(w, feed, requestURL(r))
+		} else {
+			w.Header().Set("Content-Type", syndication.RSSContentType)
+			err = syndication.WriteRSS(w, feed)
+		}
+		if err != nil {
+			logger.Error("error writing syndication document", "error", err)
+		}
+	}
+}
+
+// absolute url of the request, as seen by the client
+func requestURL(r *http.Request) string {
+	scheme := "http"
+	if r.TLS != nil {
+		scheme = "https"
+	}
+	return scheme + "://" + r.Host + r.URL.RequestURI()
+}
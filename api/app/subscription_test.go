@@ -0,0 +1,119 @@
+package app
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/xaviercrochet/turbo-octo-adventure/api/musicbrainz"
+	"github.com/xaviercrochet/turbo-octo-adventure/api/subscription"
+	"github.com/xaviercrochet/turbo-octo-adventure/api/syndication"
+	"github.com/xaviercrochet/turbo-octo-adventure/api/tenant"
+)
+
+func TestSyndicationHandler(t *testing.T) {
+	store := subscription.NewStore([]byte("secret"))
+	_, token, err := store.Create("org-1", "user-1", "alice")
+	if err != nil {
+		t.Fatalf("Create() error = %v", err)
+	}
+	_, revoked, err := store.Create("org-1", "user-2", "bob")
+	if err != nil {
+		t.Fatalf("Create() error = %v", err)
+	}
+	if err := store.Revoke("org-1", "user-2"); err != nil {
+		t.Fatalf("Revoke() error = %v", err)
+	}
+	// the same user in another organisation, which selected another feed
+	_, otherOrg, err := store.Create("org-2", "user-1", "alice")
+	if err != nil {
+		t.Fatalf("Create() error = %v", err)
+	}
+
+	var requested string
+	getFeed := func(username string) (*musicbrainz.Feed, error) {
+		requested = username
+		return &musicbrainz.Feed{Username: username, Songs: []*musicbrainz.Song{}}, nil
+	}
+
+	tenants := testTenants()
+	for id, username := range map[tenant.ID]string{"org-1": "xcrochet", "org-2": "rjmunro"} {
+		state, _ := tenants.Get(id)
+		state.feed.set(username)
+		state.getFeed = getFeed
+	}
+
+	tests := []struct {
+		name        string
+		atom        bool
+		token       string
+		status      int
+		contentType string
+		feed        string
+	}{
+		{
+			name:        "atom",
+			atom:        true,
+			token:       token,
+			status:      http.StatusOK,
+			contentType: syndication.AtomContentType,
+			feed:        "xcrochet",
+		},
+		{
+			name:        "rss",
+			token:       token,
+			status:      http.StatusOK,
+			contentType: syndication.RSSContentType,
+			feed:        "xcrochet",
+		},
+		{
+			name:        "feed of the organisation of the subscription",
+			atom:        true,
+			token:       otherOrg,
+			status:      http.StatusOK,
+			contentType: syndication.AtomContentType,
+			feed:        "rjmunro",
+		},
+		{
+			name:   "missing token",
+			atom:   true,
+			status: http.StatusUnauthorized,
+		},
+		{
+			name:   "revoked token",
+			atom:   true,
+			token:  revoked,
+			status: http.StatusUnauthorized,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			query := url.Values{}
+			query.Set("token", tt.token)
+			req := httptest.NewRequest(http.MethodGet, "/api/feed.atom?"+query.Encode(), nil)
+			rec := httptest.NewRecorder()
+
+			requested = ""
+			syndicationHandler(store, tenants, tt.atom).ServeHTTP(rec, req)
+
+			if rec.Code != tt.status {
+				t.Fatalf("status = %v, expected %v", rec.Code, tt.status)
+			}
+			if tt.status != http.StatusOK {
+				return
+			}
+			if requested != tt.feed {
+				t.Errorf("served the feed of %v, expected %v", requested, tt.feed)
+			}
+			if got := rec.Header().Get("Content-Type"); got != tt.contentType {
+				t.Errorf("Content-Type = %v, expected %v", got, tt.contentType)
+			}
+			if !strings.HasPrefix(rec.Body.String(), "<?xml") {
+				t.Errorf("body = %v, expected a xml document", rec.Body.String())
+			}
+		})
+	}
+}
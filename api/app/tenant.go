@@ -0,0 +1,193 @@
+package app
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"slices"
+	"sync"
+
+	"github.com/xaviercrochet/turbo-octo-adventure/api/archive"
+	"github.com/xaviercrochet/turbo-octo-adventure/api/audit"
+	"github.com/xaviercrochet/turbo-octo-adventure/api/search"
+	"github.com/xaviercrochet/turbo-octo-adventure/api/tenant"
+	"github.com/xaviercrochet/turbo-octo-adventure/api/webhook"
+	"github.com/xaviercrochet/turbo-octo-adventure/pkg/auth"
+	"github.com/xaviercrochet/turbo-octo-adventure/pkg/preferences"
+	"github.com/xaviercrochet/turbo-octo-adventure/pkg/util"
+	"github.com/zitadel/zitadel-go/v3/pkg/http/middleware"
+)
+
+// feed selected by an organisation until one of its admins selects another one
+const defaultSelectedUsername = "xcrochet"
+
+// username from which the feed of an organisation is retrieved from the musicbrainz api, kept in memory
+type selectedFeed struct {
+	rwmu     sync.RWMutex
+	username string
+
+	// serialize changes, so the old value recorded in the audit trail is always accurate
+	changeMu sync.Mutex
+}
+
+func newSelectedFeed(username string) *selectedFeed {
+	return &selectedFeed{username: username}
+}
+
+// updates the username used for MusicBrainz requests
+func (f *selectedFeed) set(username string) {
+	f.rwmu.Lock()
+	f.username = username
+	f.rwmu.Unlock()
+}
+
+// returns the currently selected username
+func (f *selectedFeed) get() string {
+	f.rwmu.RLock()
+	defer f.rwmu.RUnlock()
+	return f.username
+}
+
+// the feed selected by the last change in the audit trail, so the selection survives restarts
+func lastSelectedUsername(auditLog *audit.Log) string {
+	if last := auditLog.Query(audit.Filter{Limit: 1}); len(last) > 0 {
+		return last[0].NewValue
+	}
+	return defaultSelectedUsername
+}
+
+/*
+State of an organisation
+
+Api keys and subscriptions are the exception: they are looked up by their token before the organisation is known, their
+stores are shared and keyed by organisation instead
+*/
+type tenantState struct {
+	id       tenant.ID
+	feed     *selectedFeed
+	auditLog *audit.Log
+	prefs    *preferences.Store
+	// new listens of the selected feed are sent to the webhooks of the organisation
+	webhooks   *webhook.Registry
+	dispatcher *webhook.Dispatcher
+	// listens of the users followed by the organisation, its selected feed and the tracked users
+	archive *archive.Store
+	index   *search.Index
+	worker  *archive.Worker
+	getFeed feedGetter
+}
+
+// users whose listens are archived for the organisation
+func (t *tenantState) users(trackedUsers []string) func() []string {
+	return func() []string {
+		users := append([]string{t.feed.get()}, trackedUsers...)
+		slices.Sort(users)
+		return slices.Compact(users)
+	}
+}
+
+/*
+Returns the function creating the state of an organisation, see tenant.Registry
+
+Files are derived from the ones of the options, one per organisation. The background work of the organisation stops
+with ctx
+*/
+func newTenantState(ctx context.Context, options *ServerOptions) func(id tenant.ID) (*tenantState, error) {
+	return func(id tenant.ID) (*tenantState, error) {
+		var err error
+
+		// every change of the selected feed is recorded here
+		auditLog := audit.NewLog()
+		if path := tenant.File(options.auditFile, id); path != "" {
+			if auditLog, err = audit.OpenLog(path); err != nil {
+				return nil, fmt.Errorf("could not open audit trail: %v", err)
+			}
+		}
+
+		// display preferences of the users
+		prefs := preferences.NewStore()
+		if path := tenant.File(options.preferencesFile, id); path != "" {
+			if prefs, err = preferences.OpenStore(path); err != nil {
+				auditLog.Close()
+				return nil, fmt.Errorf("could not open preferences: %v", err)
+			}
+		}
+
+		// listens are archived in the background, so history older than the musicbrainz window is kept
+		archiveStore := archive.NewStore()
+		if path := tenant.File(options.archiveFile, id); path != "" {
+			if archiveStore, err = archive.OpenStore(path); err != nil {
+				auditLog.Close()
+				return nil, fmt.Errorf("could not open archive: %v", err)
+			}
+		}
+
+		// only once everything is opened, so a failed creation leaves nothing behind
+		go func() {
+			<-ctx.Done()
+			auditLog.Close()
+			archiveStore.Close()
+		}()
+
+		// archived listens are indexed as they are stored, so they can be searched
+		index := search.NewIndex()
+		for _, username := range archiveStore.Users() {
+			index.Add(username, archiveStore.Query(username, archive.Query{}))
+		}
+		archiveStore.OnUpsert(index.Add)
+
+		t := &tenantState{
+			id:         id,
+			feed:       newSelectedFeed(lastSelectedUsername(auditLog)),
+			auditLog:   auditLog,
+			prefs:      prefs,
+			webhooks:   webhook.NewRegistry(),
+			dispatcher: webhook.NewDispatcher(),
+			archive:    archiveStore,
+			index:      index,
+		}
+
+		t.worker = archive.NewWorker(archiveStore, options.musicbrainz.GetListens, t.users(options.trackedUsers), options.archiveSyncInterval,
+			archive.WithDuplicateTolerance(options.duplicateTolerance))
+		go t.worker.Run(ctx)
+		t.getFeed = archivedFeedGetter(ctx, t.worker, archiveStore)
+
+		poller := webhook.NewPoller(t.webhooks, t.dispatcher, options.musicbrainz.GetFeedXml, t.feed.get, options.webhookPollInterval)
+		go poller.Run(ctx)
+
+		util.DefaultLogger.Info("organisation state created", "org_id", id)
+		return t, nil
+	}
+}
+
+/*
+Serve the request with the handler built for the organisation of the caller
+
+The organisation comes from the verified token of the caller, and the handler is only given the state of that
+organisation, so it can't reach the state of another one
+
+Response:
+  - 403 if the caller doesn't belong to an organisation
+*/
+func perTenant(authMw *middleware.Interceptor[*auth.Context], tenants *tenant.Registry[*tenantState], handler func(t *tenantState) http.Handler) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		logger := util.DefaultLogger.FromContext(r.Context())
+		authCtx := authMw.Context(r.Context())
+
+		id, err := tenant.Of(authCtx)
+		if err != nil {
+			logger.Warn("caller rejected", append(authCtx.LogAttrs(), "error", err)...)
+			http.Error(w, err.Error(), http.StatusForbidden)
+			return
+		}
+
+		t, err := tenants.Get(id)
+		if err != nil {
+			logger.Error("could not load the organisation", "org_id", id, "error", err)
+			http.Error(w, "could not load the organisation", http.StatusInternalServerError)
+			return
+		}
+
+		handler(t).ServeHTTP(w, r)
+	}
+}
@@ -0,0 +1,214 @@
+package app
+
+import (
+	"context"
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/xaviercrochet/turbo-octo-adventure/api/audit"
+	"github.com/xaviercrochet/turbo-octo-adventure/api/musicbrainz"
+	"github.com/xaviercrochet/turbo-octo-adventure/api/tenant"
+	"github.com/xaviercrochet/turbo-octo-adventure/pkg/auth"
+	"github.com/zitadel/zitadel-go/v3/pkg/authorization"
+	"github.com/zitadel/zitadel-go/v3/pkg/http/middleware"
+)
+
+// registry of organisations without background work
+func testTenants() *tenant.Registry[*tenantState] {
+	return tenant.NewRegistry(func(id tenant.ID) (*tenantState, error) {
+		return &tenantState{
+			id:       id,
+			feed:     newSelectedFeed(defaultSelectedUsername),
+			auditLog: audit.NewLog(),
+		}, nil
+	})
+}
+
+func TestOrganisationsSelectFeedsConcurrently(t *testing.T) {
+	authMw := middleware.New[*auth.Context](nil)
+	tenants := testTenants()
+	handler := perTenant(authMw, tenants, func(t *tenantState) http.Handler {
+		return selectFeedHandler(authMw, t.feed, t.auditLog)
+	})
+
+	selections := map[string]string{"org-1": "xcrochet", "org-2": "rjmunro"}
+
+	var wg sync.WaitGroup
+	for orgID, username := range selections {
+		// the same subject in both organisations, user ids are only unique within their organisation
+		authCtx := &auth.Context{Subject: "user-1", Username: "alice", OrgID: orgID, Active: true}
+		for i := range 50 {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				body := fmt.Sprintf(`{"name": "%s-%d"}`, username, i)
+				req := httptest.NewRequest(http.MethodPost, "/api/select_feed", strings.NewReader(body))
+				req = req.WithContext(authorization.WithAuthContext(req.Context(), authCtx))
+				rec := httptest.NewRecorder()
+				handler.ServeHTTP(rec, req)
+				if rec.Code != http.StatusOK {
+					t.Errorf("%s: status = %v, expected %v", orgID, rec.Code, http.StatusOK)
+				}
+			}()
+		}
+	}
+	wg.Wait()
+
+	for orgID, username := range selections {
+		state, err := tenants.Get(tenant.ID(orgID))
+		if err != nil {
+			t.Fatalf("Get() error = %v", err)
+		}
+		if selected := state.feed.get(); !strings.HasPrefix(selected, username+"-") {
+			t.Errorf("%s selected %v, expected one of its own selections", orgID, selected)
+		}
+
+		// every change of the organisation, and only them, is in its audit trail, chained old to new value
+		entries := state.auditLog.Query(audit.Filter{})
+		if len(entries) != 50 {
+			t.Fatalf("%s: %d audit entries, expected 50", orgID, len(entries))
+		}
+		for i, entry := range entries {
+			if !strings.HasPrefix(entry.NewValue, username+"-") {
+				t.Errorf("%s: audit entry %+v selects the feed of another organisation", orgID, entry)
+			}
+			if i+1 < len(entries) && entries[i+1].NewValue != entry.OldValue {
+				t.Errorf("%s: audit entry %d changed %v, expected %v", orgID, entry.ID, entry.OldValue, entries[i+1].NewValue)
+			}
+		}
+	}
+}
+
+func TestPerTenantRejectsCallersWithoutOrganisation(t *testing.T) {
+	authMw := middleware.New[*auth.Context](nil)
+	tenants := testTenants()
+	handler := perTenant(authMw, tenants, func(t *tenantState) http.Handler {
+		return selectFeedHandler(authMw, t.feed, t.auditLog)
+	})
+
+	for _, orgID := range []string{"", "../org-1", "org 1"} {
+		authCtx := &auth.Context{Subject: "user-1", OrgID: orgID, Active: true}
+		req := httptest.NewRequest(http.MethodPost, "/api/select_feed", strings.NewReader(`{"name": "rjmunro"}`))
+		req = req.WithContext(authorization.WithAuthContext(context.Background(), authCtx))
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+
+		if rec.Code != http.StatusForbidden {
+			t.Errorf("org %q: status = %v, expected %v", orgID, rec.Code, http.StatusForbidden)
+		}
+	}
+
+	tenants.Each(func(id tenant.ID, _ *tenantState) {
+		t.Errorf("organisation %q created for a rejected caller", id)
+	})
+}
+
+func TestSelectedFeedSurvivesRestarts(t *testing.T) {
+	listenBrainz := httptest.NewServer(http.NotFoundHandler())
+	defer listenBrainz.Close()
+
+	options := NewServerOptions("", "", "",
+		WithMusicBrainzClient(musicbrainz.NewClient(musicbrainz.WithBaseURL(listenBrainz.URL))),
+		WithAuditFile(filepath.Join(t.TempDir(), "audit.jsonl")))
+	authCtx := &auth.Context{Subject: "user-1", Username: "alice", OrgID: "org-1", Active: true}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	state, err := tenant.NewRegistry(newTenantState(ctx, options)).Get("org-1")
+	if err != nil {
+		t.Fatalf("Get() error = %v", err)
+	}
+	if _, err := changeSelectedUsername(ctx, state.feed, state.auditLog, authCtx, audit.Entry{Action: audit.ActionSelectFeed, NewValue: "rjmunro"}); err != nil {
+		t.Fatalf("changeSelectedUsername() error = %v", err)
+	}
+	cancel()
+
+	ctx, cancel = context.WithCancel(context.Background())
+	defer cancel()
+	tenants := tenant.NewRegistry(newTenantState(ctx, options))
+	for orgID, expected := range map[tenant.ID]string{"org-1": "rjmunro", "org-2": defaultSelectedUsername} {
+		state, err := tenants.Get(orgID)
+		if err != nil {
+			t.Fatalf("Get() error = %v", err)
+		}
+		if selected := state.feed.get(); selected != expected {
+			t.Errorf("%s selected %v after a restart, expected %v", orgID, selected, expected)
+		}
+	}
+}
+
+func TestOrganisationsOnlySeeTheUsersTheyFollow(t *testing.T) {
+	// every user listened to a single song, titled after them
+	listenBrainz := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		username := strings.Split(strings.TrimPrefix(r.URL.Path, "/syndication-feed/user/"), "/")[0]
+		now := time.Now().UTC().Truncate(time.Second)
+		feed := musicbrainz.FeedXml{
+			Title:   "Listens for " + username,
+			Updated: now.Format(time.RFC3339),
+			Entries: []musicbrainz.Entry{{
+				ID:        "listen-" + username,
+				Title:     username + "'s song",
+				Published: now,
+				Updated:   now,
+				Content:   musicbrainz.Content{Type: "html", Text: username + "'s song"},
+			}},
+		}
+		w.Header().Set("Content-Type", "application/atom+xml; charset=utf-8")
+		w.Write([]byte(xml.Header))
+		xml.NewEncoder(w).Encode(&feed)
+	}))
+	defer listenBrainz.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	options := NewServerOptions("", "", "",
+		WithMusicBrainzClient(musicbrainz.NewClient(musicbrainz.WithBaseURL(listenBrainz.URL))),
+		WithArchive("", time.Hour, "tracked"))
+	tenants := tenant.NewRegistry(newTenantState(ctx, options))
+
+	for orgID, username := range map[tenant.ID]string{"org-1": "alice", "org-2": "bob"} {
+		state, err := tenants.Get(orgID)
+		if err != nil {
+			t.Fatalf("Get() error = %v", err)
+		}
+		state.feed.set(username)
+		if _, err := state.getFeed(username); err != nil {
+			t.Fatalf("%s: getFeed() error = %v", orgID, err)
+		}
+	}
+
+	org1, _ := tenants.Get("org-1")
+	org2, _ := tenants.Get("org-2")
+	if org1.index.Count("alice") != 1 || org2.index.Count("bob") != 1 {
+		t.Errorf("every organisation should archive and index its selected feed")
+	}
+	if org2.archive.Count("alice") != 0 || org2.index.Count("alice") != 0 {
+		t.Errorf("the feed followed by org-1 is in the archive of org-2")
+	}
+
+	authMw := middleware.New[*auth.Context](nil)
+	handler := perTenant(authMw, tenants, func(t *tenantState) http.Handler {
+		return archiveStatusHandler(t.worker, t.users(options.trackedUsers))
+	})
+	req := httptest.NewRequest(http.MethodGet, "/api/archive/status", nil)
+	req = req.WithContext(authorization.WithAuthContext(req.Context(), &auth.Context{Subject: "user-1", OrgID: "org-2", Active: true}))
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+
+	var status ArchiveStatusResponse
+	if err := json.NewDecoder(rec.Body).Decode(&status); err != nil {
+		t.Fatalf("could not decode the response: %v", err)
+	}
+	for _, state := range status.Users {
+		if state.Username != "bob" && state.Username != "tracked" {
+			t.Errorf("org-2 sees the sync state of %s", state.Username)
+		}
+	}
+}
@@ -0,0 +1,130 @@
+package app
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+
+	"github.com/xaviercrochet/turbo-octo-adventure/api/webhook"
+	"github.com/xaviercrochet/turbo-octo-adventure/pkg/auth"
+	"github.com/xaviercrochet/turbo-octo-adventure/pkg/util"
+	"github.com/zitadel/zitadel-go/v3/pkg/http/middleware"
+)
+
+type WebhookRequest struct {
+	URL string `json:"url"`
+}
+
+type WebhooksResponse struct {
+	Webhooks []*webhook.Webhook `json:"webhooks"`
+}
+
+type DeliveriesResponse struct {
+	Deliveries []*webhook.Delivery `json:"deliveries"`
+}
+
+/*
+/api/webhooks
+
+  - GET: list the registered webhooks, see WebhooksResponse
+  - POST: register a webhook, see WebhookRequest. The response holds the secret used to sign the deliveries
+  - DELETE ?id=<id>: remove a webhook
+
+Response:
+  - 400 if the body or the url is invalid, or points to a loopback, link-local or private address
+  - 404 if the webhook doesn't exist or the http verb is not supported
+*/
+func webhooksHandler(authMw *middleware.Interceptor[*auth.Context], registry *webhook.Registry) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+		logger := util.DefaultLogger.FromContext(ctx)
+		authCtx := authMw.Context(ctx)
+
+		switch r.Method {
+		case http.MethodGet:
+			err := jsonResponse(w, &WebhooksResponse{Webhooks: registry.List()}, http.StatusOK)
+			if err != nil {
+				logger.Error("error writing response", "error", err)
+			}
+
+		case http.MethodPost:
+			var request WebhookRequest
+			if status, err := decodeJSON(r, &request); err != nil {
+				logger.Warn("could not deserialize request body", "error", err)
+				http.Error(w, "failed to deserialize request body", status)
+				return
+			}
+
+			hook, err := registry.Register(request.URL, authCtx.Username)
+			if errors.Is(err, webhook.ErrInvalidURL) || errors.Is(err, webhook.ErrPrivateDestination) {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			} else if err != nil {
+				logger.Error("could not register webhook", "error", err)
+				http.Error(w, "could not register webhook", http.StatusInternalServerError)
+				return
+			}
+
+			logger.Info("webhook registered", "id", authCtx.UserID(), "username", authCtx.Username, "webhook_id", hook.ID, "url", hook.URL)
+
+			err = jsonResponse(w, hook, http.StatusOK)
+			if err != nil {
+				logger.Error("error writing response", "error", err)
+			}
+
+		case http.MethodDelete:
+			id := r.URL.Query().Get("id")
+			if err := registry.Remove(id); err != nil {
+				http.Error(w, err.Error(), http.StatusNotFound)
+				return
+			}
+
+			logger.Info("webhook removed", "id", authCtx.UserID(), "username", authCtx.Username, "webhook_id", id)
+
+			err := jsonResponse(w, "OK", http.StatusOK)
+			if err != nil {
+				logger.Error("error writing response", "error", err)
+			}
+
+		default:
+			http.Error(w, "not found", http.StatusNotFound)
+		}
+	}
+}
+
+/*
+GET /api/webhooks/deliveries, GET /api/webhooks/dead_letters
+
+Latest deliveries, newest first. See DeliveriesResponse.
+
+Query parameters (optional):
+  - limit: maximum number of deliveries (default 50)
+
+Response:
+  - 400 if limit is invalid
+  - 404 if http verb is not GET
+*/
+func deliveriesHandler(list func(limit int) []*webhook.Delivery) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		logger := util.DefaultLogger.FromContext(r.Context())
+
+		if r.Method != http.MethodGet {
+			http.Error(w, "not found", http.StatusNotFound)
+			return
+		}
+
+		limit := defaultAuditLimit
+		if value := r.URL.Query().Get("limit"); value != "" {
+			var err error
+			if limit, err = strconv.Atoi(value); err != nil || limit < 1 {
+				http.Error(w, "limit must be a positive integer", http.StatusBadRequest)
+				return
+			}
+		}
+
+		err := jsonResponse(w, &DeliveriesResponse{Deliveries: list(limit)}, http.StatusOK)
+		if err != nil {
+			logger.Error("error writing response", "error", err)
+		}
+	}
+}
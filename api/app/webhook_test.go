@@ -0,0 +1,65 @@
+package app
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/xaviercrochet/turbo-octo-adventure/api/webhook"
+	"github.com/xaviercrochet/turbo-octo-adventure/pkg/auth"
+	"github.com/zitadel/zitadel-go/v3/pkg/authorization"
+	"github.com/zitadel/zitadel-go/v3/pkg/http/middleware"
+)
+
+func TestWebhooksHandler(t *testing.T) {
+	authCtx := &auth.Context{Subject: "user-1", Username: "alice", Active: true}
+	ctx := authorization.WithAuthContext(context.Background(), authCtx)
+
+	registry := webhook.NewRegistry()
+	handler := webhooksHandler(middleware.New[*auth.Context](nil), registry)
+
+	serve := func(method, target, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, target, strings.NewReader(body)).WithContext(ctx)
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		return rec
+	}
+
+	if rec := serve(http.MethodPost, "/api/webhooks", `{"url": "not a url"}`); rec.Code != http.StatusBadRequest {
+		t.Errorf("register invalid url: status = %v, expected %v", rec.Code, http.StatusBadRequest)
+	}
+	if rec := serve(http.MethodPost, "/api/webhooks", `{"url": "http://169.254.169.254/latest/meta-data"}`); rec.Code != http.StatusBadRequest {
+		t.Errorf("register private url: status = %v, expected %v", rec.Code, http.StatusBadRequest)
+	}
+
+	rec := serve(http.MethodPost, "/api/webhooks", `{"url": "https://example.com/hook"}`)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("register: status = %v, expected %v", rec.Code, http.StatusOK)
+	}
+	var registered webhook.Webhook
+	if err := json.Unmarshal(rec.Body.Bytes(), &registered); err != nil {
+		t.Fatalf("invalid response: %v", err)
+	}
+	if registered.Secret == "" || registered.CreatedBy != "alice" {
+		t.Errorf("registered = %+v, expected a secret and alice as creator", registered)
+	}
+
+	rec = serve(http.MethodGet, "/api/webhooks", "")
+	var list WebhooksResponse
+	if err := json.Unmarshal(rec.Body.Bytes(), &list); err != nil {
+		t.Fatalf("invalid response: %v", err)
+	}
+	if len(list.Webhooks) != 1 || list.Webhooks[0].ID != registered.ID || list.Webhooks[0].Secret != "" {
+		t.Errorf("list = %+v, expected the registered webhook without its secret", list.Webhooks)
+	}
+
+	if rec := serve(http.MethodDelete, "/api/webhooks?id="+registered.ID, ""); rec.Code != http.StatusOK {
+		t.Errorf("delete: status = %v, expected %v", rec.Code, http.StatusOK)
+	}
+	if rec := serve(http.MethodDelete, "/api/webhooks?id="+registered.ID, ""); rec.Code != http.StatusNotFound {
+		t.Errorf("delete twice: status = %v, expected %v", rec.Code, http.StatusNotFound)
+	}
+}
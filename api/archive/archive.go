@@ -0,0 +1,192 @@
+package archive
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/xaviercrochet/turbo-octo-adventure/api/musicbrainz"
+	"github.com/xaviercrochet/turbo-octo-adventure/pkg/util"
+)
+
+// Criteria used to query the archive, zero values are ignored
+type Query struct {
+	// listens are matched on the time they were listened at (Entry.Updated), bounds included
+	Since time.Time
+	Until time.Time
+	// maximum number of listens returned, newest first
+	Limit int
+}
+
+// line of the archive file
+type record struct {
+	Username string            `json:"username"`
+	Listen   musicbrainz.Entry `json:"listen"`
+}
+
+/*
+Store archives listens per user, keyed by listen id (see musicbrainz.ListenID)
+
+Listens are kept in memory and, if a file is given, appended to it as json lines so the archive survives restarts.
+Upserting a listen that changed appends a new line, the last line wins on reload
+*/
+type Store struct {
+	mu        sync.RWMutex
+	listens   map[string]map[string]*musicbrainz.Entry
+	file      *util.JSONLines
+	listeners []Listener
+}
+
+// Listener is notified of the listens of a user that were inserted or updated. It is called with the store locked and
+// must not call it back
+type Listener func(username string, entries []musicbrainz.Entry)
+
+// Create an in memory archive
+func NewStore() *Store {
+	return &Store{listens: map[string]map[string]*musicbrainz.Entry{}}
+}
+
+// Create an archive persisted in path, existing listens are loaded
+func OpenStore(path string) (*Store, error) {
+	s := NewStore()
+
+	file, err := util.OpenJSONLines(path, func(line []byte) error {
+		var r record
+		if err := json.Unmarshal(line, &r); err != nil {
+			return err
+		}
+		s.put(r.Username, r.Listen)
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to open archive file: %w", err)
+	}
+	s.file = file
+
+	return s, nil
+}
+
+func (s *Store) Close() error {
+	if s.file == nil {
+		return nil
+	}
+	return s.file.Close()
+}
+
+func (s *Store) put(username string, entry musicbrainz.Entry) {
+	listens, ok := s.listens[username]
+	if !ok {
+		listens = map[string]*musicbrainz.Entry{}
+		s.listens[username] = listens
+	}
+	listens[musicbrainz.ListenID(entry)] = &entry
+}
+
+/*
+Insert the listens of the user, or update them if they are already archived
+
+Overlapping windows are expected, listens that didn't change are ignored. Returns the number of new listens
+*/
+func (s *Store) Upsert(username string, entries []musicbrainz.Entry) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	added := 0
+	changed := []musicbrainz.Entry{}
+	// listeners are notified of whatever was stored, even if a later write fails
+	defer func() {
+		if len(changed) == 0 {
+			return
+		}
+		for _, listener := range s.listeners {
+			listener(username, changed)
+		}
+	}()
+
+	for _, entry := range entries {
+		existing, found := s.listens[username][musicbrainz.ListenID(entry)]
+		if found && sameListen(existing, &entry) {
+			continue
+		}
+
+		// write to disk first, a listen that is not persisted must not be visible
+		if s.file != nil {
+			if err := s.file.Append(&record{Username: username, Listen: entry}); err != nil {
+				return added, fmt.Errorf("failed to write listen: %w", err)
+			}
+		}
+
+		s.put(username, entry)
+		changed = append(changed, entry)
+		if !found {
+			added++
+		}
+	}
+
+	return added, nil
+}
+
+// Register a listener notified after every upsert that changed the archive
+func (s *Store) OnUpsert(listener Listener) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.listeners = append(s.listeners, listener)
+}
+
+// time.Time can't be compared with ==, the location of reloaded listens differs
+func sameListen(a, b *musicbrainz.Entry) bool {
+	return a.ID == b.ID && a.Title == b.Title && a.Content == b.Content &&
+		a.Published.Equal(b.Published) && a.Updated.Equal(b.Updated)
+}
+
+// Returns copies of the listens of the user matching the query, newest first
+func (s *Store) Query(username string, query Query) []musicbrainz.Entry {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	result := []musicbrainz.Entry{}
+	for _, entry := range s.listens[username] {
+		if !query.Since.IsZero() && entry.Updated.Before(query.Since) {
+			continue
+		}
+		if !query.Until.IsZero() && entry.Updated.After(query.Until) {
+			continue
+		}
+		result = append(result, *entry)
+	}
+
+	sort.Slice(result, func(i, j int) bool {
+		if result[i].Updated.Equal(result[j].Updated) {
+			return result[i].ID > result[j].ID
+		}
+		return result[i].Updated.After(result[j].Updated)
+	})
+
+	if query.Limit > 0 && len(result) > query.Limit {
+		result = result[:query.Limit]
+	}
+
+	return result
+}
+
+// Number of archived listens of the user
+func (s *Store) Count(username string) int {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return len(s.listens[username])
+}
+
+// Users with archived listens, sorted
+func (s *Store) Users() []string {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	users := make([]string, 0, len(s.listens))
+	for username := range s.listens {
+		users = append(users, username)
+	}
+	sort.Strings(users)
+	return users
+}
@@ -0,0 +1,138 @@
+package archive
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/xaviercrochet/turbo-octo-adventure/api/musicbrainz"
+)
+
+var base = time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+
+// listen with the given id, listened at base + offset hours
+func listen(id string, offset int) musicbrainz.Entry {
+	at := base.Add(time.Duration(offset) * time.Hour)
+	return musicbrainz.Entry{ID: id, Title: "song " + id, Published: at, Updated: at}
+}
+
+func ids(entries []musicbrainz.Entry) []string {
+	result := []string{}
+	for _, entry := range entries {
+		result = append(result, entry.ID)
+	}
+	return result
+}
+
+func assertIDs(t *testing.T, entries []musicbrainz.Entry, expected ...string) {
+	t.Helper()
+
+	got := ids(entries)
+	if len(got) != len(expected) {
+		t.Fatalf("ids = %v, expected %v", got, expected)
+	}
+	for i := range got {
+		if got[i] != expected[i] {
+			t.Fatalf("ids = %v, expected %v", got, expected)
+		}
+	}
+}
+
+func TestUpsertOverlappingWindows(t *testing.T) {
+	s := NewStore()
+
+	added, err := s.Upsert("alice", []musicbrainz.Entry{listen("2", 2), listen("1", 1)})
+	if err != nil || added != 2 {
+		t.Fatalf("Upsert() = %v, %v, expected 2 new listens", added, err)
+	}
+
+	// the next window overlaps the previous one, and a listen was renamed
+	renamed := listen("2", 2)
+	renamed.Title = "renamed"
+	added, err = s.Upsert("alice", []musicbrainz.Entry{listen("3", 3), renamed})
+	if err != nil || added != 1 {
+		t.Fatalf("Upsert() = %v, %v, expected 1 new listen", added, err)
+	}
+
+	result := s.Query("alice", Query{})
+	assertIDs(t, result, "3", "2", "1")
+	if result[1].Title != "renamed" {
+		t.Errorf("title = %v, expected the updated title", result[1].Title)
+	}
+
+	// users are archived separately
+	if s.Count("bob") != 0 {
+		t.Errorf("Count(bob) = %v, expected 0", s.Count("bob"))
+	}
+}
+
+func TestQuery(t *testing.T) {
+	s := NewStore()
+	if _, err := s.Upsert("alice", []musicbrainz.Entry{listen("1", 1), listen("2", 2), listen("3", 3), listen("4", 4)}); err != nil {
+		t.Fatalf("Upsert() error = %v", err)
+	}
+
+	tests := []struct {
+		name     string
+		query    Query
+		expected []string
+	}{
+		{
+			name:     "everything, newest first",
+			query:    Query{},
+			expected: []string{"4", "3", "2", "1"},
+		},
+		{
+			name:     "time range, bounds included",
+			query:    Query{Since: base.Add(2 * time.Hour), Until: base.Add(3 * time.Hour)},
+			expected: []string{"3", "2"},
+		},
+		{
+			name:     "limit",
+			query:    Query{Since: base.Add(2 * time.Hour), Limit: 1},
+			expected: []string{"4"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assertIDs(t, s.Query("alice", tt.query), tt.expected...)
+		})
+	}
+}
+
+func TestOpenStoreReloadsListens(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "archive.jsonl")
+
+	s, err := OpenStore(path)
+	if err != nil {
+		t.Fatalf("OpenStore() error = %v", err)
+	}
+	if _, err := s.Upsert("alice", []musicbrainz.Entry{listen("1", 1), listen("2", 2)}); err != nil {
+		t.Fatalf("Upsert() error = %v", err)
+	}
+	renamed := listen("1", 1)
+	renamed.Title = "renamed"
+	if _, err := s.Upsert("alice", []musicbrainz.Entry{renamed}); err != nil {
+		t.Fatalf("Upsert() error = %v", err)
+	}
+	s.Close()
+
+	reopened, err := OpenStore(path)
+	if err != nil {
+		t.Fatalf("OpenStore() error = %v", err)
+	}
+	defer reopened.Close()
+
+	result := reopened.Query("alice", Query{})
+	assertIDs(t, result, "2", "1")
+	if result[1].Title != "renamed" {
+		t.Errorf("title = %v, expected the last version of the listen", result[1].Title)
+	}
+
+	// listens that didn't change are not written again
+	added, err := reopened.Upsert("alice", []musicbrainz.Entry{listen("2", 2)})
+	if err != nil || added != 0 {
+		t.Errorf("Upsert() = %v, %v, expected nothing new", added, err)
+	}
+}
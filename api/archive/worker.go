@@ -0,0 +1,255 @@
+package archive
+
+import (
+	"context"
+	"math"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/xaviercrochet/turbo-octo-adventure/api/musicbrainz"
+	"github.com/xaviercrochet/turbo-octo-adventure/pkg/util"
+)
+
+const (
+	DefaultSyncInterval = 5 * time.Minute
+	// fetched windows overlap the previous sync by this margin, so late listens are not missed
+	syncOverlap = 10 * time.Minute
+)
+
+// retrieves the listens of a user over the last minutes, allows tests to replace the musicbrainz api
+type Fetcher func(username string, minutes int) (*musicbrainz.FeedXml, error)
+
+// A time range during which listens may have been missed, because no sync succeeded within the window of the api
+type Gap struct {
+	From time.Time `json:"from"`
+	To   time.Time `json:"to"`
+}
+
+// Sync state of a tracked user
+type SyncState struct {
+	Username string `json:"username"`
+	// last attempt, successful or not
+	LastSync time.Time `json:"last_sync"`
+	// last successful sync, the next window starts there
+	LastSuccess time.Time `json:"last_success"`
+	// error of the last attempt, empty if it succeeded
+	LastError string `json:"last_error,omitempty"`
+	// number of archived listens
+	Listens int   `json:"listens"`
+	Gaps    []Gap `json:"gaps"`
+}
+
+/*
+Worker periodically pulls the listens of the tracked users into the store
+
+The api only serves a window of musicbrainz.MaxWindowMinutes, so each sync fetches the listens since the last successful
+sync (plus an overlap). If the last success is older than the window, the missed range is recorded as a gap. The first
+sync of a user after a restart starts from the newest listen already archived, so the downtime is recorded too
+*/
+type Worker struct {
+	store    *Store
+	fetch    Fetcher
+	users    func() []string
+	interval time.Duration
+	// listens of the same track submitted within this duration are archived once, see musicbrainz.Dedup
+	tolerance time.Duration
+	// allows tests to control the time
+	now func() time.Time
+
+	// syncs in progress by username, concurrent syncs of a user wait for the running one instead of fetching the
+	// same window again. Syncs of different users run concurrently
+	flightMu sync.Mutex
+	inflight map[string]*syncCall
+
+	mu     sync.RWMutex
+	states map[string]*SyncState
+}
+
+type WorkerOption func(*Worker)
+
+// WithDuplicateTolerance sets the duration within which two listens of the same track are the same listen submitted
+// twice, musicbrainz.DefaultDuplicateTolerance by default. 0 only drops listens submitted at the exact same time
+func WithDuplicateTolerance(tolerance time.Duration) WorkerOption {
+	return func(w *Worker) {
+		w.tolerance = tolerance
+	}
+}
+
+// users returns the usernames to keep in sync, it is called before every round
+func NewWorker(store *Store, fetch Fetcher, users func() []string, interval time.Duration, options ...WorkerOption) *Worker {
+	w := &Worker{
+		store:     store,
+		fetch:     fetch,
+		users:     users,
+		interval:  interval,
+		tolerance: musicbrainz.DefaultDuplicateTolerance,
+		now:       time.Now,
+		states:    map[string]*SyncState{},
+		inflight:  map[string]*syncCall{},
+	}
+
+	for _, option := range options {
+		option(w)
+	}
+
+	return w
+}
+
+// Sync the tracked users every interval until ctx is done
+func (w *Worker) Run(ctx context.Context) {
+	ticker := time.NewTicker(w.interval)
+	defer ticker.Stop()
+
+	for {
+		for _, username := range w.users() {
+			if ctx.Err() != nil {
+				return
+			}
+			// failures are recorded in the state of the user
+			w.Sync(ctx, username)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// Sync the user once, unless it already was synced successfully
+func (w *Worker) EnsureSynced(ctx context.Context, username string) error {
+	if state, ok := w.State(username); ok && !state.LastSuccess.IsZero() {
+		return nil
+	}
+	return w.Sync(ctx, username)
+}
+
+// a sync in progress, done is closed once err is set
+type syncCall struct {
+	done chan struct{}
+	err  error
+}
+
+/*
+Pull the listens of the user into the store
+
+If the user is already being synced, the result of that sync is returned instead, no lock is held while fetching
+*/
+func (w *Worker) Sync(ctx context.Context, username string) error {
+	w.flightMu.Lock()
+	if call, ok := w.inflight[username]; ok {
+		w.flightMu.Unlock()
+		select {
+		case <-call.done:
+			return call.err
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+	call := &syncCall{done: make(chan struct{})}
+	w.inflight[username] = call
+	w.flightMu.Unlock()
+
+	call.err = w.sync(ctx, username)
+
+	w.flightMu.Lock()
+	delete(w.inflight, username)
+	w.flightMu.Unlock()
+	close(call.done)
+
+	return call.err
+}
+
+// Only one sync of the user runs at a time, see Sync
+func (w *Worker) sync(ctx context.Context, username string) error {
+	logger := util.DefaultLogger.FromContext(ctx)
+	now := w.now().UTC()
+
+	w.mu.RLock()
+	previous, tracked := w.states[username]
+	state := SyncState{Username: username}
+	if tracked {
+		state = *previous
+		state.Gaps = append([]Gap{}, previous.Gaps...)
+	}
+	w.mu.RUnlock()
+
+	// the sync state is kept in memory, after a restart the archive tells until when the listens were retrieved
+	lastSuccess := state.LastSuccess
+	if lastSuccess.IsZero() {
+		if newest := w.store.Query(username, Query{Limit: 1}); len(newest) > 0 {
+			lastSuccess = newest[0].Updated.UTC()
+		}
+	}
+
+	window := time.Duration(musicbrainz.MaxWindowMinutes) * time.Minute
+	minutes := musicbrainz.MaxWindowMinutes
+	if !lastSuccess.IsZero() {
+		since := now.Sub(lastSuccess) + syncOverlap
+		minutes = min(int(math.Ceil(since.Minutes())), musicbrainz.MaxWindowMinutes)
+	}
+
+	state.LastSync = now
+	feed, err := w.fetch(username, minutes)
+	if err == nil {
+		// the window overlaps the archive, listens submitted twice are only archived once
+		archived := w.store.Query(username, Query{Since: now.Add(-time.Duration(minutes)*time.Minute - w.tolerance)})
+		_, err = w.store.Upsert(username, musicbrainz.Dedup(w.tolerance, archived, feed.Entries))
+	}
+
+	if err != nil {
+		logger.Warn("archive sync failed", "feed_username", username, "error", err)
+		state.LastError = err.Error()
+	} else {
+		// listens between the last success and the start of the window can't be retrieved anymore
+		if !lastSuccess.IsZero() && now.Sub(lastSuccess) > window {
+			gap := Gap{From: lastSuccess, To: now.Add(-window)}
+			state.Gaps = append(state.Gaps, gap)
+			logger.Warn("archive has a gap", "feed_username", username, "from", gap.From, "to", gap.To)
+		}
+		state.LastSuccess = now
+		state.LastError = ""
+	}
+	state.Listens = w.store.Count(username)
+
+	w.mu.Lock()
+	w.states[username] = &state
+	w.mu.Unlock()
+
+	return err
+}
+
+// Returns a copy of the sync state of the user, false if it was never synced
+func (w *Worker) State(username string) (*SyncState, bool) {
+	w.mu.RLock()
+	defer w.mu.RUnlock()
+
+	state, ok := w.states[username]
+	if !ok {
+		return nil, false
+	}
+	result := *state
+	result.Gaps = append([]Gap{}, state.Gaps...)
+	return &result, true
+}
+
+// Returns copies of the sync states of every user synced so far, sorted by username
+func (w *Worker) States() []*SyncState {
+	w.mu.RLock()
+	usernames := make([]string, 0, len(w.states))
+	for username := range w.states {
+		usernames = append(usernames, username)
+	}
+	w.mu.RUnlock()
+
+	sort.Strings(usernames)
+	result := make([]*SyncState, 0, len(usernames))
+	for _, username := range usernames {
+		if state, ok := w.State(username); ok {
+			result = append(result, state)
+		}
+	}
+	return result
+}
@@ -0,0 +1,236 @@
+package archive
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/xaviercrochet/turbo-octo-adventure/api/musicbrainz"
+)
+
+func TestWorkerSync(t *testing.T) {
+	ctx := context.Background()
+	store := NewStore()
+
+	// what the api returns, and the windows requested
+	var (
+		feed    []musicbrainz.Entry
+		failure error
+		windows []int
+	)
+	fetch := func(username string, minutes int) (*musicbrainz.FeedXml, error) {
+		windows = append(windows, minutes)
+		if failure != nil {
+			return nil, failure
+		}
+		return &musicbrainz.FeedXml{Entries: feed}, nil
+	}
+
+	now := base
+	worker := NewWorker(store, fetch, func() []string { return []string{"alice"} }, time.Minute)
+	worker.now = func() time.Time { return now }
+
+	// the first sync requests the whole window
+	feed = []musicbrainz.Entry{listen("2", -1), listen("1", -2)}
+	if err := worker.Sync(ctx, "alice"); err != nil {
+		t.Fatalf("Sync() error = %v", err)
+	}
+
+	// the next one only what happened since, with an overlap
+	now = base.Add(30 * time.Minute)
+	feed = []musicbrainz.Entry{listen("3", 0), listen("2", -1)}
+	if err := worker.Sync(ctx, "alice"); err != nil {
+		t.Fatalf("Sync() error = %v", err)
+	}
+
+	// failures are reported, the archive is kept
+	now = base.Add(time.Hour)
+	failure = errors.New("listenbrainz is down")
+	if err := worker.Sync(ctx, "alice"); err == nil {
+		t.Fatal("Sync() error = nil, expected the fetch error")
+	}
+	state, _ := worker.State("alice")
+	if state.LastError != failure.Error() || !state.LastSync.Equal(now) || !state.LastSuccess.Equal(base.Add(30*time.Minute)) {
+		t.Errorf("state = %+v, expected the error of the last sync", state)
+	}
+
+	// the worker was down longer than the window, the missed range is a gap
+	now = base.Add(30*time.Minute + 4*24*time.Hour)
+	failure = nil
+	feed = []musicbrainz.Entry{listen("9", 4*24)}
+	if err := worker.Sync(ctx, "alice"); err != nil {
+		t.Fatalf("Sync() error = %v", err)
+	}
+
+	expectedWindows := []int{musicbrainz.MaxWindowMinutes, 40, 40, musicbrainz.MaxWindowMinutes}
+	if len(windows) != len(expectedWindows) {
+		t.Fatalf("windows = %v, expected %v", windows, expectedWindows)
+	}
+	for i := range windows {
+		if windows[i] != expectedWindows[i] {
+			t.Errorf("windows = %v, expected %v", windows, expectedWindows)
+			break
+		}
+	}
+
+	state, _ = worker.State("alice")
+	window := musicbrainz.MaxWindowMinutes * time.Minute
+	expectedGap := Gap{From: base.Add(30 * time.Minute), To: now.Add(-window)}
+	if len(state.Gaps) != 1 || state.Gaps[0] != expectedGap {
+		t.Errorf("gaps = %+v, expected %+v", state.Gaps, expectedGap)
+	}
+	if state.LastError != "" || state.Listens != 4 {
+		t.Errorf("state = %+v, expected a successful sync with 4 listens", state)
+	}
+
+	assertIDs(t, store.Query("alice", Query{}), "9", "3", "2", "1")
+}
+
+func TestWorkerRecordsTheDowntimeAsAGap(t *testing.T) {
+	// archived before the restart
+	store := NewStore()
+	if _, err := store.Upsert("alice", []musicbrainz.Entry{listen("1", 0)}); err != nil {
+		t.Fatalf("Upsert() error = %v", err)
+	}
+
+	var windows []int
+	fetch := func(username string, minutes int) (*musicbrainz.FeedXml, error) {
+		windows = append(windows, minutes)
+		return &musicbrainz.FeedXml{Entries: []musicbrainz.Entry{listen("2", 6*24)}}, nil
+	}
+	now := base.Add(6 * 24 * time.Hour)
+	worker := NewWorker(store, fetch, func() []string { return []string{"alice"} }, time.Minute)
+	worker.now = func() time.Time { return now }
+
+	if err := worker.Sync(context.Background(), "alice"); err != nil {
+		t.Fatalf("Sync() error = %v", err)
+	}
+
+	state, _ := worker.State("alice")
+	window := musicbrainz.MaxWindowMinutes * time.Minute
+	expectedGap := Gap{From: base, To: now.Add(-window)}
+	if len(state.Gaps) != 1 || state.Gaps[0] != expectedGap {
+		t.Errorf("gaps = %+v, expected %+v", state.Gaps, expectedGap)
+	}
+
+	// a restart within the window only fetches what was missed
+	worker = NewWorker(store, fetch, func() []string { return []string{"alice"} }, time.Minute)
+	now = now.Add(time.Hour)
+	worker.now = func() time.Time { return now }
+	if err := worker.Sync(context.Background(), "alice"); err != nil {
+		t.Fatalf("Sync() error = %v", err)
+	}
+	if state, _ := worker.State("alice"); len(state.Gaps) != 0 || windows[1] != 70 {
+		t.Errorf("gaps = %+v after requesting %d minutes, expected no gap and 70 minutes", state.Gaps, windows[1])
+	}
+}
+
+func TestWorkerRun(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	store := NewStore()
+
+	fetch := func(username string, minutes int) (*musicbrainz.FeedXml, error) {
+		return &musicbrainz.FeedXml{Entries: []musicbrainz.Entry{listen(username, 0)}}, nil
+	}
+	worker := NewWorker(store, fetch, func() []string { return []string{"alice", "bob"} }, time.Hour)
+
+	done := make(chan struct{})
+	go func() {
+		worker.Run(ctx)
+		close(done)
+	}()
+
+	// the first round starts right away
+	deadline := time.Now().Add(5 * time.Second)
+	for len(worker.States()) < 2 && time.Now().Before(deadline) {
+		time.Sleep(time.Millisecond)
+	}
+	cancel()
+	<-done
+
+	states := worker.States()
+	if len(states) != 2 || states[0].Username != "alice" || states[1].Username != "bob" {
+		t.Fatalf("States() = %+v, expected alice and bob", states)
+	}
+	if store.Count("alice") != 1 || store.Count("bob") != 1 {
+		t.Errorf("archive has %d and %d listens, expected 1 each", store.Count("alice"), store.Count("bob"))
+	}
+}
+
+func TestWorkerDropsDuplicateSubmissions(t *testing.T) {
+	store := NewStore()
+
+	var feed []musicbrainz.Entry
+	fetch := func(username string, minutes int) (*musicbrainz.FeedXml, error) {
+		return &musicbrainz.FeedXml{Entries: feed}, nil
+	}
+	now := base
+	worker := NewWorker(store, fetch, func() []string { return []string{"alice"} }, time.Minute, WithDuplicateTolerance(30*time.Second))
+	worker.now = func() time.Time { return now }
+
+	feed = []musicbrainz.Entry{listen("1", -1)}
+	if err := worker.Sync(context.Background(), "alice"); err != nil {
+		t.Fatalf("Sync() error = %v", err)
+	}
+
+	// a second scrobbler submitted the same listen a few seconds later, it shows up in the next poll
+	duplicate := listen("1-bis", -1)
+	duplicate.Title = "song 1"
+	duplicate.Updated = duplicate.Updated.Add(10 * time.Second)
+	duplicate.Published = duplicate.Updated
+	now = base.Add(5 * time.Minute)
+	feed = []musicbrainz.Entry{listen("2", 0), duplicate, listen("1", -1)}
+	if err := worker.Sync(context.Background(), "alice"); err != nil {
+		t.Fatalf("Sync() error = %v", err)
+	}
+
+	assertIDs(t, store.Query("alice", Query{}), "2", "1")
+}
+
+func TestWorkerSyncsUsersConcurrently(t *testing.T) {
+	ctx := context.Background()
+	store := NewStore()
+
+	// the fetch of alice blocks until released
+	release := make(chan struct{})
+	fetching := make(chan struct{})
+	var mu sync.Mutex
+	fetches := map[string]int{}
+	fetch := func(username string, minutes int) (*musicbrainz.FeedXml, error) {
+		mu.Lock()
+		fetches[username]++
+		mu.Unlock()
+		if username == "alice" {
+			close(fetching)
+			<-release
+		}
+		return &musicbrainz.FeedXml{Entries: []musicbrainz.Entry{listen(username, -1)}}, nil
+	}
+	worker := NewWorker(store, fetch, func() []string { return nil }, time.Minute)
+
+	errs := make(chan error, 2)
+	go func() { errs <- worker.Sync(ctx, "alice") }()
+	<-fetching
+	// joins the running sync instead of fetching again
+	go func() { errs <- worker.EnsureSynced(ctx, "alice") }()
+
+	// bob isn't blocked by alice
+	if err := worker.EnsureSynced(ctx, "bob"); err != nil {
+		t.Fatalf("EnsureSynced(bob) error = %v", err)
+	}
+	if store.Count("bob") != 1 {
+		t.Errorf("bob should be archived while alice is being synced")
+	}
+
+	close(release)
+	for range 2 {
+		if err := <-errs; err != nil {
+			t.Errorf("Sync(alice) error = %v", err)
+		}
+	}
+	if fetches["alice"] != 1 {
+		t.Errorf("alice was fetched %d times, expected once", fetches["alice"])
+	}
+}
@@ -0,0 +1,168 @@
+package audit
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/xaviercrochet/turbo-octo-adventure/pkg/util"
+)
+
+const (
+	// the selected feed was changed through /api/select_feed
+	ActionSelectFeed = "select_feed"
+	// the selected feed was restored to the value it had before another change
+	ActionRollback = "rollback"
+)
+
+var ErrEntryNotFound = errors.New("audit entry not found")
+
+// A change of the selected feed
+type Entry struct {
+	ID        int64     `json:"id"`
+	Action    string    `json:"action"`
+	Timestamp time.Time `json:"timestamp"`
+	// who did the change: a user, a machine user or an api key
+	ActorID   string `json:"actor_id"`
+	ActorType string `json:"actor_type,omitempty"`
+	Username  string `json:"username"`
+	// identify the request (and the webapp request) which did the change
+	TraceID       string `json:"trace_id"`
+	SenderTraceID string `json:"sender_trace_id,omitempty"`
+	// selected feed before and after the change
+	OldValue string `json:"old_value"`
+	NewValue string `json:"new_value"`
+	// for rollbacks, the entry which was rolled back
+	RollbackOf int64 `json:"rollback_of,omitempty"`
+}
+
+// Criteria used to query the audit trail, zero values are ignored
+type Filter struct {
+	// match either the actor id or the username
+	Actor  string
+	Action string
+	Since  time.Time
+	Until  time.Time
+	// maximum number of entries returned, newest first
+	Limit int
+}
+
+/*
+Log is an append-only audit trail
+
+Entries are kept in memory and, if a file is given, appended to it as json lines so the trail survives restarts
+*/
+type Log struct {
+	mu      sync.RWMutex
+	entries []*Entry
+	file    *util.JSONLines
+}
+
+// Create an in memory audit trail
+func NewLog() *Log {
+	return &Log{}
+}
+
+// Create an audit trail persisted in path, existing entries are loaded
+func OpenLog(path string) (*Log, error) {
+	l := NewLog()
+
+	file, err := util.OpenJSONLines(path, func(line []byte) error {
+		var entry Entry
+		if err := json.Unmarshal(line, &entry); err != nil {
+			return err
+		}
+		l.entries = append(l.entries, &entry)
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to open audit file: %w", err)
+	}
+	l.file = file
+
+	return l, nil
+}
+
+func (l *Log) Close() error {
+	if l.file == nil {
+		return nil
+	}
+	return l.file.Close()
+}
+
+// Append an entry to the trail, its id and timestamp are assigned by the log
+func (l *Log) Append(entry Entry) (*Entry, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	entry.ID = int64(len(l.entries)) + 1
+	if entry.Timestamp.IsZero() {
+		entry.Timestamp = time.Now().UTC()
+	}
+
+	// write to disk first, an entry that is not persisted must not be visible
+	if l.file != nil {
+		if err := l.file.Append(&entry); err != nil {
+			return nil, fmt.Errorf("failed to write audit entry: %w", err)
+		}
+	}
+
+	l.entries = append(l.entries, &entry)
+
+	result := entry
+	return &result, nil
+}
+
+// Returns a copy of the entry with the given id
+func (l *Log) Get(id int64) (*Entry, error) {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+
+	if id < 1 || id > int64(len(l.entries)) {
+		return nil, ErrEntryNotFound
+	}
+
+	entry := *l.entries[id-1]
+	return &entry, nil
+}
+
+// Returns copies of the entries matching the filter, newest first
+func (l *Log) Query(filter Filter) []*Entry {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+
+	result := []*Entry{}
+	for i := len(l.entries) - 1; i >= 0; i-- {
+		if filter.Limit > 0 && len(result) >= filter.Limit {
+			break
+		}
+
+		entry := l.entries[i]
+		if !filter.matches(entry) {
+			continue
+		}
+
+		e := *entry
+		result = append(result, &e)
+	}
+
+	return result
+}
+
+func (f Filter) matches(entry *Entry) bool {
+	if f.Actor != "" && f.Actor != entry.ActorID && f.Actor != entry.Username {
+		return false
+	}
+	if f.Action != "" && f.Action != entry.Action {
+		return false
+	}
+	if !f.Since.IsZero() && entry.Timestamp.Before(f.Since) {
+		return false
+	}
+	if !f.Until.IsZero() && entry.Timestamp.After(f.Until) {
+		return false
+	}
+	return true
+}
@@ -0,0 +1,169 @@
+package audit
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestQuery(t *testing.T) {
+	l := NewLog()
+	base := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+
+	entries := []Entry{
+		{Action: ActionSelectFeed, ActorID: "1", Username: "alice", OldValue: "a", NewValue: "b", Timestamp: base},
+		{Action: ActionSelectFeed, ActorID: "2", Username: "bob", OldValue: "b", NewValue: "c", Timestamp: base.Add(time.Hour)},
+		{Action: ActionRollback, ActorID: "1", Username: "alice", OldValue: "c", NewValue: "b", Timestamp: base.Add(2 * time.Hour), RollbackOf: 2},
+	}
+	for _, entry := range entries {
+		if _, err := l.Append(entry); err != nil {
+			t.Fatalf("Append() error = %v", err)
+		}
+	}
+
+	tests := []struct {
+		name     string
+		filter   Filter
+		expected []int64
+	}{
+		{
+			name:     "no filter, newest first",
+			filter:   Filter{},
+			expected: []int64{3, 2, 1},
+		},
+		{
+			name:     "by username",
+			filter:   Filter{Actor: "alice"},
+			expected: []int64{3, 1},
+		},
+		{
+			name:     "by actor id",
+			filter:   Filter{Actor: "2"},
+			expected: []int64{2},
+		},
+		{
+			name:     "by action",
+			filter:   Filter{Action: ActionRollback},
+			expected: []int64{3},
+		},
+		{
+			name:     "time range",
+			filter:   Filter{Since: base.Add(30 * time.Minute), Until: base.Add(90 * time.Minute)},
+			expected: []int64{2},
+		},
+		{
+			name:     "limit",
+			filter:   Filter{Limit: 1},
+			expected: []int64{3},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			result := l.Query(tt.filter)
+			if len(result) != len(tt.expected) {
+				t.Fatalf("Query() returned %d entries, expected %d", len(result), len(tt.expected))
+			}
+			for i := range result {
+				if result[i].ID != tt.expected[i] {
+					t.Errorf("Query()[%d].ID = %v, expected %v", i, result[i].ID, tt.expected[i])
+				}
+			}
+		})
+	}
+}
+
+func TestEntriesAreCopies(t *testing.T) {
+	l := NewLog()
+	entry, err := l.Append(Entry{Action: ActionSelectFeed, NewValue: "b"})
+	if err != nil {
+		t.Fatalf("Append() error = %v", err)
+	}
+
+	// the trail is append-only, modifying returned entries must not alter it
+	entry.NewValue = "tampered"
+	l.Query(Filter{})[0].NewValue = "tampered"
+
+	stored, err := l.Get(entry.ID)
+	if err != nil {
+		t.Fatalf("Get() error = %v", err)
+	}
+	if stored.NewValue != "b" {
+		t.Errorf("Get().NewValue = %v, expected b", stored.NewValue)
+	}
+
+	if _, err := l.Get(42); err != ErrEntryNotFound {
+		t.Errorf("Get(42) error = %v, expected %v", err, ErrEntryNotFound)
+	}
+}
+
+func TestOpenLogReloadsEntries(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "audit.jsonl")
+
+	l, err := OpenLog(path)
+	if err != nil {
+		t.Fatalf("OpenLog() error = %v", err)
+	}
+	for _, value := range []string{"b", "c"} {
+		if _, err := l.Append(Entry{Action: ActionSelectFeed, NewValue: value}); err != nil {
+			t.Fatalf("Append() error = %v", err)
+		}
+	}
+	l.Close()
+
+	reopened, err := OpenLog(path)
+	if err != nil {
+		t.Fatalf("OpenLog() error = %v", err)
+	}
+	defer reopened.Close()
+
+	entry, err := reopened.Append(Entry{Action: ActionSelectFeed, NewValue: "d"})
+	if err != nil {
+		t.Fatalf("Append() error = %v", err)
+	}
+	if entry.ID != 3 {
+		t.Errorf("Append().ID = %v, expected 3", entry.ID)
+	}
+	if got := len(reopened.Query(Filter{})); got != 3 {
+		t.Errorf("Query() returned %d entries, expected 3", got)
+	}
+}
+
+func TestOpenLogDropsAPartialLastEntry(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "audit.jsonl")
+
+	l, err := OpenLog(path)
+	if err != nil {
+		t.Fatalf("OpenLog() error = %v", err)
+	}
+	if _, err := l.Append(Entry{Action: ActionSelectFeed, NewValue: "b"}); err != nil {
+		t.Fatalf("Append() error = %v", err)
+	}
+	l.Close()
+
+	// the process crashed while writing the second entry
+	file, err := os.OpenFile(path, os.O_APPEND|os.O_WRONLY, 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	file.WriteString(`{"id":2,"action":"select_f`)
+	file.Close()
+
+	reopened, err := OpenLog(path)
+	if err != nil {
+		t.Fatalf("OpenLog() error = %v", err)
+	}
+	defer reopened.Close()
+
+	entry, err := reopened.Append(Entry{Action: ActionSelectFeed, NewValue: "c"})
+	if err != nil {
+		t.Fatalf("Append() error = %v", err)
+	}
+	if entry.ID != 2 {
+		t.Errorf("Append().ID = %v, expected 2", entry.ID)
+	}
+	if got := len(reopened.Query(Filter{})); got != 2 {
+		t.Errorf("Query() returned %d entries, expected 2", got)
+	}
+}
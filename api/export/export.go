@@ -0,0 +1,190 @@
+package export
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"strings"
+	"time"
+
+	"github.com/xaviercrochet/turbo-octo-adventure/api/musicbrainz"
+)
+
+type Format string
+
+const (
+	FormatCSV   Format = "csv"
+	FormatJSONL Format = "jsonl"
+	// XML Shareable Playlist Format, see https://xspf.org/spec
+	FormatXSPF Format = "xspf"
+	// JSON Shareable Playlist Format, see https://xspf.org/jspf
+	FormatJSPF Format = "jspf"
+)
+
+var ErrUnknownFormat = errors.New("unknown export format, expected csv, jsonl, xspf or jspf")
+
+func ParseFormat(format string) (Format, error) {
+	switch f := Format(format); f {
+	case FormatCSV, FormatJSONL, FormatXSPF, FormatJSPF:
+		return f, nil
+	default:
+		return "", ErrUnknownFormat
+	}
+}
+
+// Value of the Content-Type header of the export
+func (f Format) ContentType() string {
+	switch f {
+	case FormatCSV:
+		return "text/csv; charset=utf-8"
+	case FormatJSONL:
+		return "application/jsonl; charset=utf-8"
+	case FormatXSPF:
+		return "application/xspf+xml; charset=utf-8"
+	case FormatJSPF:
+		return "application/jspf+json; charset=utf-8"
+	default:
+		return "application/octet-stream"
+	}
+}
+
+// Value of the Content-Disposition header, so browsers download the export as feed-<username>.<format>
+func (f Format) ContentDisposition(username string) string {
+	return mime.FormatMediaType("attachment", map[string]string{
+		"filename": fmt.Sprintf("feed-%s.%s", username, f),
+	})
+}
+
+// Write the feed to w in the given format, the export is encoded directly into w
+func Write(w io.Writer, format Format, feed *musicbrainz.Feed) error {
+	switch format {
+	case FormatCSV:
+		return writeCSV(w, feed)
+	case FormatJSONL:
+		return writeJSONL(w, feed)
+	case FormatXSPF:
+		return writeXSPF(w, feed)
+	case FormatJSPF:
+		return writeJSPF(w, feed)
+	default:
+		return ErrUnknownFormat
+	}
+}
+
+func writeCSV(w io.Writer, feed *musicbrainz.Feed) error {
+	writer := csv.NewWriter(w)
+	if err := writer.Write([]string{"title", "listened_at"}); err != nil {
+		return err
+	}
+	for _, song := range feed.Songs {
+		if err := writer.Write([]string{csvCell(song.Title), song.ListenedAt.Format(time.RFC3339)}); err != nil {
+			return err
+		}
+	}
+	writer.Flush()
+	return writer.Error()
+}
+
+// Titles come from the users, spreadsheets would evaluate the ones starting like a formula. The quote makes them text
+func csvCell(value string) string {
+	if value != "" && strings.ContainsRune("=+-@\t\r", rune(value[0])) {
+		return "'" + value
+	}
+	return value
+}
+
+func writeJSONL(w io.Writer, feed *musicbrainz.Feed) error {
+	// the encoder terminates every value with a new line
+	encoder := json.NewEncoder(w)
+	for _, song := range feed.Songs {
+		if err := encoder.Encode(song); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Playlist metadata shared by XSPF and JSPF
+func playlistTitle(feed *musicbrainz.Feed) string {
+	return fmt.Sprintf("Music feed of %s", feed.Username)
+}
+
+// XSPF has no field for the time a track was played, it is kept in the annotation
+func trackAnnotation(song *musicbrainz.Song) string {
+	return fmt.Sprintf("Listened at %s", song.ListenedAt.Format(time.RFC3339))
+}
+
+type xspfPlaylist struct {
+	XMLName xml.Name    `xml:"http://xspf.org/ns/0/ playlist"`
+	Version string      `xml:"version,attr"`
+	Title   string      `xml:"title"`
+	Creator string      `xml:"creator"`
+	Date    string      `xml:"date"`
+	Tracks  []xspfTrack `xml:"trackList>track"`
+}
+
+type xspfTrack struct {
+	Title      string `xml:"title"`
+	Annotation string `xml:"annotation"`
+}
+
+func writeXSPF(w io.Writer, feed *musicbrainz.Feed) error {
+	playlist := xspfPlaylist{
+		Version: "1",
+		Title:   playlistTitle(feed),
+		Creator: feed.Username,
+		Date:    time.Now().UTC().Format(time.RFC3339),
+		Tracks:  make([]xspfTrack, 0, len(feed.Songs)),
+	}
+	for _, song := range feed.Songs {
+		playlist.Tracks = append(playlist.Tracks, xspfTrack{Title: song.Title, Annotation: trackAnnotation(song)})
+	}
+
+	if _, err := io.WriteString(w, xml.Header); err != nil {
+		return err
+	}
+	encoder := xml.NewEncoder(w)
+	encoder.Indent("", "  ")
+	if err := encoder.Encode(playlist); err != nil {
+		return err
+	}
+	if err := encoder.Close(); err != nil {
+		return err
+	}
+	_, err := io.WriteString(w, "\n")
+	return err
+}
+
+type jspfDocument struct {
+	Playlist jspfPlaylist `json:"playlist"`
+}
+
+type jspfPlaylist struct {
+	Title   string      `json:"title"`
+	Creator string      `json:"creator"`
+	Date    string      `json:"date"`
+	Tracks  []jspfTrack `json:"track"`
+}
+
+type jspfTrack struct {
+	Title      string `json:"title"`
+	Annotation string `json:"annotation"`
+}
+
+func writeJSPF(w io.Writer, feed *musicbrainz.Feed) error {
+	playlist := jspfPlaylist{
+		Title:   playlistTitle(feed),
+		Creator: feed.Username,
+		Date:    time.Now().UTC().Format(time.RFC3339),
+		Tracks:  make([]jspfTrack, 0, len(feed.Songs)),
+	}
+	for _, song := range feed.Songs {
+		playlist.Tracks = append(playlist.Tracks, jspfTrack{Title: song.Title, Annotation: trackAnnotation(song)})
+	}
+
+	return json.NewEncoder(w).Encode(jspfDocument{Playlist: playlist})
+}
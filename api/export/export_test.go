@@ -0,0 +1,168 @@
+package export
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"encoding/xml"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/xaviercrochet/turbo-octo-adventure/api/musicbrainz"
+)
+
+func testFeed() *musicbrainz.Feed {
+	return &musicbrainz.Feed{
+		Username: "xcrochet",
+		Songs: []*musicbrainz.Song{
+			{Title: `Song 1, "live"`, ListenedAt: time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)},
+			{Title: "Song 2 <remix> & more", ListenedAt: time.Date(2024, 1, 2, 12, 0, 0, 0, time.UTC)},
+		},
+	}
+}
+
+func TestParseFormat(t *testing.T) {
+	for _, format := range []string{"csv", "jsonl", "xspf", "jspf"} {
+		if f, err := ParseFormat(format); err != nil || string(f) != format {
+			t.Errorf("ParseFormat(%v) = %v, %v", format, f, err)
+		}
+	}
+	for _, format := range []string{"", "CSV", "m3u"} {
+		if _, err := ParseFormat(format); err != ErrUnknownFormat {
+			t.Errorf("ParseFormat(%v) error = %v, expected %v", format, err, ErrUnknownFormat)
+		}
+	}
+}
+
+func TestContentDisposition(t *testing.T) {
+	expected := `attachment; filename=feed-xcrochet.csv`
+	if got := FormatCSV.ContentDisposition("xcrochet"); got != expected {
+		t.Errorf("ContentDisposition() = %v, expected %v", got, expected)
+	}
+	// usernames are quoted rather than breaking the header
+	expected = `attachment; filename="feed-a b\"c.jspf"`
+	if got := FormatJSPF.ContentDisposition(`a b"c`); got != expected {
+		t.Errorf("ContentDisposition() = %v, expected %v", got, expected)
+	}
+}
+
+func TestWriteCSV(t *testing.T) {
+	var out strings.Builder
+	if err := Write(&out, FormatCSV, testFeed()); err != nil {
+		t.Fatalf("Write() error = %v", err)
+	}
+
+	records, err := csv.NewReader(strings.NewReader(out.String())).ReadAll()
+	if err != nil {
+		t.Fatalf("invalid csv: %v", err)
+	}
+	expected := [][]string{
+		{"title", "listened_at"},
+		{`Song 1, "live"`, "2024-01-01T12:00:00Z"},
+		{"Song 2 <remix> & more", "2024-01-02T12:00:00Z"},
+	}
+	if len(records) != len(expected) {
+		t.Fatalf("csv has %d records, expected %d", len(records), len(expected))
+	}
+	for i := range expected {
+		if strings.Join(records[i], "|") != strings.Join(expected[i], "|") {
+			t.Errorf("record[%d] = %v, expected %v", i, records[i], expected[i])
+		}
+	}
+}
+
+func TestWriteCSVEscapesFormulas(t *testing.T) {
+	feed := &musicbrainz.Feed{Username: "xcrochet"}
+	for _, title := range []string{`=HYPERLINK("https://example.com")`, "+1", "-1", "@SUM(A1)", "A-ha"} {
+		feed.Songs = append(feed.Songs, &musicbrainz.Song{Title: title})
+	}
+
+	var out strings.Builder
+	if err := Write(&out, FormatCSV, feed); err != nil {
+		t.Fatalf("Write() error = %v", err)
+	}
+	records, err := csv.NewReader(strings.NewReader(out.String())).ReadAll()
+	if err != nil {
+		t.Fatalf("invalid csv: %v", err)
+	}
+
+	expected := []string{`'=HYPERLINK("https://example.com")`, "'+1", "'-1", "'@SUM(A1)", "A-ha"}
+	for i, title := range expected {
+		if records[i+1][0] != title {
+			t.Errorf("record[%d] title = %v, expected %v", i+1, records[i+1][0], title)
+		}
+	}
+}
+
+func TestWriteJSONL(t *testing.T) {
+	var out strings.Builder
+	if err := Write(&out, FormatJSONL, testFeed()); err != nil {
+		t.Fatalf("Write() error = %v", err)
+	}
+
+	lines := strings.Split(strings.TrimSuffix(out.String(), "\n"), "\n")
+	if len(lines) != 2 {
+		t.Fatalf("jsonl has %d lines, expected 2", len(lines))
+	}
+	for i, line := range lines {
+		var song musicbrainz.Song
+		if err := json.Unmarshal([]byte(line), &song); err != nil {
+			t.Fatalf("line %d is invalid: %v", i, err)
+		}
+		if song != *testFeed().Songs[i] {
+			t.Errorf("line %d = %+v, expected %+v", i, song, testFeed().Songs[i])
+		}
+	}
+}
+
+func TestWriteXSPF(t *testing.T) {
+	var out strings.Builder
+	if err := Write(&out, FormatXSPF, testFeed()); err != nil {
+		t.Fatalf("Write() error = %v", err)
+	}
+
+	var playlist xspfPlaylist
+	if err := xml.Unmarshal([]byte(out.String()), &playlist); err != nil {
+		t.Fatalf("invalid xspf: %v", err)
+	}
+	if playlist.XMLName.Space != "http://xspf.org/ns/0/" || playlist.Version != "1" || playlist.Creator != "xcrochet" {
+		t.Errorf("playlist = %+v, expected a version 1 xspf playlist created by xcrochet", playlist)
+	}
+	assertTracks(t, len(playlist.Tracks), func(i int) (string, string) {
+		return playlist.Tracks[i].Title, playlist.Tracks[i].Annotation
+	})
+}
+
+func TestWriteJSPF(t *testing.T) {
+	var out strings.Builder
+	if err := Write(&out, FormatJSPF, testFeed()); err != nil {
+		t.Fatalf("Write() error = %v", err)
+	}
+
+	var document jspfDocument
+	if err := json.Unmarshal([]byte(out.String()), &document); err != nil {
+		t.Fatalf("invalid jspf: %v", err)
+	}
+	if document.Playlist.Title != "Music feed of xcrochet" || document.Playlist.Creator != "xcrochet" {
+		t.Errorf("playlist = %+v, expected the playlist of xcrochet", document.Playlist)
+	}
+	assertTracks(t, len(document.Playlist.Tracks), func(i int) (string, string) {
+		return document.Playlist.Tracks[i].Title, document.Playlist.Tracks[i].Annotation
+	})
+}
+
+// compare the tracks of a playlist with the songs of testFeed
+func assertTracks(t *testing.T, count int, track func(i int) (string, string)) {
+	t.Helper()
+
+	songs := testFeed().Songs
+	if count != len(songs) {
+		t.Fatalf("playlist has %d tracks, expected %d", count, len(songs))
+	}
+	for i, song := range songs {
+		title, annotation := track(i)
+		if title != song.Title || annotation != trackAnnotation(song) {
+			t.Errorf("track[%d] = %v (%v), expected %v (%v)", i, title, annotation, song.Title, trackAnnotation(song))
+		}
+	}
+}
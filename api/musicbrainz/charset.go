@@ -0,0 +1,21 @@
+package musicbrainz
+
+import (
+	"fmt"
+	"io"
+
+	"golang.org/x/text/encoding/htmlindex"
+)
+
+/*
+Used by the xml decoder when a feed isn't encoded in UTF-8, i.e. <?xml version="1.0" encoding="ISO-8859-1"?>
+
+Any encoding known to browsers is supported, under any of its names
+*/
+func charsetReader(charset string, input io.Reader) (io.Reader, error) {
+	encoding, err := htmlindex.Get(charset)
+	if err != nil {
+		return nil, fmt.Errorf("unsupported charset %q: %w", charset, err)
+	}
+	return encoding.NewDecoder().Reader(input), nil
+}
@@ -0,0 +1,97 @@
+package musicbrainz
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+)
+
+// listens of the same track submitted within this duration are considered the same listen
+const DefaultDuplicateTolerance = 30 * time.Second
+
+/*
+Returns the stable identifier of a listen
+
+Listens are identified by the id ListenBrainz gives them, which doesn't change between polls. Entries without one are
+identified by their title and the time they were listened at
+*/
+func ListenID(entry Entry) string {
+	if entry.ID != "" {
+		return entry.ID
+	}
+
+	sum := sha256.Sum256([]byte(fmt.Sprintf("%s\n%s", entry.Title, entry.Updated.UTC().Format(time.RFC3339Nano))))
+	return "sha256:" + hex.EncodeToString(sum[:])
+}
+
+/*
+Merge feed pages into a single list of listens, newest first
+
+  - Pages can overlap: a listen found in several pages is kept once, in its latest version (the one updated last, or the
+    one of the last page).
+  - A track submitted twice, i.e. by two scrobblers, appears as two listens with different ids. Listens of the same
+    title listened at within tolerance of each other are considered the same, the one published first is kept.
+*/
+func Dedup(tolerance time.Duration, pages ...[]Entry) []Entry {
+	byID := map[string]Entry{}
+	for _, page := range pages {
+		for _, entry := range page {
+			id := ListenID(entry)
+			if existing, ok := byID[id]; ok && existing.Updated.After(entry.Updated) {
+				continue
+			}
+			byID[id] = entry
+		}
+	}
+
+	entries := make([]Entry, 0, len(byID))
+	for _, entry := range byID {
+		entries = append(entries, entry)
+	}
+
+	// oldest first, so a listen is only compared to the previous listen of the same title
+	sort.Slice(entries, func(i, j int) bool {
+		if !entries[i].Updated.Equal(entries[j].Updated) {
+			return entries[i].Updated.Before(entries[j].Updated)
+		}
+		return ListenID(entries[i]) < ListenID(entries[j])
+	})
+
+	result := []Entry{}
+	// index in result of the last listen of each title
+	last := map[string]int{}
+	for _, entry := range entries {
+		key := strings.ToLower(strings.TrimSpace(entry.Title))
+
+		if i, ok := last[key]; ok && entry.Updated.Sub(result[i].Updated) <= tolerance {
+			if firstPublished(entry, result[i]) {
+				result[i] = entry
+			}
+			continue
+		}
+
+		last[key] = len(result)
+		result = append(result, entry)
+	}
+
+	// newest first, like the feed
+	sort.Slice(result, func(i, j int) bool {
+		if !result[i].Updated.Equal(result[j].Updated) {
+			return result[i].Updated.After(result[j].Updated)
+		}
+		return ListenID(result[i]) > ListenID(result[j])
+	})
+
+	return result
+}
+
+// true if a was published before b
+func firstPublished(a, b Entry) bool {
+	if !a.Published.Equal(b.Published) {
+		return a.Published.Before(b.Published)
+	}
+	return ListenID(a) < ListenID(b)
+}
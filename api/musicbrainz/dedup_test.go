@@ -0,0 +1,120 @@
+package musicbrainz
+
+import (
+	"encoding/xml"
+	"os"
+	"path"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// two polls of the same user half an hour apart, the second overlapping the first
+func loadPages(t *testing.T) (page1, page2 []Entry) {
+	t.Helper()
+
+	load := func(name string) []Entry {
+		data, err := os.ReadFile(filepath.Join("testdata", name))
+		if err != nil {
+			t.Fatalf("ReadFile() error = %v", err)
+		}
+		var feed FeedXml
+		if err := xml.Unmarshal(data, &feed); err != nil {
+			t.Fatalf("Unmarshal() error = %v", err)
+		}
+		return feed.Entries
+	}
+
+	return load("page1.xml"), load("page2.xml")
+}
+
+// last segment of the listenbrainz ids, for readability
+func shortIDs(entries []Entry) []string {
+	ids := []string{}
+	for _, entry := range entries {
+		ids = append(ids, path.Base(entry.ID))
+	}
+	return ids
+}
+
+func TestDedup(t *testing.T) {
+	page1, page2 := loadPages(t)
+
+	tests := []struct {
+		name      string
+		tolerance time.Duration
+		pages     [][]Entry
+		expected  []string
+	}{
+		{
+			name:      "overlapping pages and duplicate submissions",
+			tolerance: DefaultDuplicateTolerance,
+			pages:     [][]Entry{page1, page2},
+			// c2 and d2 were submitted twice, g1 and g2 are two listens of the same track
+			expected: []string{"e1", "g2", "g1", "a1", "b1", "c1", "d1"},
+		},
+		{
+			name:      "the order of the pages doesn't matter",
+			tolerance: DefaultDuplicateTolerance,
+			pages:     [][]Entry{page2, page1},
+			expected:  []string{"e1", "g2", "g1", "a1", "b1", "c1", "d1"},
+		},
+		{
+			name:      "no tolerance only merges the pages",
+			tolerance: 0,
+			pages:     [][]Entry{page1, page2},
+			expected:  []string{"e1", "g2", "g1", "a1", "b1", "c2", "c1", "d2", "d1"},
+		},
+		{
+			name:      "a larger tolerance merges repeated listens",
+			tolerance: 5 * time.Minute,
+			pages:     [][]Entry{page1, page2},
+			expected:  []string{"e1", "g1", "a1", "b1", "c1", "d1"},
+		},
+		{
+			name:      "no pages",
+			tolerance: DefaultDuplicateTolerance,
+			expected:  []string{},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := shortIDs(Dedup(tt.tolerance, tt.pages...))
+			if len(got) != len(tt.expected) {
+				t.Fatalf("Dedup() = %v, expected %v", got, tt.expected)
+			}
+			for i := range got {
+				if got[i] != tt.expected[i] {
+					t.Fatalf("Dedup() = %v, expected %v", got, tt.expected)
+				}
+			}
+		})
+	}
+}
+
+func TestDedupKeepsTheLatestVersion(t *testing.T) {
+	page1, page2 := loadPages(t)
+
+	for _, entry := range Dedup(DefaultDuplicateTolerance, page1, page2) {
+		if path.Base(entry.ID) == "b1" && entry.Title != "Aerodynamic (Radio Edit) — Daft Punk" {
+			t.Errorf("title = %v, expected the version of the last page", entry.Title)
+		}
+	}
+}
+
+func TestListenID(t *testing.T) {
+	at := parseTime("2024-01-01T12:00:00Z")
+
+	if id := ListenID(Entry{ID: "listen-1", Title: "Song", Updated: at}); id != "listen-1" {
+		t.Errorf("ListenID() = %v, expected the listenbrainz id", id)
+	}
+
+	// without an id, the same listen must be identified the same way on every poll
+	first := ListenID(Entry{Title: "Song", Updated: at})
+	again := ListenID(Entry{Title: "Song", Updated: at.In(time.FixedZone("CET", 3600))})
+	other := ListenID(Entry{Title: "Song", Updated: at.Add(time.Second)})
+	if first != again || first == other {
+		t.Errorf("ListenID() = %v, %v, %v, expected stable ids for the same listen only", first, again, other)
+	}
+}
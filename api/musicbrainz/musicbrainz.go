@@ -3,8 +3,9 @@ package musicbrainz
 import (
 	"encoding/xml"
 	"fmt"
-	"io"
 	"net/http"
+	"net/url"
+	"strings"
 	"time"
 
 	"github.com/xaviercrochet/turbo-octo-adventure/pkg/net"
@@ -40,52 +41,142 @@ type Content struct {
 	Text string `xml:",chardata" json:"text"`
 }
 
+// address of the ListenBrainz api
+const DefaultBaseURL = "https://listenbrainz.org"
+
+// Client of the ListenBrainz syndication feeds
+type Client struct {
+	baseURL    string
+	httpClient *http.Client
+	// larger feeds fail with *net.ResponseTooLargeError
+	maxBodySize int64
+}
+
+// ClientOption allows customization of the client returned by NewClient
+type ClientOption func(*Client)
+
+// WithBaseURL calls another ListenBrainz instance than DefaultBaseURL, i.e. a fake one in tests
+func WithBaseURL(baseURL string) ClientOption {
+	return func(c *Client) {
+		c.baseURL = strings.TrimSuffix(baseURL, "/")
+	}
+}
+
+// WithHTTPClient replaces http.DefaultClient
+func WithHTTPClient(httpClient *http.Client) ClientOption {
+	return func(c *Client) {
+		c.httpClient = httpClient
+	}
+}
+
+// WithMaxBodySize replaces net.DefaultMaxBodySize, the maximum size of a feed in bytes
+func WithMaxBodySize(size int64) ClientOption {
+	return func(c *Client) {
+		c.maxBodySize = size
+	}
+}
+
+func NewClient(options ...ClientOption) *Client {
+	c := &Client{
+		baseURL:     DefaultBaseURL,
+		httpClient:  http.DefaultClient,
+		maxBodySize: net.DefaultMaxBodySize,
+	}
+	for _, option := range options {
+		option(c)
+	}
+	return c
+}
+
+// client of the package level functions
+var DefaultClient = NewClient()
+
 // Integrate the feed api from musicbrainz
 func GetFeed(username string) (*Feed, error) {
-	// 5000 is the maximum time range the API allows
-	reqUrl := fmt.Sprintf("https://listenbrainz.org/syndication-feed/user/%s/listens?minutes=5000", username)
+	return DefaultClient.GetFeed(username)
+}
 
-	resp, err := http.Get(reqUrl)
+// Integrate the feed api from musicbrainz
+func (c *Client) GetFeed(username string) (*Feed, error) {
+	feed, err := c.GetFeedXml(username)
+	if err != nil {
+		return nil, err
+	}
+
+	return feedXmlToFeed(username, *feed), nil
+}
+
+// the maximum time range, in minutes, the API allows
+const MaxWindowMinutes = 5000
+
+// See Client.GetFeedXml
+func GetFeedXml(username string) (*FeedXml, error) {
+	return DefaultClient.GetFeedXml(username)
+}
+
+/*
+Retrieve the raw feed of the user over the maximum time range, the entries keep their musicbrainz ids
+
+An unknown user has an empty feed
+*/
+func (c *Client) GetFeedXml(username string) (*FeedXml, error) {
+	return c.GetListens(username, MaxWindowMinutes)
+}
+
+// See Client.GetListens
+func GetListens(username string, minutes int) (*FeedXml, error) {
+	return DefaultClient.GetListens(username, minutes)
+}
+
+// Retrieve the raw feed of the user over the last minutes, capped at MaxWindowMinutes
+func (c *Client) GetListens(username string, minutes int) (*FeedXml, error) {
+	minutes = max(1, min(minutes, MaxWindowMinutes))
+	reqUrl := fmt.Sprintf("%s/syndication-feed/user/%s/listens?minutes=%d", c.baseURL, url.PathEscape(username), minutes)
+
+	resp, err := c.httpClient.Get(reqUrl)
 	if err != nil {
 		return nil, fmt.Errorf("failed to query feed api: %w", err)
 	}
 	defer resp.Body.Close()
 
 	if resp.StatusCode == http.StatusNotFound {
-		return &Feed{
-			Username: username,
-			Songs:    []*Song{},
-		}, nil
+		return &FeedXml{Entries: []Entry{}}, nil
 	}
 
 	if err := net.HttpStatusCodeToErr(resp); err != nil {
 		return nil, fmt.Errorf("failed to query musicbrainz api: %v", err)
 	}
 
-	body, err := io.ReadAll(resp.Body)
-	if err != nil {
-		return nil, fmt.Errorf("failed to parse response body: %w", err)
-	}
+	// the feed is decoded as it is read, never more than maxBodySize bytes
+	decoder := xml.NewDecoder(net.LimitReader(resp.Body, c.maxBodySize))
+	decoder.CharsetReader = charsetReader
 
 	var feed FeedXml
-	err = xml.Unmarshal(body, &feed)
-	if err != nil {
+	if err := decoder.Decode(&feed); err != nil {
 		return nil, fmt.Errorf("failed to deserialize xml: %w", err)
 	}
 
-	return feedXmlToFeed(username, feed), nil
+	return &feed, nil
 }
 
 // MAP the deserialized XML response to Feed, the struct that will be used later
 func feedXmlToFeed(username string, feedXml FeedXml) *Feed {
+	return NewFeed(username, feedXml.Entries)
+}
+
+// Build the feed of the user out of raw entries, i.e. archived ones
+func NewFeed(username string, entries []Entry) *Feed {
 	feed := &Feed{
 		Username: username,
 		Songs:    []*Song{},
 	}
 
-	for _, entry := range feedXml.Entries {
+	for _, entry := range entries {
 		song := &Song{
+			ID:         ListenID(entry),
 			Title:      entry.Title,
+			Published:  entry.Published,
+			Updated:    entry.Updated,
 			ListenedAt: entry.Updated,
 		}
 
@@ -102,6 +193,12 @@ type Feed struct {
 }
 
 type Song struct {
-	Title      string    `json:"title"`
+	// stable across polls, see ListenID
+	ID    string `json:"id"`
+	Title string `json:"title"`
+	// when the listen was submitted, and last changed
+	Published time.Time `json:"published"`
+	Updated   time.Time `json:"updated"`
+	// kept for existing consumers, the same as Updated
 	ListenedAt time.Time `json:"listened_at"`
 }
@@ -1,9 +1,17 @@
 package musicbrainz
 
 import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
 	"reflect"
+	"strings"
 	"testing"
 	"time"
+
+	"github.com/xaviercrochet/turbo-octo-adventure/pkg/net"
 )
 
 func TestFeedXmlToFeed(t *testing.T) {
@@ -28,8 +36,10 @@ func TestFeedXmlToFeed(t *testing.T) {
 			feedXml: FeedXml{
 				Entries: []Entry{
 					{
-						Title:   "Test Song",
-						Updated: parseTime("2024-01-01T12:00:00Z"),
+						ID:        "Test Song id",
+						Title:     "Test Song",
+						Published: parseTime("2024-01-01T12:00:00Z"),
+						Updated:   parseTime("2024-01-01T12:00:00Z"),
 					},
 				},
 			},
@@ -37,7 +47,10 @@ func TestFeedXmlToFeed(t *testing.T) {
 				Username: "Test Username",
 				Songs: []*Song{
 					{
+						ID:         "Test Song id",
 						Title:      "Test Song",
+						Published:  parseTime("2024-01-01T12:00:00Z"),
+						Updated:    parseTime("2024-01-01T12:00:00Z"),
 						ListenedAt: parseTime("2024-01-01T12:00:00Z"),
 					},
 				},
@@ -49,16 +62,22 @@ func TestFeedXmlToFeed(t *testing.T) {
 			feedXml: FeedXml{
 				Entries: []Entry{
 					{
-						Title:   "Song 1",
-						Updated: parseTime("2024-01-01T12:00:00Z"),
+						ID:        "Song 1 id",
+						Title:     "Song 1",
+						Published: parseTime("2024-01-01T12:00:00Z"),
+						Updated:   parseTime("2024-01-01T12:00:00Z"),
 					},
 					{
-						Title:   "Song 2",
-						Updated: parseTime("2024-01-02T12:00:00Z"),
+						ID:        "Song 2 id",
+						Title:     "Song 2",
+						Published: parseTime("2024-01-02T12:00:00Z"),
+						Updated:   parseTime("2024-01-02T12:00:00Z"),
 					},
 					{
-						Title:   "Song 3",
-						Updated: parseTime("2024-01-03T12:00:00Z"),
+						ID:        "Song 3 id",
+						Title:     "Song 3",
+						Published: parseTime("2024-01-03T12:00:00Z"),
+						Updated:   parseTime("2024-01-03T12:00:00Z"),
 					},
 				},
 			},
@@ -66,15 +85,24 @@ func TestFeedXmlToFeed(t *testing.T) {
 				Username: "Test Username",
 				Songs: []*Song{
 					{
+						ID:         "Song 1 id",
 						Title:      "Song 1",
+						Published:  parseTime("2024-01-01T12:00:00Z"),
+						Updated:    parseTime("2024-01-01T12:00:00Z"),
 						ListenedAt: parseTime("2024-01-01T12:00:00Z"),
 					},
 					{
+						ID:         "Song 2 id",
 						Title:      "Song 2",
+						Published:  parseTime("2024-01-02T12:00:00Z"),
+						Updated:    parseTime("2024-01-02T12:00:00Z"),
 						ListenedAt: parseTime("2024-01-02T12:00:00Z"),
 					},
 					{
+						ID:         "Song 3 id",
 						Title:      "Song 3",
+						Published:  parseTime("2024-01-03T12:00:00Z"),
+						Updated:    parseTime("2024-01-03T12:00:00Z"),
 						ListenedAt: parseTime("2024-01-03T12:00:00Z"),
 					},
 				},
@@ -86,8 +114,10 @@ func TestFeedXmlToFeed(t *testing.T) {
 			feedXml: FeedXml{
 				Entries: []Entry{
 					{
-						Title:   "Test Song",
-						Updated: parseTime("2024-01-01T12:00:00Z"),
+						ID:        "Test Song id",
+						Title:     "Test Song",
+						Published: parseTime("2024-01-01T12:00:00Z"),
+						Updated:   parseTime("2024-01-01T12:00:00Z"),
 					},
 				},
 			},
@@ -95,7 +125,10 @@ func TestFeedXmlToFeed(t *testing.T) {
 				Username: "",
 				Songs: []*Song{
 					{
+						ID:         "Test Song id",
 						Title:      "Test Song",
+						Published:  parseTime("2024-01-01T12:00:00Z"),
+						Updated:    parseTime("2024-01-01T12:00:00Z"),
 						ListenedAt: parseTime("2024-01-01T12:00:00Z"),
 					},
 				},
@@ -131,3 +164,93 @@ func parseTime(date string) time.Time {
 	t, _ := time.Parse(time.RFC3339, date)
 	return t
 }
+
+func TestClientGetListens(t *testing.T) {
+	page, err := os.ReadFile(filepath.Join("testdata", "page1.xml"))
+	if err != nil {
+		t.Fatalf("ReadFile() error = %v", err)
+	}
+
+	var requested []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requested = append(requested, r.URL.RequestURI())
+		if r.URL.Path != "/syndication-feed/user/xcrochet/listens" {
+			http.NotFound(w, r)
+			return
+		}
+		w.Write(page)
+	}))
+	defer server.Close()
+
+	client := NewClient(WithBaseURL(server.URL + "/"))
+
+	feed, err := client.GetListens("xcrochet", 2*MaxWindowMinutes)
+	if err != nil {
+		t.Fatalf("GetListens() error = %v", err)
+	}
+	if len(feed.Entries) == 0 {
+		t.Errorf("GetListens() returned no entry")
+	}
+
+	// unknown users have an empty feed
+	feed, err = client.GetFeedXml("unknown")
+	if err != nil || len(feed.Entries) != 0 {
+		t.Errorf("GetFeedXml() = %+v, %v, expected an empty feed", feed, err)
+	}
+
+	expected := []string{
+		"/syndication-feed/user/xcrochet/listens?minutes=5000",
+		"/syndication-feed/user/unknown/listens?minutes=5000",
+	}
+	if !reflect.DeepEqual(requested, expected) {
+		t.Errorf("requested %v, expected %v", requested, expected)
+	}
+}
+
+func TestClientLimitsTheFeedSize(t *testing.T) {
+	page, err := os.ReadFile(filepath.Join("testdata", "page1.xml"))
+	if err != nil {
+		t.Fatalf("ReadFile() error = %v", err)
+	}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write(page)
+	}))
+	defer server.Close()
+
+	if _, err := NewClient(WithBaseURL(server.URL), WithMaxBodySize(int64(len(page)))).GetFeedXml("xcrochet"); err != nil {
+		t.Errorf("GetFeedXml() error = %v, a feed of the maximum size should be accepted", err)
+	}
+
+	_, err = NewClient(WithBaseURL(server.URL), WithMaxBodySize(int64(len(page)/2))).GetFeedXml("xcrochet")
+	var tooLarge *net.ResponseTooLargeError
+	if !errors.As(err, &tooLarge) || tooLarge.Limit != int64(len(page)/2) {
+		t.Errorf("GetFeedXml() error = %v, expected a ResponseTooLargeError", err)
+	}
+}
+
+func TestClientDecodesCharsets(t *testing.T) {
+	// "Café Del Mar" encoded in ISO-8859-1
+	feed := "<?xml version=\"1.0\" encoding=\"ISO-8859-1\"?>\n" +
+		"<feed xmlns=\"http://www.w3.org/2005/Atom\"><entry><id>1</id><title>Caf\xe9 Del Mar</title></entry></feed>"
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/atom+xml; charset=ISO-8859-1")
+		w.Write([]byte(feed))
+	}))
+	defer server.Close()
+
+	got, err := NewClient(WithBaseURL(server.URL)).GetFeedXml("xcrochet")
+	if err != nil {
+		t.Fatalf("GetFeedXml() error = %v", err)
+	}
+	if len(got.Entries) != 1 || got.Entries[0].Title != "Café Del Mar" {
+		t.Errorf("GetFeedXml() = %+v, expected the title decoded to UTF-8", got.Entries)
+	}
+
+	unknown := strings.Replace(feed, "ISO-8859-1", "x-klingon", 1)
+	server.Config.Handler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(unknown))
+	})
+	if _, err := NewClient(WithBaseURL(server.URL)).GetFeedXml("xcrochet"); err == nil {
+		t.Error("GetFeedXml() should fail on an unknown charset")
+	}
+}
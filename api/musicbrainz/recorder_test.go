@@ -0,0 +1,126 @@
+package musicbrainz
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestRecordAndReplay(t *testing.T) {
+	page, err := os.ReadFile(filepath.Join("testdata", "page1.xml"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	calls := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		if r.URL.Path != "/syndication-feed/user/xcrochet/listens" {
+			http.NotFound(w, r)
+			return
+		}
+		w.Header().Set("Content-Type", "application/atom+xml")
+		w.Header().Set("X-RateLimit-Remaining", "41")
+		w.Write(page)
+	}))
+	dir := t.TempDir()
+
+	recording := NewClient(WithBaseURL(server.URL), WithHTTPClient(&http.Client{Transport: NewRecorder(dir, ModeRecord, nil)}))
+	recorded, err := recording.GetFeed("xcrochet")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := recording.GetFeed("nobody"); err != nil {
+		t.Fatal(err)
+	}
+	server.Close()
+
+	files, _ := filepath.Glob(filepath.Join(dir, "*.json"))
+	if calls != 2 || len(files) != 2 {
+		t.Fatalf("expected 2 calls and 2 fixtures, got %d calls and %v", calls, files)
+	}
+
+	// nothing listens on the base url anymore, the fixtures answer
+	recorder := NewRecorder(dir, ModeReplay, nil)
+	replaying := NewClient(WithBaseURL("http://listenbrainz.invalid"), WithHTTPClient(&http.Client{Transport: recorder}))
+	replayed, err := replaying.GetFeed("xcrochet")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(replayed.Songs) != len(recorded.Songs) || replayed.Songs[0].ID != recorded.Songs[0].ID {
+		t.Errorf("replayed feed differs from the recorded one: %+v", replayed.Songs)
+	}
+
+	// the recorded 404 is replayed as well
+	unknown, err := replaying.GetFeed("nobody")
+	if err != nil || len(unknown.Songs) != 0 {
+		t.Errorf("expected the empty feed of an unknown user, got %+v, %v", unknown, err)
+	}
+
+	// headers and status are replayed
+	req, _ := http.NewRequest(http.MethodGet, "http://listenbrainz.invalid/syndication-feed/user/xcrochet/listens?minutes=5000", nil)
+	resp, err := recorder.RoundTrip(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK || resp.Header.Get("X-RateLimit-Remaining") != "41" || resp.ContentLength != int64(len(page)) {
+		t.Errorf("unexpected replayed response: %d %v %d", resp.StatusCode, resp.Header, resp.ContentLength)
+	}
+
+	// requests that weren't recorded fail
+	if _, err := replaying.GetListens("xcrochet", 60); !errors.Is(err, ErrNoFixture) {
+		t.Errorf("expected ErrNoFixture, got %v", err)
+	}
+}
+
+func TestReplayIgnoresQueryOrder(t *testing.T) {
+	dir := t.TempDir()
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(r.URL.RawQuery))
+	}))
+	defer server.Close()
+
+	get := func(recorder *Recorder, query string) (string, error) {
+		req, _ := http.NewRequest(http.MethodGet, server.URL+"/listens?"+query, nil)
+		resp, err := recorder.RoundTrip(req)
+		if err != nil {
+			return "", err
+		}
+		defer resp.Body.Close()
+		body := make([]byte, 64)
+		n, _ := resp.Body.Read(body)
+		return string(body[:n]), nil
+	}
+
+	if _, err := get(NewRecorder(dir, ModeRecord, nil), "minutes=60&tag=b&tag=a"); err != nil {
+		t.Fatal(err)
+	}
+
+	replay := NewRecorder(dir, ModeReplay, nil)
+	for _, query := range []string{"minutes=60&tag=b&tag=a", "tag=a&minutes=60&tag=b", "tag=b&tag=a&minutes=60"} {
+		body, err := get(replay, query)
+		if err != nil {
+			t.Errorf("%s: %v", query, err)
+		} else if body != "minutes=60&tag=b&tag=a" {
+			t.Errorf("%s: replayed %q", query, body)
+		}
+	}
+
+	if _, err := get(replay, "minutes=61&tag=b&tag=a"); !errors.Is(err, ErrNoFixture) {
+		t.Errorf("other parameters should not match, got %v", err)
+	}
+}
+
+func TestParseMode(t *testing.T) {
+	for _, value := range []string{"live", "record", "replay"} {
+		if mode, err := ParseMode(value); err != nil || string(mode) != value {
+			t.Errorf("%s: got %q, %v", value, mode, err)
+		}
+	}
+	if _, err := ParseMode("rewind"); err == nil {
+		t.Error("unknown modes should be rejected")
+	}
+}
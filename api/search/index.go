@@ -0,0 +1,322 @@
+package search
+
+import (
+	"html"
+	"math"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/xaviercrochet/turbo-octo-adventure/api/musicbrainz"
+)
+
+// BM25 parameters
+const (
+	k1 = 1.2
+	b  = 0.75
+)
+
+// words found in the title of a listen weigh more than those of its content
+const titleWeight = 2
+
+var tagsRegexp = regexp.MustCompile(`<[^>]*>`)
+
+// A listen matching a query
+type Result struct {
+	Listen musicbrainz.Entry `json:"listen"`
+	Score  float64           `json:"score"`
+	// byte ranges of the title matching the query, in order
+	Highlights []Span `json:"highlights"`
+}
+
+// Byte range [Start, End) of a text
+type Span struct {
+	Start int `json:"start"`
+	End   int `json:"end"`
+}
+
+type document struct {
+	listen musicbrainz.Entry
+	// number of words of the title, the content follows
+	titleLength int
+	length      int
+}
+
+// term -> listen id (see musicbrainz.ListenID) -> positions of the term in the listen
+type postings map[string]map[string][]int
+
+type userIndex struct {
+	documents map[string]*document
+	postings  postings
+	// sum of the document lengths, for the average
+	totalLength int
+}
+
+/*
+Index is an in memory inverted index of the listens of every user
+
+ListenBrainz doesn't split the track, the artist and the album of a listen: words are matched against the title and the
+content of the listen, title matches ranking higher. Listens are indexed again when they are updated
+*/
+type Index struct {
+	mu    sync.RWMutex
+	users map[string]*userIndex
+}
+
+func NewIndex() *Index {
+	return &Index{users: map[string]*userIndex{}}
+}
+
+// Index the listens of the user, replacing the previous version of listens already indexed
+func (i *Index) Add(username string, entries []musicbrainz.Entry) {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+
+	user, ok := i.users[username]
+	if !ok {
+		user = &userIndex{documents: map[string]*document{}, postings: postings{}}
+		i.users[username] = user
+	}
+
+	for _, entry := range entries {
+		id := musicbrainz.ListenID(entry)
+		user.remove(id)
+		user.add(id, entry)
+	}
+}
+
+// Number of listens of the user indexed
+func (i *Index) Count(username string) int {
+	i.mu.RLock()
+	defer i.mu.RUnlock()
+
+	if user, ok := i.users[username]; ok {
+		return len(user.documents)
+	}
+	return 0
+}
+
+// Words of the listen: the title, then the text of the content
+func documentTokens(entry musicbrainz.Entry) (title tokens, content tokens) {
+	text := entry.Content.Text
+	if entry.Content.Type == "html" || entry.Content.Type == "xhtml" {
+		text = html.UnescapeString(tagsRegexp.ReplaceAllString(text, " "))
+	}
+	return tokenize(entry.Title), tokenize(text)
+}
+
+func (u *userIndex) add(id string, entry musicbrainz.Entry) {
+	title, content := documentTokens(entry)
+	doc := &document{listen: entry, titleLength: len(title), length: len(title) + len(content)}
+	u.documents[id] = doc
+	u.totalLength += doc.length
+
+	// positions skip one between the title and the content so phrases can't span both
+	for position, token := range append(append(title, token{}), content...) {
+		if token.word == "" {
+			continue
+		}
+		listens, ok := u.postings[token.word]
+		if !ok {
+			listens = map[string][]int{}
+			u.postings[token.word] = listens
+		}
+		listens[id] = append(listens[id], position)
+	}
+}
+
+func (u *userIndex) remove(id string) {
+	doc, ok := u.documents[id]
+	if !ok {
+		return
+	}
+
+	title, content := documentTokens(doc.listen)
+	for _, token := range append(title, content...) {
+		delete(u.postings[token.word], id)
+		if len(u.postings[token.word]) == 0 {
+			delete(u.postings, token.word)
+		}
+	}
+	u.totalLength -= doc.length
+	delete(u.documents, id)
+}
+
+// positions of the listens matching the clause
+func (u *userIndex) match(clause Clause) map[string][]int {
+	if clause.Prefix {
+		result := map[string][]int{}
+		for term, listens := range u.postings {
+			if !strings.HasPrefix(term, clause.Terms[0]) {
+				continue
+			}
+			for id, positions := range listens {
+				result[id] = append(result[id], positions...)
+			}
+		}
+		return result
+	}
+
+	if !clause.phrase() {
+		return u.postings[clause.Terms[0]]
+	}
+
+	// positions of the first word followed by the rest of the phrase
+	result := map[string][]int{}
+	for id, positions := range u.postings[clause.Terms[0]] {
+		for _, start := range positions {
+			if u.phraseAt(id, start, clause.Terms[1:]) {
+				result[id] = append(result[id], start)
+			}
+		}
+	}
+	return result
+}
+
+func (u *userIndex) phraseAt(id string, start int, rest []string) bool {
+	for offset, term := range rest {
+		found := false
+		for _, position := range u.postings[term][id] {
+			if position == start+offset+1 {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+	return true
+}
+
+/*
+Listens of the user matching every clause of the query, by decreasing relevance then newest first
+
+Relevance is the BM25 score of the clauses. At most limit results are returned, all of them if limit is 0
+*/
+func (i *Index) Search(username string, query *Query, limit int) []*Result {
+	i.mu.RLock()
+	defer i.mu.RUnlock()
+
+	results := []*Result{}
+	user, ok := i.users[username]
+	if !ok || len(user.documents) == 0 {
+		return results
+	}
+
+	scores := map[string]float64{}
+	for n, clause := range query.Clauses {
+		matches := user.match(clause)
+
+		// listens must match every clause
+		if n > 0 {
+			for id := range scores {
+				if _, ok := matches[id]; !ok {
+					delete(scores, id)
+				}
+			}
+		}
+
+		total := float64(len(user.documents))
+		idf := math.Log(1 + (total-float64(len(matches))+0.5)/(float64(len(matches))+0.5))
+		averageLength := float64(user.totalLength) / total
+
+		for id, positions := range matches {
+			if _, ok := scores[id]; !ok && n > 0 {
+				continue
+			}
+			doc := user.documents[id]
+			if !inRange(doc.listen.Updated, query.Since, query.Until) {
+				continue
+			}
+
+			frequency := 0.0
+			for _, position := range positions {
+				if position < doc.titleLength {
+					frequency += titleWeight
+				} else {
+					frequency++
+				}
+			}
+			norm := k1 * (1 - b + b*float64(doc.length)/max(averageLength, 1))
+			scores[id] += idf * frequency * (k1 + 1) / (frequency + norm)
+		}
+
+		if len(scores) == 0 {
+			return results
+		}
+	}
+
+	for id, score := range scores {
+		listen := user.documents[id].listen
+		results = append(results, &Result{Listen: listen, Score: score, Highlights: Highlight(listen.Title, query)})
+	}
+
+	sort.Slice(results, func(i, j int) bool {
+		if results[i].Score != results[j].Score {
+			return results[i].Score > results[j].Score
+		}
+		if !results[i].Listen.Updated.Equal(results[j].Listen.Updated) {
+			return results[i].Listen.Updated.After(results[j].Listen.Updated)
+		}
+		return musicbrainz.ListenID(results[i].Listen) > musicbrainz.ListenID(results[j].Listen)
+	})
+
+	if limit > 0 && len(results) > limit {
+		results = results[:limit]
+	}
+
+	return results
+}
+
+func inRange(at, since, until time.Time) bool {
+	if !since.IsZero() && at.Before(since) {
+		return false
+	}
+	if !until.IsZero() && at.After(until) {
+		return false
+	}
+	return true
+}
+
+// Byte ranges of the text matching the clauses of the query, in order
+func Highlight(text string, query *Query) []Span {
+	words := tokenize(text)
+	matched := make([]bool, len(words))
+
+	for _, clause := range query.Clauses {
+		for start := range words {
+			if start+len(clause.Terms) > len(words) {
+				break
+			}
+			if !clauseAt(clause, words[start:]) {
+				continue
+			}
+			for offset := range clause.Terms {
+				matched[start+offset] = true
+			}
+		}
+	}
+
+	spans := []Span{}
+	for n, word := range words {
+		if matched[n] {
+			spans = append(spans, Span{Start: word.start, End: word.end})
+		}
+	}
+	return spans
+}
+
+func clauseAt(clause Clause, words tokens) bool {
+	if clause.Prefix {
+		return strings.HasPrefix(words[0].word, clause.Terms[0])
+	}
+	for offset, term := range clause.Terms {
+		if words[offset].word != term {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,160 @@
+package search
+
+import (
+	"errors"
+	"strings"
+	"time"
+	"unicode"
+)
+
+// layout of the dates accepted by the after: and before: operators
+const dateLayout = "2006-01-02"
+
+var ErrEmptyQuery = errors.New("query must contain at least one word")
+
+/*
+Query is a parsed search query, every clause must match
+
+Syntax:
+  - word: listens containing the word
+  - word*: listens containing a word starting with the prefix
+  - "some words": listens containing the words next to each other, in this order
+  - after:2024-01-31, before:2024-02-28: listens listened at during or after/before the given day
+*/
+type Query struct {
+	Clauses []Clause
+	Since   time.Time
+	Until   time.Time
+}
+
+// A single word, prefix or phrase
+type Clause struct {
+	// normalized words, a single one unless the clause is a phrase
+	Terms  []string
+	Prefix bool
+}
+
+func (c Clause) phrase() bool {
+	return len(c.Terms) > 1
+}
+
+// Parse a query typed by a user, the days of the after: and before: operators start at midnight in location
+func ParseQuery(q string, location *time.Location) (*Query, error) {
+	query := &Query{}
+
+	for _, field := range splitQuery(q) {
+		if strings.HasPrefix(field, `"`) {
+			terms := tokenize(strings.Trim(field, `"`))
+			if len(terms) > 0 {
+				query.Clauses = append(query.Clauses, Clause{Terms: terms.words()})
+			}
+			continue
+		}
+
+		if value, ok := strings.CutPrefix(field, "after:"); ok {
+			day, err := time.ParseInLocation(dateLayout, value, location)
+			if err != nil {
+				return nil, errors.New("after: expects a date formatted as 2006-01-02")
+			}
+			query.Since = day
+			continue
+		}
+		if value, ok := strings.CutPrefix(field, "before:"); ok {
+			day, err := time.ParseInLocation(dateLayout, value, location)
+			if err != nil {
+				return nil, errors.New("before: expects a date formatted as 2006-01-02")
+			}
+			// the whole day is included, days aren't always 24 hours long
+			query.Until = day.AddDate(0, 0, 1).Add(-time.Nanosecond)
+			continue
+		}
+
+		prefix := strings.HasSuffix(field, "*")
+		// a field can hold several words, i.e. "AC/DC"
+		terms := tokenize(strings.TrimSuffix(field, "*")).words()
+		for i, term := range terms {
+			query.Clauses = append(query.Clauses, Clause{Terms: []string{term}, Prefix: prefix && i == len(terms)-1})
+		}
+	}
+
+	if len(query.Clauses) == 0 {
+		return nil, ErrEmptyQuery
+	}
+
+	return query, nil
+}
+
+// split on spaces, except within double quotes. Quoted fields keep their quotes
+func splitQuery(q string) []string {
+	fields := []string{}
+	var current strings.Builder
+	quoted := false
+
+	flush := func() {
+		if current.Len() > 0 {
+			fields = append(fields, current.String())
+			current.Reset()
+		}
+	}
+
+	for _, r := range q {
+		switch {
+		case r == '"':
+			if quoted {
+				current.WriteRune(r)
+				flush()
+			} else {
+				flush()
+				current.WriteRune(r)
+			}
+			quoted = !quoted
+		case unicode.IsSpace(r) && !quoted:
+			flush()
+		default:
+			current.WriteRune(r)
+		}
+	}
+	flush()
+
+	return fields
+}
+
+// A normalized word of a text, with its byte offsets in the text
+type token struct {
+	word       string
+	start, end int
+}
+
+type tokens []token
+
+func (t tokens) words() []string {
+	words := make([]string, 0, len(t))
+	for _, token := range t {
+		words = append(words, token.word)
+	}
+	return words
+}
+
+// Split the text into lower cased words made of letters and digits
+func tokenize(text string) tokens {
+	result := tokens{}
+	start := -1
+
+	for i, r := range text {
+		if unicode.IsLetter(r) || unicode.IsDigit(r) {
+			if start < 0 {
+				start = i
+			}
+			continue
+		}
+		if start >= 0 {
+			result = append(result, token{word: strings.ToLower(text[start:i]), start: start, end: i})
+			start = -1
+		}
+	}
+	if start >= 0 {
+		result = append(result, token{word: strings.ToLower(text[start:]), start: start, end: len(text)})
+	}
+
+	return result
+}
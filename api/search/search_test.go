@@ -0,0 +1,182 @@
+package search
+
+import (
+	"testing"
+	"time"
+
+	"github.com/xaviercrochet/turbo-octo-adventure/api/musicbrainz"
+)
+
+var base = time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+
+// listen with the given id and title, listened at base + offset days
+func listen(id, title, content string, offset int) musicbrainz.Entry {
+	at := base.Add(time.Duration(offset) * 24 * time.Hour)
+	return musicbrainz.Entry{
+		ID:        id,
+		Title:     title,
+		Published: at,
+		Updated:   at,
+		Content:   musicbrainz.Content{Type: "html", Text: content},
+	}
+}
+
+func ids(results []*Result) []string {
+	ids := []string{}
+	for _, result := range results {
+		ids = append(ids, result.Listen.ID)
+	}
+	return ids
+}
+
+func TestParseQuery(t *testing.T) {
+	query, err := ParseQuery(`  Daft punk* "Harder, better"  after:2024-01-02 before:2024-01-03`, time.UTC)
+	if err != nil {
+		t.Fatalf("ParseQuery() error = %v", err)
+	}
+
+	expected := []Clause{
+		{Terms: []string{"daft"}},
+		{Terms: []string{"punk"}, Prefix: true},
+		{Terms: []string{"harder", "better"}},
+	}
+	if len(query.Clauses) != len(expected) {
+		t.Fatalf("clauses = %+v, expected %+v", query.Clauses, expected)
+	}
+	for i, clause := range query.Clauses {
+		if clause.Prefix != expected[i].Prefix || len(clause.Terms) != len(expected[i].Terms) {
+			t.Fatalf("clauses = %+v, expected %+v", query.Clauses, expected)
+		}
+		for j := range clause.Terms {
+			if clause.Terms[j] != expected[i].Terms[j] {
+				t.Fatalf("clauses = %+v, expected %+v", query.Clauses, expected)
+			}
+		}
+	}
+
+	if !query.Since.Equal(base.Add(12*time.Hour)) || query.Until.Format(time.RFC3339) != "2024-01-03T23:59:59Z" {
+		t.Errorf("range = %v - %v, expected the 2nd to the end of the 3rd", query.Since, query.Until)
+	}
+
+	// days start at midnight in the timezone of the user
+	brussels, _ := time.LoadLocation("Europe/Brussels")
+	query, _ = ParseQuery("punk after:2024-01-02", brussels)
+	if query.Since.UTC().Format(time.RFC3339) != "2024-01-01T23:00:00Z" {
+		t.Errorf("since = %v, expected midnight in Brussels", query.Since.UTC())
+	}
+
+	for _, invalid := range []string{"", `  "" `, "after:yesterday", "before:2024-13-01"} {
+		if _, err := ParseQuery(invalid, time.UTC); err == nil {
+			t.Errorf("ParseQuery(%q) error = nil, expected an error", invalid)
+		}
+	}
+}
+
+func TestSearch(t *testing.T) {
+	index := NewIndex()
+	index.Add("alice", []musicbrainz.Entry{
+		listen("1", "Harder, Better, Faster, Stronger by Daft Punk", "<a href=\"https://musicbrainz.org\">Discovery</a>", 0),
+		listen("2", "One More Time by Daft Punk", "Discovery", 1),
+		listen("3", "Better Together by Jack Johnson", "In Between Dreams", 2),
+		listen("4", "Punk Rock Song by Bad Religion", "The Gray Race", 3),
+		listen("5", "Something About Us", "Discovery by Daft Punk", 4),
+	})
+	index.Add("bob", []musicbrainz.Entry{listen("b1", "Digital Love by Daft Punk", "Discovery", 0)})
+
+	tests := []struct {
+		name     string
+		query    string
+		expected []string
+	}{
+		{name: "word, title matches rank higher", query: "punk", expected: []string{"2", "1", "4", "5"}},
+		{name: "every word must match", query: "daft discovery", expected: []string{"2", "1", "5"}},
+		{name: "phrase", query: `"better faster"`, expected: []string{"1"}},
+		{name: "phrase in order only", query: `"faster better"`, expected: []string{}},
+		{name: "phrases don't span title and content", query: `"punk discovery"`, expected: []string{}},
+		{name: "prefix", query: "tog*", expected: []string{"3"}},
+		{name: "album in the content", query: "dreams", expected: []string{"3"}},
+		{name: "markup is not indexed", query: "href", expected: []string{}},
+		{name: "date range", query: "punk after:2024-01-02 before:2024-01-04", expected: []string{"2", "4"}},
+		{name: "no match", query: "metallica", expected: []string{}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			query, err := ParseQuery(tt.query, time.UTC)
+			if err != nil {
+				t.Fatalf("ParseQuery() error = %v", err)
+			}
+			got := ids(index.Search("alice", query, 0))
+			if len(got) != len(tt.expected) {
+				t.Fatalf("Search() = %v, expected %v", got, tt.expected)
+			}
+			for i := range got {
+				if got[i] != tt.expected[i] {
+					t.Fatalf("Search() = %v, expected %v", got, tt.expected)
+				}
+			}
+		})
+	}
+
+	query, _ := ParseQuery("punk", time.UTC)
+	if got := ids(index.Search("alice", query, 2)); len(got) != 2 {
+		t.Errorf("Search() = %v, expected 2 results", got)
+	}
+	if got := ids(index.Search("carol", query, 0)); len(got) != 0 {
+		t.Errorf("Search() = %v, expected nothing for an unknown user", got)
+	}
+}
+
+func TestReindex(t *testing.T) {
+	index := NewIndex()
+	index.Add("alice", []musicbrainz.Entry{listen("1", "Around the World", "", 0)})
+	index.Add("alice", []musicbrainz.Entry{listen("1", "Digital Love", "", 0)})
+
+	if index.Count("alice") != 1 {
+		t.Errorf("Count() = %v, expected 1", index.Count("alice"))
+	}
+
+	old, _ := ParseQuery("world", time.UTC)
+	if got := index.Search("alice", old, 0); len(got) != 0 {
+		t.Errorf("Search(world) = %v, expected the old title to be forgotten", ids(got))
+	}
+	current, _ := ParseQuery("love", time.UTC)
+	if got := index.Search("alice", current, 0); len(got) != 1 {
+		t.Errorf("Search(love) = %v, expected the new title", ids(got))
+	}
+}
+
+func TestListensWithoutID(t *testing.T) {
+	index := NewIndex()
+	index.Add("alice", []musicbrainz.Entry{listen("", "Around the World", "", 0), listen("", "Digital Love", "", 0)})
+
+	// keyed like the archive, so they don't replace each other
+	if index.Count("alice") != 2 {
+		t.Errorf("Count() = %v, expected 2", index.Count("alice"))
+	}
+	query, _ := ParseQuery("world", time.UTC)
+	if got := index.Search("alice", query, 0); len(got) != 1 || got[0].Listen.Title != "Around the World" {
+		t.Errorf("Search(world) = %v, expected Around the World", got)
+	}
+}
+
+func TestHighlight(t *testing.T) {
+	query, _ := ParseQuery(`"one more" tim*`, time.UTC)
+	title := "One More Time, one more"
+
+	spans := Highlight(title, query)
+	got := []string{}
+	for _, span := range spans {
+		got = append(got, title[span.Start:span.End])
+	}
+
+	expected := []string{"One", "More", "Time", "one", "more"}
+	if len(got) != len(expected) {
+		t.Fatalf("Highlight() = %v, expected %v", got, expected)
+	}
+	for i := range got {
+		if got[i] != expected[i] {
+			t.Fatalf("Highlight() = %v, expected %v", got, expected)
+		}
+	}
+}
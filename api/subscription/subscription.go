@@ -0,0 +1,258 @@
+package subscription
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+var (
+	ErrInvalidToken = errors.New("invalid subscription token")
+	ErrNotFound     = errors.New("subscription not found")
+)
+
+/*
+A subscription allows a feed reader, which can't log in, to read the feed on behalf of a user
+
+A user has at most one subscription, creating a new one revokes the previous one. The subscription only grants access
+to the feed of the organisation of the user
+*/
+type Subscription struct {
+	// random, identifies the subscription within its token
+	ID        string    `json:"id"`
+	OrgID     string    `json:"org_id"`
+	UserID    string    `json:"user_id"`
+	Username  string    `json:"username"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// signed part of the token
+type claims struct {
+	ID     string `json:"id"`
+	OrgID  string `json:"org"`
+	UserID string `json:"sub"`
+}
+
+/*
+Store issues and verifies subscription tokens
+
+A token is <base64url(claims)>.<base64url(hmac(claims))>. The signature proves the token was issued by the store, the
+lookup of the subscription allows it to be revoked. Subscriptions are kept in memory and, if a file is given, saved to
+it so tokens survive restarts
+*/
+type Store struct {
+	key []byte
+
+	mu sync.RWMutex
+	// keyed by organisation and user, see subscriptionKey
+	subscriptions map[string]*Subscription
+	path          string
+}
+
+// Create an in memory store, key is used to sign the tokens and must be kept secret
+func NewStore(key []byte) *Store {
+	return &Store{
+		key:           key,
+		subscriptions: map[string]*Subscription{},
+	}
+}
+
+// Create a store persisted in path, existing subscriptions are loaded
+func OpenStore(key []byte, path string) (*Store, error) {
+	s := NewStore(key)
+	s.path = path
+
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return s, nil
+	} else if err != nil {
+		return nil, fmt.Errorf("failed to read subscriptions: %w", err)
+	}
+
+	var subscriptions []*Subscription
+	if err := json.Unmarshal(data, &subscriptions); err != nil {
+		return nil, fmt.Errorf("failed to deserialize subscriptions: %w", err)
+	}
+	for _, sub := range subscriptions {
+		s.subscriptions[subscriptionKey(sub.OrgID, sub.UserID)] = sub
+	}
+
+	return s, nil
+}
+
+// Create a subscription for the user of the organisation, the previous one is revoked. Returns the new subscription and
+// its token
+func (s *Store) Create(orgID, userID, username string) (*Subscription, string, error) {
+	id := make([]byte, 16)
+	if _, err := rand.Read(id); err != nil {
+		return nil, "", fmt.Errorf("failed to generate subscription id: %w", err)
+	}
+
+	sub := &Subscription{
+		ID:        hex.EncodeToString(id),
+		OrgID:     orgID,
+		UserID:    userID,
+		Username:  username,
+		CreatedAt: time.Now().UTC(),
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	k := subscriptionKey(orgID, userID)
+	previous := s.subscriptions[k]
+	s.subscriptions[k] = sub
+	if err := s.save(); err != nil {
+		// keep the memory and the file consistent
+		s.restore(k, previous)
+		return nil, "", err
+	}
+
+	token, err := s.token(sub)
+	if err != nil {
+		return nil, "", err
+	}
+
+	result := *sub
+	return &result, token, nil
+}
+
+// Returns the subscription of the user of the organisation and its token
+func (s *Store) Get(orgID, userID string) (*Subscription, string, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	sub, ok := s.subscriptions[subscriptionKey(orgID, userID)]
+	if !ok {
+		return nil, "", ErrNotFound
+	}
+
+	token, err := s.token(sub)
+	if err != nil {
+		return nil, "", err
+	}
+
+	result := *sub
+	return &result, token, nil
+}
+
+// Revoke the subscription of the user of the organisation, its token is rejected from now on
+func (s *Store) Revoke(orgID, userID string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	k := subscriptionKey(orgID, userID)
+	previous, ok := s.subscriptions[k]
+	if !ok {
+		return ErrNotFound
+	}
+
+	delete(s.subscriptions, k)
+	if err := s.save(); err != nil {
+		s.restore(k, previous)
+		return err
+	}
+
+	return nil
+}
+
+// Returns the subscription the token was issued for, ErrInvalidToken if it is forged, malformed or revoked
+func (s *Store) Verify(token string) (*Subscription, error) {
+	payload, signature, ok := strings.Cut(token, ".")
+	if !ok {
+		return nil, ErrInvalidToken
+	}
+
+	expected := s.sign([]byte(payload))
+	actual, err := base64.RawURLEncoding.DecodeString(signature)
+	if err != nil || !hmac.Equal(actual, expected) {
+		return nil, ErrInvalidToken
+	}
+
+	data, err := base64.RawURLEncoding.DecodeString(payload)
+	if err != nil {
+		return nil, ErrInvalidToken
+	}
+	var c claims
+	if err := json.Unmarshal(data, &c); err != nil {
+		return nil, ErrInvalidToken
+	}
+
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	// revoked or replaced by a newer subscription
+	sub, ok := s.subscriptions[subscriptionKey(c.OrgID, c.UserID)]
+	if !ok || !hmac.Equal([]byte(sub.ID), []byte(c.ID)) {
+		return nil, ErrInvalidToken
+	}
+
+	result := *sub
+	return &result, nil
+}
+
+// tokens are derived from the subscription, so they don't need to be stored
+func (s *Store) token(sub *Subscription) (string, error) {
+	data, err := json.Marshal(claims{ID: sub.ID, OrgID: sub.OrgID, UserID: sub.UserID})
+	if err != nil {
+		return "", fmt.Errorf("failed to serialize subscription token: %w", err)
+	}
+
+	payload := base64.RawURLEncoding.EncodeToString(data)
+	signature := base64.RawURLEncoding.EncodeToString(s.sign([]byte(payload)))
+	return payload + "." + signature, nil
+}
+
+func (s *Store) sign(payload []byte) []byte {
+	mac := hmac.New(sha256.New, s.key)
+	mac.Write(payload)
+	return mac.Sum(nil)
+}
+
+func (s *Store) restore(k string, previous *Subscription) {
+	if previous == nil {
+		delete(s.subscriptions, k)
+		return
+	}
+	s.subscriptions[k] = previous
+}
+
+// user ids are only unique within their organisation
+func subscriptionKey(orgID, userID string) string {
+	return orgID + "/" + userID
+}
+
+// write every subscription to the file, the file is replaced atomically. Must be called with the lock held
+func (s *Store) save() error {
+	if s.path == "" {
+		return nil
+	}
+
+	subscriptions := make([]*Subscription, 0, len(s.subscriptions))
+	for _, sub := range s.subscriptions {
+		subscriptions = append(subscriptions, sub)
+	}
+	data, err := json.Marshal(subscriptions)
+	if err != nil {
+		return fmt.Errorf("failed to serialize subscriptions: %w", err)
+	}
+
+	tmp := s.path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0600); err != nil {
+		return fmt.Errorf("failed to write subscriptions: %w", err)
+	}
+	if err := os.Rename(tmp, s.path); err != nil {
+		return fmt.Errorf("failed to write subscriptions: %w", err)
+	}
+
+	return nil
+}
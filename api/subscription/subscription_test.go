@@ -0,0 +1,142 @@
+package subscription
+
+import (
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestVerify(t *testing.T) {
+	store := NewStore([]byte("secret"))
+
+	sub, token, err := store.Create("org-1", "user-1", "alice")
+	if err != nil {
+		t.Fatalf("Create() error = %v", err)
+	}
+
+	verified, err := store.Verify(token)
+	if err != nil {
+		t.Fatalf("Verify() error = %v", err)
+	}
+	if *verified != *sub {
+		t.Errorf("Verify() = %+v, expected %+v", verified, sub)
+	}
+
+	payload, signature, _ := strings.Cut(token, ".")
+	_, otherToken, err := NewStore([]byte("other secret")).Create("org-1", "user-1", "alice")
+	if err != nil {
+		t.Fatalf("Create() error = %v", err)
+	}
+
+	tests := []struct {
+		name  string
+		token string
+	}{
+		{name: "empty", token: ""},
+		{name: "malformed", token: "not-a-token"},
+		{name: "tampered payload", token: payload + "x." + signature},
+		{name: "tampered signature", token: payload + "." + signature + "x"},
+		{name: "signed with another key", token: otherToken},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := store.Verify(tt.token); err != ErrInvalidToken {
+				t.Errorf("Verify() error = %v, expected %v", err, ErrInvalidToken)
+			}
+		})
+	}
+}
+
+func TestRevoke(t *testing.T) {
+	store := NewStore([]byte("secret"))
+
+	_, first, err := store.Create("org-1", "user-1", "alice")
+	if err != nil {
+		t.Fatalf("Create() error = %v", err)
+	}
+
+	// a new subscription revokes the previous one
+	_, second, err := store.Create("org-1", "user-1", "alice")
+	if err != nil {
+		t.Fatalf("Create() error = %v", err)
+	}
+	if _, err := store.Verify(first); err != ErrInvalidToken {
+		t.Errorf("Verify(first) error = %v, expected %v", err, ErrInvalidToken)
+	}
+	if _, err := store.Verify(second); err != nil {
+		t.Errorf("Verify(second) error = %v", err)
+	}
+
+	if err := store.Revoke("org-1", "user-1"); err != nil {
+		t.Fatalf("Revoke() error = %v", err)
+	}
+	if _, err := store.Verify(second); err != ErrInvalidToken {
+		t.Errorf("Verify(second) error = %v, expected %v", err, ErrInvalidToken)
+	}
+	if _, _, err := store.Get("org-1", "user-1"); err != ErrNotFound {
+		t.Errorf("Get() error = %v, expected %v", err, ErrNotFound)
+	}
+	if err := store.Revoke("org-1", "user-1"); err != ErrNotFound {
+		t.Errorf("Revoke() error = %v, expected %v", err, ErrNotFound)
+	}
+}
+
+func TestOpenStoreReloadsSubscriptions(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "subscriptions.json")
+	key := []byte("secret")
+
+	store, err := OpenStore(key, path)
+	if err != nil {
+		t.Fatalf("OpenStore() error = %v", err)
+	}
+	_, token, err := store.Create("org-1", "user-1", "alice")
+	if err != nil {
+		t.Fatalf("Create() error = %v", err)
+	}
+	if _, _, err := store.Create("org-1", "user-2", "bob"); err != nil {
+		t.Fatalf("Create() error = %v", err)
+	}
+	if err := store.Revoke("org-1", "user-2"); err != nil {
+		t.Fatalf("Revoke() error = %v", err)
+	}
+
+	reopened, err := OpenStore(key, path)
+	if err != nil {
+		t.Fatalf("OpenStore() error = %v", err)
+	}
+	if _, err := reopened.Verify(token); err != nil {
+		t.Errorf("Verify() error = %v", err)
+	}
+	if _, _, err := reopened.Get("org-1", "user-2"); err != ErrNotFound {
+		t.Errorf("Get(user-2) error = %v, expected %v", err, ErrNotFound)
+	}
+}
+
+func TestOrganisationsAreSeparated(t *testing.T) {
+	store := NewStore([]byte("secret"))
+
+	// user ids are only unique within their organisation
+	first, token, err := store.Create("org-1", "user-1", "alice")
+	if err != nil {
+		t.Fatalf("Create() error = %v", err)
+	}
+	if _, _, err := store.Create("org-2", "user-1", "alice"); err != nil {
+		t.Fatalf("Create() error = %v", err)
+	}
+
+	verified, err := store.Verify(token)
+	if err != nil {
+		t.Fatalf("Verify() error = %v", err)
+	}
+	if verified.ID != first.ID || verified.OrgID != "org-1" {
+		t.Errorf("Verify() = %+v, expected the subscription of org-1", verified)
+	}
+
+	if err := store.Revoke("org-2", "user-1"); err != nil {
+		t.Fatalf("Revoke() error = %v", err)
+	}
+	if _, err := store.Verify(token); err != nil {
+		t.Errorf("Verify() after revoking the subscription of another organisation error = %v", err)
+	}
+}
@@ -0,0 +1,182 @@
+package syndication
+
+import (
+	"encoding/xml"
+	"fmt"
+	"io"
+	"net/url"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/xaviercrochet/turbo-octo-adventure/api/musicbrainz"
+)
+
+const (
+	AtomContentType = "application/atom+xml; charset=utf-8"
+	RSSContentType  = "application/rss+xml; charset=utf-8"
+)
+
+// namespace of the ids of the entries, so they are stable across renderings
+var entryNamespace = uuid.MustParse("5f1d1e8a-6c1b-4d3e-9a7f-2b8c4e6d0a13")
+
+// page of the user on listenbrainz, used as the alternate link of the documents
+func profileURL(username string) string {
+	return fmt.Sprintf("https://listenbrainz.org/user/%s/", url.PathEscape(username))
+}
+
+func title(feed *musicbrainz.Feed) string {
+	return fmt.Sprintf("Music feed of %s", feed.Username)
+}
+
+// a listen is identified by who listened to what and when
+func entryID(feed *musicbrainz.Feed, song *musicbrainz.Song) string {
+	name := fmt.Sprintf("%s\n%s\n%s", feed.Username, song.Title, song.ListenedAt.UTC().Format(time.RFC3339Nano))
+	return "urn:uuid:" + uuid.NewSHA1(entryNamespace, []byte(name)).String()
+}
+
+// time of the latest listen, now if the feed is empty
+func updated(feed *musicbrainz.Feed) time.Time {
+	latest := time.Time{}
+	for _, song := range feed.Songs {
+		if song.ListenedAt.After(latest) {
+			latest = song.ListenedAt
+		}
+	}
+	if latest.IsZero() {
+		return time.Now().UTC()
+	}
+	return latest.UTC()
+}
+
+type atomFeed struct {
+	XMLName xml.Name    `xml:"http://www.w3.org/2005/Atom feed"`
+	ID      string      `xml:"id"`
+	Title   string      `xml:"title"`
+	Updated string      `xml:"updated"`
+	Author  atomAuthor  `xml:"author"`
+	Links   []atomLink  `xml:"link"`
+	Entries []atomEntry `xml:"entry"`
+}
+
+type atomAuthor struct {
+	Name string `xml:"name"`
+}
+
+type atomLink struct {
+	Rel  string `xml:"rel,attr"`
+	Href string `xml:"href,attr"`
+}
+
+type atomEntry struct {
+	ID        string      `xml:"id"`
+	Title     string      `xml:"title"`
+	Published string      `xml:"published"`
+	Updated   string      `xml:"updated"`
+	Content   atomContent `xml:"content"`
+}
+
+type atomContent struct {
+	Type string `xml:"type,attr"`
+	Text string `xml:",chardata"`
+}
+
+/*
+Write the feed as an Atom document, see RFC 4287
+
+selfURL is the url the document is served at
+*/
+func WriteAtom(w io.Writer, feed *musicbrainz.Feed, selfURL string) error {
+	doc := atomFeed{
+		ID:      "urn:uuid:" + uuid.NewSHA1(entryNamespace, []byte(feed.Username)).String(),
+		Title:   title(feed),
+		Updated: updated(feed).Format(time.RFC3339),
+		Author:  atomAuthor{Name: feed.Username},
+		Links: []atomLink{
+			{Rel: "self", Href: selfURL},
+			{Rel: "alternate", Href: profileURL(feed.Username)},
+		},
+		Entries: make([]atomEntry, 0, len(feed.Songs)),
+	}
+	for _, song := range feed.Songs {
+		listenedAt := song.ListenedAt.UTC().Format(time.RFC3339)
+		doc.Entries = append(doc.Entries, atomEntry{
+			ID:        entryID(feed, song),
+			Title:     song.Title,
+			Published: listenedAt,
+			Updated:   listenedAt,
+			Content:   atomContent{Type: "text", Text: fmt.Sprintf("%s listened to %s", feed.Username, song.Title)},
+		})
+	}
+
+	return writeXML(w, doc)
+}
+
+type rssDocument struct {
+	XMLName xml.Name   `xml:"rss"`
+	Version string     `xml:"version,attr"`
+	Channel rssChannel `xml:"channel"`
+}
+
+type rssChannel struct {
+	Title         string    `xml:"title"`
+	Link          string    `xml:"link"`
+	Description   string    `xml:"description"`
+	LastBuildDate string    `xml:"lastBuildDate"`
+	Items         []rssItem `xml:"item"`
+}
+
+type rssItem struct {
+	Title       string  `xml:"title"`
+	Description string  `xml:"description"`
+	GUID        rssGUID `xml:"guid"`
+	PubDate     string  `xml:"pubDate"`
+}
+
+type rssGUID struct {
+	IsPermaLink bool   `xml:"isPermaLink,attr"`
+	Value       string `xml:",chardata"`
+}
+
+/*
+Write the feed as a RSS 2.0 document, see https://www.rssboard.org/rss-specification
+
+the channel links to the listenbrainz profile of the user, RSS has no notion of self link
+*/
+func WriteRSS(w io.Writer, feed *musicbrainz.Feed) error {
+	doc := rssDocument{
+		Version: "2.0",
+		Channel: rssChannel{
+			Title:         title(feed),
+			Link:          profileURL(feed.Username),
+			Description:   fmt.Sprintf("Songs recently listened to by %s", feed.Username),
+			LastBuildDate: updated(feed).Format(time.RFC1123Z),
+			Items:         make([]rssItem, 0, len(feed.Songs)),
+		},
+	}
+	for _, song := range feed.Songs {
+		doc.Channel.Items = append(doc.Channel.Items, rssItem{
+			Title:       song.Title,
+			Description: fmt.Sprintf("%s listened to %s", feed.Username, song.Title),
+			GUID:        rssGUID{Value: entryID(feed, song)},
+			PubDate:     song.ListenedAt.UTC().Format(time.RFC1123Z),
+		})
+	}
+
+	return writeXML(w, doc)
+}
+
+func writeXML(w io.Writer, doc any) error {
+	if _, err := io.WriteString(w, xml.Header); err != nil {
+		return err
+	}
+	encoder := xml.NewEncoder(w)
+	encoder.Indent("", "  ")
+	if err := encoder.Encode(doc); err != nil {
+		return err
+	}
+	if err := encoder.Close(); err != nil {
+		return err
+	}
+	_, err := io.WriteString(w, "\n")
+	return err
+}
@@ -0,0 +1,90 @@
+package syndication
+
+import (
+	"encoding/xml"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/xaviercrochet/turbo-octo-adventure/api/musicbrainz"
+)
+
+func testFeed() *musicbrainz.Feed {
+	return &musicbrainz.Feed{
+		Username: "xcrochet",
+		Songs: []*musicbrainz.Song{
+			{Title: "Song 2 <remix> & more", ListenedAt: time.Date(2024, 1, 2, 12, 0, 0, 0, time.UTC)},
+			{Title: "Song 1", ListenedAt: time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)},
+		},
+	}
+}
+
+func TestWriteAtom(t *testing.T) {
+	var out strings.Builder
+	if err := WriteAtom(&out, testFeed(), "https://example.com/api/feed.atom?token=t"); err != nil {
+		t.Fatalf("WriteAtom() error = %v", err)
+	}
+
+	var doc atomFeed
+	if err := xml.Unmarshal([]byte(out.String()), &doc); err != nil {
+		t.Fatalf("invalid atom document: %v", err)
+	}
+
+	// required elements, see RFC 4287 section 4.1.1
+	if doc.XMLName.Space != "http://www.w3.org/2005/Atom" || doc.ID == "" || doc.Title == "" {
+		t.Errorf("feed = %+v, expected an atom feed with an id and a title", doc)
+	}
+	if doc.Updated != "2024-01-02T12:00:00Z" {
+		t.Errorf("updated = %v, expected the latest listen", doc.Updated)
+	}
+	if len(doc.Links) == 0 || doc.Links[0].Rel != "self" || doc.Links[0].Href != "https://example.com/api/feed.atom?token=t" {
+		t.Errorf("links = %+v, expected a self link", doc.Links)
+	}
+
+	if len(doc.Entries) != 2 {
+		t.Fatalf("feed has %d entries, expected 2", len(doc.Entries))
+	}
+	entry := doc.Entries[0]
+	if entry.Title != "Song 2 <remix> & more" || entry.Updated != "2024-01-02T12:00:00Z" || !strings.HasPrefix(entry.ID, "urn:uuid:") {
+		t.Errorf("entry = %+v, expected the first song", entry)
+	}
+	if doc.Entries[0].ID == doc.Entries[1].ID {
+		t.Errorf("entries share the id %v", entry.ID)
+	}
+}
+
+func TestWriteRSS(t *testing.T) {
+	var out strings.Builder
+	if err := WriteRSS(&out, testFeed()); err != nil {
+		t.Fatalf("WriteRSS() error = %v", err)
+	}
+
+	var doc rssDocument
+	if err := xml.Unmarshal([]byte(out.String()), &doc); err != nil {
+		t.Fatalf("invalid rss document: %v", err)
+	}
+
+	// required channel elements
+	if doc.Version != "2.0" || doc.Channel.Title == "" || doc.Channel.Link == "" || doc.Channel.Description == "" {
+		t.Errorf("document = %+v, expected a rss 2.0 channel with a title, a link and a description", doc)
+	}
+	if len(doc.Channel.Items) != 2 {
+		t.Fatalf("channel has %d items, expected 2", len(doc.Channel.Items))
+	}
+	item := doc.Channel.Items[1]
+	if item.Title != "Song 1" || item.PubDate != "Mon, 01 Jan 2024 12:00:00 +0000" || item.GUID.IsPermaLink {
+		t.Errorf("item = %+v, expected the second song", item)
+	}
+}
+
+func TestEntryIDsAreStable(t *testing.T) {
+	feed := testFeed()
+
+	// the ids are used by feed readers to detect new entries, they must not change between renderings
+	if entryID(feed, feed.Songs[0]) != entryID(testFeed(), testFeed().Songs[0]) {
+		t.Error("entryID() differs for the same listen")
+	}
+	if entryID(feed, feed.Songs[0]) == entryID(feed, feed.Songs[1]) {
+		t.Error("entryID() is the same for different listens")
+	}
+}
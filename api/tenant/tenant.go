@@ -0,0 +1,139 @@
+package tenant
+
+import (
+	"errors"
+	"fmt"
+	"maps"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+
+	"github.com/xaviercrochet/turbo-octo-adventure/pkg/auth"
+)
+
+var ErrNoOrganisation = errors.New("caller doesn't belong to an organisation")
+
+// maximum length of an organisation id, ZITADEL ids are 18 digits long
+const maxIDLength = 64
+
+// ZITADEL organisation id, every piece of state of the api belongs to one organisation
+type ID string
+
+/*
+Returns the organisation of the caller, as found in its access token (or api key)
+
+Org ids end up in file names, anything but letters, digits, '-' and '_' is rejected with ErrNoOrganisation
+*/
+func Of(authCtx *auth.Context) (ID, error) {
+	if authCtx == nil {
+		return "", ErrNoOrganisation
+	}
+	return Parse(authCtx.OrgID)
+}
+
+// Validate an organisation id
+func Parse(orgID string) (ID, error) {
+	if orgID == "" || len(orgID) > maxIDLength {
+		return "", ErrNoOrganisation
+	}
+	for _, r := range orgID {
+		if !(r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9' || r == '-' || r == '_') {
+			return "", fmt.Errorf("%w: invalid organisation id %q", ErrNoOrganisation, orgID)
+		}
+	}
+	return ID(orgID), nil
+}
+
+/*
+Returns the file of the organisation derived from path, "" if path is empty (in memory only)
+
+	File("/data/audit.jsonl", "123") == "/data/audit.123.jsonl"
+*/
+func File(path string, id ID) string {
+	if path == "" {
+		return ""
+	}
+	ext := filepath.Ext(path)
+	return strings.TrimSuffix(path, ext) + "." + string(id) + ext
+}
+
+/*
+Registry holds the state of every organisation, created on first use
+
+The state of an organisation can only be reached with its ID, so code handed the state of one organisation can't reach
+the state of another one
+*/
+type Registry[T any] struct {
+	mu      sync.Mutex
+	tenants map[ID]T
+	// organisations whose state is being created
+	pending map[ID]*pending[T]
+	create  func(id ID) (T, error)
+}
+
+// creation in progress of the state of an organisation, done is closed once state and err are set
+type pending[T any] struct {
+	done  chan struct{}
+	state T
+	err   error
+}
+
+// Create a registry, create is called once per organisation, the first time its state is needed
+func NewRegistry[T any](create func(id ID) (T, error)) *Registry[T] {
+	return &Registry[T]{
+		tenants: map[ID]T{},
+		pending: map[ID]*pending[T]{},
+		create:  create,
+	}
+}
+
+/*
+Returns the state of the organisation, created if needed
+
+Concurrent calls for the same organisation wait for a single creation, other organisations aren't blocked by it. A
+failed creation isn't kept, the next call tries again
+*/
+func (r *Registry[T]) Get(id ID) (T, error) {
+	r.mu.Lock()
+	if state, ok := r.tenants[id]; ok {
+		r.mu.Unlock()
+		return state, nil
+	}
+	if p, ok := r.pending[id]; ok {
+		r.mu.Unlock()
+		<-p.done
+		return p.state, p.err
+	}
+	p := &pending[T]{done: make(chan struct{})}
+	r.pending[id] = p
+	r.mu.Unlock()
+
+	p.state, p.err = r.create(id)
+	if p.err != nil {
+		var zero T
+		p.state = zero
+		p.err = fmt.Errorf("failed to create the state of organisation %s: %w", id, p.err)
+	}
+
+	r.mu.Lock()
+	delete(r.pending, id)
+	if p.err == nil {
+		r.tenants[id] = p.state
+	}
+	r.mu.Unlock()
+	close(p.done)
+
+	return p.state, p.err
+}
+
+// Call fn with the state of every organisation created so far, sorted by ID. fn can call Get
+func (r *Registry[T]) Each(fn func(id ID, state T)) {
+	r.mu.Lock()
+	states := maps.Clone(r.tenants)
+	r.mu.Unlock()
+
+	for _, id := range slices.Sorted(maps.Keys(states)) {
+		fn(id, states[id])
+	}
+}
@@ -0,0 +1,141 @@
+package tenant
+
+import (
+	"errors"
+	"sync"
+	"testing"
+
+	"github.com/xaviercrochet/turbo-octo-adventure/pkg/auth"
+)
+
+func TestOf(t *testing.T) {
+	tests := []struct {
+		orgID string
+		valid bool
+	}{
+		{orgID: "259242039378444290", valid: true},
+		{orgID: "dev-org", valid: true},
+		{orgID: "", valid: false},
+		{orgID: "../other", valid: false},
+		{orgID: "org/1", valid: false},
+		{orgID: "org 1", valid: false},
+	}
+
+	for _, tt := range tests {
+		id, err := Of(&auth.Context{OrgID: tt.orgID})
+		if tt.valid && (err != nil || id != ID(tt.orgID)) {
+			t.Errorf("Of(%q) = %v, %v, expected the organisation", tt.orgID, id, err)
+		}
+		if !tt.valid && !errors.Is(err, ErrNoOrganisation) {
+			t.Errorf("Of(%q) error = %v, expected %v", tt.orgID, err, ErrNoOrganisation)
+		}
+	}
+
+	if _, err := Of(nil); !errors.Is(err, ErrNoOrganisation) {
+		t.Errorf("Of(nil) error = %v, expected %v", err, ErrNoOrganisation)
+	}
+}
+
+func TestFile(t *testing.T) {
+	tests := map[string]string{
+		"/data/audit.jsonl": "/data/audit.org-1.jsonl",
+		"preferences":       "preferences.org-1",
+		"":                  "",
+	}
+	for path, expected := range tests {
+		if got := File(path, "org-1"); got != expected {
+			t.Errorf("File(%q) = %q, expected %q", path, got, expected)
+		}
+	}
+}
+
+func TestRegistry(t *testing.T) {
+	var mu sync.Mutex
+	created := map[ID]int{}
+	registry := NewRegistry(func(id ID) (*[]string, error) {
+		if id == "broken" {
+			return nil, errors.New("disk full")
+		}
+		mu.Lock()
+		created[id]++
+		mu.Unlock()
+		return &[]string{string(id)}, nil
+	})
+
+	var wg sync.WaitGroup
+	for range 10 {
+		for _, id := range []ID{"org-2", "org-1"} {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				state, err := registry.Get(id)
+				if err != nil || (*state)[0] != string(id) {
+					t.Errorf("Get(%v) = %v, %v, expected the state of the organisation", id, state, err)
+				}
+			}()
+		}
+	}
+	wg.Wait()
+
+	if created["org-1"] != 1 || created["org-2"] != 1 {
+		t.Errorf("states created %v, expected once per organisation", created)
+	}
+
+	if _, err := registry.Get("broken"); err == nil {
+		t.Errorf("Get() error = nil, expected the creation error")
+	}
+
+	var ids []ID
+	registry.Each(func(id ID, _ *[]string) {
+		ids = append(ids, id)
+	})
+	if len(ids) != 2 || ids[0] != "org-1" || ids[1] != "org-2" {
+		t.Errorf("Each() visited %v, expected [org-1 org-2]", ids)
+	}
+}
+
+func TestRegistryDoesntBlockOtherOrganisations(t *testing.T) {
+	release := make(chan struct{})
+	registry := NewRegistry(func(id ID) (ID, error) {
+		if id == "slow" {
+			<-release
+		}
+		return id, nil
+	})
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		if state, err := registry.Get("slow"); err != nil || state != "slow" {
+			t.Errorf("Get(slow) = %v, %v, expected the state of the organisation", state, err)
+		}
+	}()
+
+	// the slow creation is still running
+	if state, err := registry.Get("fast"); err != nil || state != "fast" {
+		t.Errorf("Get(fast) = %v, %v, expected the state of the organisation", state, err)
+	}
+	close(release)
+	<-done
+}
+
+func TestRegistryRetriesFailedCreations(t *testing.T) {
+	attempts := 0
+	registry := NewRegistry(func(id ID) (int, error) {
+		attempts++
+		if attempts == 1 {
+			return 0, errors.New("disk full")
+		}
+		return attempts, nil
+	})
+
+	if _, err := registry.Get("org-1"); err == nil {
+		t.Fatal("Get() error = nil, expected the creation error")
+	}
+	if state, err := registry.Get("org-1"); err != nil || state != 2 {
+		t.Errorf("Get() = %v, %v, expected the state of the second attempt", state, err)
+	}
+	if state, _ := registry.Get("org-1"); state != 2 || attempts != 2 {
+		t.Errorf("Get() = %v after %d attempts, expected the created state to be kept", state, attempts)
+	}
+}
@@ -0,0 +1,345 @@
+package webhook
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/netip"
+	"strconv"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/xaviercrochet/turbo-octo-adventure/pkg/util"
+)
+
+const (
+	DeliveryPending   = "pending"
+	DeliveryDelivered = "delivered"
+	DeliveryFailed    = "failed"
+
+	DefaultMaxAttempts = 5
+	DefaultBackoff     = time.Second
+	// delay between attempts is doubled after every failure, up to this value
+	DefaultMaxBackoff = time.Minute
+	// number of deliveries kept in the log and in the dead letters, oldest are dropped first
+	DefaultLogSize = 200
+)
+
+// The attempts to send an event to a webhook
+type Delivery struct {
+	ID        string `json:"id"`
+	WebhookID string `json:"webhook_id"`
+	URL       string `json:"url"`
+	EventID   string `json:"event_id"`
+	ListenID  string `json:"listen_id"`
+	Status    string `json:"status"`
+	Attempts  int    `json:"attempts"`
+	// outcome of the last attempt, status code 0 if no response was received
+	StatusCode int       `json:"status_code,omitempty"`
+	Error      string    `json:"error,omitempty"`
+	CreatedAt  time.Time `json:"created_at"`
+	UpdatedAt  time.Time `json:"updated_at"`
+}
+
+/*
+Dispatcher sends events to webhooks
+
+Deliveries run in the background and are retried with an exponential backoff until they succeed (2XX response) or the
+maximum number of attempts is reached, in which case they are moved to the dead-letter list.
+*/
+type Dispatcher struct {
+	client      *http.Client
+	maxAttempts int
+	backoff     time.Duration
+	maxBackoff  time.Duration
+	logSize     int
+
+	mu          sync.RWMutex
+	deliveries  []*Delivery
+	deadLetters []*Delivery
+
+	// deliveries waiting for each webhook, by webhook id. A webhook has a worker as long as it has an entry
+	queueMu sync.Mutex
+	queues  map[string][]*job
+
+	wg sync.WaitGroup
+}
+
+// a delivery waiting to be sent
+type job struct {
+	ctx      context.Context
+	webhook  *Webhook
+	event    *Event
+	delivery *Delivery
+	body     []byte
+}
+
+// DispatcherOption allows customization of the Dispatcher
+type DispatcherOption func(*Dispatcher)
+
+// WithHTTPClient sends the deliveries with the given client, i.e. to set a timeout. The client is used as is, private
+// destinations and redirects are only rejected by the default one
+func WithHTTPClient(client *http.Client) DispatcherOption {
+	return func(d *Dispatcher) {
+		d.client = client
+	}
+}
+
+// WithRetries sets the maximum number of attempts per delivery and the delay before the first retry
+func WithRetries(maxAttempts int, backoff time.Duration) DispatcherOption {
+	return func(d *Dispatcher) {
+		d.maxAttempts = maxAttempts
+		d.backoff = backoff
+	}
+}
+
+/*
+Returns the default client of the dispatcher
+
+Connections to loopback, link-local or private addresses are refused once the host name of the webhook is resolved, so
+a name pointing to the network of the api can't be used to reach it. Redirects are not followed, the 3XX response
+fails the attempt
+*/
+func publicClient() *http.Client {
+	dialer := &net.Dialer{
+		Timeout: 10 * time.Second,
+		Control: func(network, address string, _ syscall.RawConn) error {
+			addrPort, err := netip.ParseAddrPort(address)
+			if err != nil {
+				return err
+			}
+			if privateAddr(addrPort.Addr()) {
+				return fmt.Errorf("%w: %s", ErrPrivateDestination, addrPort.Addr())
+			}
+			return nil
+		},
+	}
+
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	// a proxy would make the dial checks useless
+	transport.Proxy = nil
+	transport.DialContext = dialer.DialContext
+
+	return &http.Client{
+		Timeout:   10 * time.Second,
+		Transport: transport,
+		CheckRedirect: func(*http.Request, []*http.Request) error {
+			return http.ErrUseLastResponse
+		},
+	}
+}
+
+func NewDispatcher(options ...DispatcherOption) *Dispatcher {
+	d := &Dispatcher{
+		client:      publicClient(),
+		maxAttempts: DefaultMaxAttempts,
+		backoff:     DefaultBackoff,
+		maxBackoff:  DefaultMaxBackoff,
+		logSize:     DefaultLogSize,
+		queues:      map[string][]*job{},
+	}
+	for _, option := range options {
+		option(d)
+	}
+	return d
+}
+
+/*
+Send the events to every webhook in the background, pending retries are abandoned when ctx is done
+
+Each webhook receives the events in order, an event is only sent once the previous one is delivered or dead-lettered,
+including the events of previous calls. Every webhook has its own queue, a slow webhook doesn't delay the others
+*/
+func (d *Dispatcher) Dispatch(ctx context.Context, webhooks []*Webhook, events ...*Event) error {
+	bodies := make([][]byte, 0, len(events))
+	for _, event := range events {
+		body, err := json.Marshal(event)
+		if err != nil {
+			return fmt.Errorf("failed to serialize event: %w", err)
+		}
+		bodies = append(bodies, body)
+	}
+
+	for _, webhook := range webhooks {
+		// recorded upfront, so the log shows every delivery still to be attempted
+		jobs := make([]*job, 0, len(events))
+		for i, event := range events {
+			jobs = append(jobs, &job{ctx: ctx, webhook: webhook, event: event, delivery: d.record(webhook, event), body: bodies[i]})
+		}
+		d.enqueue(webhook.ID, jobs)
+	}
+
+	return nil
+}
+
+// Add the jobs to the queue of the webhook, starting its worker if it isn't running
+func (d *Dispatcher) enqueue(webhookID string, jobs []*job) {
+	d.queueMu.Lock()
+	defer d.queueMu.Unlock()
+
+	queue, running := d.queues[webhookID]
+	d.queues[webhookID] = append(queue, jobs...)
+	if !running {
+		d.wg.Add(1)
+		go d.work(webhookID)
+	}
+}
+
+// Send the queued deliveries of the webhook one at a time, until its queue is empty
+func (d *Dispatcher) work(webhookID string) {
+	defer d.wg.Done()
+
+	for {
+		d.queueMu.Lock()
+		queue := d.queues[webhookID]
+		if len(queue) == 0 {
+			delete(d.queues, webhookID)
+			d.queueMu.Unlock()
+			return
+		}
+		next := queue[0]
+		queue[0] = nil
+		d.queues[webhookID] = queue[1:]
+		d.queueMu.Unlock()
+
+		d.deliver(next.ctx, next.webhook, next.event, next.delivery, next.body)
+	}
+}
+
+// Wait blocks until every pending delivery is either delivered or dead-lettered
+func (d *Dispatcher) Wait() {
+	d.wg.Wait()
+}
+
+// Returns copies of the latest deliveries, newest first. All of them if limit is 0
+func (d *Dispatcher) Deliveries(limit int) []*Delivery {
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+	return latest(d.deliveries, limit)
+}
+
+// Returns copies of the deliveries that failed every attempt, newest first. All of them if limit is 0
+func (d *Dispatcher) DeadLetters(limit int) []*Delivery {
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+	return latest(d.deadLetters, limit)
+}
+
+func latest(deliveries []*Delivery, limit int) []*Delivery {
+	result := []*Delivery{}
+	for i := len(deliveries) - 1; i >= 0; i-- {
+		if limit > 0 && len(result) >= limit {
+			break
+		}
+		delivery := *deliveries[i]
+		result = append(result, &delivery)
+	}
+	return result
+}
+
+// add a pending delivery to the log
+func (d *Dispatcher) record(webhook *Webhook, event *Event) *Delivery {
+	now := time.Now().UTC()
+	delivery := &Delivery{
+		ID:        uuid.New().String(),
+		WebhookID: webhook.ID,
+		URL:       webhook.URL,
+		EventID:   event.ID,
+		ListenID:  event.Listen.ID,
+		Status:    DeliveryPending,
+		CreatedAt: now,
+		UpdatedAt: now,
+	}
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	d.deliveries = keepLast(append(d.deliveries, delivery), d.logSize)
+	return delivery
+}
+
+// drop the oldest deliveries, so a webhook failing for good doesn't grow the memory without bound
+func keepLast(deliveries []*Delivery, n int) []*Delivery {
+	if len(deliveries) > n {
+		// copied, so the dropped deliveries can be garbage collected
+		return append([]*Delivery(nil), deliveries[len(deliveries)-n:]...)
+	}
+	return deliveries
+}
+
+func (d *Dispatcher) deliver(ctx context.Context, webhook *Webhook, event *Event, delivery *Delivery, body []byte) {
+	logger := util.DefaultLogger.FromContext(ctx).With("webhook_id", webhook.ID, "delivery_id", delivery.ID)
+	backoff := d.backoff
+
+	for attempt := 1; ; attempt++ {
+		statusCode, err := d.send(ctx, webhook, event, body)
+
+		d.mu.Lock()
+		delivery.Attempts = attempt
+		delivery.StatusCode = statusCode
+		delivery.UpdatedAt = time.Now().UTC()
+		delivery.Error = ""
+		if err != nil {
+			delivery.Error = err.Error()
+		}
+		done := err == nil || attempt >= d.maxAttempts || ctx.Err() != nil
+		if err == nil {
+			delivery.Status = DeliveryDelivered
+		} else if done {
+			delivery.Status = DeliveryFailed
+			d.deadLetters = keepLast(append(d.deadLetters, delivery), d.logSize)
+		}
+		d.mu.Unlock()
+
+		if err == nil {
+			logger.Info("webhook delivered", "attempts", attempt)
+			return
+		}
+		if done {
+			logger.Warn("webhook delivery failed, moved to dead letters", "attempts", attempt, "error", err)
+			return
+		}
+
+		logger.Info("webhook delivery failed, retrying", "attempt", attempt, "backoff", backoff, "error", err)
+		select {
+		case <-ctx.Done():
+		case <-time.After(backoff):
+		}
+		backoff = min(2*backoff, d.maxBackoff)
+	}
+}
+
+// one attempt, returns the status code of the response if any
+func (d *Dispatcher) send(ctx context.Context, webhook *Webhook, event *Event, body []byte) (int, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, webhook.URL, bytes.NewReader(body))
+	if err != nil {
+		return 0, fmt.Errorf("failed creating http request: %w", err)
+	}
+
+	now := time.Now()
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(HeaderWebhookID, webhook.ID)
+	req.Header.Set(HeaderEventID, event.ID)
+	req.Header.Set(HeaderTimestamp, strconv.FormatInt(now.Unix(), 10))
+	req.Header.Set(HeaderSignature, Sign(webhook.Secret, now, body))
+
+	resp, err := d.client.Do(req)
+	if err != nil {
+		return 0, fmt.Errorf("failed to send webhook: %w", err)
+	}
+	defer resp.Body.Close()
+	// drain the body so the connection can be reused
+	io.Copy(io.Discard, io.LimitReader(resp.Body, 4096))
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return resp.StatusCode, fmt.Errorf("webhook answered with status %d", resp.StatusCode)
+	}
+
+	return resp.StatusCode, nil
+}
@@ -0,0 +1,105 @@
+package webhook
+
+import (
+	"context"
+	"time"
+
+	"github.com/xaviercrochet/turbo-octo-adventure/api/musicbrainz"
+	"github.com/xaviercrochet/turbo-octo-adventure/pkg/util"
+)
+
+const DefaultPollInterval = time.Minute
+
+// retrieves the raw feed of a user, allows tests to replace the musicbrainz api
+type FeedFetcher func(username string) (*musicbrainz.FeedXml, error)
+
+/*
+Poller detects new listens of the selected feed and dispatches them to the registered webhooks
+
+New listens are the entries whose id was not in the previous poll. The first poll, and the first poll after the
+selected feed changed, only record the current entries so the whole history isn't sent
+*/
+type Poller struct {
+	registry   *Registry
+	dispatcher *Dispatcher
+	fetch      FeedFetcher
+	// returns the username of the selected feed
+	username func() string
+	interval time.Duration
+
+	// last-seen state
+	lastUsername string
+	seen         map[string]bool
+}
+
+func NewPoller(registry *Registry, dispatcher *Dispatcher, fetch FeedFetcher, username func() string, interval time.Duration) *Poller {
+	return &Poller{
+		registry:   registry,
+		dispatcher: dispatcher,
+		fetch:      fetch,
+		username:   username,
+		interval:   interval,
+	}
+}
+
+// Poll every interval until ctx is done
+func (p *Poller) Run(ctx context.Context) {
+	logger := util.DefaultLogger.FromContext(ctx)
+	ticker := time.NewTicker(p.interval)
+	defer ticker.Stop()
+
+	for {
+		if err := p.Poll(ctx); err != nil {
+			logger.Warn("webhook poll failed", "error", err)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// Poll once, dispatching the new listens oldest first
+func (p *Poller) Poll(ctx context.Context) error {
+	webhooks := p.registry.targets()
+
+	// nothing to notify, the state is rebuilt once a webhook is registered
+	if len(webhooks) == 0 {
+		p.seen = nil
+		return nil
+	}
+
+	username := p.username()
+	feed, err := p.fetch(username)
+	if err != nil {
+		return err
+	}
+
+	seen := make(map[string]bool, len(feed.Entries))
+	for _, entry := range feed.Entries {
+		seen[entry.ID] = true
+	}
+
+	previous := p.seen
+	p.seen = seen
+	if previous == nil || username != p.lastUsername {
+		p.lastUsername = username
+		return nil
+	}
+
+	// entries are sorted newest first
+	events := []*Event{}
+	for i := len(feed.Entries) - 1; i >= 0; i-- {
+		entry := feed.Entries[i]
+		if !previous[entry.ID] {
+			events = append(events, NewListenEvent(username, entry))
+		}
+	}
+	if len(events) == 0 {
+		return nil
+	}
+
+	return p.dispatcher.Dispatch(ctx, webhooks, events...)
+}
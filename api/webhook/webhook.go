@@ -0,0 +1,213 @@
+package webhook
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net/netip"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/xaviercrochet/turbo-octo-adventure/api/musicbrainz"
+)
+
+const (
+	// sent for every new listen of the selected feed
+	EventListenCreated = "listen.created"
+
+	// headers sent with every delivery
+	HeaderWebhookID = "X-Webhook-Id"
+	HeaderEventID   = "X-Webhook-Event-Id"
+	// unix time at which the delivery was signed, receivers should reject old deliveries to prevent replays
+	HeaderTimestamp = "X-Webhook-Timestamp"
+	// sha256=<hex encoded hmac of "<timestamp>.<body>" keyed with the secret of the webhook>
+	HeaderSignature = "X-Webhook-Signature"
+)
+
+var (
+	ErrWebhookNotFound = errors.New("webhook not found")
+	ErrInvalidURL      = errors.New("webhook url must be an absolute http or https url")
+	// deliveries must not reach the services next to the api, i.e. the metadata endpoint of a cloud provider
+	ErrPrivateDestination = errors.New("webhook url must not point to a loopback, link-local or private address")
+)
+
+// An url notified of new listens
+type Webhook struct {
+	ID  string `json:"id"`
+	URL string `json:"url"`
+	// key of the signatures, only returned when the webhook is registered
+	Secret    string    `json:"secret,omitempty"`
+	CreatedBy string    `json:"created_by"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// Payload of a delivery
+type Event struct {
+	ID        string    `json:"id"`
+	Type      string    `json:"type"`
+	CreatedAt time.Time `json:"created_at"`
+	// user the listen belongs to
+	Username string            `json:"username"`
+	Listen   musicbrainz.Entry `json:"listen"`
+}
+
+func NewListenEvent(username string, entry musicbrainz.Entry) *Event {
+	return &Event{
+		ID:        uuid.New().String(),
+		Type:      EventListenCreated,
+		CreatedAt: time.Now().UTC(),
+		Username:  username,
+		Listen:    entry,
+	}
+}
+
+// Registry holds the registered webhooks, in memory
+type Registry struct {
+	mu       sync.RWMutex
+	webhooks []*Webhook
+	// accept urls of private destinations, see AllowPrivateURLs
+	allowPrivate bool
+}
+
+// RegistryOption allows customization of the Registry
+type RegistryOption func(*Registry)
+
+// AllowPrivateURLs accepts urls pointing to loopback, link-local or private addresses, i.e. receivers on the same host
+func AllowPrivateURLs() RegistryOption {
+	return func(r *Registry) {
+		r.allowPrivate = true
+	}
+}
+
+func NewRegistry(options ...RegistryOption) *Registry {
+	r := &Registry{}
+	for _, option := range options {
+		option(r)
+	}
+	return r
+}
+
+/*
+Register a webhook, the returned webhook holds the secret receivers use to verify the signatures
+
+Urls whose host is a loopback, link-local or private address (or localhost) are rejected with ErrPrivateDestination.
+Host names resolving to such addresses are only known when the deliveries are sent, the dispatcher rejects them then
+*/
+func (r *Registry) Register(rawURL, createdBy string) (*Webhook, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return nil, ErrInvalidURL
+	}
+	if !r.allowPrivate && privateHost(u.Hostname()) {
+		return nil, ErrPrivateDestination
+	}
+
+	secret := make([]byte, 32)
+	if _, err := rand.Read(secret); err != nil {
+		return nil, fmt.Errorf("failed to generate webhook secret: %w", err)
+	}
+
+	webhook := &Webhook{
+		ID:        uuid.New().String(),
+		URL:       u.String(),
+		Secret:    hex.EncodeToString(secret),
+		CreatedBy: createdBy,
+		CreatedAt: time.Now().UTC(),
+	}
+
+	r.mu.Lock()
+	r.webhooks = append(r.webhooks, webhook)
+	r.mu.Unlock()
+
+	result := *webhook
+	return &result, nil
+}
+
+// host is localhost or a private address, host names are not resolved
+func privateHost(host string) bool {
+	host = strings.TrimSuffix(strings.ToLower(host), ".")
+	if host == "localhost" || strings.HasSuffix(host, ".localhost") {
+		return true
+	}
+	addr, err := netip.ParseAddr(host)
+	return err == nil && privateAddr(addr)
+}
+
+// addresses of the host itself or of its networks
+func privateAddr(addr netip.Addr) bool {
+	addr = addr.Unmap()
+	return addr.IsLoopback() || addr.IsPrivate() || addr.IsLinkLocalUnicast() || addr.IsLinkLocalMulticast() ||
+		addr.IsInterfaceLocalMulticast() || addr.IsUnspecified()
+}
+
+func (r *Registry) Remove(id string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for i, webhook := range r.webhooks {
+		if webhook.ID == id {
+			r.webhooks = append(r.webhooks[:i], r.webhooks[i+1:]...)
+			return nil
+		}
+	}
+
+	return ErrWebhookNotFound
+}
+
+// Returns copies of the registered webhooks, secrets are omitted
+func (r *Registry) List() []*Webhook {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	result := make([]*Webhook, 0, len(r.webhooks))
+	for _, webhook := range r.webhooks {
+		w := *webhook
+		w.Secret = ""
+		result = append(result, &w)
+	}
+	return result
+}
+
+// copies of the registered webhooks, with their secrets
+func (r *Registry) targets() []*Webhook {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	result := make([]*Webhook, 0, len(r.webhooks))
+	for _, webhook := range r.webhooks {
+		w := *webhook
+		result = append(result, &w)
+	}
+	return result
+}
+
+// Value of the HeaderSignature header for the given body
+func Sign(secret string, timestamp time.Time, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(strconv.FormatInt(timestamp.Unix(), 10)))
+	mac.Write([]byte("."))
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+/*
+Verify the signature of a delivery, meant for receivers
+
+  - timestamp: value of the HeaderTimestamp header
+  - signature: value of the HeaderSignature header
+*/
+func Verify(secret, timestamp, signature string, body []byte) bool {
+	unix, err := strconv.ParseInt(timestamp, 10, 64)
+	if err != nil {
+		return false
+	}
+	expected := Sign(secret, time.Unix(unix, 0), body)
+	return hmac.Equal([]byte(expected), []byte(signature))
+}
@@ -0,0 +1,363 @@
+package webhook
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/xaviercrochet/turbo-octo-adventure/api/musicbrainz"
+)
+
+// records the events it receives, answering with the given status codes in turn (200 once exhausted)
+type receiver struct {
+	t        *testing.T
+	secret   string
+	statuses []int
+
+	mu     sync.Mutex
+	events []*Event
+	calls  int
+}
+
+func (r *receiver) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	body, err := io.ReadAll(req.Body)
+	if err != nil {
+		r.t.Errorf("could not read delivery: %v", err)
+		return
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	status := http.StatusOK
+	if r.calls < len(r.statuses) {
+		status = r.statuses[r.calls]
+	}
+	r.calls++
+
+	if r.secret != "" && !Verify(r.secret, req.Header.Get(HeaderTimestamp), req.Header.Get(HeaderSignature), body) {
+		r.t.Errorf("invalid signature %v", req.Header.Get(HeaderSignature))
+	}
+
+	if status == http.StatusOK {
+		var event Event
+		if err := json.Unmarshal(body, &event); err != nil {
+			r.t.Errorf("invalid event: %v", err)
+		}
+		r.events = append(r.events, &event)
+	}
+	w.WriteHeader(status)
+}
+
+func (r *receiver) listenIDs() []string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	ids := []string{}
+	for _, event := range r.events {
+		ids = append(ids, event.Listen.ID)
+	}
+	return ids
+}
+
+// dispatcher allowed to reach the test servers, which listen on the loopback
+func localDispatcher(options ...DispatcherOption) *Dispatcher {
+	return NewDispatcher(append([]DispatcherOption{WithHTTPClient(&http.Client{Timeout: 10 * time.Second})}, options...)...)
+}
+
+func feedOf(ids ...string) *musicbrainz.FeedXml {
+	feed := &musicbrainz.FeedXml{}
+	for _, id := range ids {
+		feed.Entries = append(feed.Entries, musicbrainz.Entry{ID: id, Title: "song " + id})
+	}
+	return feed
+}
+
+func TestPollerDispatchesNewListens(t *testing.T) {
+	ctx := context.Background()
+	registry := NewRegistry(AllowPrivateURLs())
+	dispatcher := localDispatcher(WithRetries(3, time.Millisecond))
+
+	recv := &receiver{t: t}
+	server := httptest.NewServer(recv)
+	defer server.Close()
+
+	webhook, err := registry.Register(server.URL, "admin")
+	if err != nil {
+		t.Fatalf("Register() error = %v", err)
+	}
+	recv.secret = webhook.Secret
+
+	// feeds returned by successive polls, newest entries first
+	username := "alice"
+	feeds := map[string][]*musicbrainz.FeedXml{
+		"alice": {feedOf("2", "1"), feedOf("4", "3", "2", "1"), feedOf("5", "4", "3")},
+		"bob":   {feedOf("b1")},
+	}
+	fetch := func(username string) (*musicbrainz.FeedXml, error) {
+		feed := feeds[username][0]
+		feeds[username] = feeds[username][1:]
+		return feed, nil
+	}
+	poller := NewPoller(registry, dispatcher, fetch, func() string { return username }, time.Minute)
+
+	poll := func() {
+		if err := poller.Poll(ctx); err != nil {
+			t.Fatalf("Poll() error = %v", err)
+		}
+		dispatcher.Wait()
+	}
+
+	// the first poll only records the existing listens
+	poll()
+	if ids := recv.listenIDs(); len(ids) != 0 {
+		t.Fatalf("delivered %v on the first poll, expected nothing", ids)
+	}
+
+	// new listens are delivered oldest first
+	poll()
+	assertIDs(t, recv.listenIDs(), "3", "4")
+
+	// listens falling out of the feed are not an issue
+	poll()
+	assertIDs(t, recv.listenIDs(), "3", "4", "5")
+
+	// a change of the selected feed starts from a new baseline
+	username = "bob"
+	poll()
+	assertIDs(t, recv.listenIDs(), "3", "4", "5")
+
+	if deliveries := dispatcher.Deliveries(0); len(deliveries) != 3 || deliveries[0].ListenID != "5" || deliveries[0].Status != DeliveryDelivered {
+		t.Errorf("Deliveries() = %+v, expected 3 delivered listens, newest first", deliveries)
+	}
+}
+
+func TestDispatcherRetries(t *testing.T) {
+	ctx := context.Background()
+	registry := NewRegistry(AllowPrivateURLs())
+
+	// fails twice before accepting the delivery
+	flaky := &receiver{t: t, statuses: []int{http.StatusInternalServerError, http.StatusBadGateway}}
+	flakyServer := httptest.NewServer(flaky)
+	defer flakyServer.Close()
+
+	// always fails
+	broken := &receiver{t: t, statuses: []int{500, 500, 500, 500}}
+	brokenServer := httptest.NewServer(broken)
+	defer brokenServer.Close()
+
+	for _, url := range []string{flakyServer.URL, brokenServer.URL} {
+		if _, err := registry.Register(url, "admin"); err != nil {
+			t.Fatalf("Register() error = %v", err)
+		}
+	}
+
+	dispatcher := localDispatcher(WithRetries(3, time.Millisecond))
+	if err := dispatcher.Dispatch(ctx, registry.targets(), NewListenEvent("alice", musicbrainz.Entry{ID: "1"})); err != nil {
+		t.Fatalf("Dispatch() error = %v", err)
+	}
+	dispatcher.Wait()
+
+	assertIDs(t, flaky.listenIDs(), "1")
+	if broken.calls != 3 {
+		t.Errorf("broken webhook was called %d times, expected 3", broken.calls)
+	}
+
+	deliveries := map[string]*Delivery{}
+	for _, delivery := range dispatcher.Deliveries(0) {
+		deliveries[delivery.URL] = delivery
+	}
+	if d := deliveries[flakyServer.URL]; d.Status != DeliveryDelivered || d.Attempts != 3 || d.StatusCode != http.StatusOK || d.Error != "" {
+		t.Errorf("flaky delivery = %+v, expected delivered after 3 attempts", d)
+	}
+	if d := deliveries[brokenServer.URL]; d.Status != DeliveryFailed || d.Attempts != 3 || d.StatusCode != http.StatusInternalServerError {
+		t.Errorf("broken delivery = %+v, expected failed after 3 attempts", d)
+	}
+
+	deadLetters := dispatcher.DeadLetters(0)
+	if len(deadLetters) != 1 || deadLetters[0].URL != brokenServer.URL {
+		t.Errorf("DeadLetters() = %+v, expected the broken delivery", deadLetters)
+	}
+}
+
+func TestDispatcherCapsDeadLetters(t *testing.T) {
+	ctx := context.Background()
+	registry := NewRegistry(AllowPrivateURLs())
+
+	broken := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusInternalServerError)
+	}))
+	defer broken.Close()
+	if _, err := registry.Register(broken.URL, "admin"); err != nil {
+		t.Fatalf("Register() error = %v", err)
+	}
+
+	dispatcher := localDispatcher(WithRetries(1, time.Millisecond))
+	dispatcher.logSize = 3
+	for _, id := range []string{"1", "2", "3", "4", "5"} {
+		if err := dispatcher.Dispatch(ctx, registry.targets(), NewListenEvent("alice", musicbrainz.Entry{ID: id})); err != nil {
+			t.Fatalf("Dispatch() error = %v", err)
+		}
+	}
+	dispatcher.Wait()
+
+	deadLetters := dispatcher.DeadLetters(0)
+	if len(deadLetters) != 3 || deadLetters[0].ListenID != "5" || deadLetters[2].ListenID != "3" {
+		t.Errorf("DeadLetters() = %+v, expected the last 3 deliveries", deadLetters)
+	}
+	if deliveries := dispatcher.Deliveries(0); len(deliveries) != 3 {
+		t.Errorf("Deliveries() = %d deliveries, expected 3", len(deliveries))
+	}
+}
+
+func TestDispatcherKeepsTheOrderAcrossCalls(t *testing.T) {
+	ctx := context.Background()
+	registry := NewRegistry(AllowPrivateURLs())
+
+	// the first event is retried while the second one is dispatched
+	flaky := &receiver{t: t, statuses: []int{http.StatusInternalServerError, http.StatusInternalServerError}}
+	server := httptest.NewServer(flaky)
+	defer server.Close()
+	if _, err := registry.Register(server.URL, "admin"); err != nil {
+		t.Fatalf("Register() error = %v", err)
+	}
+
+	dispatcher := localDispatcher(WithRetries(3, 20*time.Millisecond))
+	for _, id := range []string{"1", "2", "3"} {
+		if err := dispatcher.Dispatch(ctx, registry.targets(), NewListenEvent("alice", musicbrainz.Entry{ID: id})); err != nil {
+			t.Fatalf("Dispatch() error = %v", err)
+		}
+	}
+	dispatcher.Wait()
+
+	assertIDs(t, flaky.listenIDs(), "1", "2", "3")
+}
+
+func TestDispatcherRefusesPrivateDestinations(t *testing.T) {
+	ctx := context.Background()
+	// a host name resolving to the loopback passes the registration
+	registry := NewRegistry(AllowPrivateURLs())
+
+	recv := &receiver{t: t}
+	server := httptest.NewServer(recv)
+	defer server.Close()
+	if _, err := registry.Register(server.URL, "admin"); err != nil {
+		t.Fatalf("Register() error = %v", err)
+	}
+
+	dispatcher := NewDispatcher(WithRetries(1, time.Millisecond))
+	if err := dispatcher.Dispatch(ctx, registry.targets(), NewListenEvent("alice", musicbrainz.Entry{ID: "1"})); err != nil {
+		t.Fatalf("Dispatch() error = %v", err)
+	}
+	dispatcher.Wait()
+
+	if ids := recv.listenIDs(); len(ids) != 0 {
+		t.Errorf("delivered %v to the loopback, expected nothing", ids)
+	}
+	if deadLetters := dispatcher.DeadLetters(0); len(deadLetters) != 1 || !strings.Contains(deadLetters[0].Error, ErrPrivateDestination.Error()) {
+		t.Errorf("DeadLetters() = %+v, expected the delivery to be refused", deadLetters)
+	}
+}
+
+func TestDispatcherDoesntFollowRedirects(t *testing.T) {
+	recv := &receiver{t: t}
+	target := httptest.NewServer(recv)
+	defer target.Close()
+	redirect := httptest.NewServer(http.RedirectHandler(target.URL, http.StatusTemporaryRedirect))
+	defer redirect.Close()
+
+	// the default client, without the dial checks so it can reach the test servers
+	client := publicClient()
+	client.Transport = http.DefaultTransport
+	dispatcher := NewDispatcher(WithHTTPClient(client), WithRetries(1, time.Millisecond))
+
+	webhook := &Webhook{ID: "1", URL: redirect.URL, Secret: "secret"}
+	if err := dispatcher.Dispatch(context.Background(), []*Webhook{webhook}, NewListenEvent("alice", musicbrainz.Entry{ID: "1"})); err != nil {
+		t.Fatalf("Dispatch() error = %v", err)
+	}
+	dispatcher.Wait()
+
+	if ids := recv.listenIDs(); len(ids) != 0 {
+		t.Errorf("redirect followed to deliver %v", ids)
+	}
+	if deadLetters := dispatcher.DeadLetters(0); len(deadLetters) != 1 || deadLetters[0].StatusCode != http.StatusTemporaryRedirect {
+		t.Errorf("DeadLetters() = %+v, expected the redirect to fail the delivery", deadLetters)
+	}
+}
+
+func TestRegistry(t *testing.T) {
+	registry := NewRegistry()
+
+	for _, url := range []string{"", "not a url", "ftp://example.com", "/relative"} {
+		if _, err := registry.Register(url, "admin"); err != ErrInvalidURL {
+			t.Errorf("Register(%q) error = %v, expected %v", url, err, ErrInvalidURL)
+		}
+	}
+
+	for _, url := range []string{"http://127.0.0.1:8080/hook", "http://localhost/hook", "http://[::1]/hook", "http://10.0.0.1/hook",
+		"http://169.254.169.254/latest/meta-data", "http://[::ffff:192.168.1.1]/hook", "http://0.0.0.0/hook"} {
+		if _, err := registry.Register(url, "admin"); !errors.Is(err, ErrPrivateDestination) {
+			t.Errorf("Register(%q) error = %v, expected %v", url, err, ErrPrivateDestination)
+		}
+	}
+
+	webhook, err := registry.Register("https://example.com/hook", "admin")
+	if err != nil {
+		t.Fatalf("Register() error = %v", err)
+	}
+	if webhook.Secret == "" {
+		t.Error("Register() returned no secret")
+	}
+
+	// secrets are only revealed on registration
+	listed := registry.List()
+	if len(listed) != 1 || listed[0].ID != webhook.ID || listed[0].Secret != "" {
+		t.Errorf("List() = %+v, expected the webhook without its secret", listed)
+	}
+
+	if err := registry.Remove(webhook.ID); err != nil {
+		t.Fatalf("Remove() error = %v", err)
+	}
+	if err := registry.Remove(webhook.ID); err != ErrWebhookNotFound {
+		t.Errorf("Remove() error = %v, expected %v", err, ErrWebhookNotFound)
+	}
+}
+
+func TestVerify(t *testing.T) {
+	now := time.Unix(1700000000, 0)
+	body := []byte(`{"id":"1"}`)
+	signature := Sign("secret", now, body)
+
+	if !Verify("secret", "1700000000", signature, body) {
+		t.Error("Verify() = false, expected a valid signature")
+	}
+	if Verify("other", "1700000000", signature, body) {
+		t.Error("Verify() = true with another secret")
+	}
+	if Verify("secret", "1700000001", signature, body) {
+		t.Error("Verify() = true with another timestamp")
+	}
+	if Verify("secret", "1700000000", signature, []byte(`{"id":"2"}`)) {
+		t.Error("Verify() = true with another body")
+	}
+}
+
+func assertIDs(t *testing.T, ids []string, expected ...string) {
+	t.Helper()
+
+	if len(ids) != len(expected) {
+		t.Fatalf("delivered %v, expected %v", ids, expected)
+	}
+	for i := range ids {
+		if ids[i] != expected[i] {
+			t.Fatalf("delivered %v, expected %v", ids, expected)
+		}
+	}
+}
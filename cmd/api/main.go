@@ -5,12 +5,21 @@ import (
 	"errors"
 	"flag"
 	"fmt"
+	"log/slog"
 	"net/http"
 	"os"
-
-	"golang.org/x/exp/slog"
+	"strings"
 
 	"github.com/xaviercrochet/turbo-octo-adventure/api/app"
+	"github.com/xaviercrochet/turbo-octo-adventure/api/archive"
+	"github.com/xaviercrochet/turbo-octo-adventure/api/musicbrainz"
+	"github.com/xaviercrochet/turbo-octo-adventure/api/webhook"
+	"github.com/xaviercrochet/turbo-octo-adventure/pkg/auth"
+	mw "github.com/xaviercrochet/turbo-octo-adventure/pkg/middleware"
+	"github.com/xaviercrochet/turbo-octo-adventure/pkg/mtls"
+	"github.com/xaviercrochet/turbo-octo-adventure/pkg/net"
+	"github.com/xaviercrochet/turbo-octo-adventure/pkg/policy"
+	"github.com/xaviercrochet/turbo-octo-adventure/pkg/util"
 )
 
 var (
@@ -18,6 +27,54 @@ var (
 	domain = flag.String("domain", "", "your ZITADEL instance domain (in the form: <instance>.zitadel.cloud or <yourdomain>)")
 	key    = flag.String("key", "", "path to your key.json")
 	port   = flag.String("port", "8090", "port to run the server on (default is 8090)")
+	// role to permission mapping
+	policyFile = flag.String("policy", "", "path to the json file mapping roles to permissions (admin gets everything by default)")
+	auditFile  = flag.String("auditFile", "", "path from which the audit trail file of every organisation is derived, e.g. audit.jsonl gives audit.<org id>.jsonl (in memory only if empty)")
+	// subscription urls used by feed readers
+	subscriptionKey  = flag.String("subscriptionKey", "", "secret used to sign the subscription tokens (random if empty, tokens are then invalidated on restart)")
+	subscriptionFile = flag.String("subscriptionFile", "", "path to the file in which subscriptions are saved (in memory only if empty)")
+	// archive of the listens
+	archiveFile         = flag.String("archiveFile", "", "path from which the archive file of every organisation is derived, e.g. archive.jsonl gives archive.<org id>.jsonl (in memory only if empty)")
+	archiveSyncInterval = flag.Duration("archiveSyncInterval", archive.DefaultSyncInterval, "how often listens are pulled into the archive")
+	trackedUsers        = flag.String("trackUsers", "", "comma separated users archived in addition to the selected feed")
+	duplicateTolerance  = flag.Duration("duplicateTolerance", musicbrainz.DefaultDuplicateTolerance, "listens of the same track submitted within this duration are archived once")
+	// display preferences of the users
+	preferencesFile = flag.String("preferencesFile", "", "path from which the preferences file of every organisation is derived (in memory only if empty)")
+	apiKeysFile     = flag.String("apiKeysFile", "", "path to the file in which the hashed api keys are saved (in memory only if empty)")
+	// record/replay of the ListenBrainz traffic, to develop offline or reproduce a bug
+	musicbrainzMode     = flag.String("musicbrainzMode", string(musicbrainz.ModeLive), "live, record (ListenBrainz responses are saved to -musicbrainzFixtures) or replay (responses are read from -musicbrainzFixtures, nothing leaves the machine)")
+	musicbrainzFixtures = flag.String("musicbrainzFixtures", "", "directory of the ListenBrainz fixtures, required to record or replay")
+	// bodies larger than these are rejected
+	maxRequestSize  = flag.Int64("maxRequestSize", mw.DefaultMaxBodySize, "maximum size of the request bodies, in bytes")
+	maxResponseSize = flag.Int64("maxResponseSize", net.DefaultMaxBodySize, "maximum size of the ListenBrainz feeds, in bytes")
+	// outgoing webhooks
+	webhookPollInterval = flag.Duration("webhookPollInterval", webhook.DefaultPollInterval, "how often the selected feed is checked for new listens to send to the webhooks")
+	// authorization backend
+	authBackend = flag.String("auth", "zitadel", "authorization backend: zitadel, oidc or dev (locally minted tokens, never use in production)")
+	issuer      = flag.String("issuer", "", "oidc: issuer url of the OpenID Connect provider")
+	audience    = flag.String("audience", "", "oidc: audience the access tokens must contain, required")
+	rolesClaim  = flag.String("rolesClaim", auth.ZitadelRolesClaim, "oidc: claim holding the roles of the user")
+	orgClaim    = flag.String("orgClaim", auth.ZitadelOrgClaim, "oidc: claim holding the organisation of the user")
+	defaultOrg  = flag.String("defaultOrg", "", "oidc: organisation of the users whose token doesn't have the organisation claim")
+	devKey      = flag.String("devKey", auth.DefaultDevKey, "dev: key used to verify the development tokens, must match the webapp")
+	// logs
+	logFormat           = flag.String("logFormat", "text", "format of the logs: text or json")
+	logLevel            = flag.String("logLevel", "info", "minimum level of the logs: debug, info, warn or error")
+	logFile             = flag.String("logFile", "", "file the logs are written to (stdout if empty)")
+	logMaxSize          = flag.Int64("logMaxSize", 100<<20, "size in bytes at which the log file is rotated (never if 0)")
+	logMaxBackups       = flag.Int("logMaxBackups", 5, "number of rotated log files kept")
+	logSampleFirst      = flag.Int("logSampleFirst", 0, "info and debug logs with the same message are sampled after this many per second (no sampling if 0)")
+	logSampleThereafter = flag.Int("logSampleThereafter", 100, "once sampled, one log out of this many is kept")
+	logRedactKeys       = flag.String("logRedactKeys", strings.Join(util.DefaultRedactKeys, ","), "comma separated attributes masked in the logs")
+	logHashKeys         = flag.String("logHashKeys", strings.Join(util.DefaultHashKeys, ","), "comma separated attributes hashed in the logs, i.e. user identifiers")
+	logHashSecret       = flag.String("logHashSecret", "", "key of the HMAC hashing the attributes, random if empty so the hashes change on restart")
+	// access log
+	accessLogFormat = flag.String("accessLogFormat", "structured", "format of the access log: structured (with the other logs), common or combined (on stdout)")
+	trustedProxies  = flag.String("trustedProxies", "", "comma separated addresses or networks of the reverse proxies whose X-Forwarded-For and Forwarded headers are trusted")
+	// optional mutual TLS, the server is started with https when a certificate is provided
+	tlsCert  = flag.String("tlsCert", "", "path to the server certificate, enables https")
+	tlsKey   = flag.String("tlsKey", "", "path to the private key of the server certificate")
+	clientCA = flag.String("clientCA", "", "path to the CA used to verify client certificates, enables mutual TLS")
 )
 
 /*
@@ -28,26 +85,145 @@ var (
 
  - /api/healthz (can be called by anyone)
  - /api/feed (requires authorization)
- - /api/select_feed (requires authorization with granted `admin` role)
+ - /api/select_feed (requires authorization with the `feed:select` permission, granted to `admin` by default)
+ - /api/feed.atom, /api/feed.rss (requires a subscription token)
 */
 
 func main() {
 	flag.Parse()
 	ctx := context.Background()
 
-	serverOptions := app.NewServerOptions(*domain, *key, *port)
+	logConfig, err := util.NewLogConfig(*logFormat, *logLevel, *logFile, *logMaxSize, *logMaxBackups, *logSampleFirst, *logSampleThereafter)
+	if err != nil {
+		slog.Error("invalid log configuration", "error", err)
+		os.Exit(1)
+	}
+	logConfig.Redact, err = util.NewRedactConfig(*logRedactKeys, *logHashKeys, *logHashSecret)
+	if err != nil {
+		slog.Error("invalid log configuration", "error", err)
+		os.Exit(1)
+	}
+	logger, logCloser, err := util.NewLoggerWithConfig(logConfig)
+	if err != nil {
+		slog.Error("could not create logger", "error", err)
+		os.Exit(1)
+	}
+	defer logCloser.Close()
+	// deferred calls don't run on os.Exit, the log file is closed before exiting
+	exit := func(code int) {
+		logCloser.Close()
+		os.Exit(code)
+	}
+	util.SetDefaultLogger(logger)
+	if *logHashSecret == "" && len(logConfig.Redact.HashKeys) > 0 {
+		slog.Warn("no log hash secret configured, the hashes of the logs will change on restart")
+	}
+
+	format, err := mw.ParseAccessLogFormat(*accessLogFormat)
+	if err != nil {
+		slog.Error("invalid access log format", "error", err)
+		exit(1)
+	}
+	proxies, err := mw.ParseTrustedProxies(*trustedProxies)
+	if err != nil {
+		slog.Error("invalid trusted proxies", "error", err)
+		exit(1)
+	}
+	accessLog := &mw.AccessLog{Format: format, TrustedProxies: proxies}
+
+	backend, err := auth.ParseBackend(*authBackend)
+	if err != nil {
+		slog.Error("invalid auth backend", "error", err)
+		exit(1)
+	}
+	if backend == auth.BackendDev {
+		slog.Warn("development auth backend enabled, tokens are verified with a local key. Never use in production")
+	}
+	if backend == auth.BackendOIDC && *audience == "" {
+		slog.Error("invalid auth configuration", "error", auth.ErrMissingAudience)
+		exit(1)
+	}
+
+	authConfig := auth.NewZitadelConfig(*domain, *key)
+	authConfig.Backend = backend
+	authConfig.Issuer = *issuer
+	authConfig.Audience = *audience
+	authConfig.RolesClaim = *rolesClaim
+	authConfig.OrgClaim = *orgClaim
+	authConfig.DefaultOrg = *defaultOrg
+	authConfig.DevKey = *devKey
+
+	appOptions := []app.Option{
+		app.WithAuthConfig(authConfig),
+		app.WithAuditFile(*auditFile),
+		app.WithSubscriptions([]byte(*subscriptionKey), *subscriptionFile),
+		app.WithWebhookPollInterval(*webhookPollInterval),
+		app.WithArchive(*archiveFile, *archiveSyncInterval, auth.SplitList(*trackedUsers)...),
+		app.WithDuplicateTolerance(*duplicateTolerance),
+		app.WithPreferencesFile(*preferencesFile),
+		app.WithAPIKeysFile(*apiKeysFile),
+		app.WithMaxRequestSize(*maxRequestSize),
+		app.WithAccessLog(accessLog),
+	}
+	mode, err := musicbrainz.ParseMode(*musicbrainzMode)
+	if err != nil {
+		slog.Error("invalid musicbrainz mode", "error", err)
+		exit(1)
+	}
+	clientOptions := []musicbrainz.ClientOption{musicbrainz.WithMaxBodySize(*maxResponseSize)}
+	if mode != musicbrainz.ModeLive {
+		if *musicbrainzFixtures == "" {
+			slog.Error("a fixtures directory is required to record or replay", "mode", mode)
+			exit(1)
+		}
+		slog.Warn("ListenBrainz traffic is recorded or replayed", "mode", mode, "fixtures", *musicbrainzFixtures)
+		recorder := musicbrainz.NewRecorder(*musicbrainzFixtures, mode, nil)
+		clientOptions = append(clientOptions, musicbrainz.WithHTTPClient(&http.Client{Transport: recorder}))
+	}
+	appOptions = append(appOptions, app.WithMusicBrainzClient(musicbrainz.NewClient(clientOptions...)))
+	if *policyFile != "" {
+		p, err := policy.Load(*policyFile)
+		if err != nil {
+			slog.Error("could not load policy", "error", err)
+			exit(1)
+		}
+		appOptions = append(appOptions, app.WithPolicy(p))
+	}
+
+	serverOptions := app.NewServerOptions(*domain, *key, *port, appOptions...)
 	router := http.NewServeMux()
 	if err := app.SetupRoutes(ctx, router, serverOptions); err != nil {
 		slog.Error("could not start server", "error", err)
-		os.Exit(1)
+		exit(1)
 	}
 
 	// start the server on the specified port (default http://localhost:8101)
 	lis := fmt.Sprintf(":%s", *port)
-	slog.Info("server listening, press ctrl+c to stop", "addr", "http://localhost"+lis)
-	err := http.ListenAndServe(lis, router)
+	server := &http.Server{
+		Addr:    lis,
+		Handler: router,
+	}
+
+	tlsConfig := mtls.NewConfig(*tlsCert, *tlsKey, *clientCA)
+	if err := tlsConfig.Validate(); err != nil {
+		slog.Error("invalid tls configuration, -tlsCert and -tlsKey are required", "error", err)
+		exit(1)
+	}
+	if tlsConfig.Enabled() {
+		server.TLSConfig, err = mtls.ServerTLSConfig(ctx, tlsConfig)
+		if err != nil {
+			slog.Error("could not load server certificate", "error", err)
+			exit(1)
+		}
+		slog.Info("server listening, press ctrl+c to stop", "addr", "https://localhost"+lis, "mtls", *clientCA != "")
+		// certificates are provided by the tls config
+		err = server.ListenAndServeTLS("", "")
+	} else {
+		slog.Info("server listening, press ctrl+c to stop", "addr", "http://localhost"+lis)
+		err = server.ListenAndServe()
+	}
 	if !errors.Is(err, http.ErrServerClosed) {
 		slog.Error("server terminated", "error", err)
-		os.Exit(1)
+		exit(1)
 	}
 }
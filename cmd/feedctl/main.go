@@ -0,0 +1,290 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+	"os/signal"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/xaviercrochet/turbo-octo-adventure/pkg/auth"
+	"github.com/xaviercrochet/turbo-octo-adventure/pkg/mtls"
+	"github.com/xaviercrochet/turbo-octo-adventure/pkg/net"
+	"github.com/xaviercrochet/turbo-octo-adventure/web/feed_api"
+	"github.com/zitadel/oidc/v3/pkg/oidc"
+)
+
+var (
+	apiHostname = flag.String("apiHostname", "localhost", "hostname of the api")
+	apiPort     = flag.String("apiPort", "8090", "port of the api")
+	output      = flag.String("output", OutputTable, "output of the commands: "+strings.Join(outputs, ", "))
+	tokenFile   = flag.String("tokenFile", defaultTokenFile(), "file caching the tokens obtained by login")
+	timeout     = flag.Duration("timeout", 30*time.Second, "timeout of the api calls")
+	// optional mutual TLS with the api
+	apiTLSCert = flag.String("apiTLSCert", "", "path to the client certificate presented to the api, enables https")
+	apiTLSKey  = flag.String("apiTLSKey", "", "path to the private key of the client certificate")
+	apiCA      = flag.String("apiCA", "", "path to the CA used to verify the api certificate (system roots if empty)")
+)
+
+// exit codes, failed api calls are mapped from the errors of pkg/net
+const (
+	ExitOK               = 0
+	ExitError            = 1
+	ExitUsage            = 2
+	ExitNotAuthenticated = 3
+	ExitNoAccess         = 4
+	ExitNotFound         = 5
+	ExitUnavailable      = 6
+)
+
+var (
+	errUsage       = errors.New("invalid usage")
+	errUnavailable = errors.New("api unavailable")
+)
+
+const usage = `Usage: feedctl [flags] <command> [arguments]
+
+Commands:
+  login -issuer <url> -clientID <id>  log in with the device authorization flow
+  feed                                print the selected feed
+  select <user>                       select the feed of a ListenBrainz user (admin only)
+  health                              check the api is up
+  export [-format csv] [-file path]   download the feed, to stdout unless -file is set
+
+Exit codes:
+  0 success, 1 error, 2 invalid usage, 3 not authenticated, 4 not authorized, 5 not found, 6 api unreachable
+
+Flags:
+`
+
+func main() {
+	flag.Usage = func() {
+		fmt.Fprint(flag.CommandLine.Output(), usage)
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+
+	err := run(ctx, flag.Args(), os.Stdout)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "feedctl:", err)
+		if errors.Is(err, errUsage) {
+			flag.Usage()
+		}
+	}
+	os.Exit(exitCode(err))
+}
+
+// Map the error of a command to the exit code of the process
+func exitCode(err error) int {
+	var urlErr *url.Error
+	switch {
+	case err == nil:
+		return ExitOK
+	case errors.Is(err, errUsage):
+		return ExitUsage
+	case errors.Is(err, net.ErrNotAuthenticated):
+		return ExitNotAuthenticated
+	case errors.Is(err, net.ErrNoAccess):
+		return ExitNoAccess
+	case errors.Is(err, net.ErrNotFound):
+		return ExitNotFound
+	case errors.Is(err, errUnavailable), errors.As(err, &urlErr):
+		// the api could not be reached at all
+		return ExitUnavailable
+	default:
+		return ExitError
+	}
+}
+
+// Run the command named by the first argument
+func run(ctx context.Context, args []string, w io.Writer) error {
+	if len(args) == 0 {
+		return fmt.Errorf("%w: missing command", errUsage)
+	}
+	if !slices.Contains(outputs, *output) {
+		return fmt.Errorf("%w: unsupported output %q", errUsage, *output)
+	}
+	command, args := args[0], args[1:]
+
+	if command == "login" {
+		return runLogin(ctx, args, w)
+	}
+
+	client, err := newClient(ctx)
+	if err != nil {
+		return err
+	}
+	ctx, cancel := context.WithTimeout(ctx, *timeout)
+	defer cancel()
+
+	switch command {
+	case "health":
+		return runHealth(ctx, client, args, w)
+	case "feed":
+		return runFeed(ctx, client, args, w)
+	case "select":
+		return runSelect(ctx, client, args, w)
+	case "export":
+		return runExport(ctx, client, args, w)
+	default:
+		return fmt.Errorf("%w: unknown command %q", errUsage, command)
+	}
+}
+
+func newClient(ctx context.Context) (*feed_api.FeedClient, error) {
+	options := []feed_api.Option{}
+	tlsConfig := mtls.NewConfig(*apiTLSCert, *apiTLSKey, *apiCA)
+	if err := tlsConfig.Validate(); err != nil {
+		return nil, fmt.Errorf("%w: -apiTLSCert and -apiTLSKey are required: %v", errUsage, err)
+	}
+	if tlsConfig.Enabled() {
+		clientTLSConfig, err := mtls.ClientTLSConfig(ctx, tlsConfig)
+		if err != nil {
+			return nil, fmt.Errorf("could not load api client certificate: %w", err)
+		}
+		options = append(options, feed_api.WithTLSConfig(clientTLSConfig))
+	}
+	return feed_api.NewFeedClient(*apiHostname, *apiPort, options...), nil
+}
+
+func runLogin(ctx context.Context, args []string, w io.Writer) error {
+	flags := flag.NewFlagSet("login", flag.ContinueOnError)
+	issuer := flags.String("issuer", "", "issuer url of the OpenID Connect provider, i.e. the ZITADEL instance domain")
+	clientID := flags.String("clientID", "", "id of a native application allowed to use the device code grant")
+	scopes := flags.String("scopes", strings.Join([]string{oidc.ScopeOpenID, oidc.ScopeProfile, oidc.ScopeEmail, oidc.ScopeOfflineAccess}, ","), "comma separated scopes requested")
+	if err := flags.Parse(args); err != nil {
+		return fmt.Errorf("%w: %v", errUsage, err)
+	}
+	if *issuer == "" || *clientID == "" {
+		return fmt.Errorf("%w: login requires -issuer and -clientID", errUsage)
+	}
+
+	t, err := login(ctx, w, *issuer, *clientID, auth.SplitList(*scopes))
+	if err != nil {
+		return err
+	}
+	if err := saveToken(*tokenFile, t); err != nil {
+		return err
+	}
+	fmt.Fprintf(w, "Logged in, token cached in %s\n", *tokenFile)
+	return nil
+}
+
+func runHealth(ctx context.Context, client *feed_api.FeedClient, args []string, w io.Writer) error {
+	if len(args) != 0 {
+		return fmt.Errorf("%w: health takes no arguments", errUsage)
+	}
+
+	healthy, err := client.CheckHealth(ctx)
+	status := "ok"
+	if !healthy {
+		status = "down"
+	}
+	renderErr := render(w, *output, &result{
+		value:  map[string]any{"healthy": healthy},
+		header: []string{"status"},
+		rows:   [][]string{{status}},
+	})
+	if err != nil {
+		return fmt.Errorf("%w: %v", errUnavailable, err)
+	}
+	return renderErr
+}
+
+func runFeed(ctx context.Context, client *feed_api.FeedClient, args []string, w io.Writer) error {
+	if len(args) != 0 {
+		return fmt.Errorf("%w: feed takes no arguments", errUsage)
+	}
+	token, err := accessToken(ctx, *tokenFile)
+	if err != nil {
+		return err
+	}
+
+	feed, err := client.GetFeed(ctx, token)
+	if err != nil {
+		return err
+	}
+	return render(w, *output, feedResult(feed))
+}
+
+func feedResult(feed *feed_api.FeedResponse) *result {
+	r := &result{
+		value:  feed,
+		header: []string{"listened_at", "title", "id"},
+		rows:   [][]string{},
+	}
+	if feed.Feed == nil {
+		return r
+	}
+	for _, song := range feed.Feed.Songs {
+		r.rows = append(r.rows, []string{song.ListenedAt.Format(time.RFC3339), song.Title, song.ID})
+	}
+	return r
+}
+
+func runSelect(ctx context.Context, client *feed_api.FeedClient, args []string, w io.Writer) error {
+	if len(args) != 1 || args[0] == "" {
+		return fmt.Errorf("%w: select takes the ListenBrainz username as argument", errUsage)
+	}
+	token, err := accessToken(ctx, *tokenFile)
+	if err != nil {
+		return err
+	}
+
+	if err := client.SelectFeed(ctx, args[0], token); err != nil {
+		return err
+	}
+	return render(w, *output, &result{
+		value:  map[string]any{"selected": args[0]},
+		header: []string{"selected"},
+		rows:   [][]string{{args[0]}},
+	})
+}
+
+func runExport(ctx context.Context, client *feed_api.FeedClient, args []string, w io.Writer) error {
+	flags := flag.NewFlagSet("export", flag.ContinueOnError)
+	format := flags.String("format", "csv", "format of the export: "+strings.Join(feed_api.ExportFormats, ", "))
+	file := flags.String("file", "", "file the export is written to, stdout if empty")
+	if err := flags.Parse(args); err != nil {
+		return fmt.Errorf("%w: %v", errUsage, err)
+	}
+	if flags.NArg() != 0 {
+		return fmt.Errorf("%w: export takes no arguments", errUsage)
+	}
+	if !slices.Contains(feed_api.ExportFormats, *format) {
+		return fmt.Errorf("%w: unsupported format %q", errUsage, *format)
+	}
+	token, err := accessToken(ctx, *tokenFile)
+	if err != nil {
+		return err
+	}
+
+	export, err := client.ExportFeed(ctx, *format, token)
+	if err != nil {
+		return err
+	}
+	defer export.Body.Close()
+
+	if *file == "" {
+		_, err = io.Copy(w, export.Body)
+		return err
+	}
+	f, err := os.Create(*file)
+	if err != nil {
+		return fmt.Errorf("failed to create export file: %w", err)
+	}
+	if _, err := io.Copy(f, export.Body); err != nil {
+		f.Close()
+		return fmt.Errorf("failed to write export: %w", err)
+	}
+	return f.Close()
+}
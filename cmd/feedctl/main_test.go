@@ -0,0 +1,190 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/xaviercrochet/turbo-octo-adventure/pkg/net"
+)
+
+func TestExitCode(t *testing.T) {
+	tests := []struct {
+		err      error
+		expected int
+	}{
+		{err: nil, expected: ExitOK},
+		{err: fmt.Errorf("%w: missing command", errUsage), expected: ExitUsage},
+		{err: net.ErrNotAuthenticated, expected: ExitNotAuthenticated},
+		{err: fmt.Errorf("%w: token expired", net.ErrNotAuthenticated), expected: ExitNotAuthenticated},
+		{err: net.ErrNoAccess, expected: ExitNoAccess},
+		{err: net.ErrNotFound, expected: ExitNotFound},
+		{err: net.ErrGeneric, expected: ExitError},
+		{err: fmt.Errorf("failed to query feed api: %w", &url.Error{Op: "Get", Err: errors.New("connection refused")}), expected: ExitUnavailable},
+		{err: errors.New("boom"), expected: ExitError},
+	}
+
+	for _, tt := range tests {
+		if got := exitCode(tt.err); got != tt.expected {
+			t.Errorf("exitCode(%v) = %v, expected %v", tt.err, got, tt.expected)
+		}
+	}
+}
+
+func TestRender(t *testing.T) {
+	r := &result{
+		value:  map[string]any{"title": "One, More Time"},
+		header: []string{"title", "id"},
+		rows:   [][]string{{"One, More Time", "1"}},
+	}
+
+	tests := map[string]string{
+		OutputTable: "TITLE           ID\nOne, More Time  1\n",
+		OutputCSV:   "title,id\n\"One, More Time\",1\n",
+		OutputJSON:  "{\n  \"title\": \"One, More Time\"\n}\n",
+	}
+	for output, expected := range tests {
+		var buf bytes.Buffer
+		if err := render(&buf, output, r); err != nil {
+			t.Fatalf("%s: render() error = %v", output, err)
+		}
+		if buf.String() != expected {
+			t.Errorf("%s: render() = %q, expected %q", output, buf.String(), expected)
+		}
+	}
+
+	if err := render(&bytes.Buffer{}, "yaml", r); err == nil {
+		t.Errorf("render() error = nil, expected an error for an unsupported output")
+	}
+}
+
+func TestTokenCache(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "feedctl", "token.json")
+	ctx := context.Background()
+
+	if _, err := accessToken(ctx, path); !errors.Is(err, net.ErrNotAuthenticated) {
+		t.Fatalf("accessToken() error = %v, expected %v before login", err, net.ErrNotAuthenticated)
+	}
+
+	valid := &token{AccessToken: "valid", Expiry: time.Now().Add(time.Hour)}
+	if err := saveToken(path, valid); err != nil {
+		t.Fatalf("saveToken() error = %v", err)
+	}
+	got, err := accessToken(ctx, path)
+	if err != nil || got != "valid" {
+		t.Fatalf("accessToken() = %v, %v, expected the cached token", got, err)
+	}
+
+	// without a refresh token the user has to log in again
+	expired := &token{AccessToken: "expired", Expiry: time.Now().Add(-time.Minute)}
+	if err := saveToken(path, expired); err != nil {
+		t.Fatalf("saveToken() error = %v", err)
+	}
+	if _, err := accessToken(ctx, path); !errors.Is(err, net.ErrNotAuthenticated) {
+		t.Fatalf("accessToken() error = %v, expected %v for an expired token", err, net.ErrNotAuthenticated)
+	}
+}
+
+// Point the flags to the test api and a logged in token cache
+func setupAPI(t *testing.T, handler http.Handler) {
+	t.Helper()
+
+	server := httptest.NewServer(handler)
+	t.Cleanup(server.Close)
+	u, _ := url.Parse(server.URL)
+
+	path := filepath.Join(t.TempDir(), "token.json")
+	if err := saveToken(path, &token{AccessToken: "secret"}); err != nil {
+		t.Fatalf("saveToken() error = %v", err)
+	}
+
+	previous := []string{*apiHostname, *apiPort, *tokenFile, *output}
+	*apiHostname, *apiPort, *tokenFile = u.Hostname(), u.Port(), path
+	t.Cleanup(func() {
+		*apiHostname, *apiPort, *tokenFile, *output = previous[0], previous[1], previous[2], previous[3]
+	})
+}
+
+func TestRunFeed(t *testing.T) {
+	setupAPI(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer secret" {
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+		json.NewEncoder(w).Encode(map[string]any{
+			"permissions": []string{"feed:read"},
+			"feed": map[string]any{
+				"username": "xcrochet",
+				"songs": []map[string]any{
+					{"id": "1", "title": "One More Time", "listened_at": "2024-03-01T10:00:00Z"},
+				},
+			},
+		})
+	}))
+
+	*output = OutputCSV
+	var buf bytes.Buffer
+	if err := run(context.Background(), []string{"feed"}, &buf); err != nil {
+		t.Fatalf("run() error = %v", err)
+	}
+	expected := "listened_at,title,id\n2024-03-01T10:00:00Z,One More Time,1\n"
+	if buf.String() != expected {
+		t.Errorf("run() output = %q, expected %q", buf.String(), expected)
+	}
+}
+
+func TestRunMapsAPIErrors(t *testing.T) {
+	setupAPI(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/api/select_feed":
+			http.Error(w, "forbidden", http.StatusForbidden)
+		case "/api/healthz":
+			http.Error(w, "unavailable", http.StatusServiceUnavailable)
+		default:
+			http.Error(w, "not found", http.StatusNotFound)
+		}
+	}))
+
+	tests := []struct {
+		args     []string
+		expected int
+	}{
+		{args: []string{"select", "xcrochet"}, expected: ExitNoAccess},
+		{args: []string{"health"}, expected: ExitUnavailable},
+		{args: []string{"export", "-format", "jsonl"}, expected: ExitNotFound},
+		{args: []string{"export", "-format", "mp3"}, expected: ExitUsage},
+		{args: []string{"select"}, expected: ExitUsage},
+		{args: []string{"unknown"}, expected: ExitUsage},
+		{args: []string{}, expected: ExitUsage},
+	}
+
+	for _, tt := range tests {
+		err := run(context.Background(), tt.args, &bytes.Buffer{})
+		if got := exitCode(err); got != tt.expected {
+			t.Errorf("run(%s) exit code = %v (%v), expected %v", strings.Join(tt.args, " "), got, err, tt.expected)
+		}
+	}
+}
+
+func TestRunRejectsAnIncompleteKeyPair(t *testing.T) {
+	setupAPI(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		t.Errorf("the request was sent without tls to %s", r.URL.Path)
+	}))
+	previous := *apiCA
+	*apiCA = "ca.pem"
+	t.Cleanup(func() { *apiCA = previous })
+
+	err := run(context.Background(), []string{"health"}, &bytes.Buffer{})
+	if got := exitCode(err); got != ExitUsage {
+		t.Errorf("run(health) exit code = %v (%v), expected %v", got, err, ExitUsage)
+	}
+}
@@ -0,0 +1,58 @@
+package main
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+	"text/tabwriter"
+)
+
+// output modes of the commands, see -output
+const (
+	OutputTable = "table"
+	OutputJSON  = "json"
+	OutputCSV   = "csv"
+)
+
+var outputs = []string{OutputTable, OutputJSON, OutputCSV}
+
+/*
+Result of a command
+
+value is serialized as is in json, header and rows are used by the table and csv outputs
+*/
+type result struct {
+	value  any
+	header []string
+	rows   [][]string
+}
+
+// Write the result to w in the output mode
+func render(w io.Writer, output string, r *result) error {
+	switch output {
+	case OutputJSON:
+		encoder := json.NewEncoder(w)
+		encoder.SetIndent("", "  ")
+		return encoder.Encode(r.value)
+	case OutputCSV:
+		writer := csv.NewWriter(w)
+		if err := writer.Write(r.header); err != nil {
+			return err
+		}
+		if err := writer.WriteAll(r.rows); err != nil {
+			return err
+		}
+		return writer.Error()
+	case OutputTable:
+		writer := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+		fmt.Fprintln(writer, strings.ToUpper(strings.Join(r.header, "\t")))
+		for _, row := range r.rows {
+			fmt.Fprintln(writer, strings.Join(row, "\t"))
+		}
+		return writer.Flush()
+	default:
+		return fmt.Errorf("unsupported output %q, expected one of %s", output, strings.Join(outputs, ", "))
+	}
+}
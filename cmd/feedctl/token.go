@@ -0,0 +1,163 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/xaviercrochet/turbo-octo-adventure/pkg/net"
+	"github.com/zitadel/oidc/v3/pkg/client/rp"
+	"github.com/zitadel/oidc/v3/pkg/oidc"
+)
+
+// tokens are refreshed a bit before they expire, so they don't expire in flight
+const expiryMargin = 30 * time.Second
+
+// Tokens obtained by `feedctl login`, cached in a file only readable by the user
+type token struct {
+	Issuer       string    `json:"issuer"`
+	ClientID     string    `json:"client_id"`
+	AccessToken  string    `json:"access_token"`
+	RefreshToken string    `json:"refresh_token,omitempty"`
+	Expiry       time.Time `json:"expiry,omitempty"`
+}
+
+// Returns true if the access token can still be used at now
+func (t *token) valid(now time.Time) bool {
+	return t.AccessToken != "" && (t.Expiry.IsZero() || now.Add(expiryMargin).Before(t.Expiry))
+}
+
+// Default location of the token cache: <user cache dir>/feedctl/token.json
+func defaultTokenFile() string {
+	dir, err := os.UserCacheDir()
+	if err != nil {
+		return "feedctl-token.json"
+	}
+	return filepath.Join(dir, "feedctl", "token.json")
+}
+
+// Load the cached token, ErrNotAuthenticated if the user never logged in
+func loadToken(path string) (*token, error) {
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, fmt.Errorf("%w: run feedctl login first", net.ErrNotAuthenticated)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read token cache: %w", err)
+	}
+
+	var t token
+	if err := json.Unmarshal(data, &t); err != nil {
+		return nil, fmt.Errorf("failed to deserialize token cache %s: %w", path, err)
+	}
+	return &t, nil
+}
+
+// Write the token to the cache, replacing the file atomically
+func saveToken(path string, t *token) error {
+	data, err := json.MarshalIndent(t, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to serialize token: %w", err)
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0o700); err != nil {
+		return fmt.Errorf("failed to create token cache directory: %w", err)
+	}
+
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0o600); err != nil {
+		return fmt.Errorf("failed to write token cache: %w", err)
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		return fmt.Errorf("failed to write token cache: %w", err)
+	}
+	return nil
+}
+
+/*
+Returns a usable access token from the cache
+
+Expired tokens are refreshed, and the cache updated, if the provider issued a refresh token. ErrNotAuthenticated is
+returned if the user has to log in again
+*/
+func accessToken(ctx context.Context, path string) (string, error) {
+	t, err := loadToken(path)
+	if err != nil {
+		return "", err
+	}
+	if t.valid(time.Now()) {
+		return t.AccessToken, nil
+	}
+	if t.RefreshToken == "" {
+		return "", fmt.Errorf("%w: token expired, run feedctl login", net.ErrNotAuthenticated)
+	}
+
+	relyingParty, err := rp.NewRelyingPartyOIDC(ctx, t.Issuer, t.ClientID, "", "", nil)
+	if err != nil {
+		return "", fmt.Errorf("failed to discover the oidc provider: %w", err)
+	}
+	tokens, err := rp.RefreshTokens[*oidc.IDTokenClaims](ctx, relyingParty, t.RefreshToken, "", "")
+	if err != nil {
+		return "", fmt.Errorf("%w: token refresh failed, run feedctl login: %v", net.ErrNotAuthenticated, err)
+	}
+
+	t.AccessToken = tokens.AccessToken
+	t.Expiry = tokens.Expiry
+	// providers rotating refresh tokens issue a new one on each refresh
+	if tokens.RefreshToken != "" {
+		t.RefreshToken = tokens.RefreshToken
+	}
+	if err := saveToken(path, t); err != nil {
+		return "", err
+	}
+	return t.AccessToken, nil
+}
+
+/*
+Log in with the OAuth 2.0 device authorization grant (RFC 8628)
+
+The user opens the verification url in a browser, possibly on another device, and enters the code printed to w. The
+provider is polled until the user approves or denies the request
+*/
+func login(ctx context.Context, w io.Writer, issuer, clientID string, scopes []string) (*token, error) {
+	relyingParty, err := rp.NewRelyingPartyOIDC(ctx, issuer, clientID, "", "", scopes)
+	if err != nil {
+		return nil, fmt.Errorf("failed to discover the oidc provider: %w", err)
+	}
+
+	authorization, err := rp.DeviceAuthorization(ctx, scopes, relyingParty, nil)
+	if err != nil {
+		return nil, fmt.Errorf("device authorization failed: %w", err)
+	}
+
+	if authorization.VerificationURIComplete != "" {
+		fmt.Fprintf(w, "Open %s in a browser and check that the code is %s\n", authorization.VerificationURIComplete, authorization.UserCode)
+	} else {
+		fmt.Fprintf(w, "Open %s in a browser and enter the code %s\n", authorization.VerificationURI, authorization.UserCode)
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, time.Duration(authorization.ExpiresIn)*time.Second)
+	defer cancel()
+	// RFC 8628 defaults to 5 seconds when the provider doesn't say
+	interval := time.Duration(max(authorization.Interval, 5)) * time.Second
+
+	resp, err := rp.DeviceAccessToken(ctx, authorization.DeviceCode, interval, relyingParty)
+	if err != nil {
+		return nil, fmt.Errorf("%w: device login failed: %v", net.ErrNotAuthenticated, err)
+	}
+
+	t := &token{
+		Issuer:       issuer,
+		ClientID:     clientID,
+		AccessToken:  resp.AccessToken,
+		RefreshToken: resp.RefreshToken,
+	}
+	if resp.ExpiresIn > 0 {
+		t.Expiry = time.Now().Add(time.Duration(resp.ExpiresIn) * time.Second)
+	}
+	return t, nil
+}
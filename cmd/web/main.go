@@ -10,7 +10,13 @@ import (
 	"log/slog"
 	"net/http"
 	"os"
+	"strings"
 
+	"github.com/xaviercrochet/turbo-octo-adventure/pkg/auth"
+	mw "github.com/xaviercrochet/turbo-octo-adventure/pkg/middleware"
+	"github.com/xaviercrochet/turbo-octo-adventure/pkg/mtls"
+	"github.com/xaviercrochet/turbo-octo-adventure/pkg/net"
+	"github.com/xaviercrochet/turbo-octo-adventure/pkg/util"
 	"github.com/xaviercrochet/turbo-octo-adventure/web"
 )
 
@@ -23,28 +29,134 @@ var (
 	clientID    = flag.String("clientID", "", "clientID provided by ZITADEL")
 	redirectURI = flag.String("redirectURI", "", "redirectURI registered at ZITADEL")
 	port        = flag.String("port", "8089", "port to run the server on (default is 8089)")
+	// authentication backend
+	authBackend = flag.String("auth", "zitadel", "authentication backend: zitadel, oidc or dev (local login form, never use in production)")
+	issuer      = flag.String("issuer", "", "oidc: issuer url of the OpenID Connect provider")
+	devKey      = flag.String("devKey", auth.DefaultDevKey, "dev: key used to sign the development tokens, must match the api")
+	devRoles    = flag.String("devRoles", "admin", "dev: comma separated roles pre-filled in the login form")
+	// optional mutual TLS between the webapp and the api
+	apiTLSCert = flag.String("apiTLSCert", "", "path to the client certificate presented to the api, enables https")
+	apiTLSKey  = flag.String("apiTLSKey", "", "path to the private key of the client certificate")
+	apiCA      = flag.String("apiCA", "", "path to the CA used to verify the api certificate (system roots if empty)")
+	// url of the api in the subscription urls
+	publicAPIURL = flag.String("publicApiURL", "", "url at which feed readers reach the api (the url used by the webapp if empty)")
+	// responses of the api larger than this are rejected
+	maxResponseSize = flag.Int64("maxResponseSize", net.DefaultMaxBodySize, "maximum size of the responses of the api, in bytes")
+	// logs
+	logFormat           = flag.String("logFormat", "text", "format of the logs: text or json")
+	logLevel            = flag.String("logLevel", "info", "minimum level of the logs: debug, info, warn or error")
+	logFile             = flag.String("logFile", "", "file the logs are written to (stdout if empty)")
+	logMaxSize          = flag.Int64("logMaxSize", 100<<20, "size in bytes at which the log file is rotated (never if 0)")
+	logMaxBackups       = flag.Int("logMaxBackups", 5, "number of rotated log files kept")
+	logSampleFirst      = flag.Int("logSampleFirst", 0, "info and debug logs with the same message are sampled after this many per second (no sampling if 0)")
+	logSampleThereafter = flag.Int("logSampleThereafter", 100, "once sampled, one log out of this many is kept")
+	logRedactKeys       = flag.String("logRedactKeys", strings.Join(util.DefaultRedactKeys, ","), "comma separated attributes masked in the logs")
+	logHashKeys         = flag.String("logHashKeys", strings.Join(util.DefaultHashKeys, ","), "comma separated attributes hashed in the logs, i.e. user identifiers")
+	logHashSecret       = flag.String("logHashSecret", "", "key of the HMAC hashing the attributes, random if empty so the hashes change on restart")
+	// access log
+	accessLogFormat = flag.String("accessLogFormat", "structured", "format of the access log: structured (with the other logs), common or combined (on stdout)")
+	trustedProxies  = flag.String("trustedProxies", "", "comma separated addresses or networks of the reverse proxies whose X-Forwarded-For and Forwarded headers are trusted")
+	// security headers
+	csp = flag.String("csp", mw.DefaultCSP, "Content-Security-Policy of the pages, "+mw.CSPNoncePlaceholder+" is replaced by a per request nonce")
 )
 
 func main() {
 	flag.Parse()
 	ctx := context.Background()
 
+	logConfig, err := util.NewLogConfig(*logFormat, *logLevel, *logFile, *logMaxSize, *logMaxBackups, *logSampleFirst, *logSampleThereafter)
+	if err != nil {
+		slog.Error("invalid log configuration", "error", err)
+		os.Exit(1)
+	}
+	logConfig.Redact, err = util.NewRedactConfig(*logRedactKeys, *logHashKeys, *logHashSecret)
+	if err != nil {
+		slog.Error("invalid log configuration", "error", err)
+		os.Exit(1)
+	}
+	logger, logCloser, err := util.NewLoggerWithConfig(logConfig)
+	if err != nil {
+		slog.Error("could not create logger", "error", err)
+		os.Exit(1)
+	}
+	defer logCloser.Close()
+	// deferred calls don't run on os.Exit, the log file is closed before exiting
+	exit := func(code int) {
+		logCloser.Close()
+		os.Exit(code)
+	}
+	util.SetDefaultLogger(logger)
+	if *logHashSecret == "" && len(logConfig.Redact.HashKeys) > 0 {
+		slog.Warn("no log hash secret configured, the hashes of the logs will change on restart")
+	}
+
+	format, err := mw.ParseAccessLogFormat(*accessLogFormat)
+	if err != nil {
+		slog.Error("invalid access log format", "error", err)
+		exit(1)
+	}
+	proxies, err := mw.ParseTrustedProxies(*trustedProxies)
+	if err != nil {
+		slog.Error("invalid trusted proxies", "error", err)
+		exit(1)
+	}
+	accessLog := &mw.AccessLog{Format: format, TrustedProxies: proxies}
+
 	base64Key, err := base64.StdEncoding.DecodeString(*key)
 	if err != nil {
 		slog.Error("unable to decode aes key", "error", err)
-		os.Exit(1)
+		exit(1)
 	}
 
 	if err != nil {
 		slog.Error("zitadel sdk could not initialize", "error", err)
-		os.Exit(1)
+		exit(1)
+	}
+
+	backend, err := auth.ParseBackend(*authBackend)
+	if err != nil {
+		slog.Error("invalid auth backend", "error", err)
+		exit(1)
+	}
+	if backend == auth.BackendDev {
+		slog.Warn("development auth backend enabled, anyone can log in with any role. Never use in production")
+	}
+
+	authConfig := auth.NewZitadelConfig(*domain, "")
+	authConfig.Backend = backend
+	authConfig.Issuer = *issuer
+	authConfig.DevKey = *devKey
+	authConfig.DevRoles = auth.SplitList(*devRoles)
+
+	securityHeaders := mw.DefaultSecurityHeaders()
+	securityHeaders.CSP = *csp
+
+	webOptions := []web.Option{
+		web.WithAuthConfig(authConfig),
+		web.WithSecurityHeaders(securityHeaders),
+		web.WithPublicAPIURL(*publicAPIURL),
+		web.WithMaxResponseSize(*maxResponseSize),
+		web.WithAccessLog(accessLog),
+	}
+	tlsConfig := mtls.NewConfig(*apiTLSCert, *apiTLSKey, *apiCA)
+	if err := tlsConfig.Validate(); err != nil {
+		slog.Error("invalid api tls configuration, -apiTLSCert and -apiTLSKey are required", "error", err)
+		exit(1)
+	}
+	if tlsConfig.Enabled() {
+		clientTLSConfig, err := mtls.ClientTLSConfig(ctx, tlsConfig)
+		if err != nil {
+			slog.Error("could not load api client certificate", "error", err)
+			exit(1)
+		}
+		webOptions = append(webOptions, web.WithAPITLSConfig(clientTLSConfig))
 	}
 
 	router := http.NewServeMux()
-	options := web.NewServerOptions(base64Key, *apiHostname, *apiPort, *domain, *clientID, *redirectURI)
+	options := web.NewServerOptions(base64Key, *apiHostname, *apiPort, *domain, *clientID, *redirectURI, webOptions...)
 	if err := web.SetupRoutes(ctx, router, options); err != nil {
 		slog.Error("could not setup routes", "error", err)
-		os.Exit(1)
+		exit(1)
 
 	}
 
@@ -53,6 +165,6 @@ func main() {
 	err = http.ListenAndServe(lis, router)
 	if !errors.Is(err, http.ErrServerClosed) {
 		slog.Error("server terminated", "error", err)
-		os.Exit(1)
+		exit(1)
 	}
 }
@@ -0,0 +1,142 @@
+package e2e
+
+import (
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"testing"
+)
+
+func admin(orgID string) *User {
+	return &User{ID: "admin-" + orgID, Username: "ada", GivenName: "Ada", FamilyName: "Lovelace", Email: "ada@example.com", OrgID: orgID, Roles: []string{"admin"}}
+}
+
+func reader(orgID string) *User {
+	return &User{ID: "reader-" + orgID, Username: "rob", GivenName: "Rob", FamilyName: "Reader", Email: "rob@example.com", OrgID: orgID}
+}
+
+func TestHomeRedirectsLoggedInUsers(t *testing.T) {
+	h := New(t)
+	browser := h.Browser(t)
+
+	response := browser.Get("/")
+	if response.StatusCode != http.StatusOK {
+		t.Fatalf("anonymous users should get the home page, got %d", response.StatusCode)
+	}
+
+	// the feed is only for logged in users
+	response = browser.Get("/feed")
+	if response.StatusCode < 300 || response.StatusCode >= 400 {
+		t.Fatalf("anonymous users should be sent to the login, got %d", response.StatusCode)
+	}
+
+	browser.Login(reader("org-1"))
+
+	response = browser.Get("/")
+	if response.StatusCode != http.StatusFound || response.Location() != "/feed" {
+		t.Fatalf("logged in users should be sent to /feed, got %d to %q", response.StatusCode, response.Location())
+	}
+}
+
+func TestFeedRendersTheListens(t *testing.T) {
+	h := New(t)
+	h.ListenBrainz.Listen("xcrochet", "Harder Better Faster Stronger", "Around The World")
+	browser := h.Browser(t)
+
+	response := browser.Login(reader("org-1"))
+	if response.URL.Path != "/feed" {
+		t.Fatalf("the login should land on /feed, got %s", response.URL.Path)
+	}
+	for _, title := range []string{"Harder Better Faster Stronger", "Around The World", "Rob"} {
+		if !strings.Contains(response.Body, title) {
+			t.Errorf("feed page should contain %q", title)
+		}
+	}
+	// only admins can select the feed
+	if strings.Contains(response.Body, `action="/select_feed"`) {
+		t.Error("readers should not get the select form")
+	}
+}
+
+func TestAdminSelectsTheFeed(t *testing.T) {
+	h := New(t)
+	h.ListenBrainz.Listen("xcrochet", "Around The World")
+	h.ListenBrainz.Listen("rjmunro", "Windowlicker")
+	browser := h.Browser(t)
+
+	response := browser.Login(admin("org-1"))
+	if !strings.Contains(response.Body, `action="/select_feed"`) {
+		t.Fatal("admins should get the select form")
+	}
+
+	response = browser.PostForm("/select_feed", url.Values{"name": {"rjmunro"}})
+	if response.StatusCode != http.StatusSeeOther || response.Location() != "/feed" {
+		t.Fatalf("selecting a feed should redirect to /feed, got %d to %q: %s", response.StatusCode, response.Location(), response.Body)
+	}
+
+	response = browser.Follow("/feed")
+	if !strings.Contains(response.Body, "Windowlicker") || strings.Contains(response.Body, "Around The World") {
+		t.Errorf("the feed of rjmunro should be displayed:\n%s", response.Body)
+	}
+
+	// the other organisations keep their own selection
+	other := h.Browser(t)
+	response = other.Login(reader("org-2"))
+	if !strings.Contains(response.Body, "Around The World") || strings.Contains(response.Body, "Windowlicker") {
+		t.Errorf("org-2 should still read the feed of xcrochet:\n%s", response.Body)
+	}
+}
+
+func TestReadersCantSelectTheFeed(t *testing.T) {
+	h := New(t)
+	h.ListenBrainz.Listen("xcrochet", "Around The World")
+	h.ListenBrainz.Listen("rjmunro", "Windowlicker")
+	browser := h.Browser(t)
+	browser.Login(reader("org-1"))
+
+	response := browser.PostForm("/select_feed", url.Values{"name": {"rjmunro"}})
+	if response.StatusCode != http.StatusForbidden {
+		t.Fatalf("readers should be forbidden to select the feed, got %d: %s", response.StatusCode, response.Body)
+	}
+
+	response = browser.Follow("/feed")
+	if !strings.Contains(response.Body, "Around The World") {
+		t.Errorf("the feed should not have changed:\n%s", response.Body)
+	}
+}
+
+// run with -race, the handlers must not share state between requests
+func TestPagesAreServedConcurrently(t *testing.T) {
+	h := New(t)
+	h.ListenBrainz.Listen("xcrochet", "Around The World")
+
+	browsers := make([]*Browser, 4)
+	for i := range browsers {
+		browsers[i] = h.Browser(t)
+		browsers[i].Login(reader("org-1"))
+	}
+
+	var wg sync.WaitGroup
+	for _, browser := range browsers {
+		// a browser isn't safe for concurrent use, each one loads its pages in turn
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for _, path := range []string{"/feed", "/settings"} {
+				if response := browser.Get(path); response.StatusCode != http.StatusOK {
+					t.Errorf("%s: got %d", path, response.StatusCode)
+				}
+			}
+		}()
+		// anonymous users get the home page
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if response := h.Browser(t).Get("/"); response.StatusCode != http.StatusOK {
+				t.Errorf("/: got %d", response.StatusCode)
+			}
+		}()
+	}
+	wg.Wait()
+}
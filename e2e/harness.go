@@ -0,0 +1,252 @@
+/*
+Package e2e runs the api and the web app in-process, against a fake OpenID Connect provider and a fake ListenBrainz, so
+tests can drive the app like a browser does
+
+	h := e2e.New(t)
+	h.ListenBrainz.Listen("xcrochet", "One More Time")
+	browser := h.Browser(t)
+	browser.Login(&e2e.User{ID: "1", OrgID: "org-1", Roles: []string{"admin"}})
+	page := browser.Get("/feed")
+*/
+package e2e
+
+import (
+	"context"
+	"crypto/rand"
+	"io"
+	"net"
+	"net/http"
+	"net/http/cookiejar"
+	"net/http/httptest"
+	"net/url"
+	"regexp"
+	"strings"
+	"testing"
+
+	"github.com/xaviercrochet/turbo-octo-adventure/api/app"
+	"github.com/xaviercrochet/turbo-octo-adventure/api/musicbrainz"
+	"github.com/xaviercrochet/turbo-octo-adventure/pkg/auth"
+	"github.com/xaviercrochet/turbo-octo-adventure/web"
+)
+
+// OAuth client of the web app at the fake provider
+const ClientID = "feed-web"
+
+// maximum number of redirects followed by Browser.Follow
+const maxRedirects = 10
+
+// The running app and the fakes it depends on, everything is stopped at the end of the test
+type Harness struct {
+	OIDC         *FakeOIDC
+	ListenBrainz *FakeListenBrainz
+	API          *httptest.Server
+	// served over tls, the session cookie is always secure
+	Web *httptest.Server
+}
+
+// Start the fakes, the api and the web app
+func New(t testing.TB) *Harness {
+	t.Helper()
+
+	ctx, cancel := context.WithCancel(context.Background())
+
+	oidcServer, err := NewFakeOIDC()
+	if err != nil {
+		t.Fatal(err)
+	}
+	listenBrainz := NewFakeListenBrainz()
+	h := &Harness{OIDC: oidcServer, ListenBrainz: listenBrainz}
+
+	// the last cleanup runs first: stop the background work before the servers
+	t.Cleanup(oidcServer.Close)
+	t.Cleanup(listenBrainz.Close)
+	t.Cleanup(cancel)
+
+	authConfig := &auth.Config{
+		Backend:    auth.BackendOIDC,
+		Issuer:     oidcServer.URL,
+		Audience:   ClientID,
+		RolesClaim: auth.ZitadelRolesClaim,
+		OrgClaim:   auth.ZitadelOrgClaim,
+	}
+
+	// the servers are started before their routes are set up, the web app needs to know both addresses
+	apiRouter := http.NewServeMux()
+	h.API = httptest.NewServer(apiRouter)
+	t.Cleanup(h.API.Close)
+	apiHost, apiPort, err := net.SplitHostPort(h.API.Listener.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = app.SetupRoutes(ctx, apiRouter, app.NewServerOptions("localhost", "", apiPort,
+		app.WithAuthConfig(authConfig),
+		app.WithMusicBrainzClient(musicbrainz.NewClient(musicbrainz.WithBaseURL(listenBrainz.URL))),
+	))
+	if err != nil {
+		t.Fatalf("failed to setup the api: %v", err)
+	}
+
+	webRouter := http.NewServeMux()
+	h.Web = httptest.NewTLSServer(webRouter)
+	t.Cleanup(h.Web.Close)
+
+	err = web.SetupRoutes(ctx, webRouter, web.NewServerOptions(newKey(t), apiHost, apiPort, "localhost", ClientID,
+		h.Web.URL+"/auth/callback", web.WithAuthConfig(authConfig)))
+	if err != nil {
+		t.Fatalf("failed to setup the web app: %v", err)
+	}
+
+	return h
+}
+
+// 32 bytes key encrypting the cookies of the web app
+func newKey(t testing.TB) []byte {
+	key := make([]byte, 32)
+	if _, err := rand.Read(key); err != nil {
+		t.Fatal(err)
+	}
+	return key
+}
+
+// A response, read entirely
+type Response struct {
+	StatusCode int
+	Header     http.Header
+	Body       string
+	// url of the request
+	URL *url.URL
+}
+
+// Returns the path of the Location header, "" if there's none
+func (r *Response) Location() string {
+	location, err := r.URL.Parse(r.Header.Get("Location"))
+	if err != nil || r.Header.Get("Location") == "" {
+		return ""
+	}
+	return location.RequestURI()
+}
+
+var csrfTokenPattern = regexp.MustCompile(`name="csrf_token" value="([^"]+)"`)
+
+/*
+Browser of the web app, with its own cookies
+
+Redirects are not followed unless asked, so tests can assert them
+*/
+type Browser struct {
+	t      testing.TB
+	h      *Harness
+	client *http.Client
+
+	// token of the last page with a form, sent back by PostForm
+	csrfToken string
+}
+
+// Returns a new browser, nobody is logged in
+func (h *Harness) Browser(t testing.TB) *Browser {
+	t.Helper()
+
+	jar, err := cookiejar.New(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return &Browser{
+		t: t,
+		h: h,
+		client: &http.Client{
+			// trusts the certificate of the web app
+			Transport: h.Web.Client().Transport,
+			Jar:       jar,
+			CheckRedirect: func(*http.Request, []*http.Request) error {
+				return http.ErrUseLastResponse
+			},
+		},
+	}
+}
+
+/*
+Log in as the user through the web app and the fake provider, the user is registered at the provider if needed
+
+Fails the test unless the browser lands on a page of the app
+*/
+func (b *Browser) Login(user *User) *Response {
+	b.t.Helper()
+
+	b.h.OIDC.AddUser(user)
+	// tells the fake provider who logs in, only this browser sends it
+	providerURL, _ := url.Parse(b.h.OIDC.URL)
+	b.client.Jar.SetCookies(providerURL, []*http.Cookie{{Name: userCookie, Value: user.ID, Path: "/"}})
+
+	response := b.Follow("/auth/login")
+	if response.StatusCode != http.StatusOK || response.URL.Host != strings.TrimPrefix(b.h.Web.URL, "https://") {
+		b.t.Fatalf("login of %s failed: %d on %s\n%s", user.ID, response.StatusCode, response.URL, response.Body)
+	}
+	return response
+}
+
+// GET a page of the web app
+func (b *Browser) Get(path string) *Response {
+	b.t.Helper()
+	return b.do(http.MethodGet, b.h.Web.URL+path, nil)
+}
+
+// GET a page of the web app and follow the redirects
+func (b *Browser) Follow(path string) *Response {
+	b.t.Helper()
+
+	response := b.Get(path)
+	for range maxRedirects {
+		location := response.Header.Get("Location")
+		if response.StatusCode < 300 || response.StatusCode >= 400 || location == "" {
+			return response
+		}
+		next, err := response.URL.Parse(location)
+		if err != nil {
+			b.t.Fatalf("invalid redirect %q: %v", location, err)
+		}
+		response = b.do(http.MethodGet, next.String(), nil)
+	}
+	b.t.Fatalf("too many redirects from %s", path)
+	return nil
+}
+
+// Submit a form of the web app, with the csrf token of the last page if the form doesn't have one
+func (b *Browser) PostForm(path string, values url.Values) *Response {
+	b.t.Helper()
+
+	if !values.Has("csrf_token") {
+		values.Set("csrf_token", b.csrfToken)
+	}
+	return b.do(http.MethodPost, b.h.Web.URL+path, strings.NewReader(values.Encode()))
+}
+
+func (b *Browser) do(method, target string, body io.Reader) *Response {
+	b.t.Helper()
+
+	req, err := http.NewRequest(method, target, body)
+	if err != nil {
+		b.t.Fatal(err)
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	}
+
+	res, err := b.client.Do(req)
+	if err != nil {
+		b.t.Fatalf("%s %s failed: %v", method, target, err)
+	}
+	defer res.Body.Close()
+
+	data, err := io.ReadAll(res.Body)
+	if err != nil {
+		b.t.Fatalf("failed to read the response of %s %s: %v", method, target, err)
+	}
+
+	if match := csrfTokenPattern.FindSubmatch(data); match != nil {
+		b.csrfToken = string(match[1])
+	}
+
+	return &Response{StatusCode: res.StatusCode, Header: res.Header, Body: string(data), URL: req.URL}
+}
@@ -0,0 +1,78 @@
+package e2e
+
+import (
+	"encoding/xml"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"time"
+
+	"github.com/xaviercrochet/turbo-octo-adventure/api/musicbrainz"
+)
+
+/*
+FakeListenBrainz serves the syndication feeds of the listens the tests script
+
+Users without listens are unknown, their feed is a 404 like on ListenBrainz
+*/
+type FakeListenBrainz struct {
+	*httptest.Server
+
+	mu      sync.Mutex
+	listens map[string][]musicbrainz.Entry
+}
+
+func NewFakeListenBrainz() *FakeListenBrainz {
+	l := &FakeListenBrainz{listens: map[string][]musicbrainz.Entry{}}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /syndication-feed/user/{username}/listens", l.feed)
+	l.Server = httptest.NewServer(mux)
+
+	return l
+}
+
+// Add listens of the songs to the feed of the user, the last song is the most recent listen
+func (l *FakeListenBrainz) Listen(username string, titles ...string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := time.Now().UTC().Truncate(time.Second)
+	for i, title := range titles {
+		listenedAt := now.Add(time.Duration(i-len(titles)) * time.Minute)
+		entry := musicbrainz.Entry{
+			ID:        fmt.Sprintf("%s/syndication-feed/user/%s/listens/%d/%d", l.URL, username, listenedAt.Unix(), len(l.listens[username])),
+			Title:     title,
+			Published: listenedAt,
+			Updated:   listenedAt,
+			Content:   musicbrainz.Content{Type: "html", Text: title},
+		}
+		// most recent first, like ListenBrainz
+		l.listens[username] = append([]musicbrainz.Entry{entry}, l.listens[username]...)
+	}
+}
+
+func (l *FakeListenBrainz) feed(w http.ResponseWriter, r *http.Request) {
+	username := r.PathValue("username")
+
+	l.mu.Lock()
+	entries, ok := l.listens[username]
+	l.mu.Unlock()
+	if !ok {
+		http.Error(w, "user not found", http.StatusNotFound)
+		return
+	}
+
+	feed := musicbrainz.FeedXml{
+		Title:   "Listens for " + username,
+		ID:      l.URL + r.URL.Path,
+		Updated: time.Now().UTC().Format(time.RFC3339),
+		Author:  musicbrainz.Author{Name: "ListenBrainz"},
+		Entries: entries,
+	}
+
+	w.Header().Set("Content-Type", "application/atom+xml; charset=utf-8")
+	w.Write([]byte(xml.Header))
+	xml.NewEncoder(w).Encode(&feed)
+}
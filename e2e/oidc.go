@@ -0,0 +1,350 @@
+package e2e
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	jose "github.com/go-jose/go-jose/v4"
+	"github.com/xaviercrochet/turbo-octo-adventure/pkg/auth"
+	"github.com/zitadel/oidc/v3/pkg/oidc"
+)
+
+// cookie of the fake provider telling which user the browser logs in as, see Browser.Login
+const userCookie = "fake_oidc_user"
+
+// lifetime of the tokens issued by the fake provider
+const tokenLifetime = time.Hour
+
+// A user the fake provider can log in
+type User struct {
+	ID         string
+	Username   string
+	GivenName  string
+	FamilyName string
+	Email      string
+	// ZITADEL organisation of the user
+	OrgID string
+	// project roles, granted in the organisation of the user
+	Roles []string
+}
+
+// login in progress, between the authorization and the token request
+type grant struct {
+	user        *User
+	clientID    string
+	redirectURI string
+	challenge   string
+	nonce       string
+}
+
+/*
+FakeOIDC is an OpenID Connect provider issuing tokens shaped like the ones of ZITADEL, for the users the tests script
+
+It supports the discovery, the authorization code flow with PKCE, the refresh token grant and the userinfo endpoint.
+There is no login page: the authorization endpoint logs the browser in as the user named by its cookie
+*/
+type FakeOIDC struct {
+	*httptest.Server
+
+	key *rsa.PrivateKey
+
+	mu    sync.Mutex
+	users map[string]*User
+	// pending logins by code
+	grants map[string]*grant
+	// users by refresh token
+	refreshTokens map[string]*User
+	// users by access token, for the userinfo endpoint
+	accessTokens map[string]*User
+}
+
+func NewFakeOIDC() (*FakeOIDC, error) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		return nil, fmt.Errorf("failed to generate signing key: %w", err)
+	}
+
+	o := &FakeOIDC{
+		key:           key,
+		users:         map[string]*User{},
+		grants:        map[string]*grant{},
+		refreshTokens: map[string]*User{},
+		accessTokens:  map[string]*User{},
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/.well-known/openid-configuration", o.discovery)
+	mux.HandleFunc("/keys", o.keys)
+	mux.HandleFunc("/authorize", o.authorize)
+	mux.HandleFunc("/oauth/token", o.token)
+	mux.HandleFunc("/userinfo", o.userinfo)
+	o.Server = httptest.NewServer(mux)
+
+	return o, nil
+}
+
+// Register the user, so browsers can log in as them
+func (o *FakeOIDC) AddUser(user *User) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	o.users[user.ID] = user
+}
+
+/*
+Returns an access token of the user for the client, as the api receives it
+
+Useful to call the api directly, without going through the web app
+*/
+func (o *FakeOIDC) AccessToken(user *User, clientID string) (string, error) {
+	token, err := o.sign(o.claims(user, clientID, ""))
+	if err != nil {
+		return "", err
+	}
+
+	o.mu.Lock()
+	o.accessTokens[token] = user
+	o.mu.Unlock()
+	return token, nil
+}
+
+func (o *FakeOIDC) discovery(w http.ResponseWriter, _ *http.Request) {
+	writeJSON(w, &oidc.DiscoveryConfiguration{
+		Issuer:                            o.URL,
+		AuthorizationEndpoint:             o.URL + "/authorize",
+		TokenEndpoint:                     o.URL + "/oauth/token",
+		UserinfoEndpoint:                  o.URL + "/userinfo",
+		JwksURI:                           o.URL + "/keys",
+		ScopesSupported:                   []string{oidc.ScopeOpenID, oidc.ScopeProfile, oidc.ScopeEmail, oidc.ScopeOfflineAccess},
+		ResponseTypesSupported:            []string{string(oidc.ResponseTypeCode)},
+		GrantTypesSupported:               []oidc.GrantType{oidc.GrantTypeCode, oidc.GrantTypeRefreshToken},
+		SubjectTypesSupported:             []string{"public"},
+		IDTokenSigningAlgValuesSupported:  []string{string(jose.RS256)},
+		CodeChallengeMethodsSupported:     []oidc.CodeChallengeMethod{oidc.CodeChallengeMethodS256},
+		TokenEndpointAuthMethodsSupported: []oidc.AuthMethod{oidc.AuthMethodNone},
+	})
+}
+
+func (o *FakeOIDC) keys(w http.ResponseWriter, _ *http.Request) {
+	writeJSON(w, &jose.JSONWebKeySet{Keys: []jose.JSONWebKey{
+		{Key: &o.key.PublicKey, KeyID: "fake-key", Algorithm: string(jose.RS256), Use: oidc.KeyUseSignature},
+	}})
+}
+
+// Logs the browser in as the user of its cookie and sends it back to the client with a code
+func (o *FakeOIDC) authorize(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+
+	cookie, err := r.Cookie(userCookie)
+	if err != nil {
+		http.Error(w, "no user scripted for this browser", http.StatusUnauthorized)
+		return
+	}
+
+	o.mu.Lock()
+	user, ok := o.users[cookie.Value]
+	o.mu.Unlock()
+	if !ok {
+		http.Error(w, "unknown user", http.StatusUnauthorized)
+		return
+	}
+
+	redirectURI, err := url.Parse(query.Get("redirect_uri"))
+	if err != nil || query.Get("response_type") != string(oidc.ResponseTypeCode) {
+		http.Error(w, "invalid authorization request", http.StatusBadRequest)
+		return
+	}
+	if query.Get("code_challenge") != "" && query.Get("code_challenge_method") != string(oidc.CodeChallengeMethodS256) {
+		http.Error(w, "unsupported code challenge method", http.StatusBadRequest)
+		return
+	}
+
+	code := randomString()
+	o.mu.Lock()
+	o.grants[code] = &grant{
+		user:        user,
+		clientID:    query.Get("client_id"),
+		redirectURI: redirectURI.String(),
+		challenge:   query.Get("code_challenge"),
+		nonce:       query.Get("nonce"),
+	}
+	o.mu.Unlock()
+
+	callback := redirectURI.Query()
+	callback.Set("code", code)
+	callback.Set("state", query.Get("state"))
+	redirectURI.RawQuery = callback.Encode()
+	http.Redirect(w, r, redirectURI.String(), http.StatusFound)
+}
+
+// Exchanges a code or a refresh token for tokens
+func (o *FakeOIDC) token(w http.ResponseWriter, r *http.Request) {
+	if err := r.ParseForm(); err != nil {
+		tokenError(w, oidc.ErrInvalidRequest())
+		return
+	}
+	clientID := r.PostForm.Get("client_id")
+	if id, _, ok := r.BasicAuth(); ok {
+		clientID, _ = url.QueryUnescape(id)
+	}
+
+	var (
+		user  *User
+		nonce string
+	)
+	switch oidc.GrantType(r.PostForm.Get("grant_type")) {
+	case oidc.GrantTypeCode:
+		o.mu.Lock()
+		g, ok := o.grants[r.PostForm.Get("code")]
+		delete(o.grants, r.PostForm.Get("code"))
+		o.mu.Unlock()
+
+		if !ok || g.clientID != clientID || g.redirectURI != r.PostForm.Get("redirect_uri") {
+			tokenError(w, oidc.ErrInvalidGrant())
+			return
+		}
+		if g.challenge != "" && !verifyChallenge(g.challenge, r.PostForm.Get("code_verifier")) {
+			tokenError(w, oidc.ErrInvalidGrant().WithDescription("invalid code verifier"))
+			return
+		}
+		user, nonce = g.user, g.nonce
+
+	case oidc.GrantTypeRefreshToken:
+		o.mu.Lock()
+		user = o.refreshTokens[r.PostForm.Get("refresh_token")]
+		o.mu.Unlock()
+		if user == nil {
+			tokenError(w, oidc.ErrInvalidGrant())
+			return
+		}
+
+	default:
+		tokenError(w, oidc.ErrUnsupportedGrantType())
+		return
+	}
+
+	accessToken, err := o.AccessToken(user, clientID)
+	if err != nil {
+		tokenError(w, oidc.ErrServerError().WithParent(err))
+		return
+	}
+	idToken, err := o.sign(o.claims(user, clientID, nonce))
+	if err != nil {
+		tokenError(w, oidc.ErrServerError().WithParent(err))
+		return
+	}
+	refreshToken := randomString()
+	o.mu.Lock()
+	o.refreshTokens[refreshToken] = user
+	o.mu.Unlock()
+
+	writeJSON(w, &oidc.AccessTokenResponse{
+		AccessToken:  accessToken,
+		TokenType:    oidc.BearerToken,
+		RefreshToken: refreshToken,
+		ExpiresIn:    uint64(tokenLifetime.Seconds()),
+		IDToken:      idToken,
+	})
+}
+
+func (o *FakeOIDC) userinfo(w http.ResponseWriter, r *http.Request) {
+	token, _ := strings.CutPrefix(r.Header.Get("Authorization"), oidc.PrefixBearer)
+
+	o.mu.Lock()
+	user, ok := o.accessTokens[token]
+	o.mu.Unlock()
+	if !ok {
+		http.Error(w, "invalid access token", http.StatusUnauthorized)
+		return
+	}
+
+	writeJSON(w, &oidc.UserInfo{
+		Subject: user.ID,
+		UserInfoProfile: oidc.UserInfoProfile{
+			Name:              strings.TrimSpace(user.GivenName + " " + user.FamilyName),
+			GivenName:         user.GivenName,
+			FamilyName:        user.FamilyName,
+			PreferredUsername: user.Username,
+		},
+		UserInfoEmail: oidc.UserInfoEmail{Email: user.Email},
+	})
+}
+
+// claims of the access and id tokens, the same as ZITADEL: roles and organisation in the urn:zitadel claims
+func (o *FakeOIDC) claims(user *User, clientID, nonce string) map[string]any {
+	now := time.Now()
+
+	roles := map[string]any{}
+	for _, role := range user.Roles {
+		roles[role] = map[string]any{user.OrgID: user.OrgID}
+	}
+
+	claims := map[string]any{
+		"iss":                  o.URL,
+		"sub":                  user.ID,
+		"aud":                  []string{clientID},
+		"client_id":            clientID,
+		"exp":                  now.Add(tokenLifetime).Unix(),
+		"iat":                  now.Unix(),
+		"jti":                  randomString(),
+		"preferred_username":   user.Username,
+		"given_name":           user.GivenName,
+		"family_name":          user.FamilyName,
+		"email":                user.Email,
+		auth.ZitadelOrgClaim:   user.OrgID,
+		auth.ZitadelRolesClaim: roles,
+	}
+	if nonce != "" {
+		claims["nonce"] = nonce
+	}
+	return claims
+}
+
+func (o *FakeOIDC) sign(claims map[string]any) (string, error) {
+	signer, err := jose.NewSigner(jose.SigningKey{Algorithm: jose.RS256, Key: o.key}, (&jose.SignerOptions{}).WithType("JWT").WithHeader("kid", "fake-key"))
+	if err != nil {
+		return "", fmt.Errorf("failed to create signer: %w", err)
+	}
+	payload, err := json.Marshal(claims)
+	if err != nil {
+		return "", fmt.Errorf("failed to serialize claims: %w", err)
+	}
+	signed, err := signer.Sign(payload)
+	if err != nil {
+		return "", fmt.Errorf("failed to sign token: %w", err)
+	}
+	return signed.CompactSerialize()
+}
+
+// S256 code challenge: base64url(sha256(verifier))
+func verifyChallenge(challenge, verifier string) bool {
+	sum := sha256.Sum256([]byte(verifier))
+	return base64.RawURLEncoding.EncodeToString(sum[:]) == challenge
+}
+
+func tokenError(w http.ResponseWriter, err *oidc.Error) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusBadRequest)
+	json.NewEncoder(w).Encode(err)
+}
+
+func writeJSON(w http.ResponseWriter, value any) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(value)
+}
+
+func randomString() string {
+	data := make([]byte, 16)
+	rand.Read(data)
+	return hex.EncodeToString(data)
+}
@@ -0,0 +1,247 @@
+package auth
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"time"
+
+	"github.com/zitadel/oidc/v3/pkg/client/rp"
+	httphelper "github.com/zitadel/oidc/v3/pkg/http"
+	"github.com/zitadel/oidc/v3/pkg/oidc"
+	"github.com/zitadel/zitadel-go/v3/pkg/authentication"
+	openid "github.com/zitadel/zitadel-go/v3/pkg/authentication/oidc"
+	"github.com/zitadel/zitadel-go/v3/pkg/authorization"
+	"github.com/zitadel/zitadel-go/v3/pkg/zitadel"
+)
+
+// Identity provider used to authenticate users (web) and authorize requests (api)
+type Backend string
+
+const (
+	// ZITADEL instance, access tokens are introspected
+	BackendZitadel Backend = "zitadel"
+	// any OpenID Connect provider issuing JWT access tokens, verified against the provider's JWKS
+	BackendOIDC Backend = "oidc"
+	// built-in development issuer, nothing leaves the machine. Never use in production
+	BackendDev Backend = "dev"
+)
+
+func ParseBackend(value string) (Backend, error) {
+	backend := Backend(value)
+	switch backend {
+	case BackendZitadel, BackendOIDC, BackendDev:
+		return backend, nil
+	default:
+		return "", fmt.Errorf("unknown auth backend %q, expected one of zitadel, oidc, dev", value)
+	}
+}
+
+// Configuration shared by the api and the webapp, only the fields of the selected backend are used
+type Config struct {
+	Backend Backend
+
+	// zitadel: instance domain
+	Domain string
+	// zitadel: path to the key.json used to call the introspection endpoint (api only)
+	KeyFile string
+
+	// oidc: issuer url, the provider must support discovery
+	Issuer string
+	// oidc: access tokens must contain this audience, required
+	Audience string
+	// oidc: claim holding the roles of the user, either a list of roles or a ZITADEL style map
+	RolesClaim string
+	// oidc: claim holding the organisation of the user
+	OrgClaim string
+	// oidc: organisation of the users whose token doesn't have OrgClaim, i.e. providers without organisations
+	DefaultOrg string
+
+	// dev: secret used to sign and verify the development tokens
+	DevKey string
+	// dev: roles pre-filled in the development login form
+	DevRoles []string
+}
+
+// Default configuration, authentication and authorization are delegated to the given ZITADEL instance
+func NewZitadelConfig(domain, keyFile string) *Config {
+	return &Config{
+		Backend:    BackendZitadel,
+		Domain:     domain,
+		KeyFile:    keyFile,
+		RolesClaim: ZitadelRolesClaim,
+		OrgClaim:   ZitadelOrgClaim,
+		DevKey:     DefaultDevKey,
+		DevRoles:   []string{"admin"},
+	}
+}
+
+/*
+Setup the authorization of the api for the configured backend
+
+The returned authorizer can be used with the zitadel http middleware, the authorization context is always a *Context
+*/
+func NewAuthorizer(ctx context.Context, config *Config, options ...AuthorizerOption) (*authorization.Authorizer[*Context], error) {
+	o := &authorizerOptions{}
+	for _, option := range options {
+		option(o)
+	}
+
+	var verifier authorization.VerifierInitializer[*Context]
+	switch config.Backend {
+	case BackendZitadel:
+		verifier = zitadelVerifier(config.KeyFile)
+	case BackendOIDC:
+		verifier = oidcVerifier(config)
+	case BackendDev:
+		verifier = devVerifier(NewDevIssuer(config.DevKey))
+	default:
+		return nil, fmt.Errorf("unknown auth backend %q", config.Backend)
+	}
+
+	// the zitadel instance is only used by the zitadel backend, the other backends ignore it
+	authZ, err := authorization.New(ctx, zitadel.New(config.Domain), callerVerifier(verifier, o.apiKeys))
+	if err != nil {
+		return nil, fmt.Errorf("%s authorization could not initialize: %v", config.Backend, err)
+	}
+	return authZ, nil
+}
+
+// Authentication context of the webapp, the same for every backend
+type UserInfoContext = openid.UserInfoContext[*oidc.IDTokenClaims, *oidc.UserInfo]
+
+/*
+Setup the authentication of the webapp for the configured backend
+
+  - clientID, redirectURI: OAuth client registered at the provider (ignored by the dev backend)
+  - encryptionKey: used to encrypt the session cookie and the state
+  - sessions: where the sessions are stored, the refreshed tokens are stored there as well
+*/
+func NewAuthenticator(ctx context.Context, config *Config, clientID, redirectURI, encryptionKey string, sessions *Sessions) (*authentication.Authenticator[*UserInfoContext], error) {
+	var handler authentication.HandlerInitializer[*UserInfoContext]
+	switch config.Backend {
+	case BackendZitadel:
+		handler = openid.DefaultAuthentication(clientID, redirectURI, encryptionKey, webScopes...)
+	case BackendOIDC:
+		handler = codeFlow(config.Issuer, clientID, redirectURI, encryptionKey)
+	case BackendDev:
+		handler = devAuthentication(NewDevIssuer(config.DevKey), config.DevRoles)
+	default:
+		return nil, fmt.Errorf("unknown auth backend %q", config.Backend)
+	}
+
+	authN, err := authentication.New(ctx, zitadel.New(config.Domain), encryptionKey, handler, authentication.WithSessionStore[*UserInfoContext](sessions))
+	if err != nil {
+		return nil, fmt.Errorf("%s authentication could not initialize: %v", config.Backend, err)
+	}
+	return authN, nil
+}
+
+// Same as openid.DefaultAuthentication, but against an arbitrary issuer instead of a ZITADEL domain
+func codeFlow(issuer, clientID, redirectURI, key string) authentication.HandlerInitializer[*UserInfoContext] {
+	cookieHandler := httphelper.NewCookieHandler([]byte(key), []byte(key))
+
+	return openid.WithCodeFlow[*UserInfoContext, *oidc.IDTokenClaims, *oidc.UserInfo](func(ctx context.Context, _ string) (rp.RelyingParty, error) {
+		return rp.NewRelyingPartyOIDC(ctx, issuer, clientID, "", redirectURI, webScopes, rp.WithPKCE(cookieHandler))
+	})
+}
+
+/*
+Context implements authorization.Ctx, it holds what the api knows about the caller whatever the backend is
+*/
+type Context struct {
+	Subject  string
+	Username string
+	Email    string
+	// organisation the user belongs to
+	OrgID string
+	// granted roles and the organisations they are granted in (can be empty when the backend doesn't know)
+	Roles map[string][]string
+	// expiration of the access token
+	Expiry time.Time
+	// the token was valid when the context was created
+	Active bool
+	// user, machine or api key, a user if empty
+	Caller CallerType
+	// api keys: the permissions the key is restricted to, whatever its roles grant
+	Scopes []string
+
+	token string
+}
+
+func (c *Context) IsAuthorized() bool {
+	if c == nil {
+		return false
+	}
+	return c.Active
+}
+
+func (c *Context) UserID() string {
+	if c == nil {
+		return ""
+	}
+	return c.Subject
+}
+
+func (c *Context) IsGrantedRole(role string) bool {
+	if c == nil {
+		return false
+	}
+	_, ok := c.Roles[role]
+	return ok
+}
+
+func (c *Context) IsGrantedRoleInOrganization(role, organizationID string) bool {
+	if c == nil {
+		return false
+	}
+	return slices.Contains(c.Roles[role], organizationID)
+}
+
+// Implements policy.Organizational
+func (c *Context) Organization() string {
+	if c == nil {
+		return ""
+	}
+	return c.OrgID
+}
+
+// Implements policy.Organizational, the organisations the role is granted in
+func (c *Context) RoleOrganizations(role string) []string {
+	if c == nil {
+		return nil
+	}
+	return c.Roles[role]
+}
+
+// Returns the type of the caller
+func (c *Context) CallerType() CallerType {
+	if c == nil || c.Caller == "" {
+		return CallerUser
+	}
+	return c.Caller
+}
+
+// Returns the scopes of the caller and true if its permissions are restricted to them, see policy.Scoped
+func (c *Context) RestrictedTo() ([]string, bool) {
+	if c == nil {
+		return nil, false
+	}
+	return c.Scopes, c.CallerType() == CallerAPIKey
+}
+
+// Attributes identifying the caller in the logs
+func (c *Context) LogAttrs() []any {
+	if c == nil {
+		return nil
+	}
+	return []any{"caller_type", c.CallerType(), "caller_id", c.UserID(), "caller_name", c.Username, "org_id", c.OrgID}
+}
+
+func (c *Context) SetToken(token string) {
+	c.token = token
+}
+
+func (c *Context) GetToken() string {
+	return c.token
+}
@@ -0,0 +1,511 @@
+package auth
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	jose "github.com/go-jose/go-jose/v4"
+	"github.com/zitadel/oidc/v3/pkg/oidc"
+	"github.com/zitadel/zitadel-go/v3/pkg/authorization"
+	"github.com/zitadel/zitadel-go/v3/pkg/zitadel"
+)
+
+func TestDevIssuer(t *testing.T) {
+	issuer := NewDevIssuer("test-key")
+	user := &DevUser{
+		ID:       "dev-alice",
+		Username: "alice",
+		Email:    "alice@localhost",
+		OrgID:    "org-1",
+		Roles:    []string{"admin", "reader"},
+	}
+
+	token, _, err := issuer.Mint(user)
+	if err != nil {
+		t.Fatalf("Mint() error = %v", err)
+	}
+
+	verifier, err := devVerifier(issuer)(context.Background(), nil)
+	if err != nil {
+		t.Fatalf("devVerifier() error = %v", err)
+	}
+
+	authCtx, err := verifier.CheckAuthorization(context.Background(), "Bearer "+token)
+	if err != nil {
+		t.Fatalf("CheckAuthorization() error = %v", err)
+	}
+
+	if !authCtx.IsAuthorized() {
+		t.Error("IsAuthorized() = false, expected true")
+	}
+	if authCtx.UserID() != user.ID {
+		t.Errorf("UserID() = %v, expected %v", authCtx.UserID(), user.ID)
+	}
+	if authCtx.Username != user.Username {
+		t.Errorf("Username = %v, expected %v", authCtx.Username, user.Username)
+	}
+	if authCtx.OrgID != user.OrgID {
+		t.Errorf("OrgID = %v, expected %v", authCtx.OrgID, user.OrgID)
+	}
+	for _, role := range user.Roles {
+		if !authCtx.IsGrantedRoleInOrganization(role, user.OrgID) {
+			t.Errorf("IsGrantedRoleInOrganization(%v, %v) = false, expected true", role, user.OrgID)
+		}
+	}
+	if authCtx.IsGrantedRole("owner") {
+		t.Error("IsGrantedRole(owner) = true, expected false")
+	}
+}
+
+func TestDevVerifierRejectsInvalidTokens(t *testing.T) {
+	issuer := NewDevIssuer("test-key")
+	user := &DevUser{ID: "dev-alice", Username: "alice"}
+
+	forged, _, err := NewDevIssuer("another-key").Mint(user)
+	if err != nil {
+		t.Fatalf("Mint() error = %v", err)
+	}
+
+	expiredIssuer := NewDevIssuer("test-key")
+	expiredIssuer.lifetime = -time.Minute
+	expired, _, err := expiredIssuer.Mint(user)
+	if err != nil {
+		t.Fatalf("Mint() error = %v", err)
+	}
+
+	verifier, err := devVerifier(issuer)(context.Background(), nil)
+	if err != nil {
+		t.Fatalf("devVerifier() error = %v", err)
+	}
+
+	tests := []struct {
+		name  string
+		token string
+	}{
+		{
+			name:  "signed with another key",
+			token: "Bearer " + forged,
+		},
+		{
+			name:  "expired",
+			token: "Bearer " + expired,
+		},
+		{
+			name:  "not a jwt",
+			token: "Bearer not-a-token",
+		},
+		{
+			name:  "missing bearer prefix",
+			token: forged,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := verifier.CheckAuthorization(context.Background(), tt.token); err == nil {
+				t.Error("CheckAuthorization() error = nil, expected an error")
+			}
+		})
+	}
+}
+
+func TestParseRoles(t *testing.T) {
+	tests := []struct {
+		name     string
+		claim    any
+		expected map[string][]string
+	}{
+		{
+			name:     "missing claim",
+			claim:    nil,
+			expected: map[string][]string{},
+		},
+		{
+			name:     "list of roles",
+			claim:    []any{"admin", "reader", 42},
+			expected: map[string][]string{"admin": nil, "reader": nil},
+		},
+		{
+			name: "zitadel roles",
+			claim: map[string]any{
+				"admin": map[string]any{"org-1": "org-1.localhost"},
+			},
+			expected: map[string][]string{"admin": {"org-1"}},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			result := parseRoles(tt.claim)
+			if !reflect.DeepEqual(result, tt.expected) {
+				t.Errorf("parseRoles() = %v, expected %v", result, tt.expected)
+			}
+		})
+	}
+}
+
+// fake OpenID Connect provider exposing discovery and its signing keys
+func newProvider(t *testing.T, key *rsa.PrivateKey) *httptest.Server {
+	t.Helper()
+
+	mux := http.NewServeMux()
+	server := httptest.NewServer(mux)
+	t.Cleanup(server.Close)
+
+	mux.HandleFunc("/.well-known/openid-configuration", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(&oidc.DiscoveryConfiguration{
+			Issuer:                           server.URL,
+			JwksURI:                          server.URL + "/keys",
+			IDTokenSigningAlgValuesSupported: []string{string(jose.RS256)},
+		})
+	})
+	mux.HandleFunc("/keys", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(&jose.JSONWebKeySet{Keys: []jose.JSONWebKey{
+			{Key: &key.PublicKey, KeyID: "key-1", Algorithm: string(jose.RS256), Use: "sig"},
+		}})
+	})
+
+	return server
+}
+
+func signRS256(t *testing.T, key *rsa.PrivateKey, claims map[string]any) string {
+	t.Helper()
+
+	signer, err := jose.NewSigner(jose.SigningKey{Algorithm: jose.RS256, Key: key}, (&jose.SignerOptions{}).WithHeader("kid", "key-1"))
+	if err != nil {
+		t.Fatalf("failed to create signer: %v", err)
+	}
+	payload, err := json.Marshal(claims)
+	if err != nil {
+		t.Fatalf("failed to serialize claims: %v", err)
+	}
+	signed, err := signer.Sign(payload)
+	if err != nil {
+		t.Fatalf("failed to sign: %v", err)
+	}
+	token, err := signed.CompactSerialize()
+	if err != nil {
+		t.Fatalf("failed to serialize token: %v", err)
+	}
+	return token
+}
+
+func TestOIDCVerifier(t *testing.T) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatalf("failed to generate key: %v", err)
+	}
+	provider := newProvider(t, key)
+
+	verifier, err := oidcVerifier(&Config{Issuer: provider.URL, Audience: "feed-api", RolesClaim: "roles"})(context.Background(), nil)
+	if err != nil {
+		t.Fatalf("oidcVerifier() error = %v", err)
+	}
+
+	claims := func(overrides map[string]any) map[string]any {
+		c := map[string]any{
+			"iss":                provider.URL,
+			"sub":                "user-1",
+			"aud":                []string{"feed-api"},
+			"exp":                time.Now().Add(time.Hour).Unix(),
+			"preferred_username": "bob",
+			"roles":              []string{"admin"},
+		}
+		for k, v := range overrides {
+			c[k] = v
+		}
+		return c
+	}
+
+	tests := []struct {
+		name    string
+		token   string
+		wantErr bool
+	}{
+		{
+			name:  "valid token",
+			token: signRS256(t, key, claims(nil)),
+		},
+		{
+			name:    "wrong issuer",
+			token:   signRS256(t, key, claims(map[string]any{"iss": "https://somewhere.else"})),
+			wantErr: true,
+		},
+		{
+			name:    "wrong audience",
+			token:   signRS256(t, key, claims(map[string]any{"aud": []string{"other-api"}})),
+			wantErr: true,
+		},
+		{
+			name:    "expired",
+			token:   signRS256(t, key, claims(map[string]any{"exp": time.Now().Add(-time.Hour).Unix()})),
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			authCtx, err := verifier.CheckAuthorization(context.Background(), "Bearer "+tt.token)
+			if tt.wantErr {
+				if !errors.Is(err, ErrInvalidToken) {
+					t.Errorf("CheckAuthorization() error = %v, expected %v", err, ErrInvalidToken)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("CheckAuthorization() error = %v", err)
+			}
+			if authCtx.Username != "bob" || !authCtx.IsGrantedRole("admin") {
+				t.Errorf("CheckAuthorization() = %+v, expected bob with the admin role", authCtx)
+			}
+		})
+	}
+}
+
+func TestDevAuthenticationCallback(t *testing.T) {
+	handler, err := devAuthentication(NewDevIssuer("test-key"), []string{"admin"})(context.Background(), nil)
+	if err != nil {
+		t.Fatalf("devAuthentication() error = %v", err)
+	}
+
+	form := url.Values{
+		"state":       {"encrypted-state"},
+		"username":    {"alice"},
+		"given_name":  {"Alice"},
+		"family_name": {"Doe"},
+		"roles":       {"admin, reader"},
+	}
+	req := httptest.NewRequest(http.MethodPost, "/auth/callback", strings.NewReader(form.Encode()))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+
+	authCtx, state := handler.Callback(httptest.NewRecorder(), req)
+	if !authCtx.IsAuthenticated() {
+		t.Fatal("IsAuthenticated() = false, expected true")
+	}
+	if state != "encrypted-state" {
+		t.Errorf("state = %v, expected encrypted-state", state)
+	}
+	if authCtx.UserInfo.GivenName != "Alice" {
+		t.Errorf("GivenName = %v, expected Alice", authCtx.UserInfo.GivenName)
+	}
+
+	verifier, _ := devVerifier(NewDevIssuer("test-key"))(context.Background(), nil)
+	apiCtx, err := verifier.CheckAuthorization(context.Background(), "Bearer "+authCtx.Tokens.AccessToken)
+	if err != nil {
+		t.Fatalf("CheckAuthorization() error = %v", err)
+	}
+	if !apiCtx.IsGrantedRole("reader") {
+		t.Error("IsGrantedRole(reader) = false, expected true")
+	}
+
+	// a GET can't complete the login
+	authCtx, _ = handler.Callback(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/auth/callback", nil))
+	if authCtx.IsAuthenticated() {
+		t.Error("IsAuthenticated() after GET = true, expected false")
+	}
+}
+
+func TestOIDCVerifierRequiresAnAudience(t *testing.T) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatalf("failed to generate key: %v", err)
+	}
+	provider := newProvider(t, key)
+
+	if _, err := oidcVerifier(&Config{Issuer: provider.URL, RolesClaim: "roles"})(context.Background(), nil); !errors.Is(err, ErrMissingAudience) {
+		t.Errorf("oidcVerifier() error = %v, expected %v", err, ErrMissingAudience)
+	}
+}
+
+func TestOIDCVerifierOrganisation(t *testing.T) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatalf("failed to generate key: %v", err)
+	}
+	provider := newProvider(t, key)
+
+	verifier, err := oidcVerifier(&Config{
+		Issuer:     provider.URL,
+		Audience:   "feed-api",
+		RolesClaim: "roles",
+		OrgClaim:   "org_id",
+		DefaultOrg: "org-default",
+	})(context.Background(), nil)
+	if err != nil {
+		t.Fatalf("oidcVerifier() error = %v", err)
+	}
+
+	tests := []struct {
+		name     string
+		org      any
+		expected string
+	}{
+		{
+			name:     "organisation claim",
+			org:      "org-1",
+			expected: "org-1",
+		},
+		{
+			name:     "missing claim falls back to the default organisation",
+			expected: "org-default",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			claims := map[string]any{
+				"iss": provider.URL,
+				"sub": "user-1",
+				"aud": []string{"feed-api"},
+				"exp": time.Now().Add(time.Hour).Unix(),
+			}
+			if tt.org != nil {
+				claims["org_id"] = tt.org
+			}
+
+			authCtx, err := verifier.CheckAuthorization(context.Background(), "Bearer "+signRS256(t, key, claims))
+			if err != nil {
+				t.Fatalf("CheckAuthorization() error = %v", err)
+			}
+			if authCtx.OrgID != tt.expected {
+				t.Errorf("OrgID = %v, expected %v", authCtx.OrgID, tt.expected)
+			}
+		})
+	}
+}
+
+func TestOIDCVerifierIdentifiesMachines(t *testing.T) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatalf("failed to generate key: %v", err)
+	}
+	provider := newProvider(t, key)
+
+	verifier, err := oidcVerifier(&Config{Issuer: provider.URL, Audience: "feed-api", RolesClaim: "roles"})(context.Background(), nil)
+	if err != nil {
+		t.Fatalf("oidcVerifier() error = %v", err)
+	}
+
+	tests := []struct {
+		name     string
+		claims   map[string]any
+		expected CallerType
+	}{
+		{
+			name:     "user logged in through a web client",
+			claims:   map[string]any{"sub": "user-1", "client_id": "web-app", "preferred_username": "bob"},
+			expected: CallerUser,
+		},
+		{
+			name:     "client credentials, client is the subject",
+			claims:   map[string]any{"sub": "ci-bot", "client_id": "ci-bot"},
+			expected: CallerMachine,
+		},
+		{
+			name:     "client credentials, client in azp",
+			claims:   map[string]any{"sub": "ci-bot", "azp": "ci-bot"},
+			expected: CallerMachine,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.claims["iss"] = provider.URL
+			tt.claims["aud"] = "feed-api"
+			tt.claims["exp"] = time.Now().Add(time.Hour).Unix()
+
+			authCtx, err := verifier.CheckAuthorization(context.Background(), "Bearer "+signRS256(t, key, tt.claims))
+			if err != nil {
+				t.Fatalf("CheckAuthorization() error = %v", err)
+			}
+			if authCtx.CallerType() != tt.expected {
+				t.Errorf("CallerType() = %v, expected %v", authCtx.CallerType(), tt.expected)
+			}
+		})
+	}
+}
+
+type apiKeyFunc func(ctx context.Context, key string) (*Context, error)
+
+func (f apiKeyFunc) VerifyAPIKey(ctx context.Context, key string) (*Context, error) {
+	return f(ctx, key)
+}
+
+func TestCallerVerifierRoutesAPIKeys(t *testing.T) {
+	backend := func(context.Context, *zitadel.Zitadel) (authorization.Verifier[*Context], error) {
+		return verifierFunc(func(_ context.Context, token string) (*Context, error) {
+			return &Context{Subject: "user-1", Active: true}, nil
+		}), nil
+	}
+	apiKeys := apiKeyFunc(func(_ context.Context, key string) (*Context, error) {
+		if key != APIKeyPrefix+"valid" {
+			return nil, ErrInvalidToken
+		}
+		return &Context{Subject: "apikey:1", Active: true, Caller: CallerAPIKey}, nil
+	})
+
+	verifier, err := callerVerifier(backend, apiKeys)(context.Background(), nil)
+	if err != nil {
+		t.Fatalf("callerVerifier() error = %v", err)
+	}
+
+	if authCtx, err := verifier.CheckAuthorization(context.Background(), "Bearer "+APIKeyPrefix+"valid"); err != nil || authCtx.CallerType() != CallerAPIKey {
+		t.Errorf("api key: CheckAuthorization() = %+v, %v, expected the api key", authCtx, err)
+	}
+	if _, err := verifier.CheckAuthorization(context.Background(), "Bearer "+APIKeyPrefix+"revoked"); err == nil {
+		t.Errorf("invalid api key: CheckAuthorization() error = nil, expected an error")
+	}
+	if authCtx, err := verifier.CheckAuthorization(context.Background(), "Bearer access-token"); err != nil || authCtx.CallerType() != CallerUser {
+		t.Errorf("access token: CheckAuthorization() = %+v, %v, expected the user", authCtx, err)
+	}
+
+	// without api keys configured, everything goes to the backend
+	verifier, err = callerVerifier(backend, nil)(context.Background(), nil)
+	if err != nil {
+		t.Fatalf("callerVerifier() error = %v", err)
+	}
+	if authCtx, err := verifier.CheckAuthorization(context.Background(), "Bearer "+APIKeyPrefix+"valid"); err != nil || authCtx.Subject != "user-1" {
+		t.Errorf("CheckAuthorization() = %+v, %v, expected the backend to verify the token", authCtx, err)
+	}
+}
+
+func TestSessionsReplace(t *testing.T) {
+	sessions := NewSessions()
+	if _, err := sessions.Get("unknown"); !errors.Is(err, ErrSessionNotFound) {
+		t.Errorf("Get() error = %v, expected %v", err, ErrSessionNotFound)
+	}
+
+	first := &UserInfoContext{}
+	if err := sessions.Set("session-id", first); err != nil {
+		t.Fatal(err)
+	}
+	second, third := &UserInfoContext{}, &UserInfoContext{}
+	if !sessions.Replace(first, second) {
+		t.Fatal("the stored session should be replaced")
+	}
+	// only the stored version can be replaced, a concurrent request already did
+	if sessions.Replace(first, third) {
+		t.Error("a replaced session should not be replaced again")
+	}
+	if stored, _ := sessions.Get("session-id"); stored != second {
+		t.Errorf("Get() = %p, expected the replacement %p", stored, second)
+	}
+	if latest := sessions.Latest(first); latest != second {
+		t.Errorf("Latest() = %p, expected the replacement %p", latest, second)
+	}
+
+	// unknown sessions are their own latest version
+	if latest := sessions.Latest(third); latest != third {
+		t.Errorf("Latest() = %p, expected the session itself", latest)
+	}
+}
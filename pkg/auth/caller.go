@@ -0,0 +1,89 @@
+package auth
+
+import (
+	"context"
+	"strings"
+
+	"github.com/xaviercrochet/turbo-octo-adventure/pkg/util"
+	"github.com/zitadel/oidc/v3/pkg/oidc"
+	"github.com/zitadel/zitadel-go/v3/pkg/authorization"
+	"github.com/zitadel/zitadel-go/v3/pkg/zitadel"
+)
+
+// What is calling the api
+type CallerType string
+
+const (
+	// a person, logged in interactively
+	CallerUser CallerType = "user"
+	// a ZITADEL machine user (or any OAuth client) authenticated with the client credentials grant
+	CallerMachine CallerType = "machine"
+	// an api key issued by an admin, see WithAPIKeys
+	CallerAPIKey CallerType = "api_key"
+)
+
+// bearer tokens starting with the prefix are api keys, anything else is an access token of the provider
+const APIKeyPrefix = "fk_"
+
+// Resolves the api keys presented as bearer tokens
+type APIKeyVerifier interface {
+	// Returns the context of the key, an error if the key is unknown, revoked or expired
+	VerifyAPIKey(ctx context.Context, key string) (*Context, error)
+}
+
+// AuthorizerOption allows customization of the authorizer returned by NewAuthorizer
+type AuthorizerOption func(*authorizerOptions)
+
+type authorizerOptions struct {
+	apiKeys APIKeyVerifier
+}
+
+// WithAPIKeys accepts the api keys resolved by verifier in addition to the access tokens of the backend
+func WithAPIKeys(verifier APIKeyVerifier) AuthorizerOption {
+	return func(o *authorizerOptions) {
+		o.apiKeys = verifier
+	}
+}
+
+/*
+Routes api keys to their verifier and the access tokens to the backend, so both go through the same middleware
+
+Every authorized caller is logged once, with the trace id of the request
+*/
+func callerVerifier(backend authorization.VerifierInitializer[*Context], apiKeys APIKeyVerifier) authorization.VerifierInitializer[*Context] {
+	return func(ctx context.Context, z *zitadel.Zitadel) (authorization.Verifier[*Context], error) {
+		next, err := backend(ctx, z)
+		if err != nil {
+			return nil, err
+		}
+		return verifierFunc(func(ctx context.Context, authorizationToken string) (*Context, error) {
+			// local to the call, requests are verified concurrently
+			var (
+				authCtx *Context
+				err     error
+			)
+			token := strings.TrimSpace(strings.TrimPrefix(authorizationToken, oidc.BearerToken))
+			if apiKeys != nil && strings.HasPrefix(token, APIKeyPrefix) {
+				authCtx, err = apiKeys.VerifyAPIKey(ctx, token)
+			} else {
+				authCtx, err = next.CheckAuthorization(ctx, authorizationToken)
+			}
+			if err != nil {
+				return nil, err
+			}
+
+			if authCtx.IsAuthorized() {
+				util.DefaultLogger.FromContext(ctx).Info("caller authorized", authCtx.LogAttrs()...)
+			}
+			return authCtx, nil
+		}), nil
+	}
+}
+
+/*
+Client credentials tokens are issued to the client itself: the client is the subject of the token, or for ZITADEL machine
+users, the username
+*/
+func isMachine(subject, username, clientID string) bool {
+	return clientID != "" && (clientID == subject || clientID == username)
+}
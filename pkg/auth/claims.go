@@ -0,0 +1,213 @@
+package auth
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/zitadel/oidc/v3/pkg/client"
+	"github.com/zitadel/oidc/v3/pkg/client/rp"
+	"github.com/zitadel/oidc/v3/pkg/oidc"
+	"github.com/zitadel/zitadel-go/v3/pkg/authorization"
+	"github.com/zitadel/zitadel-go/v3/pkg/authorization/oauth"
+	"github.com/zitadel/zitadel-go/v3/pkg/zitadel"
+)
+
+const (
+	// claim in which ZITADEL puts the project roles: {"role": {"orgID": "org domain"}}
+	ZitadelRolesClaim = "urn:zitadel:iam:org:project:roles"
+	// claim in which ZITADEL puts the organisation of the user
+	ZitadelOrgClaim = "urn:zitadel:iam:user:resourceowner:id"
+)
+
+var ErrInvalidToken = errors.New("invalid access token")
+
+var ErrMissingAudience = errors.New("the oidc backend requires an audience")
+
+// Introspect the access token at the ZITADEL instance, as oauth.DefaultAuthorization does
+func zitadelVerifier(keyFile string) authorization.VerifierInitializer[*Context] {
+	return func(ctx context.Context, z *zitadel.Zitadel) (authorization.Verifier[*Context], error) {
+		introspection, err := oauth.DefaultAuthorization(keyFile)(ctx, z)
+		if err != nil {
+			return nil, err
+		}
+		return verifierFunc(func(ctx context.Context, authorizationToken string) (*Context, error) {
+			resp, err := introspection.CheckAuthorization(ctx, authorizationToken)
+			if err != nil {
+				return nil, err
+			}
+			return introspectionToContext(resp), nil
+		}), nil
+	}
+}
+
+func introspectionToContext(resp *oauth.IntrospectionContext) *Context {
+	username := resp.Username
+	if username == "" {
+		username = resp.PreferredUsername
+	}
+
+	orgID, _ := resp.Claims[ZitadelOrgClaim].(string)
+
+	caller := CallerUser
+	if isMachine(resp.Subject, username, resp.ClientID) {
+		caller = CallerMachine
+	}
+
+	return &Context{
+		Subject:  resp.Subject,
+		Username: username,
+		Email:    resp.Email,
+		OrgID:    orgID,
+		Roles:    parseRoles(resp.Claims[ZitadelRolesClaim]),
+		Expiry:   resp.Expiration.AsTime(),
+		Active:   resp.Active,
+		Caller:   caller,
+	}
+}
+
+// Verify JWT access tokens against the keys published by an OpenID Connect provider
+func oidcVerifier(config *Config) authorization.VerifierInitializer[*Context] {
+	return func(ctx context.Context, _ *zitadel.Zitadel) (authorization.Verifier[*Context], error) {
+		// without it, tokens issued to any client of the provider would be accepted
+		if config.Audience == "" {
+			return nil, ErrMissingAudience
+		}
+		discovery, err := client.Discover(ctx, config.Issuer, http.DefaultClient)
+		if err != nil {
+			return nil, fmt.Errorf("failed to discover %s: %w", config.Issuer, err)
+		}
+
+		return &jwtVerifier{
+			issuer:     discovery.Issuer,
+			audience:   config.Audience,
+			rolesClaim: config.RolesClaim,
+			orgClaim:   config.OrgClaim,
+			defaultOrg: config.DefaultOrg,
+			keySet:     rp.NewRemoteKeySet(http.DefaultClient, discovery.JwksURI),
+			algorithms: discovery.IDTokenSigningAlgValuesSupported,
+		}, nil
+	}
+}
+
+// Validate a signed JWT access token and map its claims to a Context
+type jwtVerifier struct {
+	issuer     string
+	audience   string
+	rolesClaim string
+	orgClaim   string
+	// organisation of the callers whose token doesn't have orgClaim
+	defaultOrg string
+	keySet     oidc.KeySet
+	// accepted signature algorithms, RS256, ES256 and PS256 if empty
+	algorithms []string
+}
+
+func (v *jwtVerifier) CheckAuthorization(ctx context.Context, authorizationToken string) (*Context, error) {
+	token, ok := strings.CutPrefix(authorizationToken, oidc.BearerToken)
+	if !ok {
+		return nil, oauth.ErrInvalidAuthorizationHeader
+	}
+	token = strings.TrimSpace(token)
+
+	// IDTokenClaims covers the registered claims and the profile, every other claim ends up in Claims
+	claims := new(oidc.IDTokenClaims)
+	payload, err := oidc.ParseToken(token, claims)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidToken, err)
+	}
+
+	if err := oidc.CheckSignature(ctx, token, payload, claims, v.algorithms, v.keySet); err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidToken, err)
+	}
+	if err := oidc.CheckIssuer(claims, v.issuer); err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidToken, err)
+	}
+	if err := oidc.CheckAudience(claims, v.audience); err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidToken, err)
+	}
+	if err := oidc.CheckExpiration(claims, 0); err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidToken, err)
+	}
+
+	return v.claimsToContext(claims), nil
+}
+
+func (v *jwtVerifier) claimsToContext(claims *oidc.IDTokenClaims) *Context {
+	username := claims.PreferredUsername
+	if username == "" {
+		username, _ = claims.Claims["username"].(string)
+	}
+	if username == "" {
+		username = claims.Email
+	}
+
+	orgID, _ := claims.Claims[v.orgClaim].(string)
+	if orgID == "" {
+		orgID = v.defaultOrg
+	}
+
+	// client credentials tokens name their client either in client_id or in azp
+	clientID := claims.ClientID
+	if clientID == "" {
+		clientID = claims.AuthorizedParty
+	}
+	caller := CallerUser
+	if isMachine(claims.Subject, username, clientID) {
+		caller = CallerMachine
+	}
+
+	return &Context{
+		Subject:  claims.Subject,
+		Username: username,
+		Email:    claims.Email,
+		OrgID:    orgID,
+		Roles:    parseRoles(claims.Claims[v.rolesClaim]),
+		Expiry:   claims.GetExpiration(),
+		Active:   claims.Subject != "",
+		Caller:   caller,
+	}
+}
+
+/*
+Read the roles out of a claim, two shapes are supported:
+
+  - a list of role names: ["admin", "reader"]
+  - a ZITADEL style map: {"admin": {"orgID": "org domain"}}
+*/
+func parseRoles(claim any) map[string][]string {
+	roles := make(map[string][]string)
+
+	switch value := claim.(type) {
+	case []any:
+		for _, role := range value {
+			if name, ok := role.(string); ok {
+				roles[name] = nil
+			}
+		}
+	case []string:
+		for _, name := range value {
+			roles[name] = nil
+		}
+	case map[string]any:
+		for name, orgs := range value {
+			roles[name] = nil
+			if orgs, ok := orgs.(map[string]any); ok {
+				for orgID := range orgs {
+					roles[name] = append(roles[name], orgID)
+				}
+			}
+		}
+	}
+
+	return roles
+}
+
+// Adapt a function to the authorization.Verifier interface
+type verifierFunc func(ctx context.Context, authorizationToken string) (*Context, error)
+
+func (f verifierFunc) CheckAuthorization(ctx context.Context, authorizationToken string) (*Context, error) {
+	return f(ctx, authorizationToken)
+}
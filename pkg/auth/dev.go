@@ -0,0 +1,286 @@
+package auth
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+	"html/template"
+	"net/http"
+	"strings"
+	"time"
+
+	jose "github.com/go-jose/go-jose/v4"
+	"github.com/xaviercrochet/turbo-octo-adventure/pkg/util"
+	"github.com/zitadel/oidc/v3/pkg/oidc"
+	"github.com/zitadel/zitadel-go/v3/pkg/authentication"
+	"github.com/zitadel/zitadel-go/v3/pkg/authorization"
+	"github.com/zitadel/zitadel-go/v3/pkg/zitadel"
+	"golang.org/x/oauth2"
+)
+
+const (
+	// shared by both binaries so -auth=dev works without further configuration
+	DefaultDevKey = "turbo-octo-adventure-insecure-dev-key"
+	// issuer of the development tokens
+	DevIssuerURL = "urn:turbo-octo-adventure:dev"
+	// organisation of the development users if none is given
+	DefaultDevOrgID = "dev-org"
+
+	devTokenLifetime = time.Hour
+)
+
+// A user for which the development issuer mints tokens
+type DevUser struct {
+	ID         string
+	Username   string
+	GivenName  string
+	FamilyName string
+	Email      string
+	OrgID      string
+	Roles      []string
+}
+
+/*
+DevIssuer mints HS256 signed tokens shaped like the ones issued by ZITADEL, so the rest of the app can't tell the difference.
+
+The webapp mints the tokens at login and the api verifies them, both must share the same key
+*/
+type DevIssuer struct {
+	key      []byte
+	lifetime time.Duration
+}
+
+func NewDevIssuer(key string) *DevIssuer {
+	if key == "" {
+		key = DefaultDevKey
+	}
+	// HS256 needs at least 32 bytes, any passphrase is stretched to that size
+	sum := sha256.Sum256([]byte(key))
+	return &DevIssuer{
+		key:      sum[:],
+		lifetime: devTokenLifetime,
+	}
+}
+
+// Returns a signed access token for the user and its expiration
+func (i *DevIssuer) Mint(user *DevUser) (string, time.Time, error) {
+	now := time.Now()
+	expiry := now.Add(i.lifetime)
+
+	orgID := user.OrgID
+	if orgID == "" {
+		orgID = DefaultDevOrgID
+	}
+
+	// same shape as the ZITADEL roles claim: {"role": {"orgID": "org domain"}}
+	roles := make(map[string]any)
+	for _, role := range user.Roles {
+		roles[role] = map[string]any{orgID: orgID}
+	}
+
+	claims := &oidc.IDTokenClaims{
+		TokenClaims: oidc.TokenClaims{
+			Issuer:     DevIssuerURL,
+			Subject:    user.ID,
+			Audience:   oidc.Audience{DevIssuerURL},
+			Expiration: oidc.FromTime(expiry),
+			IssuedAt:   oidc.FromTime(now),
+		},
+		UserInfoProfile: oidc.UserInfoProfile{
+			Name:              strings.TrimSpace(user.GivenName + " " + user.FamilyName),
+			GivenName:         user.GivenName,
+			FamilyName:        user.FamilyName,
+			PreferredUsername: user.Username,
+		},
+		UserInfoEmail: oidc.UserInfoEmail{
+			Email: user.Email,
+		},
+		Claims: map[string]any{
+			ZitadelRolesClaim: roles,
+			ZitadelOrgClaim:   orgID,
+		},
+	}
+
+	payload, err := json.Marshal(claims)
+	if err != nil {
+		return "", time.Time{}, fmt.Errorf("failed to serialize claims: %w", err)
+	}
+
+	signer, err := jose.NewSigner(jose.SigningKey{Algorithm: jose.HS256, Key: i.key}, (&jose.SignerOptions{}).WithType("JWT"))
+	if err != nil {
+		return "", time.Time{}, fmt.Errorf("failed to create signer: %w", err)
+	}
+
+	signed, err := signer.Sign(payload)
+	if err != nil {
+		return "", time.Time{}, fmt.Errorf("failed to sign token: %w", err)
+	}
+
+	token, err := signed.CompactSerialize()
+	if err != nil {
+		return "", time.Time{}, fmt.Errorf("failed to serialize token: %w", err)
+	}
+
+	return token, expiry, nil
+}
+
+// Returns the tokens of a completed login: the same signed token is used as access and id token
+func (i *DevIssuer) Tokens(user *DevUser) (*oidc.Tokens[*oidc.IDTokenClaims], error) {
+	token, expiry, err := i.Mint(user)
+	if err != nil {
+		return nil, err
+	}
+
+	claims := new(oidc.IDTokenClaims)
+	if _, err := oidc.ParseToken(token, claims); err != nil {
+		return nil, err
+	}
+
+	return &oidc.Tokens[*oidc.IDTokenClaims]{
+		Token: &oauth2.Token{
+			AccessToken: token,
+			TokenType:   oidc.BearerToken,
+			Expiry:      expiry,
+		},
+		IDTokenClaims: claims,
+		IDToken:       token,
+	}, nil
+}
+
+// Verifies the signature of the development tokens
+func (i *DevIssuer) KeySet() oidc.KeySet {
+	return hmacKeySet(i.key)
+}
+
+type hmacKeySet []byte
+
+func (k hmacKeySet) VerifySignature(_ context.Context, jws *jose.JSONWebSignature) ([]byte, error) {
+	return jws.Verify([]byte(k))
+}
+
+// Verify the tokens minted by the development issuer
+func devVerifier(issuer *DevIssuer) authorization.VerifierInitializer[*Context] {
+	return func(context.Context, *zitadel.Zitadel) (authorization.Verifier[*Context], error) {
+		return &jwtVerifier{
+			issuer:     DevIssuerURL,
+			audience:   DevIssuerURL,
+			rolesClaim: ZitadelRolesClaim,
+			orgClaim:   ZitadelOrgClaim,
+			keySet:     issuer.KeySet(),
+			algorithms: []string{string(jose.HS256)},
+		}, nil
+	}
+}
+
+/*
+Development login: instead of redirecting to a provider, /auth/login renders a form in which any user and roles can be picked.
+The form is posted to /auth/callback where the tokens are minted
+*/
+func devAuthentication(issuer *DevIssuer, defaultRoles []string) authentication.HandlerInitializer[*UserInfoContext] {
+	return func(context.Context, *zitadel.Zitadel) (authentication.Handler[*UserInfoContext], error) {
+		return &devHandler{
+			issuer:       issuer,
+			defaultRoles: defaultRoles,
+		}, nil
+	}
+}
+
+type devHandler struct {
+	issuer       *DevIssuer
+	defaultRoles []string
+}
+
+var devLoginPage = template.Must(template.New("dev_login").Parse(`<html>
+<head>
+    <title>Development Login</title>
+    <style nonce="{{.Nonce}}">
+        body { text-align: center }
+        .warning { color: red }
+    </style>
+</head>
+<body>
+<h1>Development Login</h1>
+<p class="warning">Tokens are minted locally, never use this mode in production</p>
+<form method="POST" action="/auth/callback">
+    <input type="hidden" name="state" value="{{.State}}">
+    <p><label>Username: <input type="text" name="username" value="dev"></label></p>
+    <p><label>First name: <input type="text" name="given_name" value="Dev"></label></p>
+    <p><label>Last name: <input type="text" name="family_name" value="User"></label></p>
+    <p><label>Email: <input type="text" name="email" value="dev@localhost"></label></p>
+    <p><label>Organisation: <input type="text" name="org_id" value="{{.OrgID}}"></label></p>
+    <p><label>Roles (comma separated): <input type="text" name="roles" value="{{.Roles}}"></label></p>
+    <button type="submit">Login</button>
+</form>
+</body>
+</html>`))
+
+func (h *devHandler) Authenticate(w http.ResponseWriter, r *http.Request, state string) {
+	err := devLoginPage.Execute(w, map[string]string{
+		"State": state,
+		"OrgID": DefaultDevOrgID,
+		"Roles": strings.Join(h.defaultRoles, ","),
+		"Nonce": util.CSPNonce(r.Context()),
+	})
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+	}
+}
+
+// Returning a nil context makes the authenticator answer with 403
+func (h *devHandler) Callback(w http.ResponseWriter, r *http.Request) (*UserInfoContext, string) {
+	if r.Method != http.MethodPost {
+		return nil, ""
+	}
+	if err := r.ParseForm(); err != nil {
+		return nil, ""
+	}
+
+	username := strings.TrimSpace(r.PostFormValue("username"))
+	if username == "" {
+		return nil, ""
+	}
+
+	user := &DevUser{
+		// stable across logins so per user state survives
+		ID:         "dev-" + username,
+		Username:   username,
+		GivenName:  r.PostFormValue("given_name"),
+		FamilyName: r.PostFormValue("family_name"),
+		Email:      r.PostFormValue("email"),
+		OrgID:      strings.TrimSpace(r.PostFormValue("org_id")),
+		Roles:      SplitList(r.PostFormValue("roles")),
+	}
+
+	tokens, err := h.issuer.Tokens(user)
+	if err != nil {
+		return nil, ""
+	}
+
+	authCtx := &UserInfoContext{
+		Tokens: tokens,
+		UserInfo: &oidc.UserInfo{
+			Subject:         user.ID,
+			UserInfoProfile: tokens.IDTokenClaims.UserInfoProfile,
+			UserInfoEmail:   tokens.IDTokenClaims.UserInfoEmail,
+		},
+	}
+
+	return authCtx, r.PostFormValue("state")
+}
+
+// there is no session at the issuer to terminate
+func (h *devHandler) Logout(w http.ResponseWriter, r *http.Request, _ *UserInfoContext, _, optionalRedirectURI string) {
+	http.Redirect(w, r, optionalRedirectURI, http.StatusFound)
+}
+
+// Split a comma separated list, empty items are dropped
+func SplitList(value string) []string {
+	var items []string
+	for _, item := range strings.Split(value, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
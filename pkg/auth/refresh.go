@@ -0,0 +1,82 @@
+package auth
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/zitadel/oidc/v3/pkg/client/rp"
+	"github.com/zitadel/oidc/v3/pkg/oidc"
+	"github.com/zitadel/zitadel-go/v3/pkg/zitadel"
+)
+
+// returned when the tokens of a session can't be refreshed, the user has to log in again
+var ErrRefreshUnavailable = errors.New("tokens can't be refreshed")
+
+// scopes requested by the webapp, offline_access asks the provider for a refresh token
+var webScopes = []string{oidc.ScopeOpenID, oidc.ScopeProfile, oidc.ScopeEmail, oidc.ScopeOfflineAccess}
+
+// Tokens of a web session
+type Tokens = oidc.Tokens[*oidc.IDTokenClaims]
+
+// Exchanges the refresh token of a web session for new tokens
+type TokenRefresher interface {
+	Refresh(ctx context.Context, tokens *Tokens) (*Tokens, error)
+}
+
+/*
+Setup the refresh of the web sessions for the configured backend
+
+The client must be the one used by NewAuthenticator. The dev backend doesn't issue refresh tokens, its sessions end with
+their token
+*/
+func NewTokenRefresher(ctx context.Context, config *Config, clientID, redirectURI string) (TokenRefresher, error) {
+	var issuer string
+	switch config.Backend {
+	case BackendZitadel:
+		issuer = zitadel.New(config.Domain).Origin()
+	case BackendOIDC:
+		issuer = config.Issuer
+	case BackendDev:
+		return noRefresh{}, nil
+	default:
+		return nil, fmt.Errorf("unknown auth backend %q", config.Backend)
+	}
+
+	relyingParty, err := rp.NewRelyingPartyOIDC(ctx, issuer, clientID, "", redirectURI, webScopes)
+	if err != nil {
+		return nil, fmt.Errorf("%s token refresh could not initialize: %v", config.Backend, err)
+	}
+	return &rpRefresher{relyingParty: relyingParty}, nil
+}
+
+type rpRefresher struct {
+	relyingParty rp.RelyingParty
+}
+
+func (r *rpRefresher) Refresh(ctx context.Context, tokens *Tokens) (*Tokens, error) {
+	if tokens == nil || tokens.Token == nil || tokens.RefreshToken == "" {
+		return nil, ErrRefreshUnavailable
+	}
+
+	refreshed, err := rp.RefreshTokens[*oidc.IDTokenClaims](ctx, r.relyingParty, tokens.RefreshToken, "", "")
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrRefreshUnavailable, err)
+	}
+
+	// the refresh response may omit the id token and the refresh token, the previous ones remain valid then
+	if refreshed.IDToken == "" {
+		refreshed.IDToken = tokens.IDToken
+		refreshed.IDTokenClaims = tokens.IDTokenClaims
+	}
+	if refreshed.RefreshToken == "" {
+		refreshed.RefreshToken = tokens.RefreshToken
+	}
+	return refreshed, nil
+}
+
+type noRefresh struct{}
+
+func (noRefresh) Refresh(context.Context, *Tokens) (*Tokens, error) {
+	return nil, ErrRefreshUnavailable
+}
@@ -0,0 +1,94 @@
+package auth
+
+import (
+	"errors"
+	"sync"
+)
+
+var ErrSessionNotFound = errors.New("session not found")
+
+/*
+Session store of the webapp, safe for concurrent use unlike authentication.InMemorySessions
+
+The stored sessions are shared by the concurrent requests of the user, they must not be modified. A session is updated
+by storing a copy in its place, see Replace
+*/
+type Sessions struct {
+	mu       sync.Mutex
+	sessions map[string]*UserInfoContext
+	// id of the stored sessions, and of the versions they replaced that requests in flight may still hold
+	ids map[*UserInfoContext]string
+	// the version each session replaced
+	replaced map[string]*UserInfoContext
+}
+
+func NewSessions() *Sessions {
+	return &Sessions{
+		sessions: make(map[string]*UserInfoContext),
+		ids:      make(map[*UserInfoContext]string),
+		replaced: make(map[string]*UserInfoContext),
+	}
+}
+
+// Implements authentication.Sessions
+func (s *Sessions) Get(id string) (*UserInfoContext, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	session, ok := s.sessions[id]
+	if !ok {
+		return nil, ErrSessionNotFound
+	}
+	return session, nil
+}
+
+// Implements authentication.Sessions
+func (s *Sessions) Set(id string, session *UserInfoContext) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.forget(id)
+	s.sessions[id] = session
+	s.ids[session] = id
+	return nil
+}
+
+// Returns the stored version of session, session itself unless it was replaced since the request got it
+func (s *Sessions) Latest(session *UserInfoContext) *UserInfoContext {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if id, ok := s.ids[session]; ok {
+		return s.sessions[id]
+	}
+	return session
+}
+
+// Store next in place of session. Returns false if session isn't the stored version, next is then dropped
+func (s *Sessions) Replace(session, next *UserInfoContext) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	id, ok := s.ids[session]
+	if !ok || s.sessions[id] != session {
+		return false
+	}
+	if older, ok := s.replaced[id]; ok {
+		delete(s.ids, older)
+	}
+	s.replaced[id] = session
+	s.sessions[id] = next
+	s.ids[next] = id
+	return true
+}
+
+// drop the versions of the session stored under id, s.mu must be held
+func (s *Sessions) forget(id string) {
+	if session, ok := s.sessions[id]; ok {
+		delete(s.ids, session)
+	}
+	if older, ok := s.replaced[id]; ok {
+		delete(s.ids, older)
+		delete(s.replaced, id)
+	}
+}
@@ -0,0 +1,30 @@
+package middleware
+
+import (
+	"net/http"
+)
+
+// Maximum size of a request body, unless configured otherwise
+const DefaultMaxBodySize int64 = 1 << 20
+
+/*
+This middleware limits the size of the request bodies. Handlers reading past limit get a *http.MaxBytesError and
+should answer with a 413
+
+Response:
+  - 413 if the Content-Length of the request is larger than limit
+*/
+func MaxBodySize(limit int64) func(next http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			// no need to read the body to know it's too large
+			if r.ContentLength > limit {
+				http.Error(w, "request body too large", http.StatusRequestEntityTooLarge)
+				return
+			}
+
+			r.Body = http.MaxBytesReader(w, r.Body, limit)
+			next.ServeHTTP(w, r)
+		})
+	}
+}
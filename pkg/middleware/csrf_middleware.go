@@ -0,0 +1,189 @@
+package middleware
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"net/http"
+
+	"github.com/xaviercrochet/turbo-octo-adventure/pkg/util"
+)
+
+const (
+	// cookie identifying the browser session the CSRF token is bound to
+	DefaultCSRFCookieName = "csrf.session"
+	// form field in which html forms send the CSRF token
+	CSRFFormField = "csrf_token"
+)
+
+/*
+CSRF issues and verifies per-session CSRF tokens
+
+A random session id is stored in a cookie, the token is an HMAC of this id. A forged request can't carry a valid
+token, as the attacker can neither read the cookie nor compute the HMAC without the key
+*/
+type CSRF struct {
+	key        []byte
+	cookieName string
+	secure     bool
+}
+
+// CSRFOption allows customization of the CSRF middleware
+type CSRFOption func(*CSRF)
+
+// WithCSRFCookieName allows a cookie name other than DefaultCSRFCookieName
+func WithCSRFCookieName(name string) CSRFOption {
+	return func(c *CSRF) {
+		c.cookieName = name
+	}
+}
+
+// WithCSRFInsecureCookie allows the cookie to be sent over plain http. Do not use in production
+func WithCSRFInsecureCookie() CSRFOption {
+	return func(c *CSRF) {
+		c.secure = false
+	}
+}
+
+// key is used to sign the tokens, it must be kept secret
+func NewCSRF(key []byte, options ...CSRFOption) *CSRF {
+	// derive a dedicated key, so the given key can be shared with other components
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte("csrf"))
+
+	c := &CSRF{
+		key:        mac.Sum(nil),
+		cookieName: DefaultCSRFCookieName,
+		secure:     true,
+	}
+	for _, option := range options {
+		option(c)
+	}
+	return c
+}
+
+// Returns the CSRF token of the request, to be embedded in the forms of the rendered page
+func CSRFToken(ctx context.Context) string {
+	token, _ := ctx.Value(util.CSRFTokenContextKey).(string)
+	return token
+}
+
+/*
+This middleware
+  - issues the session cookie if the browser doesn't have one yet
+  - provides the token in the context, see CSRFToken
+  - rejects state-changing requests (anything but GET, HEAD, OPTIONS, TRACE) without a valid token, either in the
+    csrf_token form field or in the X-Csrf-Token header
+
+Response:
+  - 403 if the token is missing or invalid
+*/
+func (c *CSRF) Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+		logger := util.DefaultLogger.FromContext(ctx)
+
+		sessionID := ""
+		if cookie, err := r.Cookie(c.cookieName); err == nil {
+			sessionID = cookie.Value
+		}
+
+		if !isSafeMethod(r.Method) {
+			if sessionID == "" || !c.valid(sessionID, submittedToken(r)) {
+				logger.Warn("invalid csrf token", "method", r.Method, "path", r.URL.Path)
+				http.Error(w, "invalid csrf token", http.StatusForbidden)
+				return
+			}
+		}
+
+		if sessionID == "" {
+			var err error
+			if sessionID, err = c.newSession(w); err != nil {
+				logger.Error("could not generate csrf session", "error", err)
+				http.Error(w, "internal server error", http.StatusInternalServerError)
+				return
+			}
+		}
+
+		ctx = context.WithValue(ctx, util.CSRFTokenContextKey, c.token(sessionID))
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
+
+/*
+This middleware issues a new session cookie before serving the request. It wraps the routes changing the authenticated
+user, i.e. the login callback, so a session planted in the browser before the login (and the token an attacker derived
+from it) isn't valid afterwards
+
+Response:
+  - 500 if the session can't be generated
+*/
+func (c *CSRF) Rotate(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if _, err := c.newSession(w); err != nil {
+			util.DefaultLogger.FromContext(r.Context()).Error("could not generate csrf session", "error", err)
+			http.Error(w, "internal server error", http.StatusInternalServerError)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// generate a session id and set it in the cookie
+func (c *CSRF) newSession(w http.ResponseWriter) (string, error) {
+	sessionID, err := randomString(32)
+	if err != nil {
+		return "", err
+	}
+	http.SetCookie(w, &http.Cookie{
+		Name:     c.cookieName,
+		Value:    sessionID,
+		Path:     "/",
+		Secure:   c.secure,
+		HttpOnly: true,
+		SameSite: http.SameSiteLaxMode,
+	})
+	return sessionID, nil
+}
+
+func (c *CSRF) token(sessionID string) string {
+	mac := hmac.New(sha256.New, c.key)
+	mac.Write([]byte(sessionID))
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+func (c *CSRF) valid(sessionID, token string) bool {
+	if token == "" {
+		return false
+	}
+	return hmac.Equal([]byte(token), []byte(c.token(sessionID)))
+}
+
+// the header takes precedence, so the body is only parsed for html forms
+func submittedToken(r *http.Request) string {
+	if token := r.Header.Get(util.HeaderCSRFToken); token != "" {
+		return token
+	}
+	return r.PostFormValue(CSRFFormField)
+}
+
+// methods that must not change any state, see RFC 9110 section 9.2.1
+func isSafeMethod(method string) bool {
+	switch method {
+	case http.MethodGet, http.MethodHead, http.MethodOptions, http.MethodTrace:
+		return true
+	default:
+		return false
+	}
+}
+
+// url safe random string built out of n random bytes
+func randomString(n int) (string, error) {
+	b := make([]byte, n)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
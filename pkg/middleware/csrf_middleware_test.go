@@ -0,0 +1,165 @@
+package middleware
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/xaviercrochet/turbo-octo-adventure/pkg/util"
+)
+
+func TestCSRF(t *testing.T) {
+	csrf := NewCSRF([]byte("secret"))
+
+	var token string
+	handler := csrf.Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		token = CSRFToken(r.Context())
+	}))
+
+	// a first GET issues the session cookie and the token
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/feed", nil))
+	cookies := rec.Result().Cookies()
+	if len(cookies) != 1 || cookies[0].Name != DefaultCSRFCookieName || !cookies[0].HttpOnly || !cookies[0].Secure {
+		t.Fatalf("cookies = %v, expected a secure http only %v cookie", cookies, DefaultCSRFCookieName)
+	}
+	if token == "" {
+		t.Fatal("CSRFToken() is empty")
+	}
+	session := cookies[0]
+
+	otherSession := &http.Cookie{Name: DefaultCSRFCookieName, Value: "other"}
+
+	tests := []struct {
+		name   string
+		cookie *http.Cookie
+		form   string
+		header string
+		status int
+	}{
+		{
+			name:   "token in form",
+			cookie: session,
+			form:   url.Values{CSRFFormField: {token}, "name": {"alice"}}.Encode(),
+			status: http.StatusOK,
+		},
+		{
+			name:   "token in header",
+			cookie: session,
+			header: token,
+			status: http.StatusOK,
+		},
+		{
+			name:   "missing token",
+			cookie: session,
+			form:   url.Values{"name": {"alice"}}.Encode(),
+			status: http.StatusForbidden,
+		},
+		{
+			name:   "invalid token",
+			cookie: session,
+			form:   url.Values{CSRFFormField: {"forged"}}.Encode(),
+			status: http.StatusForbidden,
+		},
+		{
+			name:   "token of another session",
+			cookie: otherSession,
+			form:   url.Values{CSRFFormField: {token}}.Encode(),
+			status: http.StatusForbidden,
+		},
+		{
+			name:   "missing cookie",
+			form:   url.Values{CSRFFormField: {token}}.Encode(),
+			status: http.StatusForbidden,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodPost, "/select_feed", strings.NewReader(tt.form))
+			req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+			if tt.cookie != nil {
+				req.AddCookie(tt.cookie)
+			}
+			if tt.header != "" {
+				req.Header.Set(util.HeaderCSRFToken, tt.header)
+			}
+			rec := httptest.NewRecorder()
+
+			handler.ServeHTTP(rec, req)
+
+			if rec.Code != tt.status {
+				t.Errorf("status = %v, expected %v", rec.Code, tt.status)
+			}
+		})
+	}
+}
+
+func TestCSRFTokenIsStablePerSession(t *testing.T) {
+	csrf := NewCSRF([]byte("secret"), WithCSRFInsecureCookie())
+
+	var tokens []string
+	handler := csrf.Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		tokens = append(tokens, CSRFToken(r.Context()))
+	}))
+
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/", nil))
+	session := rec.Result().Cookies()[0]
+	if session.Secure {
+		t.Error("cookie is secure, expected an insecure cookie")
+	}
+
+	// an existing session is kept, so forms of pages opened in other tabs stay valid
+	req := httptest.NewRequest(http.MethodGet, "/feed", nil)
+	req.AddCookie(session)
+	rec = httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+
+	if len(rec.Result().Cookies()) != 0 {
+		t.Errorf("cookies = %v, expected the session to be kept", rec.Result().Cookies())
+	}
+	if tokens[0] != tokens[1] {
+		t.Errorf("tokens = %v, expected the same token for the same session", tokens)
+	}
+}
+
+func TestCSRFRotate(t *testing.T) {
+	csrf := NewCSRF([]byte("secret"))
+
+	var token string
+	handler := csrf.Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		token = CSRFToken(r.Context())
+	}))
+	login := csrf.Rotate(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Redirect(w, r, "/feed", http.StatusFound)
+	}))
+
+	// session planted before the login, i.e. by an attacker who knows its token
+	planted := &http.Cookie{Name: DefaultCSRFCookieName, Value: "planted"}
+	req := httptest.NewRequest(http.MethodGet, "/feed", nil)
+	req.AddCookie(planted)
+	handler.ServeHTTP(httptest.NewRecorder(), req)
+	plantedToken := token
+
+	req = httptest.NewRequest(http.MethodGet, "/auth/callback", nil)
+	req.AddCookie(planted)
+	rec := httptest.NewRecorder()
+	login.ServeHTTP(rec, req)
+	cookies := rec.Result().Cookies()
+	if rec.Code != http.StatusFound || len(cookies) != 1 || cookies[0].Value == planted.Value {
+		t.Fatalf("login = %v with cookies %v, expected a redirect with a new session", rec.Code, cookies)
+	}
+
+	// the browser now sends the new session, the planted token is rejected
+	req = httptest.NewRequest(http.MethodPost, "/select_feed", nil)
+	req.AddCookie(cookies[0])
+	req.Header.Set(util.HeaderCSRFToken, plantedToken)
+	rec = httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+	if rec.Code != http.StatusForbidden {
+		t.Errorf("status = %v with the token of the planted session, expected %v", rec.Code, http.StatusForbidden)
+	}
+}
@@ -0,0 +1,242 @@
+package middleware
+
+import (
+	"bufio"
+	"compress/gzip"
+	"io"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// gzip writers are reused across responses, they are expensive to allocate
+var gzipWriters = sync.Pool{
+	New: func() any {
+		return gzip.NewWriter(nil)
+	},
+}
+
+/*
+This middleware compresses the responses with gzip, if the client accepts it (see acceptsGzip)
+
+Responses without a body (HEAD, 204, 304) and responses already encoded by the handler are sent as they are.
+Strong ETags of compressed responses are weakened, the compressed bytes differ from the ones the ETag was computed on
+*/
+func Gzip(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// the response depends on the header, caches must know
+		w.Header().Add("Vary", "Accept-Encoding")
+
+		if r.Method == http.MethodHead || !acceptsGzip(r.Header.Get("Accept-Encoding")) {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		writer, gw := wrapGzipResponseWriter(w)
+		defer gw.close()
+		next.ServeHTTP(writer, r)
+	})
+}
+
+/*
+Returns true if the Accept-Encoding header allows gzip:
+
+	gzip, deflate        -> true
+	gzip;q=0, *          -> false
+	*;q=0.5              -> true
+	identity             -> false
+*/
+func acceptsGzip(header string) bool {
+	gzipQ, anyQ := -1.0, -1.0
+	for _, part := range strings.Split(header, ",") {
+		coding, params, _ := strings.Cut(part, ";")
+		q := 1.0
+		if value, ok := strings.CutPrefix(strings.TrimSpace(params), "q="); ok {
+			parsed, err := strconv.ParseFloat(value, 64)
+			if err != nil {
+				continue
+			}
+			q = parsed
+		}
+
+		switch strings.ToLower(strings.TrimSpace(coding)) {
+		case "gzip", "x-gzip":
+			gzipQ = q
+		case "*":
+			anyQ = q
+		}
+	}
+
+	// an explicit gzip wins over *
+	if gzipQ >= 0 {
+		return gzipQ > 0
+	}
+	return anyQ > 0
+}
+
+/*
+Compresses what the handler writes, once it knows the response has a body
+
+Like responseWriter, http.Flusher, http.Hijacker and io.ReaderFrom are only exposed if the underlying writer implements
+them, see wrapGzipResponseWriter
+*/
+type gzipResponseWriter struct {
+	http.ResponseWriter
+	gz          *gzip.Writer
+	wroteHeader bool
+}
+
+/*
+Returns the writer to pass to the next handler, and the gzipResponseWriter it is built on to close the compressed stream.
+The returned writer implements the optional interfaces of w, and only those
+*/
+func wrapGzipResponseWriter(w http.ResponseWriter) (http.ResponseWriter, *gzipResponseWriter) {
+	gw := &gzipResponseWriter{ResponseWriter: w}
+
+	_, isFlusher := w.(http.Flusher)
+	_, isHijacker := w.(http.Hijacker)
+	_, isReaderFrom := w.(io.ReaderFrom)
+
+	switch {
+	case isFlusher && isHijacker && isReaderFrom:
+		return struct {
+			*gzipResponseWriter
+			gzipFlusher
+			gzipHijacker
+			gzipReaderFrom
+		}{gw, gzipFlusher{gw}, gzipHijacker{gw}, gzipReaderFrom{gw}}, gw
+	case isFlusher && isHijacker:
+		return struct {
+			*gzipResponseWriter
+			gzipFlusher
+			gzipHijacker
+		}{gw, gzipFlusher{gw}, gzipHijacker{gw}}, gw
+	case isFlusher && isReaderFrom:
+		return struct {
+			*gzipResponseWriter
+			gzipFlusher
+			gzipReaderFrom
+		}{gw, gzipFlusher{gw}, gzipReaderFrom{gw}}, gw
+	case isHijacker && isReaderFrom:
+		return struct {
+			*gzipResponseWriter
+			gzipHijacker
+			gzipReaderFrom
+		}{gw, gzipHijacker{gw}, gzipReaderFrom{gw}}, gw
+	case isFlusher:
+		return struct {
+			*gzipResponseWriter
+			gzipFlusher
+		}{gw, gzipFlusher{gw}}, gw
+	case isHijacker:
+		return struct {
+			*gzipResponseWriter
+			gzipHijacker
+		}{gw, gzipHijacker{gw}}, gw
+	case isReaderFrom:
+		return struct {
+			*gzipResponseWriter
+			gzipReaderFrom
+		}{gw, gzipReaderFrom{gw}}, gw
+	default:
+		return gw, gw
+	}
+}
+
+func (w *gzipResponseWriter) WriteHeader(code int) {
+	if w.wroteHeader {
+		return
+	}
+	// informational responses (i.e. 103 Early Hints) precede the final one, which decides the encoding
+	if informational(code) {
+		w.ResponseWriter.WriteHeader(code)
+		return
+	}
+	w.wroteHeader = true
+
+	header := w.Header()
+	if code != http.StatusNoContent && code != http.StatusNotModified && code >= http.StatusOK && header.Get("Content-Encoding") == "" {
+		header.Set("Content-Encoding", "gzip")
+		// the length of the compressed body isn't known in advance
+		header.Del("Content-Length")
+		if tag := header.Get("ETag"); tag != "" && !strings.HasPrefix(tag, "W/") {
+			header.Set("ETag", "W/"+tag)
+		}
+
+		w.gz = gzipWriters.Get().(*gzip.Writer)
+		w.gz.Reset(w.ResponseWriter)
+	}
+
+	w.ResponseWriter.WriteHeader(code)
+}
+
+func (w *gzipResponseWriter) Write(b []byte) (int, error) {
+	if !w.wroteHeader {
+		// same as net/http, the content type is sniffed from the uncompressed body
+		if w.Header().Get("Content-Type") == "" {
+			w.Header().Set("Content-Type", http.DetectContentType(b))
+		}
+		w.WriteHeader(http.StatusOK)
+	}
+	if w.gz == nil {
+		return w.ResponseWriter.Write(b)
+	}
+	return w.gz.Write(b)
+}
+
+// Lets http.ResponseController reach the underlying writer, i.e. to set deadlines
+func (w *gzipResponseWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
+
+// Writes the end of the compressed stream
+func (w *gzipResponseWriter) close() {
+	if w.gz == nil {
+		return
+	}
+	w.gz.Close()
+	w.gz.Reset(nil)
+	gzipWriters.Put(w.gz)
+	w.gz = nil
+}
+
+// http.Flusher, only exposed if the underlying writer implements it
+type gzipFlusher struct {
+	w *gzipResponseWriter
+}
+
+// Sends what was compressed so far, i.e. for streamed responses
+func (f gzipFlusher) Flush() {
+	if !f.w.wroteHeader {
+		f.w.WriteHeader(http.StatusOK)
+	}
+	if f.w.gz != nil {
+		f.w.gz.Flush()
+	}
+	f.w.ResponseWriter.(http.Flusher).Flush()
+}
+
+// http.Hijacker, only exposed if the underlying writer implements it
+type gzipHijacker struct {
+	w *gzipResponseWriter
+}
+
+func (h gzipHijacker) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	return h.w.ResponseWriter.(http.Hijacker).Hijack()
+}
+
+// io.ReaderFrom, only exposed if the underlying writer implements it
+type gzipReaderFrom struct {
+	w *gzipResponseWriter
+}
+
+// Uncompressed responses are sent by the underlying writer, i.e. with sendfile. Anything else goes through Write
+func (r gzipReaderFrom) ReadFrom(src io.Reader) (int64, error) {
+	if r.w.wroteHeader && r.w.gz == nil {
+		return r.w.ResponseWriter.(io.ReaderFrom).ReadFrom(src)
+	}
+	// hides ReadFrom, io.Copy would call it again
+	return io.Copy(struct{ io.Writer }{r.w}, src)
+}
@@ -0,0 +1,254 @@
+package middleware
+
+import (
+	"compress/gzip"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestAcceptsGzip(t *testing.T) {
+	tests := map[string]bool{
+		"":                     false,
+		"gzip":                 true,
+		"deflate, gzip;q=0.8":  true,
+		"GZIP":                 true,
+		"x-gzip":               true,
+		"identity":             false,
+		"gzip;q=0":             false,
+		"gzip;q=0, *":          false,
+		"*":                    true,
+		"*;q=0.5, br":          true,
+		"*;q=0":                false,
+		"br, gzip;q=invalid":   false,
+		"deflate;q=1, gzip;q=": false,
+	}
+
+	for header, expected := range tests {
+		if acceptsGzip(header) != expected {
+			t.Errorf("acceptsGzip(%q) = %v, expected %v", header, !expected, expected)
+		}
+	}
+}
+
+func TestGzip(t *testing.T) {
+	body := strings.Repeat(`{"title": "One More Time"}`, 100)
+
+	tests := []struct {
+		name           string
+		method         string
+		acceptEncoding string
+		handler        http.HandlerFunc
+		compressed     bool
+		etag           string
+	}{
+		{
+			name:           "compressed",
+			method:         http.MethodGet,
+			acceptEncoding: "gzip",
+			handler: func(w http.ResponseWriter, r *http.Request) {
+				w.Header().Set("ETag", `"abc"`)
+				w.Header().Set("Content-Length", "2600")
+				io.WriteString(w, body)
+			},
+			compressed: true,
+			etag:       `W/"abc"`,
+		},
+		{
+			name:           "not accepted",
+			method:         http.MethodGet,
+			acceptEncoding: "identity",
+			handler: func(w http.ResponseWriter, r *http.Request) {
+				w.Header().Set("ETag", `"abc"`)
+				io.WriteString(w, body)
+			},
+			etag: `"abc"`,
+		},
+		{
+			name:           "not modified",
+			method:         http.MethodGet,
+			acceptEncoding: "gzip",
+			handler: func(w http.ResponseWriter, r *http.Request) {
+				w.Header().Set("ETag", `"abc"`)
+				w.WriteHeader(http.StatusNotModified)
+			},
+			etag: `"abc"`,
+		},
+		{
+			name:           "already encoded",
+			method:         http.MethodGet,
+			acceptEncoding: "gzip",
+			handler: func(w http.ResponseWriter, r *http.Request) {
+				w.Header().Set("Content-Encoding", "br")
+				io.WriteString(w, body)
+			},
+		},
+		{
+			name:           "head",
+			method:         http.MethodHead,
+			acceptEncoding: "gzip",
+			handler: func(w http.ResponseWriter, r *http.Request) {
+				w.WriteHeader(http.StatusOK)
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(tt.method, "/", nil)
+			req.Header.Set("Accept-Encoding", tt.acceptEncoding)
+			rec := httptest.NewRecorder()
+			Gzip(tt.handler).ServeHTTP(rec, req)
+
+			res := rec.Result()
+			if res.Header.Get("Vary") != "Accept-Encoding" {
+				t.Errorf("Vary = %q, expected Accept-Encoding", res.Header.Get("Vary"))
+			}
+			if res.Header.Get("ETag") != tt.etag {
+				t.Errorf("ETag = %q, expected %q", res.Header.Get("ETag"), tt.etag)
+			}
+			if (res.Header.Get("Content-Encoding") == "gzip") != tt.compressed {
+				t.Fatalf("Content-Encoding = %q, compressed expected %v", res.Header.Get("Content-Encoding"), tt.compressed)
+			}
+			if !tt.compressed {
+				return
+			}
+
+			if res.Header.Get("Content-Length") != "" {
+				t.Errorf("Content-Length = %q, expected none", res.Header.Get("Content-Length"))
+			}
+			if rec.Body.Len() >= len(body) {
+				t.Errorf("%d bytes sent, expected less than %d", rec.Body.Len(), len(body))
+			}
+			reader, err := gzip.NewReader(rec.Body)
+			if err != nil {
+				t.Fatal(err)
+			}
+			data, err := io.ReadAll(reader)
+			if err != nil || string(data) != body {
+				t.Errorf("decompressed body = %q, %v", data, err)
+			}
+		})
+	}
+}
+
+func TestGzipFlush(t *testing.T) {
+	flushed := make(chan struct{})
+	server := httptest.NewServer(Gzip(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, "first")
+		http.NewResponseController(w).Flush()
+		// the client reads the first part before the handler returns
+		<-flushed
+		io.WriteString(w, "second")
+	})))
+	defer server.Close()
+
+	res, err := server.Client().Get(server.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer res.Body.Close()
+	// decompressed by the transport
+	if !res.Uncompressed {
+		t.Fatal("the response should have been compressed")
+	}
+
+	first := make([]byte, len("first"))
+	if _, err := io.ReadFull(res.Body, first); err != nil || string(first) != "first" {
+		t.Fatalf("read %q, %v", first, err)
+	}
+	close(flushed)
+
+	rest, err := io.ReadAll(res.Body)
+	if err != nil || string(rest) != "second" {
+		t.Errorf("read %q, %v", rest, err)
+	}
+}
+
+func TestWrapGzipResponseWriter(t *testing.T) {
+	for i := 0; i < 8; i++ {
+		flush, hijack, readFrom := i&1 != 0, i&2 != 0, i&4 != 0
+		underlying, fake := newFakeWriter(flush, hijack, readFrom)
+		writer, gw := wrapGzipResponseWriter(underlying)
+
+		f, isFlusher := writer.(http.Flusher)
+		h, isHijacker := writer.(http.Hijacker)
+		r, isReaderFrom := writer.(io.ReaderFrom)
+		if isFlusher != flush || isHijacker != hijack || isReaderFrom != readFrom {
+			t.Errorf("flusher, hijacker, reader from: got %v %v %v, expected %v %v %v",
+				isFlusher, isHijacker, isReaderFrom, flush, hijack, readFrom)
+			continue
+		}
+
+		if unwrapped := writer.(interface{ Unwrap() http.ResponseWriter }).Unwrap(); unwrapped != underlying {
+			t.Errorf("Unwrap should return the underlying writer")
+		}
+
+		if hijack {
+			if _, _, err := h.Hijack(); err != nil || !fake.hijacked {
+				t.Errorf("Hijack should be forwarded, got %v", err)
+			}
+		}
+		if readFrom {
+			// compressed, so it can't be forwarded
+			if n, err := r.ReadFrom(strings.NewReader("hello")); n != 5 || err != nil {
+				t.Errorf("ReadFrom = %d, %v", n, err)
+			}
+			if fake.readFrom || fake.header.Get("Content-Encoding") != "gzip" {
+				t.Errorf("ReadFrom should compress the body")
+			}
+		}
+		if flush {
+			f.Flush()
+			if !fake.flushed || fake.status != http.StatusOK {
+				t.Errorf("Flush should be forwarded and send the headers")
+			}
+		}
+		gw.close()
+
+		err := http.NewResponseController(writer).Flush()
+		if flush && err != nil {
+			t.Errorf("ResponseController.Flush = %v", err)
+		}
+		if !flush && err == nil {
+			t.Errorf("ResponseController.Flush should not be supported")
+		}
+	}
+}
+
+func TestGzipForwardsInformationalResponses(t *testing.T) {
+	underlying, fake := newFakeWriter(false, false, false)
+	writer, gw := wrapGzipResponseWriter(underlying)
+
+	writer.Header().Set("Link", "</static/app.css>; rel=preload")
+	writer.WriteHeader(http.StatusEarlyHints)
+	writer.WriteHeader(http.StatusOK)
+	io.WriteString(writer, "hello")
+	gw.close()
+
+	if !slices.Equal(fake.statuses, []int{http.StatusEarlyHints, http.StatusOK}) {
+		t.Errorf("statuses = %v, expected 103 then 200", fake.statuses)
+	}
+	if fake.header.Get("Content-Encoding") != "gzip" {
+		t.Errorf("the final response should be compressed")
+	}
+}
+
+func TestGzipReadFromEncodedResponse(t *testing.T) {
+	underlying, fake := newFakeWriter(false, false, true)
+	writer, gw := wrapGzipResponseWriter(underlying)
+	defer gw.close()
+
+	// encoded by the handler, the underlying writer sends it as it is
+	writer.Header().Set("Content-Encoding", "br")
+	writer.WriteHeader(http.StatusOK)
+	if n, err := writer.(io.ReaderFrom).ReadFrom(strings.NewReader("hello")); n != 5 || err != nil {
+		t.Errorf("ReadFrom = %d, %v", n, err)
+	}
+	if !fake.readFrom || fake.body.String() != "hello" {
+		t.Errorf("ReadFrom should be forwarded, got %q", fake.body.String())
+	}
+}
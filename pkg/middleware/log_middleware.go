@@ -1,78 +1,290 @@
 package middleware
 
 import (
+	"context"
+	"fmt"
+	"io"
+	"net"
 	"net/http"
+	"net/netip"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
 	"time"
 
 	"github.com/xaviercrochet/turbo-octo-adventure/pkg/util"
 )
 
-// Wrap the response to capture the status code
-type responseWriter struct {
-	http.ResponseWriter
-	status int
-	// prevent multiple response.WriteHeader clals
-	wroteHeader bool
+// How the access log entries are written
+type AccessLogFormat string
+
+const (
+	// through util.DefaultLogger, so the entries are redacted and sampled like every other log
+	AccessLogStructured AccessLogFormat = "structured"
+	// Common Log Format, the format of the Apache and nginx access logs
+	AccessLogCommon AccessLogFormat = "common"
+	// Common Log Format followed by the referer and the user agent
+	AccessLogCombined AccessLogFormat = "combined"
+)
+
+func ParseAccessLogFormat(value string) (AccessLogFormat, error) {
+	format := AccessLogFormat(strings.ToLower(value))
+	switch format {
+	case AccessLogStructured, AccessLogCommon, AccessLogCombined:
+		return format, nil
+	default:
+		return "", fmt.Errorf("unknown access log format %q, expected structured, common or combined", value)
+	}
 }
 
-func wrapResponseWriter(w http.ResponseWriter) *responseWriter {
-	return &responseWriter{ResponseWriter: w}
+// Parse a comma separated list of addresses (i.e. 10.0.0.1) and networks (i.e. 10.0.0.0/8)
+func ParseTrustedProxies(value string) ([]netip.Prefix, error) {
+	var proxies []netip.Prefix
+	for _, proxy := range strings.Split(value, ",") {
+		proxy = strings.TrimSpace(proxy)
+		if proxy == "" {
+			continue
+		}
+		if strings.Contains(proxy, "/") {
+			prefix, err := netip.ParsePrefix(proxy)
+			if err != nil {
+				return nil, fmt.Errorf("invalid trusted proxy %q: %w", proxy, err)
+			}
+			proxies = append(proxies, prefix.Masked())
+			continue
+		}
+		addr, err := netip.ParseAddr(proxy)
+		if err != nil {
+			return nil, fmt.Errorf("invalid trusted proxy %q: %w", proxy, err)
+		}
+		addr = addr.Unmap()
+		proxies = append(proxies, netip.PrefixFrom(addr, addr.BitLen()))
+	}
+	return proxies, nil
 }
 
-func (rw *responseWriter) Status() int {
-	return rw.status
+// Access log configuration, the zero value logs structured entries and trusts no proxy
+type AccessLog struct {
+	// the client address is read from X-Forwarded-For or Forwarded only when the request comes from these proxies
+	TrustedProxies []netip.Prefix
+	// AccessLogStructured if empty
+	Format AccessLogFormat
+	// where the common and combined lines are written, os.Stdout if nil. Must be safe for concurrent writes
+	Output io.Writer
 }
 
-// Ensure the status code is written, as it is not the case when response is 200 (see https://pkg.go.dev/net/http#ResponseWriter)
-func (rw *responseWriter) Write(b []byte) (int, error) {
-	// Prevent  WriteHeader to be called multiple times
-	if !rw.wroteHeader {
-		rw.WriteHeader(http.StatusOK)
-	}
-	return rw.ResponseWriter.Write(b)
+// Structured entries, no trusted proxy
+func DefaultAccessLog() *AccessLog {
+	return &AccessLog{Format: AccessLogStructured}
+}
+
+// the entry of the request being served, completed by the handlers, see SetAccessLogUser
+type accessLogEntry struct {
+	userID string
 }
 
-// Capture the status code of the request
-func (rw *responseWriter) WriteHeader(code int) {
-	// Prevent  WriteHeader to be called multiple times
-	if rw.wroteHeader {
-		return
+type accessLogEntryKey struct{}
+
+// Record the authenticated user of the request, it is logged once the request is completed
+func SetAccessLogUser(ctx context.Context, userID string) {
+	if entry, ok := ctx.Value(accessLogEntryKey{}).(*accessLogEntry); ok {
+		entry.userID = userID
 	}
-	rw.status = code
-	rw.wroteHeader = true
-	rw.ResponseWriter.WriteHeader(code)
 }
 
 /*
-This middleware log metadata about the request. It also generates a unique id to identify requests
+This middleware logs metadata about the request once it is completed, with the default configuration (see
+AccessLog.Middleware)
+*/
+func LogMiddleware(next http.Handler) http.Handler {
+	return DefaultAccessLog().Middleware(next)
+}
+
+/*
+This middleware log metadata about the request once it is completed
 
 Logged fields:
   - HTTP Verb
-  - Request path
-  - Request status code
+  - Matched route pattern (the request path if it wasn't routed by a http.ServeMux)
+  - Request status code and number of bytes of the body
+  - Whether the response was flushed or the connection hijacked
   - Request trace id
   - Duration
+  - Client address, see TrustedProxies
+  - User agent
+  - Authenticated user, see SetAccessLogUser
 */
-
-func LogMiddleware(next http.Handler) http.Handler {
+func (a *AccessLog) Middleware(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		start := time.Now()
-		wrapped := wrapResponseWriter(w)
+		writer, wrapped := wrapResponseWriter(w)
 
-		ctx := r.Context()
+		entry := &accessLogEntry{}
+		ctx := context.WithValue(r.Context(), accessLogEntryKey{}, entry)
 		logger := util.DefaultLogger.FromContext(ctx)
 
 		// Process request...
-		next.ServeHTTP(wrapped, r)
+		next.ServeHTTP(writer, r.WithContext(ctx))
 
 		duration := time.Since(start)
+		status := wrapped.Status()
+		// nothing was written, net/http answers 200. Unknown if the connection was hijacked
+		if status == 0 && !wrapped.Hijacked() {
+			status = http.StatusOK
+		}
 
-		// Do log when the request is completed
-		logger.Info("http request completed",
+		route := r.Pattern
+		if route == "" {
+			route = r.URL.Path
+		}
+
+		if a.Format == AccessLogCommon || a.Format == AccessLogCombined {
+			a.writeLine(r, start, status, wrapped.BytesWritten(), entry.userID)
+			return
+		}
+
+		attrs := []any{
 			"method", r.Method,
-			"path", r.URL.Path,
-			"status", wrapped.Status(),
+			"route", route,
+			"status", status,
+			"bytes", wrapped.BytesWritten(),
 			"duration_ms", duration.Milliseconds(),
-		)
+			"client_ip", a.clientIP(r),
+			"user_agent", r.UserAgent(),
+			"user_id", entry.userID,
+		}
+		// only logged when set, they are rare
+		if wrapped.Flushed() {
+			attrs = append(attrs, "flushed", true)
+		}
+		if wrapped.Hijacked() {
+			attrs = append(attrs, "hijacked", true)
+		}
+
+		// Do log when the request is completed
+		logger.Info("http request completed", attrs...)
 	})
 }
+
+/*
+Writes the request in the Common or Combined Log Format:
+
+	127.0.0.1 - 9f86d081884c7d65 [10/Oct/2026:13:55:36 +0000] "GET /api/feed HTTP/1.1" 200 2326 "https://feed.example/" "Mozilla/5.0"
+
+The query strings of the request and of the referer are left out, they may hold tokens. The user id is hashed like the
+user_id of the structured entries
+*/
+func (a *AccessLog) writeLine(r *http.Request, start time.Time, status int, bytes int64, userID string) {
+	size := "-"
+	if bytes > 0 {
+		size = strconv.FormatInt(bytes, 10)
+	}
+
+	var line strings.Builder
+	fmt.Fprintf(&line, "%s - %s [%s] %s %d %s",
+		a.clientIP(r),
+		orDash(util.DefaultLogger.Redact("user_id", userID)),
+		start.Format("02/Jan/2006:15:04:05 -0700"),
+		strconv.Quote(r.Method+" "+r.URL.EscapedPath()+" "+r.Proto),
+		status,
+		size,
+	)
+	if a.Format == AccessLogCombined {
+		fmt.Fprintf(&line, " %s %s", strconv.Quote(orDash(withoutQuery(r.Referer()))), strconv.Quote(orDash(r.UserAgent())))
+	}
+	line.WriteByte('\n')
+
+	output := a.Output
+	if output == nil {
+		output = os.Stdout
+	}
+	// a single write, so concurrent lines aren't interleaved
+	io.WriteString(output, line.String())
+}
+
+func orDash(value string) string {
+	if value == "" {
+		return "-"
+	}
+	return value
+}
+
+func withoutQuery(rawURL string) string {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return ""
+	}
+	u.RawQuery, u.ForceQuery, u.Fragment, u.RawFragment, u.User = "", false, "", "", nil
+	return u.String()
+}
+
+/*
+Returns the address of the client
+
+The forwarded addresses are read from right to left, the first one that isn't a trusted proxy is the client. Headers
+sent by anyone else could be forged, they are ignored
+*/
+func (a *AccessLog) clientIP(r *http.Request) string {
+	client, ok := parseAddr(r.RemoteAddr)
+	if !ok {
+		return r.RemoteAddr
+	}
+
+	hops := forwardedFor(r.Header)
+	for i := len(hops) - 1; i >= 0 && a.trusted(client); i-- {
+		hop, ok := parseAddr(hops[i])
+		// obfuscated or unknown, the last trusted proxy is the best we know
+		if !ok {
+			break
+		}
+		client = hop
+	}
+	return client.String()
+}
+
+func (a *AccessLog) trusted(addr netip.Addr) bool {
+	for _, proxy := range a.TrustedProxies {
+		if proxy.Contains(addr) {
+			return true
+		}
+	}
+	return false
+}
+
+// The forwarded addresses, the client first. Forwarded (RFC 7239) takes precedence over X-Forwarded-For
+func forwardedFor(header http.Header) []string {
+	var hops []string
+	for _, value := range header.Values("Forwarded") {
+		for _, element := range strings.Split(value, ",") {
+			for _, pair := range strings.Split(element, ";") {
+				name, value, ok := strings.Cut(strings.TrimSpace(pair), "=")
+				if ok && strings.EqualFold(name, "for") {
+					hops = append(hops, strings.Trim(value, `"`))
+				}
+			}
+		}
+	}
+	if len(hops) > 0 {
+		return hops
+	}
+
+	for _, value := range header.Values("X-Forwarded-For") {
+		for _, hop := range strings.Split(value, ",") {
+			hops = append(hops, strings.TrimSpace(hop))
+		}
+	}
+	return hops
+}
+
+// Parse an address with an optional port, i.e. 192.0.2.1:8080 or [2001:db8::1]:8080
+func parseAddr(value string) (netip.Addr, bool) {
+	if host, _, err := net.SplitHostPort(value); err == nil {
+		value = host
+	}
+	addr, err := netip.ParseAddr(strings.Trim(value, "[]"))
+	if err != nil {
+		return netip.Addr{}, false
+	}
+	return addr.Unmap(), true
+}
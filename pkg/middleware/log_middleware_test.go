@@ -0,0 +1,248 @@
+package middleware
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"testing"
+
+	"github.com/xaviercrochet/turbo-octo-adventure/pkg/util"
+)
+
+func TestParseTrustedProxies(t *testing.T) {
+	proxies, err := ParseTrustedProxies(" 10.0.0.0/8, 192.0.2.1,::ffff:198.51.100.7, 2001:db8::/32,")
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []string{"10.0.0.0/8", "192.0.2.1/32", "198.51.100.7/32", "2001:db8::/32"}
+	if len(proxies) != len(expected) {
+		t.Fatalf("got %v, expected %v", proxies, expected)
+	}
+	for i, proxy := range proxies {
+		if proxy.String() != expected[i] {
+			t.Errorf("proxy %d = %s, expected %s", i, proxy, expected[i])
+		}
+	}
+
+	for _, value := range []string{"10.0.0.0/33", "proxy.internal"} {
+		if _, err := ParseTrustedProxies(value); err == nil {
+			t.Errorf("%q should be rejected", value)
+		}
+	}
+}
+
+func TestClientIP(t *testing.T) {
+	proxies, err := ParseTrustedProxies("10.0.0.0/8, 2001:db8::1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	accessLog := &AccessLog{TrustedProxies: proxies}
+
+	tests := []struct {
+		name       string
+		remoteAddr string
+		header     http.Header
+		expected   string
+	}{
+		{
+			name:       "no proxy",
+			remoteAddr: "203.0.113.9:4242",
+			expected:   "203.0.113.9",
+		},
+		{
+			name:       "untrusted peer",
+			remoteAddr: "203.0.113.9:4242",
+			header:     http.Header{"X-Forwarded-For": {"198.51.100.1"}},
+			expected:   "203.0.113.9",
+		},
+		{
+			name:       "trusted proxy",
+			remoteAddr: "10.0.0.2:4242",
+			header:     http.Header{"X-Forwarded-For": {"198.51.100.1"}},
+			expected:   "198.51.100.1",
+		},
+		{
+			name:       "forged by the client",
+			remoteAddr: "10.0.0.2:4242",
+			header:     http.Header{"X-Forwarded-For": {"1.2.3.4, 198.51.100.1", "10.0.0.3"}},
+			expected:   "198.51.100.1",
+		},
+		{
+			name:       "every hop trusted",
+			remoteAddr: "10.0.0.2:4242",
+			header:     http.Header{"X-Forwarded-For": {"10.0.0.4, 10.0.0.3"}},
+			expected:   "10.0.0.4",
+		},
+		{
+			name:       "forwarded",
+			remoteAddr: "[2001:db8::1]:4242",
+			header: http.Header{
+				"Forwarded":       {`for="[2001:db8:cafe::17]:4711";proto=https, for=10.0.0.3`},
+				"X-Forwarded-For": {"198.51.100.1"},
+			},
+			expected: "2001:db8:cafe::17",
+		},
+		{
+			name:       "obfuscated",
+			remoteAddr: "10.0.0.2:4242",
+			header:     http.Header{"Forwarded": {"for=_hidden, for=10.0.0.3"}},
+			expected:   "10.0.0.3",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, "/", nil)
+			req.RemoteAddr = tt.remoteAddr
+			req.Header = tt.header
+			if req.Header == nil {
+				req.Header = http.Header{}
+			}
+			if ip := accessLog.clientIP(req); ip != tt.expected {
+				t.Errorf("clientIP = %s, expected %s", ip, tt.expected)
+			}
+		})
+	}
+}
+
+// Replace the default logger by one writing json to a file, returns a function reading the records
+func captureLogs(t *testing.T) func() []map[string]any {
+	path := filepath.Join(t.TempDir(), "test.log")
+	logger, closer, err := util.NewLoggerWithConfig(&util.LogConfig{Format: util.LogFormatJSON, File: path})
+	if err != nil {
+		t.Fatal(err)
+	}
+	previous := util.DefaultLogger
+	util.SetDefaultLogger(logger)
+	t.Cleanup(func() {
+		util.SetDefaultLogger(previous)
+		closer.Close()
+	})
+
+	return func() []map[string]any {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var records []map[string]any
+		decoder := json.NewDecoder(bytes.NewReader(data))
+		for decoder.More() {
+			var record map[string]any
+			if err := decoder.Decode(&record); err != nil {
+				t.Fatal(err)
+			}
+			records = append(records, record)
+		}
+		return records
+	}
+}
+
+func TestAccessLogStructured(t *testing.T) {
+	records := captureLogs(t)
+
+	mux := http.NewServeMux()
+	mux.Handle("/api/feed/{format}", DefaultAccessLog().Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		SetAccessLogUser(r.Context(), "1234")
+		w.WriteHeader(http.StatusCreated)
+		io.WriteString(w, "hello")
+	})))
+
+	req := httptest.NewRequest(http.MethodGet, "/api/feed/alice?token=secret", nil)
+	req.RemoteAddr = "203.0.113.9:4242"
+	req.Header.Set("User-Agent", "feedctl/1.0")
+	mux.ServeHTTP(httptest.NewRecorder(), req)
+
+	logs := records()
+	if len(logs) != 1 {
+		t.Fatalf("expected 1 record, got %v", logs)
+	}
+	expected := map[string]any{
+		"method":     "GET",
+		"route":      "/api/feed/{format}",
+		"status":     float64(http.StatusCreated),
+		"bytes":      float64(5),
+		"client_ip":  "203.0.113.9",
+		"user_agent": "feedctl/1.0",
+		"user_id":    "1234",
+	}
+	for key, value := range expected {
+		if logs[0][key] != value {
+			t.Errorf("%s = %v, expected %v", key, logs[0][key], value)
+		}
+	}
+}
+
+func TestAccessLogCombined(t *testing.T) {
+	var output bytes.Buffer
+	accessLog := &AccessLog{Format: AccessLogCombined, Output: &output}
+	handler := accessLog.Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+
+	req := httptest.NewRequest(http.MethodGet, "/api/feed.atom?token=secret", nil)
+	req.RemoteAddr = "203.0.113.9:4242"
+	req.Header.Set("Referer", "https://feed.example/feed?token=secret")
+	req.Header.Set("User-Agent", `Reader "2"`)
+	handler.ServeHTTP(httptest.NewRecorder(), req)
+
+	line := regexp.MustCompile(`^203\.0\.113\.9 - - \[\d{2}/\w{3}/\d{4}:\d{2}:\d{2}:\d{2} [+-]\d{4}\] "GET /api/feed\.atom HTTP/1\.1" 200 - "https://feed\.example/feed" "Reader \\"2\\""\n$`)
+	if !line.MatchString(output.String()) {
+		t.Errorf("unexpected line %q", output.String())
+	}
+
+	output.Reset()
+	accessLog.Format = AccessLogCommon
+	handler.ServeHTTP(httptest.NewRecorder(), req)
+	if !regexp.MustCompile(`"GET /api/feed\.atom HTTP/1\.1" 200 -\n$`).MatchString(output.String()) {
+		t.Errorf("unexpected line %q", output.String())
+	}
+}
+
+func TestAccessLogCommonHashesTheUser(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "test.log")
+	redact, err := util.NewRedactConfig("", "user_id", "log-secret")
+	if err != nil {
+		t.Fatal(err)
+	}
+	logger, closer, err := util.NewLoggerWithConfig(&util.LogConfig{Format: util.LogFormatJSON, File: path, Redact: redact})
+	if err != nil {
+		t.Fatal(err)
+	}
+	previous := util.DefaultLogger
+	util.SetDefaultLogger(logger)
+	defer func() {
+		util.SetDefaultLogger(previous)
+		closer.Close()
+	}()
+
+	var output bytes.Buffer
+	accessLog := &AccessLog{Format: AccessLogCommon, Output: &output}
+	handler := accessLog.Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		SetAccessLogUser(r.Context(), "1234")
+	}))
+	handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/api/feed", nil))
+
+	// the same hash as in the structured entries, so both can be correlated
+	accessLog.Format = AccessLogStructured
+	handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/api/feed", nil))
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var record map[string]any
+	if err := json.Unmarshal(data, &record); err != nil {
+		t.Fatal(err)
+	}
+
+	hashed, _ := record["user_id"].(string)
+	if hashed == "" || hashed == "1234" {
+		t.Fatalf("user_id = %v, expected a hash", record["user_id"])
+	}
+	if !strings.HasPrefix(output.String(), "192.0.2.1 - "+hashed+" [") {
+		t.Errorf("unexpected line %q, expected the user %s", output.String(), hashed)
+	}
+}
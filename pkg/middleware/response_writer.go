@@ -0,0 +1,179 @@
+package middleware
+
+import (
+	"bufio"
+	"io"
+	"net"
+	"net/http"
+)
+
+/*
+Wrap the response to capture the status code and the size of the body
+
+Only Header, Write and WriteHeader are implemented by responseWriter itself. http.Flusher, http.Hijacker and
+io.ReaderFrom are only exposed if the underlying writer implements them, see wrapResponseWriter, so handlers checking
+for them behave as if they weren't wrapped
+*/
+type responseWriter struct {
+	http.ResponseWriter
+	status int
+	// prevent multiple response.WriteHeader clals
+	wroteHeader bool
+	bytes       int64
+	flushed     bool
+	hijacked    bool
+}
+
+/*
+Returns the writer to pass to the next handler, and the responseWriter it is built on to read what was written.
+The returned writer implements the optional interfaces of w, and only those
+*/
+func wrapResponseWriter(w http.ResponseWriter) (http.ResponseWriter, *responseWriter) {
+	rw := &responseWriter{ResponseWriter: w}
+
+	_, isFlusher := w.(http.Flusher)
+	_, isHijacker := w.(http.Hijacker)
+	_, isReaderFrom := w.(io.ReaderFrom)
+
+	switch {
+	case isFlusher && isHijacker && isReaderFrom:
+		return struct {
+			*responseWriter
+			flusher
+			hijacker
+			readerFrom
+		}{rw, flusher{rw}, hijacker{rw}, readerFrom{rw}}, rw
+	case isFlusher && isHijacker:
+		return struct {
+			*responseWriter
+			flusher
+			hijacker
+		}{rw, flusher{rw}, hijacker{rw}}, rw
+	case isFlusher && isReaderFrom:
+		return struct {
+			*responseWriter
+			flusher
+			readerFrom
+		}{rw, flusher{rw}, readerFrom{rw}}, rw
+	case isHijacker && isReaderFrom:
+		return struct {
+			*responseWriter
+			hijacker
+			readerFrom
+		}{rw, hijacker{rw}, readerFrom{rw}}, rw
+	case isFlusher:
+		return struct {
+			*responseWriter
+			flusher
+		}{rw, flusher{rw}}, rw
+	case isHijacker:
+		return struct {
+			*responseWriter
+			hijacker
+		}{rw, hijacker{rw}}, rw
+	case isReaderFrom:
+		return struct {
+			*responseWriter
+			readerFrom
+		}{rw, readerFrom{rw}}, rw
+	default:
+		return rw, rw
+	}
+}
+
+func (rw *responseWriter) Status() int {
+	return rw.status
+}
+
+// Number of bytes of the body written so far
+func (rw *responseWriter) BytesWritten() int64 {
+	return rw.bytes
+}
+
+// Whether the response was flushed before the handler returned, i.e. a stream
+func (rw *responseWriter) Flushed() bool {
+	return rw.flushed
+}
+
+// Whether the handler took over the connection, i.e. a websocket. The status and the size are then unknown
+func (rw *responseWriter) Hijacked() bool {
+	return rw.hijacked
+}
+
+// Ensure the status code is written, as it is not the case when response is 200 (see https://pkg.go.dev/net/http#ResponseWriter)
+func (rw *responseWriter) Write(b []byte) (int, error) {
+	// Prevent  WriteHeader to be called multiple times
+	if !rw.wroteHeader {
+		rw.WriteHeader(http.StatusOK)
+	}
+	n, err := rw.ResponseWriter.Write(b)
+	rw.bytes += int64(n)
+	return n, err
+}
+
+// Capture the status code of the request
+func (rw *responseWriter) WriteHeader(code int) {
+	// Prevent  WriteHeader to be called multiple times
+	if rw.wroteHeader {
+		return
+	}
+	// informational responses (i.e. 103 Early Hints) precede the final one, which is the status of the request
+	if informational(code) {
+		rw.ResponseWriter.WriteHeader(code)
+		return
+	}
+	rw.status = code
+	rw.wroteHeader = true
+	rw.ResponseWriter.WriteHeader(code)
+}
+
+// 1XX status codes that can be followed by other ones, 101 Switching Protocols is a final response
+func informational(code int) bool {
+	return code >= 100 && code < 200 && code != http.StatusSwitchingProtocols
+}
+
+// Lets http.ResponseController reach the underlying writer, i.e. to set deadlines
+func (rw *responseWriter) Unwrap() http.ResponseWriter {
+	return rw.ResponseWriter
+}
+
+// http.Flusher, only exposed if the underlying writer implements it
+type flusher struct {
+	rw *responseWriter
+}
+
+// Flushing sends the headers, 200 if none was written
+func (f flusher) Flush() {
+	if !f.rw.wroteHeader {
+		f.rw.WriteHeader(http.StatusOK)
+	}
+	f.rw.ResponseWriter.(http.Flusher).Flush()
+	f.rw.flushed = true
+}
+
+// http.Hijacker, only exposed if the underlying writer implements it
+type hijacker struct {
+	rw *responseWriter
+}
+
+func (h hijacker) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	conn, buf, err := h.rw.ResponseWriter.(http.Hijacker).Hijack()
+	if err == nil {
+		h.rw.hijacked = true
+	}
+	return conn, buf, err
+}
+
+// io.ReaderFrom, only exposed if the underlying writer implements it, net/http uses it to send files with sendfile
+type readerFrom struct {
+	rw *responseWriter
+}
+
+func (r readerFrom) ReadFrom(src io.Reader) (int64, error) {
+	if !r.rw.wroteHeader {
+		r.rw.WriteHeader(http.StatusOK)
+	}
+	n, err := r.rw.ResponseWriter.(io.ReaderFrom).ReadFrom(src)
+	r.rw.bytes += n
+	return n, err
+}
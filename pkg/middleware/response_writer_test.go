@@ -0,0 +1,230 @@
+package middleware
+
+import (
+	"bufio"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strings"
+	"testing"
+)
+
+// http.ResponseWriter without any optional interface
+type fakeWriter struct {
+	header http.Header
+	body   strings.Builder
+	status int
+	// every status written, informational ones included
+	statuses []int
+	flushed  bool
+	hijacked bool
+	readFrom bool
+}
+
+func (w *fakeWriter) Header() http.Header         { return w.header }
+func (w *fakeWriter) Write(b []byte) (int, error) { return w.body.Write(b) }
+func (w *fakeWriter) WriteHeader(status int) {
+	w.status = status
+	w.statuses = append(w.statuses, status)
+}
+
+type fakeFlusher struct{ w *fakeWriter }
+
+func (f fakeFlusher) Flush() { f.w.flushed = true }
+
+type fakeHijacker struct{ w *fakeWriter }
+
+func (h fakeHijacker) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	h.w.hijacked = true
+	return nil, nil, nil
+}
+
+type fakeReaderFrom struct{ w *fakeWriter }
+
+func (r fakeReaderFrom) ReadFrom(src io.Reader) (int64, error) {
+	r.w.readFrom = true
+	return io.Copy(&r.w.body, src)
+}
+
+// Returns a writer implementing the requested optional interfaces
+func newFakeWriter(flush, hijack, readFrom bool) (http.ResponseWriter, *fakeWriter) {
+	w := &fakeWriter{header: http.Header{}}
+	switch {
+	case flush && hijack && readFrom:
+		return struct {
+			*fakeWriter
+			fakeFlusher
+			fakeHijacker
+			fakeReaderFrom
+		}{w, fakeFlusher{w}, fakeHijacker{w}, fakeReaderFrom{w}}, w
+	case flush && hijack:
+		return struct {
+			*fakeWriter
+			fakeFlusher
+			fakeHijacker
+		}{w, fakeFlusher{w}, fakeHijacker{w}}, w
+	case flush && readFrom:
+		return struct {
+			*fakeWriter
+			fakeFlusher
+			fakeReaderFrom
+		}{w, fakeFlusher{w}, fakeReaderFrom{w}}, w
+	case hijack && readFrom:
+		return struct {
+			*fakeWriter
+			fakeHijacker
+			fakeReaderFrom
+		}{w, fakeHijacker{w}, fakeReaderFrom{w}}, w
+	case flush:
+		return struct {
+			*fakeWriter
+			fakeFlusher
+		}{w, fakeFlusher{w}}, w
+	case hijack:
+		return struct {
+			*fakeWriter
+			fakeHijacker
+		}{w, fakeHijacker{w}}, w
+	case readFrom:
+		return struct {
+			*fakeWriter
+			fakeReaderFrom
+		}{w, fakeReaderFrom{w}}, w
+	default:
+		return w, w
+	}
+}
+
+func TestWrapResponseWriter(t *testing.T) {
+	for i := 0; i < 8; i++ {
+		flush, hijack, readFrom := i&1 != 0, i&2 != 0, i&4 != 0
+		underlying, fake := newFakeWriter(flush, hijack, readFrom)
+		writer, wrapped := wrapResponseWriter(underlying)
+
+		f, isFlusher := writer.(http.Flusher)
+		h, isHijacker := writer.(http.Hijacker)
+		r, isReaderFrom := writer.(io.ReaderFrom)
+		if isFlusher != flush || isHijacker != hijack || isReaderFrom != readFrom {
+			t.Errorf("flusher, hijacker, reader from: got %v %v %v, expected %v %v %v",
+				isFlusher, isHijacker, isReaderFrom, flush, hijack, readFrom)
+			continue
+		}
+
+		if unwrapped := writer.(interface{ Unwrap() http.ResponseWriter }).Unwrap(); unwrapped != underlying {
+			t.Errorf("Unwrap should return the underlying writer")
+		}
+
+		if readFrom {
+			if n, err := r.ReadFrom(strings.NewReader("hello")); n != 5 || err != nil {
+				t.Errorf("ReadFrom = %d, %v", n, err)
+			}
+			if !fake.readFrom || wrapped.BytesWritten() != 5 || wrapped.Status() != http.StatusOK {
+				t.Errorf("ReadFrom should be forwarded and counted, got %d bytes and status %d", wrapped.BytesWritten(), wrapped.Status())
+			}
+		}
+		if flush {
+			f.Flush()
+			if !fake.flushed || !wrapped.Flushed() || fake.status != http.StatusOK {
+				t.Errorf("Flush should be forwarded, send the headers and be recorded")
+			}
+		}
+		if hijack {
+			if _, _, err := h.Hijack(); err != nil {
+				t.Fatal(err)
+			}
+			if !fake.hijacked || !wrapped.Hijacked() {
+				t.Errorf("Hijack should be forwarded and recorded")
+			}
+		}
+
+		// the response controller finds the optional interfaces as well
+		err := http.NewResponseController(writer).Flush()
+		if flush && err != nil {
+			t.Errorf("ResponseController.Flush = %v", err)
+		}
+		if !flush && err == nil {
+			t.Errorf("ResponseController.Flush should not be supported")
+		}
+	}
+}
+
+func TestResponseWriterForwardsInformationalResponses(t *testing.T) {
+	fake, underlying := newFakeWriter(false, false, false)
+	w, rw := wrapResponseWriter(fake)
+
+	w.WriteHeader(http.StatusEarlyHints)
+	w.WriteHeader(http.StatusCreated)
+	w.Write([]byte("created"))
+
+	if !slices.Equal(underlying.statuses, []int{http.StatusEarlyHints, http.StatusCreated}) {
+		t.Errorf("statuses = %v, expected 103 then 201", underlying.statuses)
+	}
+	if rw.status != http.StatusCreated {
+		t.Errorf("status = %v, expected the final one", rw.status)
+	}
+}
+
+func TestAccessLogStreamsAndHijacks(t *testing.T) {
+	records := captureLogs(t)
+
+	mux := http.NewServeMux()
+	mux.Handle("/stream", DefaultAccessLog().Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, "first")
+		if err := http.NewResponseController(w).Flush(); err != nil {
+			t.Errorf("flush failed: %v", err)
+		}
+		io.WriteString(w, "second")
+	})))
+	mux.Handle("/upgrade", DefaultAccessLog().Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		conn, buf, err := http.NewResponseController(w).Hijack()
+		if err != nil {
+			t.Errorf("hijack failed: %v", err)
+			return
+		}
+		defer conn.Close()
+		buf.WriteString("HTTP/1.1 200 OK\r\nContent-Length: 8\r\nConnection: close\r\n\r\nhijacked")
+		buf.Flush()
+	})))
+	// the server doesn't wait for the handlers of hijacked connections
+	served := make(chan struct{}, 2)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mux.ServeHTTP(w, r)
+		served <- struct{}{}
+	}))
+	defer server.Close()
+
+	for path, expected := range map[string]string{"/stream": "firstsecond", "/upgrade": "hijacked"} {
+		res, err := server.Client().Get(server.URL + path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		body, err := io.ReadAll(res.Body)
+		res.Body.Close()
+		if err != nil || string(body) != expected {
+			t.Errorf("%s: got %q, %v, expected %q", path, body, err, expected)
+		}
+	}
+	<-served
+	<-served
+
+	logs := records()
+	if len(logs) != 2 {
+		t.Fatalf("expected 2 records, got %v", logs)
+	}
+	for _, record := range logs {
+		switch record["route"] {
+		case "/stream":
+			if record["flushed"] != true || record["hijacked"] != nil || record["bytes"] != float64(11) {
+				t.Errorf("unexpected record %v", record)
+			}
+		case "/upgrade":
+			if record["hijacked"] != true || record["status"] != float64(0) {
+				t.Errorf("unexpected record %v", record)
+			}
+		default:
+			t.Errorf("unexpected record %v", record)
+		}
+	}
+}
@@ -0,0 +1,70 @@
+package middleware
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestSecurityHeaders(t *testing.T) {
+	var nonces []string
+	handler := DefaultSecurityHeaders().Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		nonces = append(nonces, CSPNonce(r.Context()))
+	}))
+
+	var policies []string
+	for i := 0; i < 2; i++ {
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/", nil))
+
+		header := rec.Result().Header
+		expected := map[string]string{
+			"Strict-Transport-Security": DefaultHSTS,
+			"X-Frame-Options":           DefaultFrameOptions,
+			"Referrer-Policy":           DefaultReferrerPolicy,
+			"X-Content-Type-Options":    "nosniff",
+		}
+		for name, value := range expected {
+			if header.Get(name) != value {
+				t.Errorf("%v = %v, expected %v", name, header.Get(name), value)
+			}
+		}
+		policies = append(policies, header.Get("Content-Security-Policy"))
+	}
+
+	for i, policy := range policies {
+		if nonces[i] == "" || !strings.Contains(policy, "'nonce-"+nonces[i]+"'") || strings.Contains(policy, CSPNoncePlaceholder) {
+			t.Errorf("Content-Security-Policy = %v, expected the nonce %v", policy, nonces[i])
+		}
+	}
+	if nonces[0] == nonces[1] {
+		t.Errorf("nonces = %v, expected a new nonce per request", nonces)
+	}
+}
+
+func TestSecurityHeadersCanBeDisabled(t *testing.T) {
+	headers := &SecurityHeaders{CSP: "default-src 'self'"}
+
+	var nonce string
+	handler := headers.Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		nonce = CSPNonce(r.Context())
+	}))
+
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/", nil))
+
+	header := rec.Result().Header
+	if header.Get("Content-Security-Policy") != headers.CSP {
+		t.Errorf("Content-Security-Policy = %v, expected %v", header.Get("Content-Security-Policy"), headers.CSP)
+	}
+	for _, name := range []string{"Strict-Transport-Security", "X-Frame-Options", "Referrer-Policy"} {
+		if _, ok := header[name]; ok {
+			t.Errorf("%v is set, expected it to be disabled", name)
+		}
+	}
+	// no placeholder, no nonce
+	if nonce != "" {
+		t.Errorf("CSPNonce() = %v, expected no nonce", nonce)
+	}
+}
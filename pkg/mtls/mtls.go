@@ -0,0 +1,333 @@
+package mtls
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/xaviercrochet/turbo-octo-adventure/pkg/util"
+)
+
+const DefaultReloadInterval = 10 * time.Second
+
+var ErrNoCertificate = errors.New("no certificate found in file")
+
+var ErrIncompleteKeyPair = errors.New("tls requires both a certificate and its key")
+
+// Paths of the pem encoded files used to setup mutual TLS
+type Config struct {
+	// certificate presented to the peer
+	CertFile string
+	KeyFile  string
+	// CA used to verify the certificate presented by the peer
+	CAFile string
+	// how often the files are checked for changes, DefaultReloadInterval if not set
+	ReloadInterval time.Duration
+}
+
+func NewConfig(certFile, keyFile, caFile string) *Config {
+	return &Config{
+		CertFile:       certFile,
+		KeyFile:        keyFile,
+		CAFile:         caFile,
+		ReloadInterval: DefaultReloadInterval,
+	}
+}
+
+// mTLS is only enabled when a certificate and its key are configured
+func (c *Config) Enabled() bool {
+	return c != nil && c.CertFile != "" && c.KeyFile != ""
+}
+
+// A CA, certificate or key alone would silently fall back to plain http, it is rejected with ErrIncompleteKeyPair
+func (c *Config) Validate() error {
+	if c.CertFile == "" && c.KeyFile == "" && c.CAFile == "" {
+		return nil
+	}
+	if !c.Enabled() {
+		return ErrIncompleteKeyPair
+	}
+	return nil
+}
+
+/*
+Build the tls config of a server
+
+  - the server certificate is reloaded when CertFile or KeyFile change
+  - if CAFile is set, clients must present a certificate signed by this CA. The CA is reloaded when CAFile changes
+
+The files are watched until ctx is done
+*/
+func ServerTLSConfig(ctx context.Context, config *Config) (*tls.Config, error) {
+	keyPair, err := NewKeyPair(config.CertFile, config.KeyFile)
+	if err != nil {
+		return nil, err
+	}
+
+	reloaders := []reloader{keyPair}
+
+	var clientCAs *CertPool
+	if config.CAFile != "" {
+		clientCAs, err = NewCertPool(config.CAFile)
+		if err != nil {
+			return nil, err
+		}
+		reloaders = append(reloaders, clientCAs)
+	}
+
+	go watch(ctx, config.ReloadInterval, reloaders...)
+
+	return &tls.Config{
+		MinVersion: tls.VersionTLS12,
+		// a new config is built for every handshake so reloaded files are picked up without restarting the server
+		GetConfigForClient: func(*tls.ClientHelloInfo) (*tls.Config, error) {
+			cfg := &tls.Config{
+				MinVersion:     tls.VersionTLS12,
+				GetCertificate: keyPair.GetCertificate,
+				ClientAuth:     tls.NoClientCert,
+			}
+			if clientCAs != nil {
+				cfg.ClientAuth = tls.RequireAndVerifyClientCert
+				cfg.ClientCAs = clientCAs.Pool()
+			}
+			return cfg, nil
+		},
+	}, nil
+}
+
+/*
+Build the tls config of a client
+
+  - the client certificate is reloaded when CertFile or KeyFile change
+  - if CAFile is set, the server certificate is verified against this CA instead of the system roots. The CA is reloaded when CAFile changes
+
+The files are watched until ctx is done
+*/
+func ClientTLSConfig(ctx context.Context, config *Config) (*tls.Config, error) {
+	keyPair, err := NewKeyPair(config.CertFile, config.KeyFile)
+	if err != nil {
+		return nil, err
+	}
+
+	tlsConfig := &tls.Config{
+		MinVersion:           tls.VersionTLS12,
+		GetClientCertificate: keyPair.GetClientCertificate,
+	}
+
+	reloaders := []reloader{keyPair}
+
+	if config.CAFile != "" {
+		rootCAs, err := NewCertPool(config.CAFile)
+		if err != nil {
+			return nil, err
+		}
+		reloaders = append(reloaders, rootCAs)
+
+		// RootCAs can't be swapped on a live config, the default verification is replaced by one using the current pool
+		tlsConfig.InsecureSkipVerify = true
+		tlsConfig.VerifyConnection = func(cs tls.ConnectionState) error {
+			return verifyServer(cs, rootCAs.Pool())
+		}
+	}
+
+	go watch(ctx, config.ReloadInterval, reloaders...)
+
+	return tlsConfig, nil
+}
+
+// same checks as the default verification of crypto/tls, against the given roots
+func verifyServer(cs tls.ConnectionState, roots *x509.CertPool) error {
+	if len(cs.PeerCertificates) == 0 {
+		return errors.New("server did not present a certificate")
+	}
+
+	intermediates := x509.NewCertPool()
+	for _, cert := range cs.PeerCertificates[1:] {
+		intermediates.AddCert(cert)
+	}
+
+	_, err := cs.PeerCertificates[0].Verify(x509.VerifyOptions{
+		Roots:         roots,
+		Intermediates: intermediates,
+		DNSName:       cs.ServerName,
+		KeyUsages:     []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	})
+	return err
+}
+
+// Something that is loaded from disk and can be reloaded when its files change
+type reloader interface {
+	reloadIfChanged() (bool, error)
+	name() string
+}
+
+// Periodically reload the files until ctx is done
+func watch(ctx context.Context, interval time.Duration, reloaders ...reloader) {
+	if interval <= 0 {
+		interval = DefaultReloadInterval
+	}
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			for _, r := range reloaders {
+				reloaded, err := r.reloadIfChanged()
+				if err != nil {
+					// keep serving the previous version, the files might be in the middle of being rotated
+					util.DefaultLogger.Warn("could not reload tls files", "files", r.name(), "error", err)
+				} else if reloaded {
+					util.DefaultLogger.Info("tls files reloaded", "files", r.name())
+				}
+			}
+		}
+	}
+}
+
+// most recent modification time of the files
+func lastModified(files ...string) (time.Time, error) {
+	var latest time.Time
+	for _, file := range files {
+		info, err := os.Stat(file)
+		if err != nil {
+			return time.Time{}, err
+		}
+		if info.ModTime().After(latest) {
+			latest = info.ModTime()
+		}
+	}
+	return latest, nil
+}
+
+// A certificate and its private key, reloaded when one of the files change
+type KeyPair struct {
+	certFile string
+	keyFile  string
+
+	mu      sync.RWMutex
+	cert    *tls.Certificate
+	modTime time.Time
+}
+
+func NewKeyPair(certFile, keyFile string) (*KeyPair, error) {
+	k := &KeyPair{
+		certFile: certFile,
+		keyFile:  keyFile,
+	}
+	if _, err := k.reloadIfChanged(); err != nil {
+		return nil, err
+	}
+	return k, nil
+}
+
+func (k *KeyPair) name() string {
+	return fmt.Sprintf("%s,%s", k.certFile, k.keyFile)
+}
+
+func (k *KeyPair) reloadIfChanged() (bool, error) {
+	modTime, err := lastModified(k.certFile, k.keyFile)
+	if err != nil {
+		return false, err
+	}
+
+	k.mu.RLock()
+	unchanged := k.cert != nil && modTime.Equal(k.modTime)
+	k.mu.RUnlock()
+	if unchanged {
+		return false, nil
+	}
+
+	cert, err := tls.LoadX509KeyPair(k.certFile, k.keyFile)
+	if err != nil {
+		return false, fmt.Errorf("failed to load key pair: %w", err)
+	}
+
+	k.mu.Lock()
+	k.cert = &cert
+	k.modTime = modTime
+	k.mu.Unlock()
+
+	return true, nil
+}
+
+// Returns the current certificate, see tls.Config.GetCertificate
+func (k *KeyPair) GetCertificate(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	k.mu.RLock()
+	defer k.mu.RUnlock()
+	return k.cert, nil
+}
+
+// Returns the current certificate, see tls.Config.GetClientCertificate
+func (k *KeyPair) GetClientCertificate(*tls.CertificateRequestInfo) (*tls.Certificate, error) {
+	k.mu.RLock()
+	defer k.mu.RUnlock()
+	return k.cert, nil
+}
+
+// A pool of CA certificates, reloaded when its file change
+type CertPool struct {
+	file string
+
+	mu      sync.RWMutex
+	pool    *x509.CertPool
+	modTime time.Time
+}
+
+func NewCertPool(file string) (*CertPool, error) {
+	p := &CertPool{file: file}
+	if _, err := p.reloadIfChanged(); err != nil {
+		return nil, err
+	}
+	return p, nil
+}
+
+func (p *CertPool) name() string {
+	return p.file
+}
+
+func (p *CertPool) reloadIfChanged() (bool, error) {
+	modTime, err := lastModified(p.file)
+	if err != nil {
+		return false, err
+	}
+
+	p.mu.RLock()
+	unchanged := p.pool != nil && modTime.Equal(p.modTime)
+	p.mu.RUnlock()
+	if unchanged {
+		return false, nil
+	}
+
+	data, err := os.ReadFile(p.file)
+	if err != nil {
+		return false, fmt.Errorf("failed to read ca file: %w", err)
+	}
+
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(data) {
+		return false, fmt.Errorf("%w: %s", ErrNoCertificate, p.file)
+	}
+
+	p.mu.Lock()
+	p.pool = pool
+	p.modTime = modTime
+	p.mu.Unlock()
+
+	return true, nil
+}
+
+// Returns the current pool
+func (p *CertPool) Pool() *x509.CertPool {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	return p.pool
+}
@@ -0,0 +1,277 @@
+package mtls
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"errors"
+	"math/big"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// a throwaway certificate and its key
+type testCert struct {
+	cert *x509.Certificate
+	key  *ecdsa.PrivateKey
+	der  []byte
+}
+
+func newCA(t *testing.T, name string) *testCert {
+	t.Helper()
+	return newCert(t, name, nil, &x509.Certificate{
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+		KeyUsage:              x509.KeyUsageCertSign | x509.KeyUsageDigitalSignature,
+	})
+}
+
+func newLeaf(t *testing.T, name string, ca *testCert, usage x509.ExtKeyUsage) *testCert {
+	t.Helper()
+	return newCert(t, name, ca, &x509.Certificate{
+		KeyUsage:    x509.KeyUsageDigitalSignature,
+		ExtKeyUsage: []x509.ExtKeyUsage{usage},
+		DNSNames:    []string{"localhost"},
+		IPAddresses: []net.IP{net.ParseIP("127.0.0.1")},
+	})
+}
+
+func newCert(t *testing.T, name string, parent *testCert, template *x509.Certificate) *testCert {
+	t.Helper()
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("failed to generate key: %v", err)
+	}
+
+	serial, err := rand.Int(rand.Reader, big.NewInt(1<<62))
+	if err != nil {
+		t.Fatalf("failed to generate serial: %v", err)
+	}
+
+	template.SerialNumber = serial
+	template.Subject = pkix.Name{CommonName: name}
+	template.NotBefore = time.Now().Add(-time.Hour)
+	template.NotAfter = time.Now().Add(time.Hour)
+
+	// self signed if there is no parent
+	parentCert, parentKey := template, key
+	if parent != nil {
+		parentCert, parentKey = parent.cert, parent.key
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, template, parentCert, &key.PublicKey, parentKey)
+	if err != nil {
+		t.Fatalf("failed to create certificate: %v", err)
+	}
+
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatalf("failed to parse certificate: %v", err)
+	}
+
+	return &testCert{cert: cert, key: key, der: der}
+}
+
+// write the certificate and its key under dir, returns the paths of both files
+func (c *testCert) write(t *testing.T, dir, name string) (string, string) {
+	t.Helper()
+
+	certFile := filepath.Join(dir, name+".crt")
+	keyFile := filepath.Join(dir, name+".key")
+
+	keyDer, err := x509.MarshalECPrivateKey(c.key)
+	if err != nil {
+		t.Fatalf("failed to marshal key: %v", err)
+	}
+
+	writeFile(t, certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: c.der}))
+	writeFile(t, keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDer}))
+
+	return certFile, keyFile
+}
+
+// write the file and move its modification time forward, so the change is detected even on coarse grained file systems
+func writeFile(t *testing.T, path string, data []byte) {
+	t.Helper()
+
+	modTime := time.Now()
+	if info, err := os.Stat(path); err == nil {
+		modTime = info.ModTime().Add(time.Second)
+	}
+
+	if err := os.WriteFile(path, data, 0600); err != nil {
+		t.Fatalf("failed to write %s: %v", path, err)
+	}
+	if err := os.Chtimes(path, modTime, modTime); err != nil {
+		t.Fatalf("failed to touch %s: %v", path, err)
+	}
+}
+
+// start a https server using config, returns its url
+func startServer(t *testing.T, ctx context.Context, config *Config) string {
+	t.Helper()
+
+	tlsConfig, err := ServerTLSConfig(ctx, config)
+	if err != nil {
+		t.Fatalf("ServerTLSConfig() error = %v", err)
+	}
+
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("failed to listen: %v", err)
+	}
+
+	server := &http.Server{
+		Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(http.StatusOK)
+		}),
+		TLSConfig: tlsConfig,
+	}
+	go server.ServeTLS(lis, "", "")
+	t.Cleanup(func() { server.Close() })
+
+	return "https://" + lis.Addr().String()
+}
+
+// returns an error if the request could not be completed
+func get(t *testing.T, ctx context.Context, url string, config *Config) error {
+	t.Helper()
+
+	tlsConfig, err := ClientTLSConfig(ctx, config)
+	if err != nil {
+		t.Fatalf("ClientTLSConfig() error = %v", err)
+	}
+
+	client := &http.Client{Transport: &http.Transport{TLSClientConfig: tlsConfig}}
+	defer client.CloseIdleConnections()
+
+	resp, err := client.Get(url)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	return nil
+}
+
+func TestMutualTLS(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	dir := t.TempDir()
+
+	ca := newCA(t, "ca")
+	caFile, _ := ca.write(t, dir, "ca")
+	serverCert, serverKey := newLeaf(t, "server", ca, x509.ExtKeyUsageServerAuth).write(t, dir, "server")
+	clientCert, clientKey := newLeaf(t, "client", ca, x509.ExtKeyUsageClientAuth).write(t, dir, "client")
+
+	otherCA := newCA(t, "other-ca")
+	otherCAFile, _ := otherCA.write(t, dir, "other-ca")
+	otherCert, otherKey := newLeaf(t, "other", otherCA, x509.ExtKeyUsageClientAuth).write(t, dir, "other")
+
+	url := startServer(t, ctx, NewConfig(serverCert, serverKey, caFile))
+
+	tests := []struct {
+		name    string
+		config  *Config
+		wantErr bool
+	}{
+		{
+			name:   "client certificate signed by the trusted ca",
+			config: NewConfig(clientCert, clientKey, caFile),
+		},
+		{
+			name:    "client certificate signed by an unknown ca",
+			config:  NewConfig(otherCert, otherKey, caFile),
+			wantErr: true,
+		},
+		{
+			name:    "server certificate signed by an unknown ca",
+			config:  NewConfig(clientCert, clientKey, otherCAFile),
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := get(t, ctx, url, tt.config)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("get() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestReloadOnFileChange(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	dir := t.TempDir()
+
+	ca := newCA(t, "ca")
+	caFile, _ := ca.write(t, dir, "ca")
+	serverCert, serverKey := newLeaf(t, "server", ca, x509.ExtKeyUsageServerAuth).write(t, dir, "server")
+	clientCert, clientKey := newLeaf(t, "client", ca, x509.ExtKeyUsageClientAuth).write(t, dir, "client")
+
+	serverConfig := NewConfig(serverCert, serverKey, caFile)
+	serverConfig.ReloadInterval = 10 * time.Millisecond
+	url := startServer(t, ctx, serverConfig)
+
+	clientConfig := NewConfig(clientCert, clientKey, caFile)
+	if err := get(t, ctx, url, clientConfig); err != nil {
+		t.Fatalf("get() before rotation error = %v", err)
+	}
+
+	// rotate the server CA, the previous client certificate must be rejected once the file is reloaded
+	rotated := newCA(t, "rotated-ca")
+	rotated.write(t, dir, "ca")
+	newLeaf(t, "server", rotated, x509.ExtKeyUsageServerAuth).write(t, dir, "server")
+	rotatedCert, rotatedKey := newLeaf(t, "rotated-client", rotated, x509.ExtKeyUsageClientAuth).write(t, dir, "rotated-client")
+
+	deadline := time.Now().Add(5 * time.Second)
+	for get(t, ctx, url, NewConfig(rotatedCert, rotatedKey, caFile)) != nil {
+		if time.Now().After(deadline) {
+			t.Fatal("rotated certificates were not picked up")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	if err := get(t, ctx, url, clientConfig); err == nil {
+		t.Error("get() with a certificate of the previous ca succeeded, expected an error")
+	}
+}
+
+func TestConfigValidate(t *testing.T) {
+	tests := []struct {
+		name    string
+		config  *Config
+		wantErr bool
+	}{
+		{name: "plain http", config: NewConfig("", "", "")},
+		{name: "certificate and key", config: NewConfig("cert.pem", "key.pem", "")},
+		{name: "mutual tls", config: NewConfig("cert.pem", "key.pem", "ca.pem")},
+		{name: "certificate without key", config: NewConfig("cert.pem", "", ""), wantErr: true},
+		{name: "key without certificate", config: NewConfig("", "key.pem", "ca.pem"), wantErr: true},
+		{name: "CA only", config: NewConfig("", "", "ca.pem"), wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := tt.config.Validate()
+			if tt.wantErr && !errors.Is(err, ErrIncompleteKeyPair) {
+				t.Errorf("Validate() error = %v, expected %v", err, ErrIncompleteKeyPair)
+			}
+			if !tt.wantErr && err != nil {
+				t.Errorf("Validate() error = %v", err)
+			}
+		})
+	}
+}
@@ -0,0 +1,49 @@
+package net
+
+import (
+	"fmt"
+	"io"
+)
+
+// Maximum size of the response bodies read by the clients, unless configured otherwise
+const DefaultMaxBodySize int64 = 10 << 20
+
+// Returned when a response body is larger than the client allows
+type ResponseTooLargeError struct {
+	// maximum size, in bytes
+	Limit int64
+}
+
+func (e *ResponseTooLargeError) Error() string {
+	return fmt.Sprintf("response too large: more than %d bytes", e.Limit)
+}
+
+/*
+Returns a reader of r failing with *ResponseTooLargeError once more than limit bytes are read
+
+Unlike io.LimitReader, a body that is too large can't be mistaken for a truncated one
+*/
+func LimitReader(r io.Reader, limit int64) io.Reader {
+	return &limitedReader{r: r, remaining: limit, limit: limit}
+}
+
+type limitedReader struct {
+	r         io.Reader
+	remaining int64
+	limit     int64
+}
+
+func (l *limitedReader) Read(p []byte) (int, error) {
+	// one byte past the limit tells a body of exactly limit bytes from a larger one
+	if int64(len(p)) > l.remaining+1 {
+		p = p[:l.remaining+1]
+	}
+	n, err := l.r.Read(p)
+	if int64(n) > l.remaining {
+		n = int(l.remaining)
+		l.remaining = 0
+		return n, &ResponseTooLargeError{Limit: l.limit}
+	}
+	l.remaining -= int64(n)
+	return n, err
+}
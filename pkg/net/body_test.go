@@ -0,0 +1,45 @@
+package net
+
+import (
+	"errors"
+	"io"
+	"strings"
+	"testing"
+	"testing/iotest"
+)
+
+func TestLimitReader(t *testing.T) {
+	tests := []struct {
+		name     string
+		body     string
+		limit    int64
+		expected string
+		tooLarge bool
+	}{
+		{name: "smaller than the limit", body: "abc", limit: 4, expected: "abc"},
+		{name: "exactly the limit", body: "abcd", limit: 4, expected: "abcd"},
+		{name: "larger than the limit", body: "abcde", limit: 4, expected: "abcd", tooLarge: true},
+		{name: "empty", body: "", limit: 0, expected: ""},
+		{name: "no byte allowed", body: "a", limit: 0, expected: "", tooLarge: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// one byte at a time as well, the limit must not depend on how the body is read
+			for _, r := range []io.Reader{strings.NewReader(tt.body), iotest.OneByteReader(strings.NewReader(tt.body))} {
+				data, err := io.ReadAll(LimitReader(r, tt.limit))
+				if string(data) != tt.expected {
+					t.Errorf("expected %q, got %q", tt.expected, data)
+				}
+
+				var tooLarge *ResponseTooLargeError
+				if errors.As(err, &tooLarge) != tt.tooLarge {
+					t.Fatalf("unexpected error %v", err)
+				}
+				if tt.tooLarge && tooLarge.Limit != tt.limit {
+					t.Errorf("expected the limit in the error, got %d", tooLarge.Limit)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,191 @@
+package policy
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"slices"
+
+	"github.com/xaviercrochet/turbo-octo-adventure/pkg/util"
+	"github.com/zitadel/zitadel-go/v3/pkg/authorization"
+)
+
+// A named action on a resource, granted to roles by the policy
+type Permission string
+
+const (
+	// read the selected feed
+	FeedRead Permission = "feed:read"
+	// change the selected feed
+	FeedSelect Permission = "feed:select"
+	// read the audit trail
+	AuditRead Permission = "audit:read"
+	// register webhooks and read their deliveries
+	WebhookManage Permission = "webhook:manage"
+	// issue, list and revoke api keys
+	APIKeyManage Permission = "apikey:manage"
+	// change the log level of the api at runtime
+	LogManage Permission = "log:manage"
+)
+
+// permissions a policy file can refer to, anything else is a typo
+var knownPermissions = []Permission{
+	FeedRead,
+	FeedSelect,
+	AuditRead,
+	WebhookManage,
+	APIKeyManage,
+	LogManage,
+}
+
+// Returns true if the permission exists
+func IsKnown(permission Permission) bool {
+	return slices.Contains(knownPermissions, permission)
+}
+
+/*
+Implemented by callers whose permissions are restricted further than their roles, i.e. api keys
+
+Such callers are only granted the permissions both their roles and their scopes allow
+*/
+type Scoped interface {
+	RestrictedTo() (scopes []string, restricted bool)
+}
+
+/*
+Implemented by the contexts of callers acting in an organisation, i.e. auth.Context
+
+A role only grants its permissions in the organisations it was granted in, roles granted elsewhere are ignored. Roles
+whose organisations aren't known (i.e. a list of role names in the token) are granted in the organisation of the caller
+*/
+type Organizational interface {
+	Organization() string
+	RoleOrganizations(role string) []string
+}
+
+// Pseudo role granted to every authorized caller, whatever their project roles are
+const AnyRole = "*"
+
+// Maps project roles to permissions
+type Policy struct {
+	roles map[string][]Permission
+}
+
+// Serialized form of a policy:
+//
+//	{"roles": {"admin": ["feed:read", "feed:select"], "*": ["feed:read"]}}
+type policyFile struct {
+	Roles map[string][]Permission `json:"roles"`
+}
+
+/*
+Same rules as before policies existed:
+  - any authorized caller can read the feed
+  - admins can do everything
+*/
+func Default() *Policy {
+	return &Policy{
+		roles: map[string][]Permission{
+			AnyRole: {FeedRead},
+			"admin": slices.Clone(knownPermissions),
+		},
+	}
+}
+
+// Load a policy from a json file
+func Load(path string) (*Policy, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read policy file: %w", err)
+	}
+	return Parse(data)
+}
+
+// Parse a json policy, unknown permissions are rejected
+func Parse(data []byte) (*Policy, error) {
+	var file policyFile
+	if err := json.Unmarshal(data, &file); err != nil {
+		return nil, fmt.Errorf("failed to deserialize policy: %w", err)
+	}
+
+	for role, permissions := range file.Roles {
+		for _, permission := range permissions {
+			if !IsKnown(permission) {
+				return nil, fmt.Errorf("unknown permission %q granted to role %q", permission, role)
+			}
+		}
+	}
+
+	return &Policy{roles: file.Roles}, nil
+}
+
+// Returns the effective permissions of the caller, sorted and without duplicates
+func (p *Policy) Permissions(authCtx authorization.Ctx) []Permission {
+	permissions := []Permission{}
+	if authCtx == nil || !authCtx.IsAuthorized() {
+		return permissions
+	}
+
+	for role, granted := range p.roles {
+		if role == AnyRole || isGrantedRole(authCtx, role) {
+			permissions = append(permissions, granted...)
+		}
+	}
+
+	if scoped, ok := authCtx.(Scoped); ok {
+		if scopes, restricted := scoped.RestrictedTo(); restricted {
+			permissions = slices.DeleteFunc(permissions, func(permission Permission) bool {
+				return !slices.Contains(scopes, string(permission))
+			})
+		}
+	}
+
+	slices.Sort(permissions)
+	return slices.Compact(permissions)
+}
+
+// Returns true if the role is granted in the organisation of the caller, see Organizational
+func isGrantedRole(authCtx authorization.Ctx, role string) bool {
+	if !authCtx.IsGrantedRole(role) {
+		return false
+	}
+	if org, ok := authCtx.(Organizational); ok && len(org.RoleOrganizations(role)) > 0 {
+		return authCtx.IsGrantedRoleInOrganization(role, org.Organization())
+	}
+	return true
+}
+
+// Returns true if one of the roles of the caller grants the permission
+func (p *Policy) IsGranted(authCtx authorization.Ctx, permission Permission) bool {
+	return slices.Contains(p.Permissions(authCtx), permission)
+}
+
+/*
+This middleware only lets the request through if the caller is granted the permission.
+It must be placed after the authorization middleware, which provides the authorization context
+
+Response:
+  - 403 if the permission is not granted
+*/
+func (p *Policy) Require(permission Permission) func(next http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			ctx := r.Context()
+			authCtx := authorization.Context[authorization.Ctx](ctx)
+
+			if !p.IsGranted(authCtx, permission) {
+				logger := util.DefaultLogger.FromContext(ctx)
+				var userID string
+				if authCtx != nil {
+					userID = authCtx.UserID()
+				}
+				logger.Warn("user doesn't have access to the resource", "id", userID, "permission", permission)
+				http.Error(w, "forbidden", http.StatusForbidden)
+				return
+			}
+
+			next.ServeHTTP(w, r)
+		})
+	}
+}
@@ -0,0 +1,182 @@
+package policy
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+
+	"github.com/xaviercrochet/turbo-octo-adventure/pkg/auth"
+	"github.com/zitadel/zitadel-go/v3/pkg/authorization"
+)
+
+// authorization context granted the given roles
+func caller(roles ...string) *auth.Context {
+	authCtx := &auth.Context{
+		Subject: "user-1",
+		Roles:   map[string][]string{},
+		Active:  true,
+	}
+	for _, role := range roles {
+		authCtx.Roles[role] = nil
+	}
+	return authCtx
+}
+
+// caller of org-1 granted the role in the given organisations
+func member(role string, orgIDs ...string) *auth.Context {
+	authCtx := caller()
+	authCtx.OrgID = "org-1"
+	authCtx.Roles[role] = orgIDs
+	return authCtx
+}
+
+// turns the caller into an api key restricted to the scopes
+func apiKey(authCtx *auth.Context, scopes ...string) *auth.Context {
+	authCtx.Caller = auth.CallerAPIKey
+	authCtx.Scopes = scopes
+	return authCtx
+}
+
+func TestParse(t *testing.T) {
+	tests := []struct {
+		name    string
+		data    string
+		wantErr bool
+	}{
+		{
+			name: "valid policy",
+			data: `{"roles": {"admin": ["feed:read", "feed:select"], "*": ["feed:read"]}}`,
+		},
+		{
+			name:    "unknown permission",
+			data:    `{"roles": {"admin": ["feed:delete"]}}`,
+			wantErr: true,
+		},
+		{
+			name:    "invalid json",
+			data:    `{"roles": [`,
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := Parse([]byte(tt.data))
+			if (err != nil) != tt.wantErr {
+				t.Errorf("Parse() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestPermissions(t *testing.T) {
+	p, err := Parse([]byte(`{"roles": {
+		"*": ["feed:read"],
+		"editor": ["feed:read", "feed:select"],
+		"auditor": ["audit:read"]
+	}}`))
+	if err != nil {
+		t.Fatalf("Parse() error = %v", err)
+	}
+
+	tests := []struct {
+		name     string
+		authCtx  authorization.Ctx
+		expected []Permission
+	}{
+		{
+			name:     "no role",
+			authCtx:  caller(),
+			expected: []Permission{FeedRead},
+		},
+		{
+			name:     "roles are merged without duplicates",
+			authCtx:  caller("editor", "auditor"),
+			expected: []Permission{AuditRead, FeedRead, FeedSelect},
+		},
+		{
+			name:     "role unknown to the policy",
+			authCtx:  caller("admin"),
+			expected: []Permission{FeedRead},
+		},
+		{
+			name:     "role granted in the organisation of the caller",
+			authCtx:  member("editor", "org-2", "org-1"),
+			expected: []Permission{FeedRead, FeedSelect},
+		},
+		{
+			name:     "role granted in another organisation only",
+			authCtx:  member("editor", "org-2"),
+			expected: []Permission{FeedRead},
+		},
+		{
+			name:     "unauthorized caller",
+			authCtx:  (*auth.Context)(nil),
+			expected: []Permission{},
+		},
+		{
+			name:     "api keys are limited to their scopes",
+			authCtx:  apiKey(caller("editor", "auditor"), "feed:select", "audit:read"),
+			expected: []Permission{AuditRead, FeedSelect},
+		},
+		{
+			name:     "api keys are limited to their roles",
+			authCtx:  apiKey(caller(), "feed:read", "feed:select"),
+			expected: []Permission{FeedRead},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			result := p.Permissions(tt.authCtx)
+			if !reflect.DeepEqual(result, tt.expected) {
+				t.Errorf("Permissions() = %v, expected %v", result, tt.expected)
+			}
+		})
+	}
+}
+
+func TestRequire(t *testing.T) {
+	p := Default()
+	handler := p.Require(FeedSelect)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+
+	tests := []struct {
+		name     string
+		authCtx  *auth.Context
+		expected int
+	}{
+		{
+			name:     "admin is granted feed:select",
+			authCtx:  caller("admin"),
+			expected: http.StatusOK,
+		},
+		{
+			name:     "other roles are not",
+			authCtx:  caller("reader"),
+			expected: http.StatusForbidden,
+		},
+		{
+			name:     "missing authorization context",
+			expected: http.StatusForbidden,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodPost, "/api/select_feed", nil)
+			if tt.authCtx != nil {
+				req = req.WithContext(authorization.WithAuthContext(req.Context(), tt.authCtx))
+			}
+
+			rec := httptest.NewRecorder()
+			handler.ServeHTTP(rec, req)
+
+			if rec.Code != tt.expected {
+				t.Errorf("status = %v, expected %v", rec.Code, tt.expected)
+			}
+		})
+	}
+}
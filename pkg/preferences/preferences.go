@@ -0,0 +1,121 @@
+package preferences
+
+import (
+	"errors"
+	"fmt"
+	"slices"
+	"time"
+	// timezones must resolve even on hosts without a zoneinfo database, i.e. distroless images
+	_ "time/tzdata"
+)
+
+// clocks
+const (
+	Clock24h = "24h"
+	Clock12h = "12h"
+)
+
+// date formats, see DateLayouts
+const (
+	DateISO = "iso"
+	DateEU  = "eu"
+	DateUS  = "us"
+)
+
+var (
+	// layouts of the date formats, as in time.Format
+	DateLayouts = map[string]string{
+		DateISO: "2006-01-02",
+		DateEU:  "02/01/2006",
+		DateUS:  "01/02/2006",
+	}
+	// layouts of the clocks, as in time.Format
+	ClockLayouts = map[string]string{
+		Clock24h: "15:04:05",
+		Clock12h: "3:04:05 PM",
+	}
+	// languages the web app is translated to, an empty language follows the browser
+	Languages = []string{"en", "fr"}
+)
+
+// Display preferences of a user
+type Preferences struct {
+	// IANA name, i.e. Europe/Brussels
+	Timezone   string `json:"timezone"`
+	Clock      string `json:"clock"`
+	DateFormat string `json:"date_format"`
+	// empty to follow the language of the browser
+	Language string `json:"language"`
+}
+
+// Preferences of users who never changed them
+func Default() *Preferences {
+	return &Preferences{
+		Timezone:   "UTC",
+		Clock:      Clock24h,
+		DateFormat: DateISO,
+	}
+}
+
+// Returns an error describing the first invalid preference
+func (p *Preferences) Validate() error {
+	// "" and "Local" would resolve to the timezone of the server
+	if _, err := time.LoadLocation(p.Timezone); err != nil || p.Timezone == "" || p.Timezone == "Local" {
+		return fmt.Errorf("unknown timezone %q", p.Timezone)
+	}
+	if _, ok := ClockLayouts[p.Clock]; !ok {
+		return errors.New("clock must be 24h or 12h")
+	}
+	if _, ok := DateLayouts[p.DateFormat]; !ok {
+		return errors.New("date format must be iso, eu or us")
+	}
+	if p.Language != "" && !slices.Contains(Languages, p.Language) {
+		return fmt.Errorf("unsupported language %q", p.Language)
+	}
+	return nil
+}
+
+// Timezone of the user, UTC if it can't be loaded
+func (p *Preferences) Location() *time.Location {
+	location, err := time.LoadLocation(p.Timezone)
+	if err != nil {
+		return time.UTC
+	}
+	return location
+}
+
+func (p *Preferences) dateLayout() string {
+	if layout, ok := DateLayouts[p.DateFormat]; ok {
+		return layout
+	}
+	return DateLayouts[DateISO]
+}
+
+func (p *Preferences) clockLayout() string {
+	if layout, ok := ClockLayouts[p.Clock]; ok {
+		return layout
+	}
+	return ClockLayouts[Clock24h]
+}
+
+// Date of t in the timezone of the user
+func (p *Preferences) FormatDate(t time.Time) string {
+	return t.In(p.Location()).Format(p.dateLayout())
+}
+
+// Time of day of t in the timezone of the user
+func (p *Preferences) FormatTime(t time.Time) string {
+	return t.In(p.Location()).Format(p.clockLayout())
+}
+
+// Date and time of day of t in the timezone of the user
+func (p *Preferences) FormatDateTime(t time.Time) string {
+	return t.In(p.Location()).Format(p.dateLayout() + " " + p.clockLayout())
+}
+
+// Start of the day of t in the timezone of the user, anything bucketed by day must use it
+func (p *Preferences) Day(t time.Time) time.Time {
+	local := t.In(p.Location())
+	year, month, day := local.Date()
+	return time.Date(year, month, day, 0, 0, 0, 0, local.Location())
+}
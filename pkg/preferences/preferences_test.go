@@ -0,0 +1,103 @@
+package preferences
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// 23:30 UTC is already the next day in Brussels, and still the same day in New York
+var listenedAt = time.Date(2024, 3, 1, 23, 30, 0, 0, time.UTC)
+
+func TestFormat(t *testing.T) {
+	tests := []struct {
+		name        string
+		preferences Preferences
+		dateTime    string
+		day         string
+	}{
+		{
+			name:        "default",
+			preferences: *Default(),
+			dateTime:    "2024-03-01 23:30:00",
+			day:         "2024-03-01T00:00:00Z",
+		},
+		{
+			name:        "european",
+			preferences: Preferences{Timezone: "Europe/Brussels", Clock: Clock24h, DateFormat: DateEU},
+			dateTime:    "02/03/2024 00:30:00",
+			day:         "2024-03-02T00:00:00+01:00",
+		},
+		{
+			name:        "american",
+			preferences: Preferences{Timezone: "America/New_York", Clock: Clock12h, DateFormat: DateUS},
+			dateTime:    "03/01/2024 6:30:00 PM",
+			day:         "2024-03-01T00:00:00-05:00",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.preferences.FormatDateTime(listenedAt); got != tt.dateTime {
+				t.Errorf("FormatDateTime() = %v, expected %v", got, tt.dateTime)
+			}
+			if got := tt.preferences.Day(listenedAt).Format(time.RFC3339); got != tt.day {
+				t.Errorf("Day() = %v, expected %v", got, tt.day)
+			}
+		})
+	}
+}
+
+func TestValidate(t *testing.T) {
+	valid := Preferences{Timezone: "Asia/Tokyo", Clock: Clock12h, DateFormat: DateUS, Language: "fr"}
+	if err := valid.Validate(); err != nil {
+		t.Errorf("Validate() error = %v", err)
+	}
+
+	invalid := []Preferences{
+		{Timezone: "Mars/Olympus", Clock: Clock24h, DateFormat: DateISO},
+		{Timezone: "Local", Clock: Clock24h, DateFormat: DateISO},
+		{Timezone: "", Clock: Clock24h, DateFormat: DateISO},
+		{Timezone: "UTC", Clock: "36h", DateFormat: DateISO},
+		{Timezone: "UTC", Clock: Clock24h, DateFormat: "roman"},
+		{Timezone: "UTC", Clock: Clock24h, DateFormat: DateISO, Language: "tlh"},
+	}
+	for _, p := range invalid {
+		if err := p.Validate(); err == nil {
+			t.Errorf("Validate(%+v) error = nil, expected an error", p)
+		}
+	}
+}
+
+func TestStore(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "preferences.json")
+
+	s, err := OpenStore(path)
+	if err != nil {
+		t.Fatalf("OpenStore() error = %v", err)
+	}
+
+	if got := s.Get("user-1"); *got != *Default() {
+		t.Errorf("Get() = %+v, expected the defaults", got)
+	}
+
+	brussels := &Preferences{Timezone: "Europe/Brussels", Clock: Clock24h, DateFormat: DateEU, Language: "fr"}
+	if err := s.Set("user-1", brussels); err != nil {
+		t.Fatalf("Set() error = %v", err)
+	}
+	if err := s.Set("user-1", &Preferences{Timezone: "nowhere"}); err == nil {
+		t.Error("Set() error = nil, expected invalid preferences to be rejected")
+	}
+
+	// preferences are per user, and survive restarts
+	reopened, err := OpenStore(path)
+	if err != nil {
+		t.Fatalf("OpenStore() error = %v", err)
+	}
+	if got := reopened.Get("user-1"); *got != *brussels {
+		t.Errorf("Get(user-1) = %+v, expected %+v", got, brussels)
+	}
+	if got := reopened.Get("user-2"); *got != *Default() {
+		t.Errorf("Get(user-2) = %+v, expected the defaults", got)
+	}
+}
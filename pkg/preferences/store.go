@@ -0,0 +1,104 @@
+package preferences
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"sync"
+)
+
+/*
+Store keeps the preferences of every user, keyed by user id
+
+Preferences are kept in memory and, if a file is given, saved to it so they survive restarts
+*/
+type Store struct {
+	mu          sync.RWMutex
+	preferences map[string]*Preferences
+	path        string
+}
+
+// Create an in memory store
+func NewStore() *Store {
+	return &Store{preferences: map[string]*Preferences{}}
+}
+
+// Create a store persisted in path, existing preferences are loaded
+func OpenStore(path string) (*Store, error) {
+	s := NewStore()
+	s.path = path
+
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return s, nil
+	} else if err != nil {
+		return nil, fmt.Errorf("failed to read preferences: %w", err)
+	}
+
+	if err := json.Unmarshal(data, &s.preferences); err != nil {
+		return nil, fmt.Errorf("failed to deserialize preferences: %w", err)
+	}
+
+	return s, nil
+}
+
+// Returns a copy of the preferences of the user, the default ones if they were never changed
+func (s *Store) Get(userID string) *Preferences {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	p, ok := s.preferences[userID]
+	if !ok {
+		return Default()
+	}
+	result := *p
+	return &result
+}
+
+// Replace the preferences of the user, they must be valid
+func (s *Store) Set(userID string, p *Preferences) error {
+	if err := p.Validate(); err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	previous, found := s.preferences[userID]
+	value := *p
+	s.preferences[userID] = &value
+	if err := s.save(); err != nil {
+		// keep the memory and the file consistent
+		if found {
+			s.preferences[userID] = previous
+		} else {
+			delete(s.preferences, userID)
+		}
+		return err
+	}
+
+	return nil
+}
+
+// write every preference to the file, the file is replaced atomically. Must be called with the lock held
+func (s *Store) save() error {
+	if s.path == "" {
+		return nil
+	}
+
+	data, err := json.Marshal(s.preferences)
+	if err != nil {
+		return fmt.Errorf("failed to serialize preferences: %w", err)
+	}
+
+	tmp := s.path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0600); err != nil {
+		return fmt.Errorf("failed to write preferences: %w", err)
+	}
+	if err := os.Rename(tmp, s.path); err != nil {
+		return fmt.Errorf("failed to write preferences: %w", err)
+	}
+
+	return nil
+}
@@ -1,8 +1,20 @@
 package util
 
+import "context"
+
 type contextKey = string
 
 const (
 	TraceIDContextKey       contextKey = "trace_id"
 	SenderTraceIDContextKey contextKey = "sender_trace_id"
+	// per request nonce allowed by the Content-Security-Policy
+	CSPNonceContextKey contextKey = "csp_nonce"
+	// CSRF token to embed in the forms of the page
+	CSRFTokenContextKey contextKey = "csrf_token"
 )
+
+// Returns the CSP nonce of the request, empty if the security headers middleware didn't generate one
+func CSPNonce(ctx context.Context) string {
+	nonce, _ := ctx.Value(CSPNonceContextKey).(string)
+	return nonce
+}
@@ -3,4 +3,6 @@ package util
 const (
 	// Optional header that can be used by clients to group together log entries
 	HeaderSenderTraceID = "Sender-Trace-Id"
+	// Header in which non-form clients send the CSRF token
+	HeaderCSRFToken = "X-Csrf-Token"
 )
@@ -0,0 +1,95 @@
+package util
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+)
+
+/*
+JSONLines is an append-only file of json values, one per line, such as the audit trail and the archive
+
+A line is only complete once its trailing newline is written. A write that fails half way is truncated, and so is a
+partial or corrupt last line left by a crash, so the file can always be reloaded. Not safe for concurrent use
+*/
+type JSONLines struct {
+	file *os.File
+	// offset of the end of the last complete line
+	size int64
+}
+
+// Open the file, creating it if needed. decode is called with every line already in the file, in order
+func OpenJSONLines(path string, decode func(line []byte) error) (*JSONLines, error) {
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_RDWR|os.O_APPEND, 0600)
+	if err != nil {
+		return nil, err
+	}
+
+	f := &JSONLines{file: file}
+	if err := f.load(path, decode); err != nil {
+		file.Close()
+		return nil, err
+	}
+	return f, nil
+}
+
+func (f *JSONLines) load(path string, decode func(line []byte) error) error {
+	reader := bufio.NewReader(f.file)
+	for {
+		line, err := reader.ReadBytes('\n')
+		if errors.Is(err, io.EOF) && len(line) == 0 {
+			return nil
+		} else if err != nil && !errors.Is(err, io.EOF) {
+			return err
+		}
+
+		// a line without its newline was never completely written
+		if err != nil {
+			return f.dropLastLine(path, errors.New("missing newline"))
+		}
+		if len(bytes.TrimSpace(line)) > 0 {
+			if err := decode(line); err != nil {
+				if _, peekErr := reader.Peek(1); !errors.Is(peekErr, io.EOF) {
+					return fmt.Errorf("line at offset %d: %w", f.size, err)
+				}
+				return f.dropLastLine(path, err)
+			}
+		}
+		f.size += int64(len(line))
+	}
+}
+
+// truncate the file to its last complete line
+func (f *JSONLines) dropLastLine(path string, reason error) error {
+	DefaultLogger.Warn("dropping the incomplete last line", "path", path, "offset", f.size, "error", reason)
+	return f.file.Truncate(f.size)
+}
+
+// Append v as a new line
+func (f *JSONLines) Append(v any) error {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return fmt.Errorf("failed to serialize: %w", err)
+	}
+
+	n, err := f.file.Write(append(data, '\n'))
+	if err != nil {
+		// otherwise the next line would be appended to what was written of this one
+		if n > 0 {
+			if truncateErr := f.file.Truncate(f.size); truncateErr != nil {
+				return errors.Join(err, truncateErr)
+			}
+		}
+		return err
+	}
+	f.size += int64(n)
+	return nil
+}
+
+func (f *JSONLines) Close() error {
+	return f.file.Close()
+}
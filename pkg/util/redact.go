@@ -0,0 +1,225 @@
+package util
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/url"
+	"regexp"
+	"strings"
+)
+
+// Replaces the values that must not be logged
+const Redacted = "[REDACTED]"
+
+// Attributes masked by default, whatever their value
+var DefaultRedactKeys = []string{"authorization", "password", "secret", "token", "access_token", "refresh_token", "api_key"}
+
+// Attributes identifying a user, hashed by default
+var DefaultHashKeys = []string{"id", "user_id", "caller_id", "username", "caller_name", "feed_username", "old_feed_username", "email"}
+
+// returned when attributes are hashed without a secret, the hashes of guessable values could be reversed
+var ErrMissingHashSecret = errors.New("a hash secret is required to hash log attributes")
+
+/*
+What the logs must not hold
+
+Whatever the configuration, bearer tokens, JWTs, emails, the query strings and credentials of URLs and the usernames of
+/user/ paths (i.e. the ListenBrainz feeds) are masked in every message and string value
+*/
+type RedactConfig struct {
+	// attributes masked with Redacted, case insensitive
+	Keys []string
+	// attributes replaced by a hash of their value, case insensitive. The same value always gets the same hash, so the
+	// logs of a user can still be correlated
+	HashKeys []string
+	// key of the HMAC used to hash the values, required if HashKeys isn't empty. Without it the hashes of guessable
+	// values (i.e. usernames) could be reversed
+	HashSecret []byte
+}
+
+/*
+Build the configuration from the flags of a binary
+
+  - keys, hashKeys: comma separated attribute names
+  - hashSecret: key of the HMAC, see RedactConfig. A random one is generated if it is empty, the hashes then change on
+    every restart
+*/
+func NewRedactConfig(keys, hashKeys, hashSecret string) (*RedactConfig, error) {
+	config := &RedactConfig{
+		Keys:       splitKeys(keys),
+		HashKeys:   splitKeys(hashKeys),
+		HashSecret: []byte(hashSecret),
+	}
+	if len(config.HashKeys) > 0 && len(config.HashSecret) == 0 {
+		var err error
+		if config.HashSecret, err = randomHashSecret(); err != nil {
+			return nil, err
+		}
+	}
+	return config, nil
+}
+
+func randomHashSecret() ([]byte, error) {
+	secret := make([]byte, 32)
+	if _, err := rand.Read(secret); err != nil {
+		return nil, fmt.Errorf("could not generate log hash secret: %w", err)
+	}
+	return secret, nil
+}
+
+func splitKeys(value string) []string {
+	var keys []string
+	for _, key := range strings.Split(value, ",") {
+		if key = strings.TrimSpace(key); key != "" {
+			keys = append(keys, key)
+		}
+	}
+	return keys
+}
+
+// slog.Handler masking the sensitive values of the records before passing them to the next handler
+type redactingHandler struct {
+	next     slog.Handler
+	keys     map[string]bool
+	hashKeys map[string]bool
+	secret   []byte
+}
+
+// Wrap next so the records are redacted according to the configuration
+func NewRedactingHandler(next slog.Handler, config *RedactConfig) slog.Handler {
+	return newRedactingHandler(next, config)
+}
+
+func newRedactingHandler(next slog.Handler, config *RedactConfig) *redactingHandler {
+	h := &redactingHandler{
+		next:     next,
+		keys:     map[string]bool{},
+		hashKeys: map[string]bool{},
+		secret:   config.HashSecret,
+	}
+	for _, key := range config.Keys {
+		h.keys[strings.ToLower(key)] = true
+	}
+	for _, key := range config.HashKeys {
+		h.hashKeys[strings.ToLower(key)] = true
+	}
+	return h
+}
+
+func (h *redactingHandler) Enabled(ctx context.Context, level slog.Level) bool {
+	return h.next.Enabled(ctx, level)
+}
+
+func (h *redactingHandler) Handle(ctx context.Context, record slog.Record) error {
+	redacted := slog.NewRecord(record.Time, record.Level, redactString(record.Message), record.PC)
+	record.Attrs(func(attr slog.Attr) bool {
+		redacted.AddAttrs(h.redact(attr))
+		return true
+	})
+	return h.next.Handle(ctx, redacted)
+}
+
+// the attributes are redacted once, when the logger is derived
+func (h *redactingHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	redacted := make([]slog.Attr, len(attrs))
+	for i, attr := range attrs {
+		redacted[i] = h.redact(attr)
+	}
+	return &redactingHandler{next: h.next.WithAttrs(redacted), keys: h.keys, hashKeys: h.hashKeys, secret: h.secret}
+}
+
+func (h *redactingHandler) WithGroup(name string) slog.Handler {
+	return &redactingHandler{next: h.next.WithGroup(name), keys: h.keys, hashKeys: h.hashKeys, secret: h.secret}
+}
+
+func (h *redactingHandler) redact(attr slog.Attr) slog.Attr {
+	attr.Value = attr.Value.Resolve()
+	key := strings.ToLower(attr.Key)
+
+	switch {
+	case attr.Value.Kind() == slog.KindGroup:
+		group := attr.Value.Group()
+		redacted := make([]slog.Attr, len(group))
+		for i, member := range group {
+			redacted[i] = h.redact(member)
+		}
+		return slog.Attr{Key: attr.Key, Value: slog.GroupValue(redacted...)}
+	case h.keys[key]:
+		return slog.String(attr.Key, Redacted)
+	case h.hashKeys[key]:
+		return slog.String(attr.Key, h.hash(attr.Value.String()))
+	}
+
+	switch attr.Value.Kind() {
+	case slog.KindString:
+		return slog.String(attr.Key, redactString(attr.Value.String()))
+	case slog.KindAny:
+		// errors and urls are formatted by the next handler, they may hold anything
+		switch v := attr.Value.Any().(type) {
+		case error:
+			return slog.String(attr.Key, redactString(v.Error()))
+		case fmt.Stringer:
+			return slog.String(attr.Key, redactString(v.String()))
+		}
+	}
+	return attr
+}
+
+// empty values are kept, so a missing identifier can still be told apart
+func (h *redactingHandler) hash(value string) string {
+	if value == "" {
+		return ""
+	}
+	mac := hmac.New(sha256.New, h.secret)
+	mac.Write([]byte(value))
+	return hex.EncodeToString(mac.Sum(nil)[:8])
+}
+
+var (
+	urlPattern    = regexp.MustCompile(`\b[a-zA-Z][a-zA-Z0-9+.\-]*://[^\s"'<>]+`)
+	bearerPattern = regexp.MustCompile(`(?i)\b(bearer)\s+[^\s"',;]+`)
+	jwtPattern    = regexp.MustCompile(`\beyJ[A-Za-z0-9_\-]+\.[A-Za-z0-9_\-]+\.[A-Za-z0-9_\-]*`)
+	emailPattern  = regexp.MustCompile(`[A-Za-z0-9._%+\-]+@[A-Za-z0-9\-]+(\.[A-Za-z0-9\-]+)*\.[A-Za-z]{2,}`)
+	// the segment after /user/ is a username, i.e. /syndication-feed/user/<username>/listens
+	userPathPattern = regexp.MustCompile(`(/user/)[^/\s"'<>?#]+`)
+)
+
+// Mask the urls first, their credentials would otherwise look like emails
+func redactString(s string) string {
+	s = urlPattern.ReplaceAllStringFunc(s, redactURL)
+	s = userPathPattern.ReplaceAllString(s, "${1}"+Redacted)
+	s = bearerPattern.ReplaceAllString(s, "$1 "+Redacted)
+	s = jwtPattern.ReplaceAllString(s, Redacted)
+	return emailPattern.ReplaceAllString(s, Redacted)
+}
+
+// The credentials, the query string (i.e. subscription tokens) and the fragment are masked, the rest of the url is kept
+func redactURL(raw string) string {
+	u, err := url.Parse(raw)
+	if err != nil {
+		return Redacted
+	}
+	user := u.User != nil
+	query := u.RawQuery != "" || u.ForceQuery
+	fragment := u.Fragment != ""
+	u.User, u.RawQuery, u.ForceQuery, u.Fragment, u.RawFragment = nil, "", false, "", ""
+
+	// the markers are added after String, they would be escaped otherwise
+	redacted := u.String()
+	if user {
+		redacted = strings.Replace(redacted, "://", "://"+Redacted+"@", 1)
+	}
+	if query {
+		redacted += "?" + Redacted
+	}
+	if fragment {
+		redacted += "#" + Redacted
+	}
+	return redacted
+}
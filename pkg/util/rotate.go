@@ -0,0 +1,130 @@
+package util
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"sync"
+)
+
+/*
+RotatingFile is a log file rotated once it reaches its maximum size
+
+The current file is renamed with the .1 suffix, the previous .1 becomes .2 and so on. Only maxBackups rotated files are
+kept, the oldest ones are removed:
+
+	api.log      <- written to
+	api.log.1    <- the previous one
+	api.log.2
+*/
+type RotatingFile struct {
+	mu         sync.Mutex
+	path       string
+	maxSize    int64
+	maxBackups int
+
+	file *os.File
+	size int64
+}
+
+// Open the file, appending to it if it exists. The file is never rotated if maxSize is 0
+func OpenRotatingFile(path string, maxSize int64, maxBackups int) (*RotatingFile, error) {
+	f := &RotatingFile{path: path, maxSize: maxSize, maxBackups: maxBackups}
+	if err := f.open(); err != nil {
+		return nil, err
+	}
+	return f, nil
+}
+
+func (f *RotatingFile) open() error {
+	file, err := os.OpenFile(f.path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0600)
+	if err != nil {
+		return fmt.Errorf("failed to open log file: %w", err)
+	}
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return fmt.Errorf("failed to open log file: %w", err)
+	}
+
+	f.file = file
+	f.size = info.Size()
+	return nil
+}
+
+// Write p to the file, rotating it first if p would make it larger than the maximum size
+func (f *RotatingFile) Write(p []byte) (int, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if f.file == nil {
+		return 0, os.ErrClosed
+	}
+
+	// a record is never split across files, even if it is larger than the maximum size
+	if f.maxSize > 0 && f.size > 0 && f.size+int64(len(p)) > f.maxSize {
+		if err := f.rotate(); err != nil {
+			if f.file == nil {
+				return 0, err
+			}
+			// the file grows past its maximum size rather than losing records, the rotation is tried again next write
+			n, _ := f.file.Write(p)
+			f.size += int64(n)
+			return n, err
+		}
+	}
+
+	n, err := f.file.Write(p)
+	f.size += int64(n)
+	return n, err
+}
+
+// Must be called with the lock held. If the file can't be moved, it is opened again so the writes go on
+func (f *RotatingFile) rotate() error {
+	err := f.file.Close()
+	f.file = nil
+	if err == nil {
+		err = f.shift()
+	}
+	if err != nil {
+		if openErr := f.open(); openErr != nil {
+			return fmt.Errorf("failed to rotate log file: %w", errors.Join(err, openErr))
+		}
+		return fmt.Errorf("failed to rotate log file: %w", err)
+	}
+	return f.open()
+}
+
+// Move the file to the first backup and every backup to the next one, the oldest one is overwritten
+func (f *RotatingFile) shift() error {
+	if f.maxBackups <= 0 {
+		if err := os.Remove(f.path); err != nil && !errors.Is(err, os.ErrNotExist) {
+			return err
+		}
+		return nil
+	}
+
+	for i := f.maxBackups - 1; i >= 1; i-- {
+		err := os.Rename(f.backup(i), f.backup(i+1))
+		if err != nil && !errors.Is(err, os.ErrNotExist) {
+			return err
+		}
+	}
+	return os.Rename(f.path, f.backup(1))
+}
+
+func (f *RotatingFile) backup(i int) string {
+	return fmt.Sprintf("%s.%d", f.path, i)
+}
+
+func (f *RotatingFile) Close() error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if f.file == nil {
+		return nil
+	}
+	err := f.file.Close()
+	f.file = nil
+	return err
+}
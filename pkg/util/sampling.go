@@ -0,0 +1,102 @@
+package util
+
+import (
+	"context"
+	"log/slog"
+	"sync"
+	"time"
+)
+
+// window of the sampling, unless configured otherwise
+const DefaultSampleInterval = time.Second
+
+/*
+Sampling of the high volume records, i.e. the access logs
+
+Every Interval, the first First records with the same level and message are logged, then one every Thereafter (none if
+0). Warnings and errors are never sampled
+*/
+type SamplingConfig struct {
+	First      int
+	Thereafter int
+	// DefaultSampleInterval if 0
+	Interval time.Duration
+}
+
+// slog.Handler dropping records according to a SamplingConfig
+type samplingHandler struct {
+	next    slog.Handler
+	sampler *sampler
+}
+
+// Wrap next so the records below Warn are sampled
+func NewSamplingHandler(next slog.Handler, config *SamplingConfig) slog.Handler {
+	interval := config.Interval
+	if interval <= 0 {
+		interval = DefaultSampleInterval
+	}
+	return &samplingHandler{
+		next: next,
+		sampler: &sampler{
+			first:      config.First,
+			thereafter: config.Thereafter,
+			interval:   interval,
+			counts:     map[string]int{},
+		},
+	}
+}
+
+func (h *samplingHandler) Enabled(ctx context.Context, level slog.Level) bool {
+	return h.next.Enabled(ctx, level)
+}
+
+func (h *samplingHandler) Handle(ctx context.Context, record slog.Record) error {
+	if !h.sampler.allow(record) {
+		return nil
+	}
+	return h.next.Handle(ctx, record)
+}
+
+// the derived handlers share the counters, records are sampled whatever their attributes are
+func (h *samplingHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	return &samplingHandler{next: h.next.WithAttrs(attrs), sampler: h.sampler}
+}
+
+func (h *samplingHandler) WithGroup(name string) slog.Handler {
+	return &samplingHandler{next: h.next.WithGroup(name), sampler: h.sampler}
+}
+
+// counts the records of the current window
+type sampler struct {
+	first      int
+	thereafter int
+	interval   time.Duration
+
+	mu          sync.Mutex
+	windowStart time.Time
+	counts      map[string]int
+}
+
+func (s *sampler) allow(record slog.Record) bool {
+	if record.Level >= slog.LevelWarn {
+		return true
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	// a new window starts, every message can be logged again
+	if record.Time.Before(s.windowStart) || record.Time.Sub(s.windowStart) >= s.interval {
+		s.windowStart = record.Time
+		clear(s.counts)
+	}
+
+	key := record.Level.String() + " " + record.Message
+	s.counts[key]++
+	n := s.counts[key]
+
+	if n <= s.first {
+		return true
+	}
+	return s.thereafter > 0 && (n-s.first)%s.thereafter == 0
+}
@@ -2,32 +2,182 @@ package util
 
 import (
 	"context"
+	"fmt"
+	"io"
 	"log/slog"
 	"os"
+	"slices"
+	"strings"
 )
 
+// Logger used by the handlers, replaced by the binaries once their configuration is known, see SetDefaultLogger
 var DefaultLogger *Logger
 
 // Slog wrapper
 type Logger struct {
 	*slog.Logger
+	// shared by the loggers derived from this one, so the level can be changed at runtime
+	level *slog.LevelVar
+	// nil if the records aren't redacted
+	redact *redactingHandler
 }
 
 func init() {
 	DefaultLogger = NewLogger()
 }
 
+// Text logger on stdout, at Info level, redacting the default keys. The values are hashed with a random secret
 func NewLogger() *Logger {
+	redact, err := NewRedactConfig(strings.Join(DefaultRedactKeys, ","), strings.Join(DefaultHashKeys, ","), "")
+	if err != nil {
+		// without a secret the values would be reversible, they are masked instead
+		redact = &RedactConfig{Keys: append(slices.Clone(DefaultRedactKeys), DefaultHashKeys...)}
+	}
+	logger, _, _ := NewLoggerWithConfig(&LogConfig{Redact: redact})
+	return logger
+}
+
+// Output format of the logs
+type LogFormat string
+
+const (
+	LogFormatText LogFormat = "text"
+	LogFormatJSON LogFormat = "json"
+)
+
+func ParseLogFormat(value string) (LogFormat, error) {
+	format := LogFormat(strings.ToLower(value))
+	switch format {
+	case LogFormatText, LogFormatJSON:
+		return format, nil
+	default:
+		return "", fmt.Errorf("unknown log format %q, expected text or json", value)
+	}
+}
+
+// Parse a level: debug, info, warn, error, optionally with an offset (i.e. info+2)
+func ParseLogLevel(value string) (slog.Level, error) {
+	var level slog.Level
+	if err := level.UnmarshalText([]byte(value)); err != nil {
+		return 0, fmt.Errorf("unknown log level %q, expected debug, info, warn or error", value)
+	}
+	return level, nil
+}
+
+// How a logger is built, the zero value logs text on stdout at Info level
+type LogConfig struct {
+	// text by default
+	Format LogFormat
+	Level  slog.Level
+	// logs go to stdout if empty
+	File string
+	// the file is rotated once it reaches this size in bytes, never if 0
+	MaxSize int64
+	// number of rotated files kept, see RotatingFile
+	MaxBackups int
+	// records below Warn are sampled if set, see SamplingConfig
+	Sampling *SamplingConfig
+	// records are redacted if set, see RedactConfig
+	Redact *RedactConfig
+}
+
+/*
+Build the configuration from the flags of a binary
+
+  - format: text or json
+  - level: see ParseLogLevel
+  - file, maxSize, maxBackups: see LogConfig, the logs go to stdout if file is empty
+  - sampleFirst, sampleThereafter: see SamplingConfig, no sampling if sampleFirst is 0
+*/
+func NewLogConfig(format, level, file string, maxSize int64, maxBackups, sampleFirst, sampleThereafter int) (*LogConfig, error) {
+	config := &LogConfig{File: file, MaxSize: maxSize, MaxBackups: maxBackups}
+
+	var err error
+	if config.Format, err = ParseLogFormat(format); err != nil {
+		return nil, err
+	}
+	if config.Level, err = ParseLogLevel(level); err != nil {
+		return nil, err
+	}
+	if sampleFirst > 0 {
+		config.Sampling = &SamplingConfig{First: sampleFirst, Thereafter: sampleThereafter}
+	}
+	return config, nil
+}
+
+/*
+Build a logger from the configuration
+
+The returned closer must be called once the logger isn't used anymore, it closes the log file if any
+*/
+func NewLoggerWithConfig(config *LogConfig) (*Logger, io.Closer, error) {
+	if config.Redact != nil && len(config.Redact.HashKeys) > 0 && len(config.Redact.HashSecret) == 0 {
+		return nil, nil, ErrMissingHashSecret
+	}
+
+	var output io.WriteCloser = nopCloser{os.Stdout}
+	if config.File != "" {
+		file, err := OpenRotatingFile(config.File, config.MaxSize, config.MaxBackups)
+		if err != nil {
+			return nil, nil, err
+		}
+		output = file
+	}
+
+	level := new(slog.LevelVar)
+	level.Set(config.Level)
 	opts := &slog.HandlerOptions{
-		Level: slog.LevelInfo,
+		Level: level,
 	}
 
-	handler := slog.NewTextHandler(os.Stdout, opts)
-	logger := slog.New(handler)
+	var handler slog.Handler
+	switch config.Format {
+	case LogFormatJSON:
+		handler = slog.NewJSONHandler(output, opts)
+	case LogFormatText, "":
+		handler = slog.NewTextHandler(output, opts)
+	default:
+		output.Close()
+		return nil, nil, fmt.Errorf("unknown log format %q", config.Format)
+	}
 
-	return &Logger{
-		Logger: logger,
+	var redact *redactingHandler
+	if config.Redact != nil {
+		redact = newRedactingHandler(handler, config.Redact)
+		handler = redact
+	}
+	if config.Sampling != nil {
+		handler = NewSamplingHandler(handler, config.Sampling)
+	}
+
+	return &Logger{Logger: slog.New(handler), level: level, redact: redact}, output, nil
+}
+
+/*
+Replace DefaultLogger, and the default logger of the slog package so the logs of the libraries look the same.
+Must be called before the routes are set up
+*/
+func SetDefaultLogger(logger *Logger) {
+	DefaultLogger = logger
+	slog.SetDefault(logger.Logger)
+}
+
+// Returns the minimum level of the logged records
+func (l *Logger) Level() slog.Level {
+	return l.level.Level()
+}
+
+// Changes the minimum level of the logged records, for this logger and every logger derived from it
+func (l *Logger) SetLevel(level slog.Level) {
+	l.level.Set(level)
+}
+
+// Returns value as the logger would log it under key, i.e. hashed for a user id. For what is written outside of the logger
+func (l *Logger) Redact(key, value string) string {
+	if l.redact == nil {
+		return value
 	}
+	return l.redact.redact(slog.String(key, value)).Value.String()
 }
 
 // Create a new logger that logs values stored in the context
@@ -50,6 +200,8 @@ func (l *Logger) FromContext(ctx context.Context) *Logger {
 
 		return &Logger{
 			Logger: logger,
+			level:  l.level,
+			redact: l.redact,
 		}
 	}
 
@@ -61,5 +213,16 @@ func (l *Logger) FromContext(ctx context.Context) *Logger {
 
 	return &Logger{
 		Logger: logger,
+		level:  l.level,
+		redact: l.redact,
 	}
 }
+
+// stdout must not be closed with the logger
+type nopCloser struct {
+	io.Writer
+}
+
+func (nopCloser) Close() error {
+	return nil
+}
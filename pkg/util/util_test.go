@@ -0,0 +1,235 @@
+package util
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestNewLoggerWithConfig(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "api.log")
+	logger, closer, err := NewLoggerWithConfig(&LogConfig{Format: LogFormatJSON, Level: slog.LevelWarn, File: path})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	logger.Info("dropped")
+	ctx := context.WithValue(context.Background(), TraceIDContextKey, "trace-1")
+	derived := logger.FromContext(ctx)
+	derived.Warn("kept")
+
+	// the level of the derived loggers changes as well
+	logger.SetLevel(slog.LevelDebug)
+	derived.Debug("debug")
+	closer.Close()
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	lines := strings.Split(strings.TrimSpace(string(data)), "\n")
+	if len(lines) != 2 {
+		t.Fatalf("expected 2 records, got %q", lines)
+	}
+	var record map[string]any
+	if err := json.Unmarshal([]byte(lines[0]), &record); err != nil {
+		t.Fatalf("records should be json: %v", err)
+	}
+	if record["msg"] != "kept" || record["trace_id"] != "trace-1" {
+		t.Errorf("unexpected record %v", record)
+	}
+
+	if _, _, err := NewLoggerWithConfig(&LogConfig{Format: "xml"}); err == nil {
+		t.Error("unknown formats should be rejected")
+	}
+}
+
+func TestParseLogLevel(t *testing.T) {
+	tests := map[string]slog.Level{"debug": slog.LevelDebug, "INFO": slog.LevelInfo, "warn": slog.LevelWarn, "error+2": slog.LevelError + 2}
+	for value, expected := range tests {
+		if level, err := ParseLogLevel(value); err != nil || level != expected {
+			t.Errorf("ParseLogLevel(%q) = %v, %v, expected %v", value, level, err, expected)
+		}
+	}
+	if _, err := ParseLogLevel("loud"); err == nil {
+		t.Error("unknown levels should be rejected")
+	}
+}
+
+func TestRotatingFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "api.log")
+	file, err := OpenRotatingFile(path, 10, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// each write fills a file, the oldest one is removed once 2 backups exist
+	for _, line := range []string{"first\n", "second\n", "third\n", "fourth\n"} {
+		if _, err := file.Write([]byte(line)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	file.Close()
+
+	expected := map[string]string{path: "fourth\n", path + ".1": "third\n", path + ".2": "second\n"}
+	for name, content := range expected {
+		data, err := os.ReadFile(name)
+		if err != nil || string(data) != content {
+			t.Errorf("%s = %q, %v, expected %q", filepath.Base(name), data, err, content)
+		}
+	}
+	if _, err := os.Stat(path + ".3"); !os.IsNotExist(err) {
+		t.Errorf("only 2 backups should be kept")
+	}
+
+	// appends to the existing file, and rotates according to its size
+	file, err = OpenRotatingFile(path, 10, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	file.Write([]byte("fifth\n"))
+	file.Close()
+	if data, _ := os.ReadFile(path); string(data) != "fifth\n" {
+		t.Errorf("file = %q, expected a rotation", data)
+	}
+}
+
+func TestRotatingFileKeepsWritingWhenTheRotationFails(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "api.log")
+	file, err := OpenRotatingFile(path, 10, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+
+	// the file can't be renamed over a non empty directory
+	if err := os.MkdirAll(filepath.Join(path+".1", "busy"), 0700); err != nil {
+		t.Fatal(err)
+	}
+	file.Write([]byte("first\n"))
+	if _, err := file.Write([]byte("second\n")); err == nil {
+		t.Error("the failed rotation should be reported")
+	}
+
+	// tried again once the backup can be written
+	if err := os.RemoveAll(path + ".1"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := file.Write([]byte("third\n")); err != nil {
+		t.Fatal(err)
+	}
+
+	expected := map[string]string{path: "third\n", path + ".1": "first\nsecond\n"}
+	for name, content := range expected {
+		data, err := os.ReadFile(name)
+		if err != nil || string(data) != content {
+			t.Errorf("%s = %q, %v, expected %q", filepath.Base(name), data, err, content)
+		}
+	}
+}
+
+func TestJSONLinesRecoversFromATornWrite(t *testing.T) {
+	tests := []struct {
+		name     string
+		content  string
+		expected []string
+		wantErr  bool
+	}{
+		{
+			name:     "partial last line",
+			content:  "{\"v\":\"a\"}\n{\"v\":\"b\"}\n{\"v\":",
+			expected: []string{"a", "b"},
+		},
+		{
+			name:     "corrupt last line",
+			content:  "{\"v\":\"a\"}\n{\"v\n",
+			expected: []string{"a"},
+		},
+		{
+			name:    "corrupt line before the last one",
+			content: "{\"v\":\"a\"}\n{\"v\n{\"v\":\"b\"}\n",
+			wantErr: true,
+		},
+	}
+
+	type value struct {
+		V string `json:"v"`
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "values.jsonl")
+			if err := os.WriteFile(path, []byte(tt.content), 0600); err != nil {
+				t.Fatal(err)
+			}
+
+			var loaded []string
+			file, err := OpenJSONLines(path, func(line []byte) error {
+				var v value
+				if err := json.Unmarshal(line, &v); err != nil {
+					return err
+				}
+				loaded = append(loaded, v.V)
+				return nil
+			})
+			if tt.wantErr {
+				if err == nil {
+					t.Error("OpenJSONLines() error = nil, expected the corrupt line to be reported")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("OpenJSONLines() error = %v", err)
+			}
+			if !slices.Equal(loaded, tt.expected) {
+				t.Errorf("loaded %v, expected %v", loaded, tt.expected)
+			}
+
+			// the next line doesn't end up after the dropped one
+			if err := file.Append(&value{V: "c"}); err != nil {
+				t.Fatalf("Append() error = %v", err)
+			}
+			file.Close()
+			data, _ := os.ReadFile(path)
+			if !strings.HasSuffix(string(data), "}\n{\"v\":\"c\"}\n") || strings.Count(string(data), "\n") != len(tt.expected)+1 {
+				t.Errorf("file = %q, expected the loaded lines followed by the appended one", data)
+			}
+		})
+	}
+}
+
+func TestSamplingHandler(t *testing.T) {
+	var output bytes.Buffer
+	handler := NewSamplingHandler(slog.NewTextHandler(&output, nil), &SamplingConfig{First: 2, Thereafter: 3, Interval: time.Second})
+	// attributes don't matter, the derived handlers share the counters
+	derived := handler.WithAttrs([]slog.Attr{slog.String("path", "/api/feed")})
+
+	start := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	count := func(h slog.Handler, at time.Time, level slog.Level, message string, n int) {
+		for range n {
+			h.Handle(context.Background(), slog.NewRecord(at, level, message, 0))
+		}
+	}
+
+	// 2 first, then the 5th and the 8th
+	count(handler, start, slog.LevelInfo, "http request completed", 4)
+	count(derived, start, slog.LevelInfo, "http request completed", 4)
+	// other messages have their own counters, warnings are never sampled
+	count(handler, start, slog.LevelInfo, "retrieving user feed", 1)
+	count(handler, start, slog.LevelWarn, "http request completed", 3)
+	// a new window
+	count(handler, start.Add(time.Second), slog.LevelInfo, "http request completed", 1)
+
+	expected := map[string]int{"level=INFO msg=\"http request completed\"": 5, "retrieving user feed": 1, "level=WARN": 3}
+	for pattern, n := range expected {
+		if got := strings.Count(output.String(), pattern); got != n {
+			t.Errorf("%d records matching %q, expected %d\n%s", got, pattern, n, output.String())
+		}
+	}
+}
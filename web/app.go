@@ -2,19 +2,26 @@ package web
 
 import (
 	"context"
+	"crypto/tls"
 	"embed"
+	"errors"
 	"fmt"
-	"html"
+	"html/template"
+	"io"
 	"net/http"
-	"text/template"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
 
+	"github.com/xaviercrochet/turbo-octo-adventure/pkg/auth"
 	mw "github.com/xaviercrochet/turbo-octo-adventure/pkg/middleware"
 	"github.com/xaviercrochet/turbo-octo-adventure/pkg/net"
+	"github.com/xaviercrochet/turbo-octo-adventure/pkg/preferences"
 	"github.com/xaviercrochet/turbo-octo-adventure/pkg/util"
 	"github.com/xaviercrochet/turbo-octo-adventure/web/feed_api"
+	"github.com/xaviercrochet/turbo-octo-adventure/web/i18n"
 	"github.com/zitadel/zitadel-go/v3/pkg/authentication"
-	openid "github.com/zitadel/zitadel-go/v3/pkg/authentication/oidc"
-	"github.com/zitadel/zitadel-go/v3/pkg/zitadel"
 )
 
 //go:embed "templates/*.html"
@@ -28,17 +35,94 @@ type ServerOptions struct {
 	redirectURI string
 	apiHostname string
 	apiPort     string
+	// if set, the api is called over https with this config
+	apiTLSConfig *tls.Config
+	// how users log in, ZITADEL by default
+	authConfig *auth.Config
+	// headers set on every page, see mw.DefaultSecurityHeaders
+	securityHeaders *mw.SecurityHeaders
+	// url at which feed readers reach the api, the url used by the web app if empty
+	publicAPIURL string
+	// maximum size of the responses of the api, net.DefaultMaxBodySize if 0
+	maxResponseSize int64
+	// how the requests are logged, see mw.DefaultAccessLog
+	accessLog *mw.AccessLog
 }
 
-func NewServerOptions(base64Key []byte, apiHostname, apiPort, domain, clientID, redirectURI string) *ServerOptions {
-	return &ServerOptions{
-		base64Key:   base64Key,
-		domain:      domain,
-		clientID:    clientID,
-		redirectURI: redirectURI,
-		apiHostname: apiHostname,
-		apiPort:     apiPort,
+// Option allows customization of the ServerOptions
+type Option func(*ServerOptions)
+
+// WithAuthConfig selects another authentication backend than ZITADEL
+func WithAuthConfig(config *auth.Config) Option {
+	return func(o *ServerOptions) {
+		o.authConfig = config
+	}
+}
+
+// WithAPITLSConfig makes the web app call the api over (mutual) TLS
+func WithAPITLSConfig(tlsConfig *tls.Config) Option {
+	return func(o *ServerOptions) {
+		o.apiTLSConfig = tlsConfig
+	}
+}
+
+// WithSecurityHeaders replaces the default security headers, i.e. to allow a CDN in the Content-Security-Policy
+func WithSecurityHeaders(headers *mw.SecurityHeaders) Option {
+	return func(o *ServerOptions) {
+		o.securityHeaders = headers
+	}
+}
+
+// WithPublicAPIURL sets the url of the api shown in the subscription urls, when feed readers can't reach the api
+// at the address used by the web app
+func WithPublicAPIURL(url string) Option {
+	return func(o *ServerOptions) {
+		o.publicAPIURL = strings.TrimSuffix(url, "/")
+	}
+}
+
+// WithMaxResponseSize limits the size of the responses of the api, in bytes
+func WithMaxResponseSize(size int64) Option {
+	return func(o *ServerOptions) {
+		o.maxResponseSize = size
+	}
+}
+
+// WithAccessLog replaces the default access log, i.e. to trust a reverse proxy or log in the Combined Log Format
+func WithAccessLog(accessLog *mw.AccessLog) Option {
+	return func(o *ServerOptions) {
+		o.accessLog = accessLog
+	}
+}
+
+func NewServerOptions(base64Key []byte, apiHostname, apiPort, domain, clientID, redirectURI string, options ...Option) *ServerOptions {
+	o := &ServerOptions{
+		base64Key:       base64Key,
+		domain:          domain,
+		clientID:        clientID,
+		redirectURI:     redirectURI,
+		apiHostname:     apiHostname,
+		apiPort:         apiPort,
+		authConfig:      auth.NewZitadelConfig(domain, ""),
+		securityHeaders: mw.DefaultSecurityHeaders(),
+		accessLog:       mw.DefaultAccessLog(),
+	}
+	for _, option := range options {
+		option(o)
+	}
+	return o
+}
+
+// http client that integrate the feed api
+func (o *ServerOptions) newFeedClient() *feed_api.FeedClient {
+	options := []feed_api.Option{}
+	if o.apiTLSConfig != nil {
+		options = append(options, feed_api.WithTLSConfig(o.apiTLSConfig))
 	}
+	if o.maxResponseSize > 0 {
+		options = append(options, feed_api.WithMaxBodySize(o.maxResponseSize))
+	}
+	return feed_api.NewFeedClient(o.apiHostname, o.apiPort, options...)
 }
 
 /*
@@ -46,38 +130,83 @@ func NewServerOptions(base64Key []byte, apiHostname, apiPort, domain, clientID,
 */
 func SetupRoutes(serverCtx context.Context, router *http.ServeMux, options *ServerOptions) error {
 
-	// load html tempates
-	t, err := template.New("").ParseFS(templates, "templates/*.html")
+	// load html tempates and their translations
+	t, err := parseTemplates()
 	if err != nil {
 		return fmt.Errorf("unable to parse template: %v", err)
 	}
+	catalog, err := i18n.Load()
+	if err != nil {
+		return fmt.Errorf("unable to load translations: %v", err)
+	}
+	pages := &renderer{templates: t, catalog: catalog}
 
 	//setup authentication context
-	authN, err := authentication.New(serverCtx, zitadel.New(options.domain), string(options.base64Key),
-		openid.DefaultAuthentication(options.clientID, options.redirectURI, string(options.base64Key)),
-	)
+	sessions := auth.NewSessions()
+	authN, err := auth.NewAuthenticator(serverCtx, options.authConfig, options.clientID, options.redirectURI, string(options.base64Key), sessions)
 	if err != nil {
-		return fmt.Errorf("zitadel sdk could not initialize: %v", err)
+		return err
 	}
 
 	//initialize the authentication middleware
 	authMw := authentication.Middleware(authN)
 
-	// default authentication routes provided by the sdk
-	router.Handle("/auth/", mw.LogMiddleware(authN))
+	// expired access tokens are refreshed instead of failing the api calls
+	refresher, err := auth.NewTokenRefresher(serverCtx, options.authConfig, options.clientID, options.redirectURI)
+	if err != nil {
+		return err
+	}
+	tokens := newSessionTokens(refresher, sessions)
+
+	// shared by every handler so connections (and tls sessions) to the api are reused
+	feedClient := options.newFeedClient()
+
+	publicAPIURL := options.publicAPIURL
+	if publicAPIURL == "" {
+		publicAPIURL = feedClient.BaseURL()
+	}
+
+	// the csrf cookie can only be marked secure if the app is served over https
+	csrfOptions := []mw.CSRFOption{}
+	if !strings.HasPrefix(options.redirectURI, "https://") {
+		csrfOptions = append(csrfOptions, mw.WithCSRFInsecureCookie())
+	}
+	csrf := mw.NewCSRF(options.base64Key, csrfOptions...)
+
+	// every page gets the security headers, state-changing requests must carry a valid csrf token
+	page := func(next http.Handler) http.Handler {
+		return options.securityHeaders.Middleware(csrf.Middleware(next))
+	}
+	logRequests := options.accessLog.Middleware
+
+	// the user is known once authenticated, it is recorded in the access log
+	authenticated := func(next http.Handler) http.Handler {
+		return authMw.RequireAuthentication()(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+			if authCtx := authMw.Context(req.Context()); authCtx != nil && authCtx.UserInfo != nil {
+				mw.SetAccessLogUser(req.Context(), authCtx.UserInfo.Subject)
+			}
+			next.ServeHTTP(w, req)
+		}))
+	}
+
+	// default authentication routes provided by the sdk, the oidc state parameter protects them against csrf. The csrf
+	// session changes with the authenticated user
+	router.Handle("/auth/", logRequests(options.securityHeaders.Middleware(csrf.Rotate(authN))))
+	// same as the login of the sdk, but the user can be sent back to the page they were on
+	router.Handle("/auth/login", logRequests(options.securityHeaders.Middleware(loginHandler(authN.Authenticate))))
 
 	/*
 	   This endpoint
 	   - is only accessible with a valid authentication
 	   - is only accessible for admin users
-	   - only accepts POST requests
+	   - only accepts POST requests with a valid csrf token
 	   - integrate the  /select_feed feed api endpoint to change from which user the feed is retrieved for
 
 	   if the request is successfull, the user is redirected to /feed
 	*/
 	router.Handle("/select_feed",
 		mw.RequestContextMiddleware(
-			mw.LogMiddleware(authMw.RequireAuthentication()(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+			logRequests(page(authenticated(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
 				ctx := req.Context()
 				logger := util.DefaultLogger.FromContext(ctx)
 
@@ -99,29 +228,146 @@ func SetupRoutes(serverCtx context.Context, router *http.ServeMux, options *Serv
 				name := req.FormValue("name")
 				if name == "" {
 					http.Error(w, "name can't be empty", http.StatusBadRequest)
+					return
 				}
-				// sanitize user input
-				name = html.EscapeString(name)
 
-				// http client that integrate the feed api
-				feedClient := feed_api.NewFeedClient(options.apiHostname, options.apiPort)
-				if err := feedClient.SelectFeed(ctx, name, authCtx.Tokens.AccessToken); err == net.ErrNoAccess {
+				err = tokens.do(ctx, authCtx, func(accessToken string) error {
+					return feedClient.SelectFeed(ctx, name, accessToken)
+				})
+				if err == net.ErrNoAccess {
 					logger.Error("select feed api call failed", "error", err)
-					http.Error(w, err.Error(), http.StatusUnauthorized)
+					http.Error(w, err.Error(), http.StatusForbidden)
 					return
-				} else if err == net.ErrNotAuthenticated {
+				} else if errors.Is(err, net.ErrNotAuthenticated) {
+					logger.Warn("select feed api call failed, login required", "error", err)
+					redirectToLogin(w, req)
+					return
+				} else if err != nil {
 					logger.Error("select feed api call failed", "error", err)
+					http.Error(w, err.Error(), http.StatusInternalServerError)
+					return
+				}
+
+				// browser expect a http status 3XX if we want to redirect after a successfull post
+				http.Redirect(w, req, "/feed", http.StatusSeeOther)
+			}))))))
+
+	/*
+	   This endpoint
+	   - is only accessible with a valid authentication
+	   - is only accessible for users granted feed:select
+	   - only accepts POST requests with a valid csrf token
+	   - integrate the /audit/rollback feed api endpoint to restore the feed selected before a given change
+
+	   if the request is successfull, the user is redirected to /feed
+	*/
+	router.Handle("/rollback",
+		mw.RequestContextMiddleware(
+			logRequests(page(authenticated(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+				ctx := req.Context()
+				logger := util.DefaultLogger.FromContext(ctx)
+
+				if req.Method != http.MethodPost {
+					http.Error(w, "not found", http.StatusNotFound)
+					return
+				}
+
+				authCtx := authMw.Context(ctx)
+
+				// deserialize request payload
+				err := req.ParseForm()
+				if err != nil {
+					http.Error(w, "failed to parse form data", http.StatusBadRequest)
+					return
+				}
+
+				// validate user input
+				id, err := strconv.ParseInt(req.FormValue("id"), 10, 64)
+				if err != nil {
+					http.Error(w, "invalid audit entry", http.StatusBadRequest)
+					return
+				}
+
+				err = tokens.do(ctx, authCtx, func(accessToken string) error {
+					return feedClient.Rollback(ctx, id, accessToken)
+				})
+				if err == net.ErrNoAccess {
+					logger.Error("rollback api call failed", "error", err)
 					http.Error(w, err.Error(), http.StatusForbidden)
 					return
+				} else if errors.Is(err, net.ErrNotAuthenticated) {
+					logger.Warn("rollback api call failed, login required", "error", err)
+					redirectToLogin(w, req)
+					return
+				} else if err == net.ErrNotFound {
+					http.Error(w, "audit entry not found", http.StatusNotFound)
+					return
 				} else if err != nil {
-					logger.Error("select feed api call failed", "error", err)
+					logger.Error("rollback api call failed", "error", err)
 					http.Error(w, err.Error(), http.StatusInternalServerError)
 					return
 				}
 
 				// browser expect a http status 3XX if we want to redirect after a successfull post
 				http.Redirect(w, req, "/feed", http.StatusSeeOther)
-			})))))
+			}))))))
+
+	/*
+	   This endpoint
+	   - is only accessible with a valid authentication
+	   - only accepts POST requests with a valid csrf token
+	   - integrate the /subscription feed api endpoint to create (action=create) or revoke (action=revoke) the
+	     subscription urls of the user
+
+	   if the request is successfull, the user is redirected to /feed
+	*/
+	router.Handle("/subscription",
+		mw.RequestContextMiddleware(
+			logRequests(page(authenticated(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+				ctx := req.Context()
+				logger := util.DefaultLogger.FromContext(ctx)
+
+				if req.Method != http.MethodPost {
+					http.Error(w, "not found", http.StatusNotFound)
+					return
+				}
+
+				authCtx := authMw.Context(ctx)
+
+				// validate user input
+				var method string
+				switch req.PostFormValue("action") {
+				case "create":
+					method = http.MethodPost
+				case "revoke":
+					method = http.MethodDelete
+				default:
+					http.Error(w, "invalid action", http.StatusBadRequest)
+					return
+				}
+
+				err := tokens.do(ctx, authCtx, func(accessToken string) error {
+					_, err := feedClient.Subscription(ctx, method, accessToken)
+					return err
+				})
+				if err == net.ErrNoAccess {
+					logger.Error("subscription api call failed", "error", err)
+					http.Error(w, err.Error(), http.StatusForbidden)
+					return
+				} else if errors.Is(err, net.ErrNotAuthenticated) {
+					logger.Warn("subscription api call failed, login required", "error", err)
+					redirectToLogin(w, req)
+					return
+				} else if err != nil && err != net.ErrNotFound {
+					// revoking a missing subscription is not an error
+					logger.Error("subscription api call failed", "error", err)
+					http.Error(w, err.Error(), http.StatusInternalServerError)
+					return
+				}
+
+				// browser expect a http status 3XX if we want to redirect after a successfull post
+				http.Redirect(w, req, "/feed", http.StatusSeeOther)
+			}))))))
 
 	/*
 	   This endpoint
@@ -135,19 +381,20 @@ func SetupRoutes(serverCtx context.Context, router *http.ServeMux, options *Serv
 
 	router.Handle("/feed",
 		mw.RequestContextMiddleware(
-			mw.LogMiddleware(authMw.RequireAuthentication()(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+			logRequests(page(authenticated(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
 				ctx := req.Context()
 				logger := util.DefaultLogger.FromContext(ctx)
 				authCtx := authMw.Context(ctx)
 
 				feedPage := NewFeedPage(authCtx.UserInfo.GivenName, authCtx.UserInfo.FamilyName)
+				feedPage.Nonce = mw.CSPNonce(ctx)
+				feedPage.CSRFToken = mw.CSRFToken(ctx)
 
 				/*
 				  check if health API is healthy
 				  ideally, this should be part of a middleware
 				*/
 
-				feedClient := feed_api.NewFeedClient(options.apiHostname, options.apiPort)
 				if ok, err := feedClient.CheckHealth(ctx); !ok {
 					feedPage.Health = false
 					if err != nil {
@@ -155,27 +402,222 @@ func SetupRoutes(serverCtx context.Context, router *http.ServeMux, options *Serv
 					}
 				} else {
 					// only query for feed if feed API is healthy
-					feed, err := feedClient.GetFeed(ctx, authCtx.Tokens.AccessToken)
-					if err != nil {
+					var feed *feed_api.FeedResponse
+					err := tokens.do(ctx, authCtx, func(accessToken string) (err error) {
+						feed, err = feedClient.GetFeed(ctx, accessToken)
+						return err
+					})
+					if errors.Is(err, net.ErrNotAuthenticated) {
+						logger.Warn("feed api call failed, login required", "error", err)
+						redirectToLogin(w, req)
+						return
+					} else if err != nil {
 						logger.Error("feed api call failed", "error", err)
 						http.Error(w, err.Error(), http.StatusInternalServerError)
 						return
 					}
 
 					feedPage.Feed = feed
+
+					// dates are rendered in the timezone and format of the user, the defaults are good enough otherwise
+					err = tokens.do(ctx, authCtx, func(accessToken string) error {
+						prefs, err := feedClient.Preferences(ctx, nil, accessToken)
+						if err == nil {
+							feedPage.Prefs = prefs
+						}
+						return err
+					})
+					if err != nil {
+						logger.Error("preferences api call failed", "error", err)
+					}
+
+					// the history of the selection is only shown to users allowed to read it
+					if feed.Can("audit:read") {
+						err := tokens.do(ctx, authCtx, func(accessToken string) error {
+							entries, err := feedClient.GetAudit(ctx, accessToken, auditHistorySize)
+							feedPage.Audit = entries
+							return err
+						})
+						if err != nil {
+							logger.Error("audit api call failed", "error", err)
+						}
+					}
+
+					var subscription *feed_api.Subscription
+					err = tokens.do(ctx, authCtx, func(accessToken string) (err error) {
+						subscription, err = feedClient.Subscription(ctx, http.MethodGet, accessToken)
+						return err
+					})
+					if err != nil && err != net.ErrNotFound {
+						logger.Error("subscription api call failed", "error", err)
+					} else if subscription != nil {
+						feedPage.Subscription = &SubscriptionURLs{
+							Atom:      publicAPIURL + subscription.AtomPath,
+							RSS:       publicAPIURL + subscription.RSSPath,
+							CreatedAt: subscription.CreatedAt,
+						}
+					}
+
+					if q := strings.TrimSpace(req.URL.Query().Get("q")); q != "" {
+						feedPage.Search = &SearchResults{Query: q}
+						var results *feed_api.SearchResponse
+						err := tokens.do(ctx, authCtx, func(accessToken string) (err error) {
+							results, err = feedClient.Search(ctx, q, accessToken, searchResultsSize)
+							return err
+						})
+						if err != nil {
+							// most likely an invalid query, the rest of the page is still useful
+							logger.Warn("search api call failed", "error", err)
+							feedPage.Search.Failed = true
+						} else {
+							feedPage.Search.Results = results.Results
+						}
+					}
 				}
 
-				err = t.ExecuteTemplate(w, "feed.html", feedPage)
+				err := pages.render(w, req, feedPage.Prefs.Language, "feed.html", feedPage)
 				if err != nil {
 					logger.Error("error writing feed response", "error", err)
 				}
-			})))))
+			}))))))
+
+	/*
+	   This endpoint
+	   - is only accessible with a valid authentication
+	   - integrate the /preferences feed api endpoint to show (GET) and update (POST, with a valid csrf token) the
+	     display preferences of the user
+	   - renders settings.html
+
+	   if the update is successfull, the user is redirected to /feed
+	*/
+	router.Handle("/settings",
+		mw.RequestContextMiddleware(
+			logRequests(page(authenticated(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+				ctx := req.Context()
+				logger := util.DefaultLogger.FromContext(ctx)
+				authCtx := authMw.Context(ctx)
+
+				settingsPage := &SettingsPage{
+					Nonce:        mw.CSPNonce(ctx),
+					CSRFToken:    mw.CSRFToken(ctx),
+					LoggedInUser: fmt.Sprintf("%s %s", authCtx.UserInfo.GivenName, authCtx.UserInfo.FamilyName),
+					Timezones:    commonTimezones,
+					Languages:    languages,
+					Now:          time.Now(),
+				}
+
+				var update *preferences.Preferences
+				switch req.Method {
+				case http.MethodGet:
+				case http.MethodPost:
+					update = &preferences.Preferences{
+						Timezone:   strings.TrimSpace(req.PostFormValue("timezone")),
+						Clock:      req.PostFormValue("clock"),
+						DateFormat: req.PostFormValue("date_format"),
+						Language:   req.PostFormValue("language"),
+					}
+					// validated here too, so the user gets a meaningful error
+					if err := update.Validate(); err != nil {
+						settingsPage.Error = err.Error()
+						settingsPage.Prefs = update
+					}
+				default:
+					http.Error(w, "not found", http.StatusNotFound)
+					return
+				}
+
+				if settingsPage.Error == "" {
+					var prefs *preferences.Preferences
+					err := tokens.do(ctx, authCtx, func(accessToken string) (err error) {
+						prefs, err = feedClient.Preferences(ctx, update, accessToken)
+						return err
+					})
+					if err == net.ErrNoAccess {
+						logger.Error("preferences api call failed", "error", err)
+						http.Error(w, err.Error(), http.StatusForbidden)
+						return
+					} else if errors.Is(err, net.ErrNotAuthenticated) {
+						logger.Warn("preferences api call failed, login required", "error", err)
+						redirectToLogin(w, req)
+						return
+					} else if err != nil {
+						logger.Error("preferences api call failed", "error", err)
+						http.Error(w, err.Error(), http.StatusInternalServerError)
+						return
+					}
+
+					if update != nil {
+						// browser expect a http status 3XX if we want to redirect after a successfull post
+						http.Redirect(w, req, "/feed", http.StatusSeeOther)
+						return
+					}
+					settingsPage.Prefs = prefs
+				}
+
+				err := pages.render(w, req, settingsPage.Prefs.Language, "settings.html", settingsPage)
+				if err != nil {
+					logger.Error("error writing settings response", "error", err)
+				}
+			}))))))
+
+	/*
+	   This endpoint
+	   - is only accessible with a valid authentication
+	   - only accepts GET requests
+	   - integrate the /feed/export feed api endpoint and streams the export to the browser as a download
+	*/
+	router.Handle("/feed/export",
+		mw.RequestContextMiddleware(
+			logRequests(page(authenticated(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+				ctx := req.Context()
+				logger := util.DefaultLogger.FromContext(ctx)
+
+				if req.Method != http.MethodGet {
+					http.Error(w, "not found", http.StatusNotFound)
+					return
+				}
+
+				authCtx := authMw.Context(ctx)
+
+				// validate user input
+				format := req.URL.Query().Get("format")
+				if !slices.Contains(feed_api.ExportFormats, format) {
+					http.Error(w, "unknown export format", http.StatusBadRequest)
+					return
+				}
+
+				var export *feed_api.Export
+				err := tokens.do(ctx, authCtx, func(accessToken string) (err error) {
+					export, err = feedClient.ExportFeed(ctx, format, accessToken)
+					return err
+				})
+				if err == net.ErrNoAccess {
+					logger.Error("export api call failed", "error", err)
+					http.Error(w, err.Error(), http.StatusForbidden)
+					return
+				} else if errors.Is(err, net.ErrNotAuthenticated) {
+					logger.Warn("export api call failed, login required", "error", err)
+					redirectToLogin(w, req)
+					return
+				} else if err != nil {
+					logger.Error("export api call failed", "error", err)
+					http.Error(w, err.Error(), http.StatusInternalServerError)
+					return
+				}
+				defer export.Body.Close()
+
+				w.Header().Set("Content-Type", export.ContentType)
+				w.Header().Set("Content-Disposition", export.ContentDisposition)
+				if _, err := io.Copy(w, export.Body); err != nil {
+					logger.Error("error streaming export", "error", err)
+				}
+			}))))))
 
 	// This endpoint is accessible by anyone, but it will check if there already is a valid session (authentication).
 	// If there is an active session, the information will be put into the context for later retrieval.
 	router.Handle("/",
 		mw.RequestContextMiddleware(
-			mw.LogMiddleware(authMw.CheckAuthentication()(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+			logRequests(page(authMw.CheckAuthentication()(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
 				ctx := req.Context()
 				logger := util.DefaultLogger.FromContext(ctx)
 
@@ -185,28 +627,163 @@ func SetupRoutes(serverCtx context.Context, router *http.ServeMux, options *Serv
 					return
 				}
 
-				err = t.ExecuteTemplate(w, "home.html", nil)
+				// anonymous users get the language of their browser
+				err := pages.render(w, req, "", "home.html", &HomePage{Nonce: mw.CSPNonce(ctx)})
 				if err != nil {
 					logger.Error("error writing home page response", "error", err)
 				}
-			})))))
+			}))))))
 
 	return nil
 }
 
+// parse the embedded templates, their i18n functions are bound to the language of the request by renderer
+func parseTemplates() (*template.Template, error) {
+	var unbound *i18n.Localizer
+	return template.New("").Funcs(unbound.FuncMap()).ParseFS(templates, "templates/*.html")
+}
+
+// renders the templates in the language of the request
+type renderer struct {
+	templates *template.Template
+	catalog   *i18n.Catalog
+}
+
+// Render the page, in the language the user picked if any (see i18n.Catalog.Localizer)
+func (r *renderer) render(w http.ResponseWriter, req *http.Request, preferred, name string, data any) error {
+	localizer := r.catalog.Localizer(preferred, req.Header.Get("Accept-Language"))
+
+	// the functions of a template can't be changed once executed, each request renders a copy
+	t, err := r.templates.Clone()
+	if err != nil {
+		return err
+	}
+
+	w.Header().Set("Content-Language", localizer.Language())
+	w.Header().Add("Vary", "Accept-Language")
+	return t.Funcs(localizer.FuncMap()).ExecuteTemplate(w, name, data)
+}
+
+// Represent the state of the home.html page
+type HomePage struct {
+	// allows the inline styles of the page
+	Nonce string
+}
+
 // Represent the state of the feed.html page
 type FeedPage struct {
+	// allows the inline styles of the page
+	Nonce string
+	// embedded in the forms of the page
+	CSRFToken string
 	// informations about the current logged in user
 	LoggedInUser string
 	// Is the feed api running
 	Health bool
 	// the feed data
 	Feed *feed_api.FeedResponse
+	// latest changes of the selected feed, if the user can read them
+	Audit []*feed_api.AuditEntry
+	// urls of the feed for feed readers, if the user generated them
+	Subscription *SubscriptionURLs
+	// results of the search in the archived listens, if the user searched
+	Search *SearchResults
+	// how dates are rendered
+	Prefs *preferences.Preferences
+}
+
+// Listens of a day, in the timezone of the user
+type Day struct {
+	Date  time.Time
+	Songs []*feed_api.Song
+}
+
+// Songs of the feed grouped by the day they were listened at in the timezone of the user, in the order of the feed
+func (p *FeedPage) Days() []*Day {
+	days := []*Day{}
+	if p.Feed == nil || p.Feed.Feed == nil {
+		return days
+	}
+
+	for _, song := range p.Feed.Feed.Songs {
+		date := p.Prefs.Day(song.ListenedAt)
+		if len(days) == 0 || !days[len(days)-1].Date.Equal(date) {
+			days = append(days, &Day{Date: date})
+		}
+		days[len(days)-1].Songs = append(days[len(days)-1].Songs, song)
+	}
+
+	return days
 }
 
+// Represent the state of the settings.html page
+type SettingsPage struct {
+	// allows the inline styles of the page
+	Nonce string
+	// embedded in the form of the page
+	CSRFToken    string
+	LoggedInUser string
+	Prefs        *preferences.Preferences
+	// suggested in the timezone field, any IANA timezone is accepted
+	Timezones []string
+	Languages []Language
+	// rendered with the preferences as an example
+	Now time.Time
+	// why the submitted preferences were rejected
+	Error string
+}
+
+// A language the web app is translated to
+type Language struct {
+	Code string
+	// in the language itself, so users find their language whatever the language of the page
+	Name string
+}
+
+// see preferences.Languages
+var languages = []Language{
+	{Code: "en", Name: "English"},
+	{Code: "fr", Name: "Français"},
+}
+
+// suggested on the settings page
+var commonTimezones = []string{
+	"UTC",
+	"America/Los_Angeles",
+	"America/New_York",
+	"America/Sao_Paulo",
+	"Europe/London",
+	"Europe/Brussels",
+	"Europe/Berlin",
+	"Africa/Johannesburg",
+	"Asia/Kolkata",
+	"Asia/Singapore",
+	"Asia/Tokyo",
+	"Australia/Sydney",
+}
+
+type SearchResults struct {
+	Query   string
+	Failed  bool
+	Results []*feed_api.SearchResult
+}
+
+type SubscriptionURLs struct {
+	Atom      string
+	RSS       string
+	CreatedAt time.Time
+}
+
+// number of search results shown on the feed page
+const searchResultsSize = 50
+
+// number of audit entries shown on the feed page
+const auditHistorySize = 10
+
 func NewFeedPage(firstName, lastName string) *FeedPage {
 	return &FeedPage{
 		LoggedInUser: fmt.Sprintf("%s %s", firstName, lastName),
 		Health:       true,
+		Prefs:        preferences.Default(),
 	}
 }
@@ -0,0 +1,200 @@
+package web
+
+import (
+	"net/http/httptest"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/xaviercrochet/turbo-octo-adventure/pkg/preferences"
+	"github.com/xaviercrochet/turbo-octo-adventure/web/feed_api"
+	"github.com/xaviercrochet/turbo-octo-adventure/web/i18n"
+)
+
+// render the page as served to a browser sending the Accept-Language header
+func renderPage(t *testing.T, name string, data any, acceptLanguage string) string {
+	t.Helper()
+
+	tmpl, err := parseTemplates()
+	if err != nil {
+		t.Fatalf("parseTemplates() error = %v", err)
+	}
+	catalog, err := i18n.Load()
+	if err != nil {
+		t.Fatalf("Load() error = %v", err)
+	}
+
+	req := httptest.NewRequest("GET", "/", nil)
+	req.Header.Set("Accept-Language", acceptLanguage)
+	rec := httptest.NewRecorder()
+	pages := &renderer{templates: tmpl, catalog: catalog}
+	if err := pages.render(rec, req, "", name, data); err != nil {
+		t.Fatalf("render() error = %v", err)
+	}
+	return rec.Body.String()
+}
+
+func TestFeedPageIsEscaped(t *testing.T) {
+	page := NewFeedPage("Alice", "Doe")
+	page.Nonce = "nonce-value"
+	page.CSRFToken = "csrf-value"
+	page.Feed = &feed_api.FeedResponse{
+		Permissions: []string{"feed:read", "feed:select"},
+		Feed: &feed_api.Feed{
+			Username: "xcrochet",
+			Songs:    []*feed_api.Song{{Title: `<script>alert("xss")</script>`, ListenedAt: time.Now()}},
+		},
+	}
+
+	body := renderPage(t, "feed.html", page, "")
+
+	if strings.Contains(body, `<script>alert("xss")</script>`) {
+		t.Error("song title is rendered unescaped")
+	}
+	if !strings.Contains(body, `&lt;script&gt;`) {
+		t.Error("song title is not rendered")
+	}
+	if !strings.Contains(body, `<style nonce="nonce-value">`) {
+		t.Error("style element doesn't carry the csp nonce")
+	}
+	if !strings.Contains(body, `name="csrf_token" value="csrf-value"`) {
+		t.Error("select feed form doesn't carry the csrf token")
+	}
+}
+
+func TestSearchResultsAreHighlighted(t *testing.T) {
+	page := NewFeedPage("Alice", "Doe")
+	page.Feed = &feed_api.FeedResponse{Feed: &feed_api.Feed{Username: "xcrochet", Songs: []*feed_api.Song{}}}
+	page.Search = &SearchResults{
+		Query: "<b>punk</b>",
+		Results: []*feed_api.SearchResult{{
+			Listen: feed_api.SearchListen{ID: "1", Title: "<i>Daft</i> Punk", Updated: time.Now()},
+			// "Daft" and "Punk"
+			Highlights: []feed_api.Span{{Start: 3, End: 7}, {Start: 12, End: 16}},
+		}},
+	}
+
+	body := renderPage(t, "feed.html", page, "")
+
+	if !strings.Contains(body, `&lt;i&gt;<mark>Daft</mark>&lt;/i&gt; <mark>Punk</mark>`) {
+		t.Error("search result is not highlighted and escaped")
+	}
+	if strings.Contains(body, "<b>punk</b>") {
+		t.Error("search query is rendered unescaped")
+	}
+}
+
+func TestFeedPageDaysFollowTheTimezone(t *testing.T) {
+	page := NewFeedPage("Alice", "Doe")
+	page.Prefs = &preferences.Preferences{Timezone: "Europe/Brussels", Clock: preferences.Clock24h, DateFormat: preferences.DateISO}
+	page.Feed = &feed_api.FeedResponse{Feed: &feed_api.Feed{Songs: []*feed_api.Song{
+		// the 2nd in Brussels
+		{Title: "3", ListenedAt: time.Date(2024, 3, 1, 23, 30, 0, 0, time.UTC)},
+		{Title: "2", ListenedAt: time.Date(2024, 3, 1, 22, 0, 0, 0, time.UTC)},
+		{Title: "1", ListenedAt: time.Date(2024, 3, 1, 8, 0, 0, 0, time.UTC)},
+	}}}
+
+	days := page.Days()
+	if len(days) != 2 {
+		t.Fatalf("Days() = %d days, expected 2", len(days))
+	}
+	if got := page.Prefs.FormatDate(days[0].Date); got != "2024-03-02" || len(days[0].Songs) != 1 {
+		t.Errorf("first day = %v with %d songs, expected 2024-03-02 with 1 song", got, len(days[0].Songs))
+	}
+	if got := page.Prefs.FormatDate(days[1].Date); got != "2024-03-01" || len(days[1].Songs) != 2 {
+		t.Errorf("second day = %v with %d songs, expected 2024-03-01 with 2 songs", got, len(days[1].Songs))
+	}
+
+	out := renderPage(t, "feed.html", page, "")
+	if !strings.Contains(out, "<td>00:30:00</td>") {
+		t.Error("listen is not rendered in the timezone of the user")
+	}
+}
+
+func TestSettingsPage(t *testing.T) {
+	page := &SettingsPage{
+		CSRFToken: "csrf-value",
+		Prefs:     &preferences.Preferences{Timezone: "America/New_York", Clock: preferences.Clock12h, DateFormat: preferences.DateUS, Language: "fr"},
+		Timezones: commonTimezones,
+		Languages: languages,
+		Now:       time.Date(2024, 3, 1, 23, 30, 0, 0, time.UTC),
+	}
+
+	body := renderPage(t, "settings.html", page, "")
+
+	for _, expected := range []string{
+		`name="csrf_token" value="csrf-value"`,
+		`value="America/New_York"`,
+		`<option value="12h" selected>`,
+		`<option value="us" selected>`,
+		`<option value="fr" selected>`,
+		"03/01/2024 6:30:00 PM",
+	} {
+		if !strings.Contains(body, expected) {
+			t.Errorf("settings page doesn't contain %q", expected)
+		}
+	}
+}
+
+// every message referenced by the templates must be translated to every language
+func TestTemplatesAreTranslated(t *testing.T) {
+	tmpl, err := parseTemplates()
+	if err != nil {
+		t.Fatalf("parseTemplates() error = %v", err)
+	}
+	catalog, err := i18n.Load()
+	if err != nil {
+		t.Fatalf("Load() error = %v", err)
+	}
+
+	keys := i18n.TemplateKeys(tmpl)
+	if len(keys) == 0 {
+		t.Fatal("TemplateKeys() found no message, the templates are not translated")
+	}
+
+	for _, language := range catalog.Languages() {
+		translated := catalog.Keys(language)
+		for _, key := range keys {
+			if !slices.Contains(translated, key) {
+				t.Errorf("%s: missing translation of %q", language, key)
+			}
+		}
+	}
+
+	// the settings page offers every language of the catalog
+	for _, language := range catalog.Languages() {
+		if !slices.ContainsFunc(languages, func(l Language) bool { return l.Code == language }) {
+			t.Errorf("language %v is not offered on the settings page", language)
+		}
+	}
+}
+
+func TestPagesFollowTheLanguage(t *testing.T) {
+	page := NewFeedPage("Alice", "Doe")
+	page.Feed = &feed_api.FeedResponse{Feed: &feed_api.Feed{Username: "xcrochet", Songs: []*feed_api.Song{}}}
+	page.Search = &SearchResults{Query: "punk", Results: []*feed_api.SearchResult{}}
+
+	if body := renderPage(t, "feed.html", page, "fr-BE,fr;q=0.9,en;q=0.8"); !strings.Contains(body, "Flux musical de xcrochet") ||
+		!strings.Contains(body, "0 résultat pour punk") || !strings.Contains(body, `<html lang="fr">`) {
+		t.Error("feed page is not rendered in french")
+	}
+	if body := renderPage(t, "feed.html", page, "de-DE"); !strings.Contains(body, "Music feed of xcrochet") ||
+		!strings.Contains(body, "0 results for punk") {
+		t.Error("feed page is not rendered in the default language")
+	}
+
+	// the language picked by the user wins over the browser
+	page.Prefs.Language = "en"
+	tmpl, _ := parseTemplates()
+	catalog, _ := i18n.Load()
+	req := httptest.NewRequest("GET", "/feed", nil)
+	req.Header.Set("Accept-Language", "fr")
+	rec := httptest.NewRecorder()
+	if err := (&renderer{templates: tmpl, catalog: catalog}).render(rec, req, page.Prefs.Language, "feed.html", page); err != nil {
+		t.Fatalf("render() error = %v", err)
+	}
+	if !strings.Contains(rec.Body.String(), "Music feed of xcrochet") || rec.Header().Get("Content-Language") != "en" {
+		t.Error("feed page doesn't follow the language of the user")
+	}
+}
@@ -0,0 +1,80 @@
+package feed_api
+
+import (
+	"container/list"
+	"crypto/sha256"
+	"encoding/hex"
+	"net/http"
+	"sync"
+)
+
+// number of responses kept by default, see WithCacheSize
+const DefaultCacheSize = 64
+
+// A response of the api and its ETag
+type cachedResponse struct {
+	key  string
+	etag string
+	body []byte
+}
+
+/*
+Keeps the last responses of the api with their ETag, so they can be revalidated instead of downloaded again
+
+The least recently used response is evicted once the cache is full
+*/
+type etagCache struct {
+	mu   sync.Mutex
+	size int
+	// most recently used first
+	order   *list.List
+	entries map[string]*list.Element
+}
+
+func newETagCache(size int) *etagCache {
+	return &etagCache{
+		size:    size,
+		order:   list.New(),
+		entries: map[string]*list.Element{},
+	}
+}
+
+func (c *etagCache) get(key string) (*cachedResponse, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	element, ok := c.entries[key]
+	if !ok {
+		return nil, false
+	}
+	c.order.MoveToFront(element)
+	return element.Value.(*cachedResponse), true
+}
+
+func (c *etagCache) put(key, etag string, body []byte) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if element, ok := c.entries[key]; ok {
+		element.Value = &cachedResponse{key: key, etag: etag, body: body}
+		c.order.MoveToFront(element)
+		return
+	}
+
+	c.entries[key] = c.order.PushFront(&cachedResponse{key: key, etag: etag, body: body})
+	for c.order.Len() > c.size {
+		oldest := c.order.Back()
+		c.order.Remove(oldest)
+		delete(c.entries, oldest.Value.(*cachedResponse).key)
+	}
+}
+
+/*
+Identifies the response to the request
+
+Responses depend on the caller, the access token is part of the key. It is hashed, so the cache doesn't hold tokens
+*/
+func cacheKey(req *http.Request) string {
+	sum := sha256.Sum256([]byte(req.Method + " " + req.URL.String() + " " + req.Header.Get("Authorization")))
+	return hex.EncodeToString(sum[:])
+}
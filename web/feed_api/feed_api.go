@@ -3,45 +3,102 @@ package feed_api
 import (
 	"bytes"
 	"context"
+	"crypto/tls"
 	"encoding/json"
 	"fmt"
 	"io"
 	"net/http"
+	"net/url"
+	"slices"
+	"strconv"
 	"time"
 
 	"github.com/xaviercrochet/turbo-octo-adventure/pkg/net"
+	"github.com/xaviercrochet/turbo-octo-adventure/pkg/preferences"
 	"github.com/xaviercrochet/turbo-octo-adventure/pkg/util"
 )
 
 type FeedClient struct {
+	scheme     string
 	hostname   string
 	port       string
 	httpClient *http.Client
+	// larger responses fail with *net.ResponseTooLargeError
+	maxBodySize int64
+	// responses revalidated with their ETag, nil if disabled
+	cache *etagCache
 }
 
-func NewFeedClient(hostname, port string) *FeedClient {
-	return &FeedClient{
-		hostname:   hostname,
-		port:       port,
-		httpClient: &http.Client{},
+// Option allows customization of the FeedClient
+type Option func(*FeedClient)
+
+// WithTLSConfig makes the client talk to the api over https using the given tls config.
+// The config can hold a client certificate to authenticate against an api requiring mutual TLS
+func WithTLSConfig(tlsConfig *tls.Config) Option {
+	return func(c *FeedClient) {
+		c.scheme = "https"
+		c.httpClient = &http.Client{
+			Transport: &http.Transport{
+				TLSClientConfig: tlsConfig,
+			},
+		}
 	}
 }
 
-func (c *FeedClient) buildURL(path string) string {
-	return fmt.Sprintf("http://%s:%s/api/%s", c.hostname, c.port, path)
+// WithMaxBodySize replaces net.DefaultMaxBodySize, the maximum size of the responses of the api in bytes
+func WithMaxBodySize(size int64) Option {
+	return func(c *FeedClient) {
+		c.maxBodySize = size
+	}
 }
 
-/*
-Call /api/healthz
+// WithCacheSize replaces DefaultCacheSize, the number of responses kept to be revalidated. 0 disables the cache
+func WithCacheSize(size int) Option {
+	return func(c *FeedClient) {
+		c.cache = nil
+		if size > 0 {
+			c.cache = newETagCache(size)
+		}
+	}
+}
 
-return true if response is 200, false otherwise
-*/
-func (c *FeedClient) CheckHealth(ctx context.Context) (bool, error) {
-	url := c.buildURL("healthz")
+func NewFeedClient(hostname, port string, options ...Option) *FeedClient {
+	c := &FeedClient{
+		scheme:      "http",
+		hostname:    hostname,
+		port:        port,
+		httpClient:  &http.Client{},
+		maxBodySize: net.DefaultMaxBodySize,
+		cache:       newETagCache(DefaultCacheSize),
+	}
+	for _, option := range options {
+		option(c)
+	}
+	return c
+}
 
-	req, err := http.NewRequest("GET", url, nil)
+func (c *FeedClient) buildURL(path string) string {
+	return fmt.Sprintf("%s/api/%s", c.BaseURL(), path)
+}
+
+// Returns the url of the api, without path
+func (c *FeedClient) BaseURL() string {
+	return fmt.Sprintf("%s://%s:%s", c.scheme, c.hostname, c.port)
+}
+
+// Build a request to the api, authenticated with accessToken if not empty
+func (c *FeedClient) newRequest(ctx context.Context, method, path string, body io.Reader, accessToken string) (*http.Request, error) {
+	req, err := http.NewRequestWithContext(ctx, method, c.buildURL(path), body)
 	if err != nil {
-		return false, fmt.Errorf("failed creating http request: %v", err)
+		return nil, fmt.Errorf("failed creating http request: %v", err)
+	}
+
+	// build the authorization header
+	if accessToken != "" {
+		req.Header.Add("Authorization", "Bearer "+accessToken)
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
 	}
 
 	// Forward `trace_id`, this field will be logged by the api under `sender_trace_id`
@@ -49,13 +106,106 @@ func (c *FeedClient) CheckHealth(ctx context.Context) (bool, error) {
 		req.Header.Add(util.HeaderSenderTraceID, traceID)
 	}
 
+	return req, nil
+}
+
+/*
+Send the request and deserialize the json response into result, unless result is nil
+
+return the errors defined under pkg.net.errors based on the http status code of the response
+*/
+func (c *FeedClient) do(req *http.Request, result any) error {
 	resp, err := c.httpClient.Do(req)
 	if err != nil {
-		return false, fmt.Errorf("failed to query feed api: %w", err)
+		return fmt.Errorf("failed to query feed api: %w", err)
 	}
 	defer resp.Body.Close()
 
+	// fail based on error code if not 200
 	if err := net.HttpStatusCodeToErr(resp); err != nil {
+		return err
+	}
+
+	if result == nil {
+		return nil
+	}
+
+	// the response is decoded as it is read, never more than maxBodySize bytes
+	if err := json.NewDecoder(net.LimitReader(resp.Body, c.maxBodySize)).Decode(result); err != nil {
+		return fmt.Errorf("failed to deserialize json: %w", err)
+	}
+
+	return nil
+}
+
+/*
+Same as do, but the response is kept with its ETag. The next time, the api is asked whether it changed (If-None-Match)
+and the kept response is used if it didn't (304)
+*/
+func (c *FeedClient) doCached(req *http.Request, result any) error {
+	if c.cache == nil {
+		return c.do(req, result)
+	}
+
+	key := cacheKey(req)
+	cached, ok := c.cache.get(key)
+	if ok {
+		req.Header.Set("If-None-Match", cached.etag)
+	}
+
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("failed to query feed api: %w", err)
+	}
+	defer resp.Body.Close()
+
+	var body []byte
+	if ok && resp.StatusCode == http.StatusNotModified {
+		body = cached.body
+	} else {
+		// fail based on error code if not 200
+		if err := net.HttpStatusCodeToErr(resp); err != nil {
+			return err
+		}
+
+		// kept as is, to be decoded again when revalidated
+		body, err = io.ReadAll(net.LimitReader(resp.Body, c.maxBodySize))
+		if err != nil {
+			return fmt.Errorf("failed to read response body: %w", err)
+		}
+		if etag := resp.Header.Get("ETag"); etag != "" {
+			c.cache.put(key, etag, body)
+		}
+	}
+
+	// decoded for every call, callers can't alter the kept response
+	if err := json.Unmarshal(body, result); err != nil {
+		return fmt.Errorf("failed to deserialize json: %w", err)
+	}
+	return nil
+}
+
+// Serialize the payload of a POST request
+func jsonBody(payload any) (io.Reader, error) {
+	data, err := json.Marshal(payload)
+	if err != nil {
+		return nil, fmt.Errorf("failed serializing payload: %v", err)
+	}
+	return bytes.NewReader(data), nil
+}
+
+/*
+Call /api/healthz
+
+return true if response is 200, false otherwise
+*/
+func (c *FeedClient) CheckHealth(ctx context.Context) (bool, error) {
+	req, err := c.newRequest(ctx, http.MethodGet, "healthz", nil, "")
+	if err != nil {
+		return false, err
+	}
+
+	if err := c.do(req, nil); err != nil {
 		return false, err
 	}
 
@@ -72,95 +222,249 @@ params:
 return the errors defined under pkg.net.errors based on the http status code of the response
 */
 func (c *FeedClient) SelectFeed(ctx context.Context, selectedFeed, accessToken string) error {
-	url := c.buildURL("select_feed")
-
 	// build the payload for the post request
-	payload := map[string]interface{}{
+	body, err := jsonBody(map[string]interface{}{
 		"name": selectedFeed,
+	})
+	if err != nil {
+		return err
 	}
 
-	jsonBody, err := json.Marshal(payload)
+	req, err := c.newRequest(ctx, http.MethodPost, "select_feed", body, accessToken)
 	if err != nil {
-		return fmt.Errorf("failed serializing payload: %v\n", err)
+		return err
 	}
 
-	req, err := http.NewRequest("POST", url, bytes.NewBuffer(jsonBody))
+	return c.do(req, nil)
+}
+
+/*
+
+Call /api/feed
+
+params:
+  - accessToken: the access token
+
+  if successful, returns a list of songs. The last responses are kept and revalidated with their ETag, the feed is
+  only downloaded again when it changed
+
+  return the errors defined under feed_api.errors based on the http status code of the response otherwise
+*/
+
+func (c *FeedClient) GetFeed(ctx context.Context, accessToken string) (*FeedResponse, error) {
+	req, err := c.newRequest(ctx, http.MethodGet, "feed", nil, accessToken)
 	if err != nil {
-		return fmt.Errorf("failed creating http request: %v\n", err)
+		return nil, err
 	}
 
-	// build the authorization header
-	req.Header.Add("Authorization", "Bearer "+accessToken)
-	// Forward `trace_id`, this field will be logged by the api under `sender_trace_id`
-	if traceID, ok := ctx.Value(util.TraceIDContextKey).(string); ok {
-		req.Header.Add(util.HeaderSenderTraceID, traceID)
+	var feedResponse FeedResponse
+	if err := c.doCached(req, &feedResponse); err != nil {
+		return nil, err
+	}
+
+	return &feedResponse, nil
+}
+
+// formats supported by /api/feed/export
+var ExportFormats = []string{"csv", "jsonl", "xspf", "jspf"}
+
+// A feed export being downloaded, Body must be closed by the caller
+type Export struct {
+	ContentType        string
+	ContentDisposition string
+	Body               io.ReadCloser
+}
+
+/*
+Call /api/feed/export
+
+params:
+  - format: csv, jsonl, xspf or jspf
+  - accessToken: the access token
+
+the export is not buffered, so it can be streamed to the browser
+
+return the errors defined under pkg.net.errors based on the http status code of the response
+*/
+func (c *FeedClient) ExportFeed(ctx context.Context, format, accessToken string) (*Export, error) {
+	query := url.Values{}
+	query.Set("format", format)
+
+	req, err := c.newRequest(ctx, http.MethodGet, "feed/export?"+query.Encode(), nil, accessToken)
+	if err != nil {
+		return nil, err
 	}
 
 	resp, err := c.httpClient.Do(req)
 	if err != nil {
-		return fmt.Errorf("failed to query feed api: %v", err)
+		return nil, fmt.Errorf("failed to query feed api: %w", err)
 	}
-	defer resp.Body.Close()
 
-	// fail based on error code if not 200
-	return net.HttpStatusCodeToErr(resp)
+	if err := net.HttpStatusCodeToErr(resp); err != nil {
+		resp.Body.Close()
+		return nil, err
+	}
+
+	return &Export{
+		ContentType:        resp.Header.Get("Content-Type"),
+		ContentDisposition: resp.Header.Get("Content-Disposition"),
+		Body:               resp.Body,
+	}, nil
 }
 
 /*
-
-Call /api/feed
+Call /api/subscription
 
 params:
+  - method: GET to retrieve the current subscription, POST to create a new one, DELETE to revoke it
   - accessToken: the access token
 
-  if successful, returns a list of songs
+the returned subscription is nil after a DELETE
 
-  return the errors defined under feed_api.errors based on the http status code of the response otherwise
+return the errors defined under pkg.net.errors based on the http status code of the response, ErrNotFound if the
+user has no subscription
 */
+func (c *FeedClient) Subscription(ctx context.Context, method, accessToken string) (*Subscription, error) {
+	req, err := c.newRequest(ctx, method, "subscription", nil, accessToken)
+	if err != nil {
+		return nil, err
+	}
 
-func (c *FeedClient) GetFeed(ctx context.Context, accessToken string) (*FeedResponse, error) {
-	url := c.buildURL("feed")
+	if method == http.MethodDelete {
+		return nil, c.do(req, nil)
+	}
+
+	var subscription Subscription
+	if err := c.do(req, &subscription); err != nil {
+		return nil, err
+	}
+
+	return &subscription, nil
+}
+
+/*
+Call /api/search
 
-	req, err := http.NewRequest("GET", url, nil)
+params:
+  - q: the query, i.e. `daft punk* "one more time" after:2024-01-01`
+  - accessToken: the access token
+  - limit: maximum number of results, most relevant first
+
+return the errors defined under pkg.net.errors based on the http status code of the response, ErrGeneric if the
+query is invalid
+*/
+func (c *FeedClient) Search(ctx context.Context, q, accessToken string, limit int) (*SearchResponse, error) {
+	query := url.Values{}
+	query.Set("q", q)
+	query.Set("limit", strconv.Itoa(limit))
+
+	req, err := c.newRequest(ctx, http.MethodGet, "search?"+query.Encode(), nil, accessToken)
 	if err != nil {
-		return nil, fmt.Errorf("failed creating http request: %v", err)
+		return nil, err
 	}
 
-	// build the authorization header
-	req.Header.Add("Authorization", "Bearer "+accessToken)
-	if traceID, ok := ctx.Value(util.TraceIDContextKey).(string); ok {
-		// Forward `trace_id`, this field will be logged by the api under `sender_trace_id`
-		req.Header.Add(util.HeaderSenderTraceID, traceID)
+	var searchResponse SearchResponse
+	if err := c.do(req, &searchResponse); err != nil {
+		return nil, err
 	}
 
-	resp, err := c.httpClient.Do(req)
+	return &searchResponse, nil
+}
+
+/*
+Call /api/preferences
+
+params:
+  - prefs: the new preferences, nil to only retrieve the current ones
+  - accessToken: the access token
+
+return the errors defined under pkg.net.errors based on the http status code of the response, ErrGeneric if the
+preferences are invalid
+*/
+func (c *FeedClient) Preferences(ctx context.Context, prefs *preferences.Preferences, accessToken string) (*preferences.Preferences, error) {
+	method := http.MethodGet
+	var body io.Reader
+	if prefs != nil {
+		var err error
+		if body, err = jsonBody(prefs); err != nil {
+			return nil, err
+		}
+		method = http.MethodPut
+	}
+
+	req, err := c.newRequest(ctx, method, "preferences", body, accessToken)
 	if err != nil {
-		return nil, fmt.Errorf("failed to query feed api: %w", err)
+		return nil, err
 	}
-	defer resp.Body.Close()
 
-	if err := net.HttpStatusCodeToErr(resp); err != nil {
+	var result preferences.Preferences
+	if err := c.do(req, &result); err != nil {
 		return nil, err
 	}
 
-	body, err := io.ReadAll(resp.Body)
+	return &result, nil
+}
+
+/*
+Call /api/audit
+
+params:
+  - accessToken: the access token
+  - limit: maximum number of entries, newest first
+
+return the errors defined under pkg.net.errors based on the http status code of the response
+*/
+func (c *FeedClient) GetAudit(ctx context.Context, accessToken string, limit int) ([]*AuditEntry, error) {
+	query := url.Values{}
+	query.Set("limit", strconv.Itoa(limit))
+
+	req, err := c.newRequest(ctx, http.MethodGet, "audit?"+query.Encode(), nil, accessToken)
 	if err != nil {
-		return nil, fmt.Errorf("failed to parse response body: %w", err)
+		return nil, err
 	}
 
-	var feedResponse FeedResponse
-	err = json.Unmarshal(body, &feedResponse)
+	var auditResponse AuditResponse
+	if err := c.do(req, &auditResponse); err != nil {
+		return nil, err
+	}
+
+	return auditResponse.Entries, nil
+}
+
+/*
+Call POST /api/audit/rollback
+
+params:
+  - id: the audit entry to roll back
+  - accessToken: the access token
+
+return the errors defined under pkg.net.errors based on the http status code of the response
+*/
+func (c *FeedClient) Rollback(ctx context.Context, id int64, accessToken string) error {
+	body, err := jsonBody(map[string]interface{}{
+		"id": id,
+	})
 	if err != nil {
-		return nil, fmt.Errorf("failed to deserialize json: %w", err)
+		return err
 	}
 
-	// fail based on error code if not 200
-	return &feedResponse, nil
+	req, err := c.newRequest(ctx, http.MethodPost, "audit/rollback", body, accessToken)
+	if err != nil {
+		return err
+	}
+
+	return c.do(req, nil)
 }
 
 type FeedResponse struct {
-	WriteAccess bool  `json:"write_access"`
-	Feed        *Feed `json:"feed"`
+	// effective permissions of the logged in user, see pkg/policy
+	Permissions []string `json:"permissions"`
+	Feed        *Feed    `json:"feed"`
+}
+
+// Returns true if the logged in user is granted the permission
+func (r *FeedResponse) Can(permission string) bool {
+	return slices.Contains(r.Permissions, permission)
 }
 
 type Feed struct {
@@ -169,6 +473,85 @@ type Feed struct {
 }
 
 type Song struct {
+	ID         string    `json:"id"`
 	Title      string    `json:"title"`
 	ListenedAt time.Time `json:"listened_at"`
 }
+
+type Subscription struct {
+	Token     string    `json:"token"`
+	CreatedAt time.Time `json:"created_at"`
+	AtomPath  string    `json:"atom_path"`
+	RSSPath   string    `json:"rss_path"`
+}
+
+type SearchResponse struct {
+	Username string          `json:"username"`
+	Results  []*SearchResult `json:"results"`
+}
+
+type SearchResult struct {
+	Listen SearchListen `json:"listen"`
+	Score  float64      `json:"score"`
+	// byte ranges of the title matching the query
+	Highlights []Span `json:"highlights"`
+}
+
+type SearchListen struct {
+	ID      string    `json:"id"`
+	Title   string    `json:"title"`
+	Updated time.Time `json:"updated"`
+}
+
+type Span struct {
+	Start int `json:"start"`
+	End   int `json:"end"`
+}
+
+// A piece of text, highlighted if it matches the query
+type Segment struct {
+	Text  string
+	Match bool
+}
+
+// Split the title into segments, so matches can be highlighted without building html
+func (r *SearchResult) Segments() []Segment {
+	title := r.Listen.Title
+	segments := []Segment{}
+	offset := 0
+
+	for _, span := range r.Highlights {
+		// ignore ranges that don't fit the title, rather than failing to render it
+		if span.Start < offset || span.End > len(title) || span.Start >= span.End {
+			continue
+		}
+		if span.Start > offset {
+			segments = append(segments, Segment{Text: title[offset:span.Start]})
+		}
+		segments = append(segments, Segment{Text: title[span.Start:span.End], Match: true})
+		offset = span.End
+	}
+	if offset < len(title) {
+		segments = append(segments, Segment{Text: title[offset:]})
+	}
+
+	return segments
+}
+
+type AuditResponse struct {
+	Entries []*AuditEntry `json:"entries"`
+}
+
+// A change of the selected feed
+type AuditEntry struct {
+	ID         int64     `json:"id"`
+	Action     string    `json:"action"`
+	Timestamp  time.Time `json:"timestamp"`
+	ActorID    string    `json:"actor_id"`
+	ActorType  string    `json:"actor_type,omitempty"`
+	Username   string    `json:"username"`
+	TraceID    string    `json:"trace_id"`
+	OldValue   string    `json:"old_value"`
+	NewValue   string    `json:"new_value"`
+	RollbackOf int64     `json:"rollback_of,omitempty"`
+}
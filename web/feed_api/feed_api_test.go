@@ -0,0 +1,81 @@
+package feed_api
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestGetFeedRevalidatesWithETag(t *testing.T) {
+	var ifNoneMatch []string
+	username := "xcrochet"
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ifNoneMatch = append(ifNoneMatch, r.Header.Get("If-None-Match"))
+		etag := `"` + username + `"`
+		w.Header().Set("ETag", etag)
+		if r.Header.Get("If-None-Match") == etag {
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+		w.Write([]byte(`{"permissions": ["feed:read"], "feed": {"username": "` + username + `", "songs": []}}`))
+	}))
+	defer server.Close()
+
+	host, port, _ := net.SplitHostPort(server.Listener.Addr().String())
+	client := NewFeedClient(host, port, WithCacheSize(1))
+	ctx := context.Background()
+
+	get := func(token, expected string) {
+		t.Helper()
+		feed, err := client.GetFeed(ctx, token)
+		if err != nil {
+			t.Fatalf("GetFeed() error = %v", err)
+		}
+		if feed.Feed.Username != expected || !feed.Can("feed:read") {
+			t.Errorf("GetFeed() = %+v, expected the feed of %s", feed.Feed, expected)
+		}
+		// callers can't alter the kept response
+		feed.Feed.Username = "altered"
+	}
+
+	get("token-1", "xcrochet")
+	get("token-1", "xcrochet")
+	// the response of another caller isn't revalidated with the etag of the first one, and evicts it
+	get("token-2", "xcrochet")
+	get("token-1", "xcrochet")
+	// a changed feed is downloaded again
+	username = "rjmunro"
+	get("token-1", "rjmunro")
+
+	expected := []string{"", `"xcrochet"`, "", "", `"xcrochet"`}
+	if len(ifNoneMatch) != len(expected) {
+		t.Fatalf("If-None-Match = %q, expected %q", ifNoneMatch, expected)
+	}
+	for i := range expected {
+		if ifNoneMatch[i] != expected[i] {
+			t.Errorf("If-None-Match = %q, expected %q", ifNoneMatch, expected)
+			break
+		}
+	}
+}
+
+func TestGetFeedWithoutCache(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("If-None-Match") != "" {
+			t.Errorf("If-None-Match sent with the cache disabled")
+		}
+		w.Header().Set("ETag", `"1"`)
+		w.Write([]byte(`{"permissions": [], "feed": {"username": "xcrochet", "songs": []}}`))
+	}))
+	defer server.Close()
+
+	host, port, _ := net.SplitHostPort(server.Listener.Addr().String())
+	client := NewFeedClient(host, port, WithCacheSize(0))
+	for range 2 {
+		if _, err := client.GetFeed(context.Background(), "token"); err != nil {
+			t.Fatalf("GetFeed() error = %v", err)
+		}
+	}
+}
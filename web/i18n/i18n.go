@@ -0,0 +1,321 @@
+package i18n
+
+import (
+	"embed"
+	"encoding/json"
+	"fmt"
+	"html/template"
+	"io/fs"
+	"path"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"text/template/parse"
+)
+
+//go:embed "locales/*.json"
+var locales embed.FS
+
+// language used when no other one matches, every key must be translated to it
+const DefaultLanguage = "en"
+
+/*
+Returns the plural category of count, as named by CLDR
+
+Only the categories used by the embedded languages are supported, other languages follow the english rule
+*/
+type PluralRule func(count int) string
+
+var pluralRules = map[string]PluralRule{
+	"en": func(count int) string {
+		if count == 1 {
+			return "one"
+		}
+		return "other"
+	},
+	// 0 is singular in french
+	"fr": func(count int) string {
+		if count == 0 || count == 1 {
+			return "one"
+		}
+		return "other"
+	},
+}
+
+// A translated message, either a single text or one text per plural category
+type message struct {
+	text   string
+	plural map[string]string
+}
+
+func (m *message) UnmarshalJSON(data []byte) error {
+	if err := json.Unmarshal(data, &m.text); err == nil {
+		return nil
+	}
+	if err := json.Unmarshal(data, &m.plural); err != nil {
+		return fmt.Errorf("message must be a string or an object of plural forms: %w", err)
+	}
+	if _, ok := m.plural["other"]; !ok {
+		return fmt.Errorf("plural message must have an \"other\" form")
+	}
+	return nil
+}
+
+/*
+Catalog holds the messages of every language, keyed by message id
+
+Messages are fmt formats, arguments can be reordered with %[n]v. Plural messages pick their form from the first
+argument
+*/
+type Catalog struct {
+	messages map[string]map[string]*message
+}
+
+// Load the catalog embedded in the binary, one locales/<language>.json file per language
+func Load() (*Catalog, error) {
+	return LoadFS(locales, "locales")
+}
+
+// Load one <language>.json file per language from dir
+func LoadFS(fsys fs.FS, dir string) (*Catalog, error) {
+	files, err := fs.Glob(fsys, path.Join(dir, "*.json"))
+	if err != nil {
+		return nil, err
+	}
+
+	c := &Catalog{messages: map[string]map[string]*message{}}
+	for _, file := range files {
+		data, err := fs.ReadFile(fsys, file)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read translations: %w", err)
+		}
+		messages := map[string]*message{}
+		if err := json.Unmarshal(data, &messages); err != nil {
+			return nil, fmt.Errorf("failed to deserialize %s: %w", file, err)
+		}
+		c.messages[strings.TrimSuffix(path.Base(file), ".json")] = messages
+	}
+
+	if _, ok := c.messages[DefaultLanguage]; !ok {
+		return nil, fmt.Errorf("missing translations for the default language %s", DefaultLanguage)
+	}
+
+	return c, nil
+}
+
+// Languages of the catalog, sorted
+func (c *Catalog) Languages() []string {
+	languages := make([]string, 0, len(c.messages))
+	for language := range c.messages {
+		languages = append(languages, language)
+	}
+	sort.Strings(languages)
+	return languages
+}
+
+// Ids of the messages of the language, sorted
+func (c *Catalog) Keys(language string) []string {
+	keys := make([]string, 0, len(c.messages[language]))
+	for key := range c.messages[language] {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// Returns true if the message is translated to the default language
+func (c *Catalog) Has(key string) bool {
+	_, ok := c.messages[DefaultLanguage][key]
+	return ok
+}
+
+/*
+Returns the localizer of the request
+
+The language picked by the user wins if the catalog has it, the Accept-Language header of the browser is negotiated
+otherwise
+*/
+func (c *Catalog) Localizer(preferred, acceptLanguage string) *Localizer {
+	language := preferred
+	if _, ok := c.messages[language]; !ok {
+		language = Negotiate(acceptLanguage, c.Languages())
+	}
+	return &Localizer{catalog: c, language: language}
+}
+
+/*
+Returns the supported language the Accept-Language header prefers, DefaultLanguage if none matches
+
+Languages are compared on their primary subtag, i.e. fr-BE matches fr
+*/
+func Negotiate(acceptLanguage string, supported []string) string {
+	type candidate struct {
+		language string
+		quality  float64
+	}
+
+	candidates := []candidate{}
+	for _, part := range strings.Split(acceptLanguage, ",") {
+		tag, params, _ := strings.Cut(strings.TrimSpace(part), ";")
+		quality := 1.0
+		if value, ok := strings.CutPrefix(strings.TrimSpace(params), "q="); ok {
+			q, err := strconv.ParseFloat(value, 64)
+			if err != nil {
+				continue
+			}
+			quality = q
+		}
+		if tag == "" || quality <= 0 {
+			continue
+		}
+		primary, _, _ := strings.Cut(strings.ToLower(tag), "-")
+		candidates = append(candidates, candidate{language: primary, quality: quality})
+	}
+
+	// the order of the header breaks ties
+	sort.SliceStable(candidates, func(i, j int) bool {
+		return candidates[i].quality > candidates[j].quality
+	})
+
+	for _, candidate := range candidates {
+		if slices.Contains(supported, candidate.language) {
+			return candidate.language
+		}
+	}
+	return DefaultLanguage
+}
+
+// Localizer translates messages to a single language, falling back to DefaultLanguage
+type Localizer struct {
+	catalog  *Catalog
+	language string
+}
+
+func (l *Localizer) Language() string {
+	return l.language
+}
+
+func (l *Localizer) lookup(key string) (*message, bool) {
+	if m, ok := l.catalog.messages[l.language][key]; ok {
+		return m, true
+	}
+	m, ok := l.catalog.messages[DefaultLanguage][key]
+	return m, ok
+}
+
+// Translate the message, unknown messages are rendered as their key so they are noticed
+func (l *Localizer) T(key string, args ...any) string {
+	m, ok := l.lookup(key)
+	if !ok {
+		return key
+	}
+	if m.plural != nil {
+		return fmt.Sprintf(m.plural["other"], args...)
+	}
+	if len(args) == 0 {
+		return m.text
+	}
+	return fmt.Sprintf(m.text, args...)
+}
+
+// Translate the message in the plural form of count, count is the first argument of the format
+func (l *Localizer) Plural(key string, count int, args ...any) string {
+	m, ok := l.lookup(key)
+	if !ok {
+		return key
+	}
+	args = append([]any{count}, args...)
+	if m.plural == nil {
+		return fmt.Sprintf(m.text, args...)
+	}
+
+	rule, ok := pluralRules[l.language]
+	if !ok {
+		rule = pluralRules[DefaultLanguage]
+	}
+	format, ok := m.plural[rule(count)]
+	if !ok {
+		format = m.plural["other"]
+	}
+	return fmt.Sprintf(format, args...)
+}
+
+/*
+Template functions bound to the localizer:
+  - t "key" args...: see T
+  - plural "key" count args...: see Plural
+  - lang: the language, for the lang attribute of the page
+
+Templates parsed with a nil localizer only use the functions to check the templates, see TemplateKeys
+*/
+func (l *Localizer) FuncMap() template.FuncMap {
+	return template.FuncMap{
+		"t":      l.T,
+		"plural": l.Plural,
+		"lang":   l.Language,
+	}
+}
+
+// Ids of the messages the templates reference through the t and plural functions, sorted
+func TemplateKeys(t *template.Template) []string {
+	keys := map[string]bool{}
+	for _, tmpl := range t.Templates() {
+		if tmpl.Tree != nil {
+			collectKeys(tmpl.Tree.Root, keys)
+		}
+	}
+
+	result := make([]string, 0, len(keys))
+	for key := range keys {
+		result = append(result, key)
+	}
+	sort.Strings(result)
+	return result
+}
+
+func collectKeys(node parse.Node, keys map[string]bool) {
+	switch n := node.(type) {
+	case *parse.ListNode:
+		if n == nil {
+			return
+		}
+		for _, child := range n.Nodes {
+			collectKeys(child, keys)
+		}
+	case *parse.ActionNode:
+		collectKeys(n.Pipe, keys)
+	case *parse.IfNode:
+		collectBranch(&n.BranchNode, keys)
+	case *parse.RangeNode:
+		collectBranch(&n.BranchNode, keys)
+	case *parse.WithNode:
+		collectBranch(&n.BranchNode, keys)
+	case *parse.TemplateNode:
+		collectKeys(n.Pipe, keys)
+	case *parse.PipeNode:
+		if n == nil {
+			return
+		}
+		for _, cmd := range n.Cmds {
+			collectKeys(cmd, keys)
+		}
+	case *parse.CommandNode:
+		if len(n.Args) > 1 {
+			function, isIdentifier := n.Args[0].(*parse.IdentifierNode)
+			key, isString := n.Args[1].(*parse.StringNode)
+			if isIdentifier && isString && (function.Ident == "t" || function.Ident == "plural") {
+				keys[key.Text] = true
+			}
+		}
+		for _, arg := range n.Args {
+			collectKeys(arg, keys)
+		}
+	}
+}
+
+func collectBranch(n *parse.BranchNode, keys map[string]bool) {
+	collectKeys(n.Pipe, keys)
+	collectKeys(n.List, keys)
+	collectKeys(n.ElseList, keys)
+}
@@ -0,0 +1,137 @@
+package i18n
+
+import (
+	"html/template"
+	"testing"
+	"testing/fstest"
+)
+
+func testCatalog(t *testing.T) *Catalog {
+	t.Helper()
+
+	fsys := fstest.MapFS{
+		"locales/en.json": {Data: []byte(`{
+			"greeting": "Hello %s",
+			"listens": {"one": "%d listen", "other": "%d listens"},
+			"only.english": "English only"
+		}`)},
+		"locales/fr.json": {Data: []byte(`{
+			"greeting": "Bonjour %s",
+			"listens": {"one": "%d écoute", "other": "%d écoutes"}
+		}`)},
+	}
+	catalog, err := LoadFS(fsys, "locales")
+	if err != nil {
+		t.Fatalf("LoadFS() error = %v", err)
+	}
+	return catalog
+}
+
+func TestNegotiate(t *testing.T) {
+	supported := []string{"en", "fr"}
+
+	tests := []struct {
+		header   string
+		expected string
+	}{
+		{header: "", expected: "en"},
+		{header: "fr", expected: "fr"},
+		{header: "fr-BE", expected: "fr"},
+		{header: "de-DE,de;q=0.9,fr;q=0.8,en;q=0.7", expected: "fr"},
+		{header: "en;q=0.5, fr;q=0.9", expected: "fr"},
+		{header: "fr;q=0, en", expected: "en"},
+		{header: "de, *;q=0.1", expected: "en"},
+		{header: "fr;q=invalid, en", expected: "en"},
+	}
+
+	for _, tt := range tests {
+		if got := Negotiate(tt.header, supported); got != tt.expected {
+			t.Errorf("Negotiate(%q) = %v, expected %v", tt.header, got, tt.expected)
+		}
+	}
+}
+
+func TestLocalizer(t *testing.T) {
+	catalog := testCatalog(t)
+
+	fr := catalog.Localizer("", "fr-FR")
+	if got := fr.T("greeting", "Alice"); got != "Bonjour Alice" {
+		t.Errorf("T() = %v, expected the french message", got)
+	}
+	// missing translations fall back to the default language, unknown messages to their key
+	if got := fr.T("only.english"); got != "English only" {
+		t.Errorf("T() = %v, expected the english message", got)
+	}
+	if got := fr.T("unknown.key"); got != "unknown.key" {
+		t.Errorf("T() = %v, expected the key", got)
+	}
+
+	// the language of the user wins, unless the catalog doesn't have it
+	if got := catalog.Localizer("en", "fr").Language(); got != "en" {
+		t.Errorf("Language() = %v, expected the language of the user", got)
+	}
+	if got := catalog.Localizer("tlh", "fr").Language(); got != "fr" {
+		t.Errorf("Language() = %v, expected the language of the browser", got)
+	}
+}
+
+func TestPlural(t *testing.T) {
+	catalog := testCatalog(t)
+	en := catalog.Localizer("en", "")
+	fr := catalog.Localizer("fr", "")
+
+	tests := []struct {
+		localizer *Localizer
+		count     int
+		expected  string
+	}{
+		{localizer: en, count: 0, expected: "0 listens"},
+		{localizer: en, count: 1, expected: "1 listen"},
+		{localizer: en, count: 2, expected: "2 listens"},
+		// 0 is singular in french
+		{localizer: fr, count: 0, expected: "0 écoute"},
+		{localizer: fr, count: 1, expected: "1 écoute"},
+		{localizer: fr, count: 2, expected: "2 écoutes"},
+	}
+
+	for _, tt := range tests {
+		if got := tt.localizer.Plural("listens", tt.count); got != tt.expected {
+			t.Errorf("%s: Plural(%d) = %v, expected %v", tt.localizer.Language(), tt.count, got, tt.expected)
+		}
+	}
+}
+
+func TestLoadFSRejectsInvalidCatalogs(t *testing.T) {
+	invalid := map[string]fstest.MapFS{
+		"no default language":   {"locales/fr.json": {Data: []byte(`{}`)}},
+		"plural without other":  {"locales/en.json": {Data: []byte(`{"listens": {"one": "%d listen"}}`)}},
+		"message of wrong type": {"locales/en.json": {Data: []byte(`{"listens": 3}`)}},
+	}
+
+	for name, fsys := range invalid {
+		if _, err := LoadFS(fsys, "locales"); err == nil {
+			t.Errorf("%s: LoadFS() error = nil, expected an error", name)
+		}
+	}
+}
+
+func TestTemplateKeys(t *testing.T) {
+	var unbound *Localizer
+	tmpl := template.Must(template.New("page").Funcs(unbound.FuncMap()).Parse(`
+		<title>{{ t "title" }}</title>
+		{{ if .Items }}{{ plural "items.count" (len .Items) }}{{ else }}{{ t "items.empty" }}{{ end }}
+		{{ range .Items }}{{ printf "%s" (t "item.label" .) }}{{ end }}
+		{{ define "footer" }}{{ t "footer" }}{{ end }}
+	`))
+
+	expected := []string{"footer", "item.label", "items.count", "items.empty", "title"}
+	got := TemplateKeys(tmpl)
+	if len(got) != len(expected) {
+		t.Fatalf("TemplateKeys() = %v, expected %v", got, expected)
+	}
+	for i := range got {
+		if got[i] != expected[i] {
+			t.Fatalf("TemplateKeys() = %v, expected %v", got, expected)
+		}
+	}
+}
@@ -0,0 +1,140 @@
+package web
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/xaviercrochet/turbo-octo-adventure/pkg/auth"
+	"github.com/xaviercrochet/turbo-octo-adventure/pkg/net"
+	"github.com/xaviercrochet/turbo-octo-adventure/pkg/util"
+)
+
+// access tokens expiring within the margin are refreshed before calling the api, so they don't expire in flight
+const tokenExpiryMargin = 30 * time.Second
+
+/*
+Keeps the access tokens of the web sessions fresh
+
+The refreshed tokens are stored with a copy of the session, so the following requests of the user get them while the
+requests in flight keep reading the session they started with
+*/
+type sessionTokens struct {
+	refresher auth.TokenRefresher
+	sessions  *auth.Sessions
+	// serializes the refreshes, they are rare enough to share the lock
+	mu  sync.Mutex
+	now func() time.Time
+}
+
+func newSessionTokens(refresher auth.TokenRefresher, sessions *auth.Sessions) *sessionTokens {
+	return &sessionTokens{refresher: refresher, sessions: sessions, now: time.Now}
+}
+
+/*
+Call the api with the access token of the session
+
+The token is refreshed first if it is about to expire, and once more if the api rejects it, after which the call is
+retried once. Errors wrap net.ErrNotAuthenticated if the user has to log in again
+*/
+func (s *sessionTokens) do(ctx context.Context, session *auth.UserInfoContext, call func(accessToken string) error) error {
+	accessToken, err := s.accessToken(ctx, session)
+	if err != nil {
+		return err
+	}
+
+	err = call(accessToken)
+	if !errors.Is(err, net.ErrNotAuthenticated) {
+		return err
+	}
+
+	accessToken, err = s.refresh(ctx, session, accessToken)
+	if err != nil {
+		return err
+	}
+	return call(accessToken)
+}
+
+// Returns the access token of the session, refreshed if it is about to expire
+func (s *sessionTokens) accessToken(ctx context.Context, session *auth.UserInfoContext) (string, error) {
+	tokens := s.sessions.Latest(session).Tokens
+	if tokens == nil || tokens.Token == nil {
+		return "", fmt.Errorf("%w: session without tokens", net.ErrNotAuthenticated)
+	}
+	if tokens.Expiry.IsZero() || s.now().Add(tokenExpiryMargin).Before(tokens.Expiry) {
+		return tokens.AccessToken, nil
+	}
+	return s.refresh(ctx, session, tokens.AccessToken)
+}
+
+/*
+Refresh the tokens of the session, unless a concurrent request already replaced the stale access token
+
+Returns the new access token
+*/
+func (s *sessionTokens) refresh(ctx context.Context, session *auth.UserInfoContext, stale string) (string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	session = s.sessions.Latest(session)
+	if session.Tokens != nil && session.Tokens.Token != nil && session.Tokens.AccessToken != stale {
+		return session.Tokens.AccessToken, nil
+	}
+
+	tokens, err := s.refresher.Refresh(ctx, session.Tokens)
+	if err != nil {
+		return "", fmt.Errorf("%w: %v", net.ErrNotAuthenticated, err)
+	}
+	refreshed := *session
+	refreshed.Tokens = tokens
+	s.sessions.Replace(session, &refreshed)
+
+	util.DefaultLogger.FromContext(ctx).Info("access token refreshed", "expiry", tokens.Expiry)
+	return tokens.AccessToken, nil
+}
+
+/*
+Send the user to the login page, back to the current page once logged in
+
+Forms can't be replayed after the login, their users are sent back to /feed instead
+*/
+func redirectToLogin(w http.ResponseWriter, req *http.Request) {
+	returnTo := "/feed"
+	if req.Method == http.MethodGet {
+		returnTo = req.URL.RequestURI()
+	}
+	http.Redirect(w, req, "/auth/login?"+url.Values{"return_to": {returnTo}}.Encode(), http.StatusSeeOther)
+}
+
+/*
+/auth/login
+
+Starts a new authentication, the user lands on the return_to path of the app once logged in, on / by default.
+Anything but a local path is ignored so the login can't be used as an open redirect
+*/
+func loginHandler(authenticate func(w http.ResponseWriter, req *http.Request, requestedURI string)) http.HandlerFunc {
+	return func(w http.ResponseWriter, req *http.Request) {
+		returnTo := localPath(req.URL.Query().Get("return_to"))
+		if returnTo == "" {
+			returnTo = "/"
+		}
+		authenticate(w, req, returnTo)
+	}
+}
+
+// Returns path if it stays on the app, "" otherwise
+func localPath(path string) string {
+	if !strings.HasPrefix(path, "/") || strings.HasPrefix(path, "//") || strings.HasPrefix(path, "/\\") {
+		return ""
+	}
+	u, err := url.Parse(path)
+	if err != nil || u.Scheme != "" || u.Host != "" {
+		return ""
+	}
+	return path
+}
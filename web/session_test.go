@@ -0,0 +1,200 @@
+package web
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/xaviercrochet/turbo-octo-adventure/pkg/auth"
+	"github.com/xaviercrochet/turbo-octo-adventure/pkg/net"
+	"golang.org/x/oauth2"
+)
+
+// Issues access-1, access-2... for the refresh token "refresh"
+type fakeRefresher struct {
+	mu    sync.Mutex
+	calls int
+}
+
+func (r *fakeRefresher) Refresh(_ context.Context, tokens *auth.Tokens) (*auth.Tokens, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if tokens.RefreshToken != "refresh" {
+		return nil, auth.ErrRefreshUnavailable
+	}
+	r.calls++
+	return &auth.Tokens{Token: &oauth2.Token{
+		AccessToken:  "access-" + string(rune('0'+r.calls)),
+		RefreshToken: "refresh",
+		Expiry:       time.Now().Add(time.Hour),
+	}}, nil
+}
+
+// Returns a session stored under "session-id"
+func testSession(t *testing.T, sessions *auth.Sessions, accessToken, refreshToken string, expiry time.Time) *auth.UserInfoContext {
+	session := &auth.UserInfoContext{Tokens: &auth.Tokens{Token: &oauth2.Token{
+		AccessToken:  accessToken,
+		RefreshToken: refreshToken,
+		Expiry:       expiry,
+	}}}
+	if err := sessions.Set("session-id", session); err != nil {
+		t.Fatal(err)
+	}
+	return session
+}
+
+func TestSessionTokensRefreshBeforeExpiry(t *testing.T) {
+	refresher := &fakeRefresher{}
+	sessions := auth.NewSessions()
+	tokens := newSessionTokens(refresher, sessions)
+	session := testSession(t, sessions, "expiring", "refresh", time.Now().Add(10*time.Second))
+
+	var used []string
+	err := tokens.do(context.Background(), session, func(accessToken string) error {
+		used = append(used, accessToken)
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("do() error = %v", err)
+	}
+	if len(used) != 1 || used[0] != "access-1" {
+		t.Errorf("api called with %v, expected the refreshed token", used)
+	}
+	// the stored session has the refreshed tokens for the following requests, the one in flight isn't modified
+	stored, err := sessions.Get("session-id")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stored.Tokens.AccessToken != "access-1" {
+		t.Errorf("session token = %v, expected the refreshed token", stored.Tokens.AccessToken)
+	}
+	if session.Tokens.AccessToken != "expiring" {
+		t.Errorf("the session of the request was modified, token = %v", session.Tokens.AccessToken)
+	}
+
+	// a request still holding the previous session uses the refreshed tokens as well
+	err = tokens.do(context.Background(), session, func(accessToken string) error {
+		used = append(used, accessToken)
+		return nil
+	})
+	if err != nil || len(used) != 2 || used[1] != "access-1" || refresher.calls != 1 {
+		t.Errorf("api called with %v (%v), expected the refreshed token", used, err)
+	}
+}
+
+func TestSessionTokensRetryOnceWhenRejected(t *testing.T) {
+	refresher := &fakeRefresher{}
+	sessions := auth.NewSessions()
+	tokens := newSessionTokens(refresher, sessions)
+	session := testSession(t, sessions, "revoked", "refresh", time.Now().Add(time.Hour))
+
+	var used []string
+	err := tokens.do(context.Background(), session, func(accessToken string) error {
+		used = append(used, accessToken)
+		return net.ErrNotAuthenticated
+	})
+	if !errors.Is(err, net.ErrNotAuthenticated) {
+		t.Fatalf("do() error = %v, expected %v", err, net.ErrNotAuthenticated)
+	}
+	if len(used) != 2 || used[0] != "revoked" || used[1] != "access-1" {
+		t.Errorf("api called with %v, expected the session token then the refreshed one", used)
+	}
+}
+
+func TestSessionTokensWithoutRefreshToken(t *testing.T) {
+	sessions := auth.NewSessions()
+	tokens := newSessionTokens(&fakeRefresher{}, sessions)
+	session := testSession(t, sessions, "expired", "", time.Now().Add(-time.Minute))
+
+	called := false
+	err := tokens.do(context.Background(), session, func(string) error {
+		called = true
+		return nil
+	})
+	if !errors.Is(err, net.ErrNotAuthenticated) {
+		t.Errorf("do() error = %v, expected %v", err, net.ErrNotAuthenticated)
+	}
+	if called {
+		t.Errorf("api called with an expired token")
+	}
+}
+
+func TestSessionTokensRefreshOnceForConcurrentRequests(t *testing.T) {
+	refresher := &fakeRefresher{}
+	sessions := auth.NewSessions()
+	tokens := newSessionTokens(refresher, sessions)
+	session := testSession(t, sessions, "expired", "refresh", time.Now().Add(-time.Minute))
+
+	var wg sync.WaitGroup
+	for range 10 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			err := tokens.do(context.Background(), session, func(accessToken string) error {
+				if accessToken != "access-1" {
+					t.Errorf("api called with %v, expected the refreshed token", accessToken)
+				}
+				return nil
+			})
+			if err != nil {
+				t.Errorf("do() error = %v", err)
+			}
+		}()
+	}
+	wg.Wait()
+
+	if refresher.calls != 1 {
+		t.Errorf("tokens refreshed %d times, expected once", refresher.calls)
+	}
+}
+
+func TestRedirectToLogin(t *testing.T) {
+	tests := []struct {
+		method   string
+		target   string
+		expected string
+	}{
+		{method: http.MethodGet, target: "/feed?q=daft", expected: "/auth/login?return_to=%2Ffeed%3Fq%3Ddaft"},
+		// forms can't be replayed
+		{method: http.MethodPost, target: "/select_feed", expected: "/auth/login?return_to=%2Ffeed"},
+	}
+
+	for _, tt := range tests {
+		w := httptest.NewRecorder()
+		redirectToLogin(w, httptest.NewRequest(tt.method, tt.target, nil))
+		if w.Code != http.StatusSeeOther || w.Header().Get("Location") != tt.expected {
+			t.Errorf("%s %s redirected (%d) to %v, expected %v", tt.method, tt.target, w.Code, w.Header().Get("Location"), tt.expected)
+		}
+	}
+}
+
+func TestLoginHandlerReturnTo(t *testing.T) {
+	tests := map[string]string{
+		"/feed?q=daft":         "/feed?q=daft",
+		"":                     "/",
+		"https://evil.example": "/",
+		"//evil.example/feed":  "/",
+		"/\\evil.example":      "/",
+		"feed":                 "/",
+	}
+
+	for returnTo, expected := range tests {
+		var requested string
+		handler := loginHandler(func(_ http.ResponseWriter, _ *http.Request, requestedURI string) {
+			requested = requestedURI
+		})
+		req := httptest.NewRequest(http.MethodGet, "/auth/login", nil)
+		req.URL.RawQuery = url.Values{"return_to": {returnTo}}.Encode()
+		handler(httptest.NewRecorder(), req)
+
+		if requested != expected {
+			t.Errorf("return_to=%q: requested uri = %q, expected %q", returnTo, requested, expected)
+		}
+	}
+}